@@ -0,0 +1,267 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alesr/stdservices/apikeys/repository"
+	"github.com/alesr/stdservices/pkg/validate"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultKeyPrefix prefixes the keys, so they can be recognized (e.g. by secret scanners)
+	defaultKeyPrefix = "sk"
+
+	// keyIDLen and keySecretLen are the lengths of the public id and the secret parts of the keys
+	keyIDLen     = 12
+	keySecretLen = 32
+
+	maxNameLen = 64
+)
+
+var _ Service = (*DefaultService)(nil)
+
+type (
+	// Service defines the api key service interface
+	Service interface {
+		// CreateAPIKey creates an api key for the user, returned along with the key shown once
+		CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiry time.Duration) (*CreateAPIKeyResponse, error)
+
+		// VerifyAPIKey verifies an api key and returns its metadata, including its user and scopes
+		VerifyAPIKey(ctx context.Context, key string) (*APIKey, error)
+
+		// ListAPIKeys lists the api keys of the user, including the revoked and expired keys, newest first
+		ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+
+		// RevokeAPIKey revokes an api key of the user
+		RevokeAPIKey(ctx context.Context, userID, id string) error
+	}
+
+	repo interface {
+		Insert(ctx context.Context, in repository.APIKey) error
+		SelectByID(ctx context.Context, id string) (*repository.APIKey, error)
+		SelectByUserID(ctx context.Context, userID string) ([]repository.APIKey, error)
+		Revoke(ctx context.Context, id, userID string, revokedAt time.Time) error
+		UpdateLastUsedAt(ctx context.Context, id string, usedAt time.Time) error
+	}
+)
+
+type ServiceOption func(*DefaultService)
+
+// WithKeyPrefix sets the prefix of the keys, replacing the default "sk" (e.g. "myapp_live").
+// Verification only accepts the keys with the prefix.
+func WithKeyPrefix(prefix string) ServiceOption {
+	return func(s *DefaultService) {
+		s.prefix = prefix
+	}
+}
+
+type DefaultService struct {
+	logger *zap.Logger
+	prefix string
+	clock  func() time.Time
+	repo   repo
+}
+
+// New instantiates a new api key service
+func New(logger *zap.Logger, repo repo, opts ...ServiceOption) *DefaultService {
+	service := DefaultService{
+		logger: logger,
+		prefix: defaultKeyPrefix,
+		clock:  time.Now,
+		repo:   repo,
+	}
+
+	if service.logger == nil {
+		service.logger = zap.NewNop()
+	}
+
+	for _, opt := range opts {
+		opt(&service)
+	}
+	return &service
+}
+
+// CreateAPIKey creates an api key for the user with the scopes, expiring after expiry, or never when expiry is zero.
+// The returned key is made of the prefix, the public id of the key and a random secret, as in "sk_<id>_<secret>".
+// Only a digest of the secret is stored, so the key can't be shown again.
+func (s *DefaultService) CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiry time.Duration) (*CreateAPIKeyResponse, error) {
+	if err := validate.ID(userID); err != nil {
+		return nil, errUserIDInvalid
+	}
+
+	if name == "" || utf8.RuneCountInString(name) > maxNameLen {
+		return nil, errNameInvalid
+	}
+
+	for _, scope := range scopes {
+		if scope == "" || strings.IndexFunc(scope, unicode.IsSpace) >= 0 {
+			return nil, errScopeInvalid
+		}
+	}
+
+	if expiry < 0 {
+		return nil, errExpiryInvalid
+	}
+
+	id, err := randString(keyIDLen)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randString(keySecretLen)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock().UTC()
+
+	in := repository.APIKey{
+		ID:         id,
+		UserID:     userID,
+		Name:       name,
+		SecretHash: secretDigest(secret),
+		Scopes:     scopes,
+		CreatedAt:  now,
+	}
+
+	if expiry > 0 {
+		expiresAt := now.Add(expiry)
+		in.ExpiresAt = &expiresAt
+	}
+
+	if err := s.repo.Insert(ctx, in); err != nil {
+		s.logger.Error("could not insert api key", zap.String("operation", "create_api_key"), zap.Error(err))
+		return nil, fmt.Errorf("could not insert api key: %s", err)
+	}
+
+	s.logger.Info("api key created", zap.String("operation", "create_api_key"), zap.String("api_key_id", id))
+
+	return &CreateAPIKeyResponse{
+		APIKey: newAPIKeyFromRepository(&in),
+		Key:    s.prefix + "_" + id + "_" + secret,
+	}, nil
+}
+
+// VerifyAPIKey verifies an api key and returns its metadata. The secret is compared in constant time.
+// Malformed and unknown keys fail alike with errKeyInvalid, while revoked and expired keys are only
+// reported as such once the secret matches.
+func (s *DefaultService) VerifyAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	id, secret, ok := s.parseKey(key)
+	if !ok {
+		return nil, errKeyInvalid
+	}
+
+	storageKey, err := s.repo.SelectByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not select api key: %s", err)
+	}
+
+	if storageKey == nil || subtle.ConstantTimeCompare([]byte(secretDigest(secret)), []byte(storageKey.SecretHash)) != 1 {
+		return nil, errKeyInvalid
+	}
+
+	if storageKey.RevokedAt != nil {
+		return nil, errKeyRevoked
+	}
+
+	now := s.clock().UTC()
+
+	if storageKey.ExpiresAt != nil && !now.Before(*storageKey.ExpiresAt) {
+		return nil, errKeyExpired
+	}
+
+	// The last use is informational, so failing to record it doesn't fail the verification
+	if err := s.repo.UpdateLastUsedAt(ctx, id, now); err != nil {
+		s.logger.Error("could not update api key last use", zap.String("operation", "verify_api_key"), zap.String("api_key_id", id), zap.Error(err))
+	} else {
+		storageKey.LastUsedAt = &now
+	}
+	return newAPIKeyFromRepository(storageKey), nil
+}
+
+// ListAPIKeys lists the api keys of the user, including the revoked and expired keys, newest first
+func (s *DefaultService) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	if err := validate.ID(userID); err != nil {
+		return nil, errUserIDInvalid
+	}
+
+	storageKeys, err := s.repo.SelectByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select api keys: %s", err)
+	}
+
+	keys := make([]APIKey, 0, len(storageKeys))
+	for i := range storageKeys {
+		keys = append(keys, *newAPIKeyFromRepository(&storageKeys[i]))
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an api key of the user. Keys of other users, unknown keys
+// and keys already revoked fail alike with errKeyNotFound.
+func (s *DefaultService) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	if err := validate.ID(userID); err != nil {
+		return errUserIDInvalid
+	}
+
+	if err := s.repo.Revoke(ctx, id, userID, s.clock().UTC()); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errKeyNotFound
+		}
+		return fmt.Errorf("could not revoke api key: %s", err)
+	}
+
+	s.logger.Info("api key revoked", zap.String("operation", "revoke_api_key"), zap.String("api_key_id", id))
+	return nil
+}
+
+// parseKey splits a key into its public id and secret, reporting false for keys
+// without the service prefix or malformed parts
+func (s *DefaultService) parseKey(key string) (id, secret string, ok bool) {
+	rest := strings.TrimPrefix(key, s.prefix+"_")
+	if rest == key {
+		return "", "", false
+	}
+
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || len(id) != keyIDLen || len(secret) != keySecretLen {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// secretDigest returns the hex encoded SHA-256 digest of a secret. The secrets are random,
+// so a fast hash is enough to make the stored digests useless to an attacker.
+func secretDigest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// randString returns a random string of chars
+func randString(length int) (string, error) {
+	charCount := big.NewInt(int64(len(chars)))
+
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, charCount)
+		if err != nil {
+			return "", fmt.Errorf("could not read random source: %s", err)
+		}
+		b[i] = chars[n.Int64()]
+	}
+	return string(b), nil
+}
@@ -0,0 +1,44 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var _ Service = (*MockService)(nil)
+
+type MockService struct {
+	CreateAPIKeyFunc func(ctx context.Context, userID, name string, scopes []string, expiry time.Duration) (*CreateAPIKeyResponse, error)
+	VerifyAPIKeyFunc func(ctx context.Context, key string) (*APIKey, error)
+	ListAPIKeysFunc  func(ctx context.Context, userID string) ([]APIKey, error)
+	RevokeAPIKeyFunc func(ctx context.Context, userID, id string) error
+}
+
+func (m *MockService) CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiry time.Duration) (*CreateAPIKeyResponse, error) {
+	if m.CreateAPIKeyFunc == nil {
+		return nil, errors.New("MockService.CreateAPIKeyFunc is nil")
+	}
+	return m.CreateAPIKeyFunc(ctx, userID, name, scopes, expiry)
+}
+
+func (m *MockService) VerifyAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	if m.VerifyAPIKeyFunc == nil {
+		return nil, errors.New("MockService.VerifyAPIKeyFunc is nil")
+	}
+	return m.VerifyAPIKeyFunc(ctx, key)
+}
+
+func (m *MockService) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	if m.ListAPIKeysFunc == nil {
+		return nil, errors.New("MockService.ListAPIKeysFunc is nil")
+	}
+	return m.ListAPIKeysFunc(ctx, userID)
+}
+
+func (m *MockService) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	if m.RevokeAPIKeyFunc == nil {
+		return errors.New("MockService.RevokeAPIKeyFunc is nil")
+	}
+	return m.RevokeAPIKeyFunc(ctx, userID, id)
+}
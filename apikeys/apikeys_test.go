@@ -0,0 +1,287 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/apikeys/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newMemoryRepository returns a repository mock storing the api keys in the map
+func newMemoryRepository(keys map[string]repository.APIKey) *repositoryMock {
+	return &repositoryMock{
+		insertFunc: func(ctx context.Context, in repository.APIKey) error {
+			keys[in.ID] = in
+			return nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.APIKey, error) {
+			k, ok := keys[id]
+			if !ok {
+				return nil, nil
+			}
+			return &k, nil
+		},
+		revokeFunc: func(ctx context.Context, id, userID string, revokedAt time.Time) error {
+			k, ok := keys[id]
+			if !ok || k.UserID != userID || k.RevokedAt != nil {
+				return repository.ErrRecordNotFound
+			}
+			k.RevokedAt = &revokedAt
+			keys[id] = k
+			return nil
+		},
+		updateLastUsedAtFunc: func(ctx context.Context, id string, usedAt time.Time) error {
+			k := keys[id]
+			k.LastUsedAt = &usedAt
+			keys[id] = k
+			return nil
+		},
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.New().String()
+
+	testCases := []struct {
+		name        string
+		givenUserID string
+		givenName   string
+		givenScopes []string
+		givenExpiry time.Duration
+		expectedErr error
+	}{
+		{
+			name:        "key without expiry",
+			givenUserID: userID,
+			givenName:   "ci",
+			givenScopes: []string{"read", "write"},
+		},
+		{
+			name:        "key with expiry",
+			givenUserID: userID,
+			givenName:   "ci",
+			givenExpiry: time.Hour,
+		},
+		{
+			name:        "invalid user id",
+			givenUserID: "foo",
+			givenName:   "ci",
+			expectedErr: errUserIDInvalid,
+		},
+		{
+			name:        "empty name",
+			givenUserID: userID,
+			expectedErr: errNameInvalid,
+		},
+		{
+			name:        "name too long",
+			givenUserID: userID,
+			givenName:   strings.Repeat("a", maxNameLen+1),
+			expectedErr: errNameInvalid,
+		},
+		{
+			name:        "scope with spaces",
+			givenUserID: userID,
+			givenName:   "ci",
+			givenScopes: []string{"read write"},
+			expectedErr: errScopeInvalid,
+		},
+		{
+			name:        "negative expiry",
+			givenUserID: userID,
+			givenName:   "ci",
+			givenExpiry: -time.Hour,
+			expectedErr: errExpiryInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			keys := make(map[string]repository.APIKey)
+
+			now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			svc := New(zap.NewNop(), newMemoryRepository(keys))
+			svc.clock = func() time.Time { return now }
+
+			resp, err := svc.CreateAPIKey(context.Background(), tc.givenUserID, tc.givenName, tc.givenScopes, tc.givenExpiry)
+			assert.Equal(t, tc.expectedErr, err)
+
+			if tc.expectedErr != nil {
+				assert.Empty(t, keys)
+				return
+			}
+
+			require.Contains(t, keys, resp.ID)
+			assert.Regexp(t, `^sk_[a-zA-Z0-9]{12}_[a-zA-Z0-9]{32}$`, resp.Key)
+			assert.ElementsMatch(t, tc.givenScopes, resp.Scopes)
+
+			// Only the digest of the secret is stored
+			assert.NotContains(t, resp.Key, keys[resp.ID].SecretHash)
+			assert.Equal(t, secretDigest(resp.Key[len(resp.Key)-keySecretLen:]), keys[resp.ID].SecretHash)
+
+			if tc.givenExpiry > 0 {
+				require.NotNil(t, resp.ExpiresAt)
+				assert.Equal(t, now.Add(tc.givenExpiry), *resp.ExpiresAt)
+			} else {
+				assert.Nil(t, resp.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.New().String()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	keys := make(map[string]repository.APIKey)
+
+	svc := New(zap.NewNop(), newMemoryRepository(keys), WithKeyPrefix("test"))
+	svc.clock = func() time.Time { return now }
+
+	created, err := svc.CreateAPIKey(context.Background(), userID, "ci", []string{"read"}, time.Hour)
+	require.NoError(t, err)
+
+	expiring, err := svc.CreateAPIKey(context.Background(), userID, "expiring", nil, time.Minute)
+	require.NoError(t, err)
+
+	revoked, err := svc.CreateAPIKey(context.Background(), userID, "revoked", nil, 0)
+	require.NoError(t, err)
+	require.NoError(t, svc.RevokeAPIKey(context.Background(), userID, revoked.ID))
+
+	svc.clock = func() time.Time { return now.Add(time.Minute) }
+
+	testCases := []struct {
+		name        string
+		given       string
+		expectedErr error
+	}{
+		{
+			name:  "valid key",
+			given: created.Key,
+		},
+		{
+			name:        "wrong secret",
+			given:       created.Key[:len(created.Key)-1] + "x",
+			expectedErr: errKeyInvalid,
+		},
+		{
+			name:        "other prefix",
+			given:       "sk" + strings.TrimPrefix(created.Key, "test"),
+			expectedErr: errKeyInvalid,
+		},
+		{
+			name:        "unknown key",
+			given:       "test_aaaaaaaaaaaa_" + strings.Repeat("a", keySecretLen),
+			expectedErr: errKeyInvalid,
+		},
+		{
+			name:        "malformed key",
+			given:       "test_foo",
+			expectedErr: errKeyInvalid,
+		},
+		{
+			name:        "expired key",
+			given:       expiring.Key,
+			expectedErr: errKeyExpired,
+		},
+		{
+			name:        "revoked key",
+			given:       revoked.Key,
+			expectedErr: errKeyRevoked,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := svc.VerifyAPIKey(context.Background(), tc.given)
+			assert.Equal(t, tc.expectedErr, err)
+
+			if tc.expectedErr != nil {
+				assert.Nil(t, actual)
+				return
+			}
+
+			assert.Equal(t, userID, actual.UserID)
+			assert.True(t, actual.HasScope("read"))
+			assert.False(t, actual.HasScope("write"))
+			require.NotNil(t, actual.LastUsedAt)
+			assert.Equal(t, now.Add(time.Minute), *actual.LastUsedAt)
+		})
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.New().String()
+
+	keys := make(map[string]repository.APIKey)
+
+	svc := New(zap.NewNop(), newMemoryRepository(keys))
+
+	created, err := svc.CreateAPIKey(context.Background(), userID, "ci", nil, 0)
+	require.NoError(t, err)
+
+	// Keys are only revoked by their user
+	err = svc.RevokeAPIKey(context.Background(), uuid.New().String(), created.ID)
+	assert.Equal(t, errKeyNotFound, err)
+
+	require.NoError(t, svc.RevokeAPIKey(context.Background(), userID, created.ID))
+
+	err = svc.RevokeAPIKey(context.Background(), userID, created.ID)
+	assert.Equal(t, errKeyNotFound, err)
+}
+
+func TestListAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.New().String()
+
+	t.Run("lists the keys", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), &repositoryMock{
+			selectByUserIDFunc: func(ctx context.Context, id string) ([]repository.APIKey, error) {
+				assert.Equal(t, userID, id)
+				return []repository.APIKey{{ID: "foo", UserID: userID, SecretHash: "hash"}, {ID: "bar", UserID: userID}}, nil
+			},
+		})
+
+		actual, err := svc.ListAPIKeys(context.Background(), userID)
+		require.NoError(t, err)
+
+		require.Len(t, actual, 2)
+		assert.Equal(t, "foo", actual[0].ID)
+		assert.Equal(t, "bar", actual[1].ID)
+	})
+
+	t.Run("repository error", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), &repositoryMock{
+			selectByUserIDFunc: func(ctx context.Context, id string) ([]repository.APIKey, error) {
+				return nil, errors.New("connection refused")
+			},
+		})
+
+		_, err := svc.ListAPIKeys(context.Background(), userID)
+		assert.Error(t, err)
+	})
+}
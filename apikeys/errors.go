@@ -0,0 +1,26 @@
+package apikeys
+
+type E struct {
+	msg string
+}
+
+func newE(msg string) E {
+	return E{msg: msg}
+}
+
+func (e E) Error() string {
+	return e.msg
+}
+
+var (
+	// Enumerate service errors
+
+	errExpiryInvalid = newE("api key expiry must not be negative")
+	errKeyExpired    = newE("api key is expired")
+	errKeyInvalid    = newE("api key is invalid")
+	errKeyNotFound   = newE("api key not found")
+	errKeyRevoked    = newE("api key was revoked")
+	errNameInvalid   = newE("api key name must be between 1 and 64 characters")
+	errScopeInvalid  = newE("api key scopes must not be empty or contain spaces")
+	errUserIDInvalid = newE("api key user id is invalid")
+)
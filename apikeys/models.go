@@ -0,0 +1,50 @@
+package apikeys
+
+import (
+	"time"
+
+	"github.com/alesr/stdservices/apikeys/repository"
+)
+
+// APIKey represents the metadata of an api key. The secret is never stored.
+type APIKey struct {
+	ID     string
+	UserID string
+	Name   string
+	// Scopes are the permissions granted to the key, interpreted by the application
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// HasScope reports whether the key was granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyResponse represents a created api key along with its secret
+type CreateAPIKeyResponse struct {
+	*APIKey
+	// Key is the api key presented by the clients. It is only returned on creation.
+	Key string
+}
+
+func newAPIKeyFromRepository(k *repository.APIKey) *APIKey {
+	return &APIKey{
+		ID:         k.ID,
+		UserID:     k.UserID,
+		Name:       k.Name,
+		Scopes:     k.Scopes,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
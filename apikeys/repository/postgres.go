@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Enumerate postgresql query strings
+
+	apiKeyColumns string = "id,user_id,name,secret_hash,scopes,created_at,expires_at,last_used_at,revoked_at"
+
+	insertQuery string = `INSERT INTO api_keys (id,user_id,name,secret_hash,scopes,created_at,expires_at)
+	VALUES ($1,$2,$3,$4,$5,$6,$7);`
+
+	selectByIDQuery string = "SELECT " + apiKeyColumns + " FROM api_keys WHERE id = $1;"
+
+	selectByUserIDQuery string = "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC, id;"
+
+	revokeQuery string = `UPDATE api_keys SET revoked_at = $3
+	WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;`
+
+	updateLastUsedAtQuery string = "UPDATE api_keys SET last_used_at = $2 WHERE id = $1;"
+)
+
+// Postgres represents an api key repository instance with the given database connection
+type Postgres struct{ *sqlx.DB }
+
+// NewPostgres creates a new api key repository instance
+func NewPostgres(dbConn *sqlx.DB) *Postgres {
+	return &Postgres{dbConn}
+}
+
+// Insert inserts an api key. It returns ErrDuplicateRecord if the id is already used.
+func (p *Postgres) Insert(ctx context.Context, in APIKey) error {
+	// A nil slice would be encoded as NULL rather than an empty array
+	var scopes pgtype.TextArray
+	if err := scopes.Set(append([]string{}, in.Scopes...)); err != nil {
+		return fmt.Errorf("could not encode scopes: %w", err)
+	}
+
+	if _, err := p.ExecContext(ctx, insertQuery, in.ID, in.UserID, in.Name, in.SecretHash, &scopes, in.CreatedAt, in.ExpiresAt); err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicateRecord
+		}
+		return fmt.Errorf("could not insert api key: %w", err)
+	}
+	return nil
+}
+
+// SelectByID selects an api key by id, including revoked and expired keys.
+// It returns nil if the key does not exist.
+func (p *Postgres) SelectByID(ctx context.Context, id string) (*APIKey, error) {
+	k, err := scanAPIKey(p.QueryRowContext(ctx, selectByIDQuery, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select api key: %w", err)
+	}
+	return k, nil
+}
+
+// SelectByUserID selects the api keys of a user, including revoked and expired keys, newest first
+func (p *Postgres) SelectByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	rows, err := p.QueryContext(ctx, selectByUserIDQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan api key: %w", err)
+		}
+		keys = append(keys, *k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Revoke revokes an api key of the user at revokedAt.
+// It returns ErrRecordNotFound if the user has no such key or the key is already revoked.
+func (p *Postgres) Revoke(ctx context.Context, id, userID string, revokedAt time.Time) error {
+	res, err := p.ExecContext(ctx, revokeQuery, id, userID, revokedAt)
+	if err != nil {
+		return fmt.Errorf("could not revoke api key: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateLastUsedAt records the last use of an api key.
+// It returns ErrRecordNotFound if the key does not exist.
+func (p *Postgres) UpdateLastUsedAt(ctx context.Context, id string, usedAt time.Time) error {
+	res, err := p.ExecContext(ctx, updateLastUsedAtQuery, id, usedAt)
+	if err != nil {
+		return fmt.Errorf("could not update api key last use: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey scans a row holding the api key columns
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var (
+		k      APIKey
+		scopes pgtype.TextArray
+	)
+
+	if err := row.Scan(
+		&k.ID, &k.UserID, &k.Name, &k.SecretHash, &scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := scopes.AssignTo(&k.Scopes); err != nil {
+		return nil, fmt.Errorf("could not decode scopes: %w", err)
+	}
+	return &k, nil
+}
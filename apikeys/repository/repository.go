@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrDuplicateRecord error = errors.New("duplicate record")
+	ErrRecordNotFound  error = errors.New("record not found")
+)
+
+// APIKey represents an api key in the database table, identified by the public id embedded in the key.
+// SecretHash is the SHA-256 digest of the secret part of the key.
+type APIKey struct {
+	ID         string
+	UserID     string
+	Name       string
+	SecretHash string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
@@ -0,0 +1,54 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alesr/stdservices/apikeys/repository"
+)
+
+var _ repo = (*repositoryMock)(nil)
+
+type repositoryMock struct {
+	insertFunc           func(ctx context.Context, in repository.APIKey) error
+	selectByIDFunc       func(ctx context.Context, id string) (*repository.APIKey, error)
+	selectByUserIDFunc   func(ctx context.Context, userID string) ([]repository.APIKey, error)
+	revokeFunc           func(ctx context.Context, id, userID string, revokedAt time.Time) error
+	updateLastUsedAtFunc func(ctx context.Context, id string, usedAt time.Time) error
+}
+
+func (m *repositoryMock) Insert(ctx context.Context, in repository.APIKey) error {
+	if m.insertFunc == nil {
+		return errors.New("repositoryMock.insertFunc is nil")
+	}
+	return m.insertFunc(ctx, in)
+}
+
+func (m *repositoryMock) SelectByID(ctx context.Context, id string) (*repository.APIKey, error) {
+	if m.selectByIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectByIDFunc is nil")
+	}
+	return m.selectByIDFunc(ctx, id)
+}
+
+func (m *repositoryMock) SelectByUserID(ctx context.Context, userID string) ([]repository.APIKey, error) {
+	if m.selectByUserIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectByUserIDFunc is nil")
+	}
+	return m.selectByUserIDFunc(ctx, userID)
+}
+
+func (m *repositoryMock) Revoke(ctx context.Context, id, userID string, revokedAt time.Time) error {
+	if m.revokeFunc == nil {
+		return errors.New("repositoryMock.revokeFunc is nil")
+	}
+	return m.revokeFunc(ctx, id, userID, revokedAt)
+}
+
+func (m *repositoryMock) UpdateLastUsedAt(ctx context.Context, id string, usedAt time.Time) error {
+	if m.updateLastUsedAtFunc == nil {
+		return errors.New("repositoryMock.updateLastUsedAtFunc is nil")
+	}
+	return m.updateLastUsedAtFunc(ctx, id, usedAt)
+}
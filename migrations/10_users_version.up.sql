@@ -0,0 +1,2 @@
+-- version is incremented on each update of a user, guarding updates against lost updates.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,2 @@
+-- password_auth_disabled prevents password logins, e.g. for SSO-only accounts.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_auth_disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- password_reset_requested_at records the last password reset request, enforcing a minimum interval between requests.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_requested_at TIMESTAMP;
@@ -0,0 +1,4 @@
+-- password_changed_at records the last password change, existing users are assumed to have kept their initial password.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMP;
+UPDATE users SET password_changed_at = created_at WHERE password_changed_at IS NULL;
+ALTER TABLE users ALTER COLUMN password_changed_at SET NOT NULL;
@@ -0,0 +1,2 @@
+-- users_email_lower_idx supports the case-insensitive email lookups of batch existence checks.
+CREATE INDEX IF NOT EXISTS users_email_lower_idx ON users (LOWER(email));
@@ -0,0 +1,3 @@
+-- hashed codes don't fit the former length and can't be confirmed without the service option anyway.
+DELETE FROM email_verifications WHERE LENGTH(code) > 32;
+ALTER TABLE email_verifications ALTER COLUMN code TYPE VARCHAR(32);
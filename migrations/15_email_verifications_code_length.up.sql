@@ -0,0 +1,2 @@
+-- code holds either the plaintext code or its HMAC-SHA-256, base64url encoded (see WithHashedVerificationCodes).
+ALTER TABLE email_verifications ALTER COLUMN code TYPE VARCHAR(64);
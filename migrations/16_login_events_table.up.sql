@@ -0,0 +1,10 @@
+-- login_events records the successful logins of the users, trimmed to the newest events per user.
+CREATE TABLE IF NOT EXISTS login_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX ON login_events(user_id, created_at DESC);
@@ -0,0 +1,10 @@
+-- refresh_tokens holds the SHA-256 digests of the issued refresh tokens, so they can be revoked.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX ON refresh_tokens(user_id);
@@ -0,0 +1,10 @@
+-- password_resets holds the SHA-256 digests of the password reset codes, each usable once.
+CREATE TABLE IF NOT EXISTS password_resets (
+    code_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX ON password_resets(user_id);
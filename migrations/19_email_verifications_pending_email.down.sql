@@ -0,0 +1,2 @@
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS pending_email_ciphertext;
+ALTER TABLE email_verifications DROP COLUMN IF EXISTS pending_email;
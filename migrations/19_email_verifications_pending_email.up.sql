@@ -0,0 +1,4 @@
+-- pending_email holds the new email of an email change, swapped in once the verification is confirmed.
+-- When emails are encrypted at rest, it holds the lookup hash and pending_email_ciphertext the encrypted email.
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS pending_email_ciphertext TEXT NOT NULL DEFAULT '';
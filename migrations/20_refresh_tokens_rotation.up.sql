@@ -0,0 +1,9 @@
+-- family_id groups the refresh tokens rotated from the same login, so a reused token revokes the whole family.
+-- consumed_at is set once a refresh token was exchanged for its successor.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS consumed_at TIMESTAMP;
+
+-- Refresh tokens issued before the rotation each form their own family
+UPDATE refresh_tokens SET family_id = token_hash WHERE family_id = '';
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens(family_id);
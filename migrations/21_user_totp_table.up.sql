@@ -0,0 +1,9 @@
+-- secret_ciphertext holds the TOTP secret encrypted by the service.
+-- last_used_step is the time step of the last accepted code, so a code can't be replayed.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret_ciphertext TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    confirmed_at TIMESTAMP,
+    last_used_step BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,11 @@
+-- id is the base64url encoded id of the WebAuthn credential and public_key its COSE_Key encoded public key
+CREATE TABLE IF NOT EXISTS passkeys (
+    id VARCHAR(1366) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX ON passkeys(user_id);
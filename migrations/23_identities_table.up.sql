@@ -0,0 +1,10 @@
+-- subject is the id of the user at the provider, stable across email changes
+CREATE TABLE IF NOT EXISTS identities (
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX ON identities(user_id);
@@ -0,0 +1,15 @@
+-- secret_hash is the SHA-256 digest of the secret part of the key, which is only shown once on creation
+-- user_id references no table, so api keys can be used along with another user store than the users service
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id UUID NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    secret_hash VARCHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX ON api_keys(user_id);
@@ -0,0 +1,14 @@
+-- id is the SHA-256 digest of the session token, which is only handed to the client
+-- user_id references no table, so sessions can be used along with another user store than the users service
+CREATE TABLE IF NOT EXISTS server_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX ON server_sessions(user_id);
+CREATE INDEX ON server_sessions(expires_at);
@@ -0,0 +1,3 @@
+-- ip and user_agent identify the device of a session, letting users review where they are logged in
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- outcome tells the successful logins from the failed ones, e.g. "success" or "invalid_password"
+ALTER TABLE login_events ADD COLUMN IF NOT EXISTS outcome VARCHAR(32) NOT NULL DEFAULT 'success';
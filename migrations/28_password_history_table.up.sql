@@ -0,0 +1,9 @@
+-- password_history keeps the hashes of the passwords the users replaced, trimmed to the newest hashes per user.
+CREATE TABLE IF NOT EXISTS password_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX ON password_history(user_id, created_at DESC);
@@ -0,0 +1,5 @@
+-- session_id is the session started by the login of a refresh token family, empty when sessions are disabled,
+-- so the access tokens refreshed stay in the session and revoking the session revokes the family
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_id VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_session_id_idx ON refresh_tokens(session_id);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key VARCHAR(255) NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    hits INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (key, window_start)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_normalized_key;
+ALTER TABLE users DROP COLUMN IF EXISTS username_normalized;
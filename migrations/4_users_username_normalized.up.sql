@@ -0,0 +1,19 @@
+-- username_normalized holds the canonical (lowercased) username.
+-- The unique constraint makes usernames differing only by case collide.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_normalized VARCHAR(255);
+
+-- Existing usernames differing only by case would violate the constraint: the oldest user keeps the username
+-- and the others are renamed with the start of their id as suffix (e.g. "JDoe-1b9d6bcd"). Renamed users log in
+-- by email or with the new username, and should be told about it.
+WITH duplicates AS (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(username) ORDER BY created_at, id) AS rank
+    FROM users
+)
+UPDATE users SET username = users.username || '-' || LEFT(users.id::text, 8)
+FROM duplicates
+WHERE users.id = duplicates.id AND duplicates.rank > 1;
+
+UPDATE users SET username_normalized = LOWER(username);
+
+ALTER TABLE users ALTER COLUMN username_normalized SET NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_username_normalized_key UNIQUE (username_normalized);
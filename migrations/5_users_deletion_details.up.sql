@@ -0,0 +1,3 @@
+-- deletion_reason and deleted_by record why and by whom a user was soft deleted.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_by VARCHAR(255) NOT NULL DEFAULT '';
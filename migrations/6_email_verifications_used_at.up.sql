@@ -0,0 +1,2 @@
+-- used_at records when a verification code was confirmed, so codes can only be used once.
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS used_at TIMESTAMP;
@@ -0,0 +1,3 @@
+-- email_ciphertext holds the encrypted email when emails are encrypted at rest,
+-- in which case the email column holds a keyed hash of the email used for lookups.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_ciphertext TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- tokens_valid_after invalidates the tokens issued before it, e.g. on password change.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP;
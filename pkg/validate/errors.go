@@ -17,4 +17,7 @@ var (
 	errPasswordFormat    = errors.New("password must contain at least one number, one letter and one special character")
 	errPasswordLength    = errors.New("password must be between 8 and 64 characters")
 	errPasswordRequired  = errors.New("password is required")
+	errUsernameFormat    = errors.New("username must only contain letters, digits, dots, underscores and hyphens")
+	errUsernameLength    = errors.New("username must be between 3 and 64 characters")
+	errUsernameRequired  = errors.New("username is required")
 )
@@ -0,0 +1,134 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBcryptPasswordLength is the number of bytes of a password bcrypt hashes, ignoring the rest
+const MaxBcryptPasswordLength = 72
+
+// PasswordRule identifies the rule of a password policy a password violates
+type PasswordRule string
+
+const (
+	PasswordRuleRequired   PasswordRule = "required"
+	PasswordRuleMinLength  PasswordRule = "min_length"
+	PasswordRuleMaxLength  PasswordRule = "max_length"
+	PasswordRuleLetter     PasswordRule = "letter"
+	PasswordRuleUpper      PasswordRule = "upper"
+	PasswordRuleLower      PasswordRule = "lower"
+	PasswordRuleNumber     PasswordRule = "number"
+	PasswordRuleSpecial    PasswordRule = "special"
+	PasswordRuleBannedWord PasswordRule = "banned_word"
+)
+
+// DefaultPasswordPolicy holds the rules of Password, with the maximum length raised from 64 characters
+// to the 72 bytes bcrypt hashes. Argon2id hashes (see users.NewArgon2idHasher) cover the whole password,
+// so policies used along with them can allow longer passwords.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:      8,
+	MaxLength:      MaxBcryptPasswordLength,
+	RequireLetter:  true,
+	RequireNumber:  true,
+	RequireSpecial: true,
+}
+
+// PasswordPolicy is a configurable set of password rules. Zero values disable their rule.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters
+	MinLength int
+
+	// MaxLength is the maximum number of bytes, at most MaxBcryptPasswordLength for bcrypt hashes.
+	// Argon2id hashes have no such limit.
+	MaxLength int
+
+	RequireLetter  bool
+	RequireUpper   bool
+	RequireLower   bool
+	RequireNumber  bool
+	RequireSpecial bool
+
+	// BannedWords are rejected when found anywhere in the password, ignoring the case
+	BannedWords []string
+}
+
+// PasswordViolation is the error returned for a password violating a rule of a password policy
+type PasswordViolation struct {
+	Rule    PasswordRule
+	Message string
+}
+
+func (v *PasswordViolation) Error() string {
+	return v.Message
+}
+
+// Validate checks the password against the rules of the policy and returns a *PasswordViolation
+// for the first rule the password violates
+func (p PasswordPolicy) Validate(password string) error {
+	if password == "" {
+		return &PasswordViolation{Rule: PasswordRuleRequired, Message: "password is required"}
+	}
+
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		return &PasswordViolation{
+			Rule:    PasswordRuleMinLength,
+			Message: fmt.Sprintf("password must be at least %d characters", p.MinLength),
+		}
+	}
+
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return &PasswordViolation{
+			Rule:    PasswordRuleMaxLength,
+			Message: fmt.Sprintf("password must be at most %d bytes", p.MaxLength),
+		}
+	}
+
+	var hasLetter, hasUpper, hasLower, hasNumber, hasSpecial bool
+
+	for _, char := range password {
+		switch {
+		case unicode.IsLetter(char):
+			hasLetter = true
+			hasUpper = hasUpper || unicode.IsUpper(char)
+			hasLower = hasLower || unicode.IsLower(char)
+		case unicode.IsNumber(char):
+			hasNumber = true
+		default:
+			hasSpecial = true
+		}
+	}
+
+	classes := []struct {
+		required bool
+		present  bool
+		rule     PasswordRule
+		message  string
+	}{
+		{p.RequireLetter, hasLetter, PasswordRuleLetter, "password must contain at least one letter"},
+		{p.RequireUpper, hasUpper, PasswordRuleUpper, "password must contain at least one uppercase letter"},
+		{p.RequireLower, hasLower, PasswordRuleLower, "password must contain at least one lowercase letter"},
+		{p.RequireNumber, hasNumber, PasswordRuleNumber, "password must contain at least one number"},
+		{p.RequireSpecial, hasSpecial, PasswordRuleSpecial, "password must contain at least one special character"},
+	}
+
+	for _, class := range classes {
+		if class.required && !class.present {
+			return &PasswordViolation{Rule: class.rule, Message: class.message}
+		}
+	}
+
+	lowered := strings.ToLower(password)
+
+	for _, word := range p.BannedWords {
+		if word != "" && strings.Contains(lowered, strings.ToLower(word)) {
+			return &PasswordViolation{
+				Rule:    PasswordRuleBannedWord,
+				Message: fmt.Sprintf("password must not contain %q", word),
+			}
+		}
+	}
+	return nil
+}
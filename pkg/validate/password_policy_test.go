@@ -0,0 +1,117 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	strict := PasswordPolicy{
+		MinLength:      10,
+		MaxLength:      MaxBcryptPasswordLength,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireNumber:  true,
+		RequireSpecial: true,
+		BannedWords:    []string{"acme", "password"},
+	}
+
+	testCases := []struct {
+		name         string
+		givenPolicy  PasswordPolicy
+		given        string
+		expectedRule PasswordRule
+	}{
+		{
+			name:        "valid",
+			givenPolicy: strict,
+			given:       "Correct-horse-7",
+		},
+		{
+			name:         "empty",
+			givenPolicy:  PasswordPolicy{},
+			given:        "",
+			expectedRule: PasswordRuleRequired,
+		},
+		{
+			name:         "too short",
+			givenPolicy:  strict,
+			given:        "Abcd-123",
+			expectedRule: PasswordRuleMinLength,
+		},
+		{
+			name:         "min length counts characters",
+			givenPolicy:  PasswordPolicy{MinLength: 4},
+			given:        "ééé",
+			expectedRule: PasswordRuleMinLength,
+		},
+		{
+			name:         "longer than bcrypt hashes",
+			givenPolicy:  strict,
+			given:        "Abcd-123" + strings.Repeat("a", MaxBcryptPasswordLength),
+			expectedRule: PasswordRuleMaxLength,
+		},
+		{
+			name:         "no uppercase letter",
+			givenPolicy:  strict,
+			given:        "correct-horse-7",
+			expectedRule: PasswordRuleUpper,
+		},
+		{
+			name:         "no lowercase letter",
+			givenPolicy:  strict,
+			given:        "CORRECT-HORSE-7",
+			expectedRule: PasswordRuleLower,
+		},
+		{
+			name:         "no number",
+			givenPolicy:  strict,
+			given:        "Correct-horse-x",
+			expectedRule: PasswordRuleNumber,
+		},
+		{
+			name:         "no special character",
+			givenPolicy:  strict,
+			given:        "Correcthorse7",
+			expectedRule: PasswordRuleSpecial,
+		},
+		{
+			name:         "no letter",
+			givenPolicy:  DefaultPasswordPolicy,
+			given:        "0123456789!@",
+			expectedRule: PasswordRuleLetter,
+		},
+		{
+			name:         "banned word in another case",
+			givenPolicy:  strict,
+			given:        "My-ACME-login-7",
+			expectedRule: PasswordRuleBannedWord,
+		},
+		{
+			name:        "empty policy",
+			givenPolicy: PasswordPolicy{},
+			given:       "a",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.givenPolicy.Validate(tc.given)
+
+			if tc.expectedRule == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var violation *PasswordViolation
+			require.True(t, errors.As(err, &violation), err)
+			assert.Equal(t, tc.expectedRule, violation.Rule)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package validate
 
 import (
 	"net/mail"
+	"strings"
 	"time"
 	"unicode"
 
@@ -12,6 +13,9 @@ const (
 	minFullnameLen = 3
 	maxFullnameLen = 64
 
+	minUsernameLen = 3
+	maxUsernameLen = 64
+
 	birthdateFormat string = "2006-01-02"
 )
 
@@ -32,6 +36,24 @@ func Fullname(name string) error {
 	return nil
 }
 
+// Username validates a username: letters, digits, dots, underscores and hyphens, without spaces
+func Username(username string) error {
+	if username == "" {
+		return errUsernameRequired
+	}
+
+	for _, char := range username {
+		if !unicode.IsLetter(char) && !unicode.IsDigit(char) && !strings.ContainsRune("._-", char) {
+			return errUsernameFormat
+		}
+	}
+
+	if len(username) < minUsernameLen || len(username) > maxUsernameLen {
+		return errUsernameLength
+	}
+	return nil
+}
+
 func Birthdate(bDate string) error {
 	if bDate == "" {
 		return errBirthdateRequired
@@ -50,6 +50,49 @@ func TestFullname(t *testing.T) {
 	}
 }
 
+func TestUsername(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected error
+	}{
+		{
+			name:     "valid",
+			given:    "john.doe_42",
+			expected: nil,
+		},
+		{
+			name:     "empty",
+			given:    "",
+			expected: errUsernameRequired,
+		},
+		{
+			name:     "too short",
+			given:    "jd",
+			expected: errUsernameLength,
+		},
+		{
+			name:     "spaces",
+			given:    "John Doe",
+			expected: errUsernameFormat,
+		},
+		{
+			name:     "invalid characters",
+			given:    "jdoe*",
+			expected: errUsernameFormat,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := Username(tc.given)
+			assert.Equal(t, actual, tc.expected)
+		})
+	}
+}
+
 func TestBirthdate(t *testing.T) {
 	t.Parallel()
 
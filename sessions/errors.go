@@ -0,0 +1,22 @@
+package sessions
+
+type E struct {
+	msg string
+}
+
+func newE(msg string) E {
+	return E{msg: msg}
+}
+
+func (e E) Error() string {
+	return e.msg
+}
+
+var (
+	// Enumerate service errors
+
+	errSessionExpired  = newE("session is expired")
+	errSessionInvalid  = newE("session is invalid")
+	errSessionNotFound = newE("session not found")
+	errUserIDInvalid   = newE("session user id is invalid")
+)
@@ -0,0 +1,25 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+)
+
+// userIDField returns the log field of a user id, masked so that log lines can be
+// correlated without identifying the user
+func userIDField(id string) zap.Field {
+	return zap.String("user_id", maskID(id))
+}
+
+// maskID replaces an id with the first 12 hex characters of its SHA-256, the same masking as the users service
+// so that log lines of both services can be correlated
+func maskID(id string) string {
+	if id == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])[:12]
+}
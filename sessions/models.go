@@ -0,0 +1,31 @@
+package sessions
+
+import "time"
+
+// Session represents a server-side session of a user
+type Session struct {
+	// ID is the SHA-256 digest of the session token, identifying the session without exposing the token
+	ID        string
+	UserID    string
+	UserAgent string
+	IPAddress string
+	CreatedAt time.Time
+	// LastSeenAt is the last time the session was used, updated along with the sliding expiration
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// CreateSessionInput represents the input for creating a session.
+// The user agent and ip address let users recognize their devices when listing their sessions.
+type CreateSessionInput struct {
+	UserID    string
+	UserAgent string
+	IPAddress string
+}
+
+// CreateSessionResponse represents a created session along with its token
+type CreateSessionResponse struct {
+	*Session
+	// Token is the session token handed to the client (e.g. in a cookie). It is only returned on creation.
+	Token string
+}
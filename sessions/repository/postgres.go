@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// Enumerate postgresql query strings
+
+	sessionColumns string = "id,user_id,user_agent,ip_address,created_at,last_seen_at,expires_at"
+
+	insertSessionQuery string = "INSERT INTO server_sessions (" + sessionColumns + ") VALUES ($1,$2,$3,$4,$5,$6,$7);"
+
+	selectSessionQuery string = "SELECT " + sessionColumns + " FROM server_sessions WHERE id = $1;"
+
+	selectSessionsByUserIDQuery string = "SELECT " + sessionColumns + " FROM server_sessions WHERE user_id = $1;"
+
+	touchSessionQuery string = "UPDATE server_sessions SET last_seen_at = $2, expires_at = $3 WHERE id = $1;"
+
+	deleteSessionQuery string = "DELETE FROM server_sessions WHERE id = $1;"
+
+	deleteExpiredSessionsQuery string = "DELETE FROM server_sessions WHERE expires_at <= $1;"
+)
+
+// Postgres represents a session repository instance with the given database connection
+type Postgres struct{ *sqlx.DB }
+
+// NewPostgres creates a new session repository instance
+func NewPostgres(dbConn *sqlx.DB) *Postgres {
+	return &Postgres{dbConn}
+}
+
+// Insert inserts a new session
+func (p *Postgres) Insert(ctx context.Context, session Session) error {
+	if _, err := p.ExecContext(ctx, insertSessionQuery,
+		session.ID, session.UserID, session.UserAgent, session.IPAddress, session.CreatedAt, session.LastSeenAt, session.ExpiresAt,
+	); err != nil {
+		return fmt.Errorf("could not insert session: %w", err)
+	}
+	return nil
+}
+
+// SelectByID selects the session with the id. It returns nil if the session does not exist.
+func (p *Postgres) SelectByID(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	if err := scanSession(p.QueryRowContext(ctx, selectSessionQuery, id), &session); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select session: %w", err)
+	}
+	return &session, nil
+}
+
+// SelectByUserID selects the sessions of a user
+func (p *Postgres) SelectByUserID(ctx context.Context, userID string) ([]Session, error) {
+	rows, err := p.QueryContext(ctx, selectSessionsByUserIDQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, fmt.Errorf("could not scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// UpdateLastSeenAt updates the last use and the expiration of a session
+func (p *Postgres) UpdateLastSeenAt(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error {
+	if _, err := p.ExecContext(ctx, touchSessionQuery, id, lastSeenAt, expiresAt); err != nil {
+		return fmt.Errorf("could not update session: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes a session
+func (p *Postgres) Delete(ctx context.Context, id string) error {
+	if _, err := p.ExecContext(ctx, deleteSessionQuery, id); err != nil {
+		return fmt.Errorf("could not delete session: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired deletes the sessions expired at now and returns how many were deleted.
+// Expired sessions are otherwise only deleted as they are used, so it is meant to be run periodically.
+func (p *Postgres) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res, err := p.ExecContext(ctx, deleteExpiredSessionsQuery, now)
+	if err != nil {
+		return 0, fmt.Errorf("could not delete expired sessions: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not get rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSession scans a row holding the session columns
+func scanSession(row scanner, session *Session) error {
+	return row.Scan(
+		&session.ID, &session.UserID, &session.UserAgent, &session.IPAddress,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt,
+	)
+}
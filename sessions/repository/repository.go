@@ -0,0 +1,14 @@
+package repository
+
+import "time"
+
+// Session represents a server-side session in the database table, identified by the digest of its token
+type Session struct {
+	ID         string
+	UserID     string
+	UserAgent  string
+	IPAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
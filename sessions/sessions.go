@@ -0,0 +1,234 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/alesr/stdservices/pkg/validate"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultIdleTimeout = 24 * time.Hour
+	defaultMaxLifetime = 30 * 24 * time.Hour
+
+	// touchInterval is the minimum extension of the expiration worth writing to the store,
+	// sparing a write on every request of an active session
+	touchInterval = time.Minute
+
+	tokenLen = 32
+)
+
+var _ Service = (*DefaultService)(nil)
+
+// Service defines the session service interface
+type Service interface {
+	// CreateSession creates a session for the user, returned along with the token handed to the client
+	CreateSession(ctx context.Context, in CreateSessionInput) (*CreateSessionResponse, error)
+
+	// GetSession returns the session of the token, extending its expiration
+	GetSession(ctx context.Context, token string) (*Session, error)
+
+	// ListSessions lists the active sessions of the user, oldest first
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+
+	// RevokeSession revokes a session of the user, logging out its device right away
+	RevokeSession(ctx context.Context, userID, id string) error
+}
+
+type ServiceOption func(*DefaultService)
+
+// WithIdleTimeout sets how long a session lasts without being used, replacing the default of 24 hours.
+// Each use of the session pushes its expiration back by the idle timeout (sliding expiration).
+func WithIdleTimeout(timeout time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithMaxLifetime sets how long a session lasts at most since its creation, however active,
+// replacing the default of 30 days
+func WithMaxLifetime(lifetime time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.maxLifetime = lifetime
+	}
+}
+
+type DefaultService struct {
+	logger      *zap.Logger
+	store       Store
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	clock       func() time.Time
+}
+
+// New instantiates a new session service keeping the sessions in the store
+// (see NewMemoryStore, NewRedisStore and NewPostgresStore)
+func New(logger *zap.Logger, store Store, opts ...ServiceOption) *DefaultService {
+	service := DefaultService{
+		logger:      logger,
+		store:       store,
+		idleTimeout: defaultIdleTimeout,
+		maxLifetime: defaultMaxLifetime,
+		clock:       time.Now,
+	}
+
+	if service.logger == nil {
+		service.logger = zap.NewNop()
+	}
+
+	for _, opt := range opts {
+		opt(&service)
+	}
+	return &service
+}
+
+// CreateSession creates a session for the user. The returned token is random and only
+// its digest is stored, so a leaked store doesn't let an attacker impersonate the users.
+func (s *DefaultService) CreateSession(ctx context.Context, in CreateSessionInput) (*CreateSessionResponse, error) {
+	if err := validate.ID(in.UserID); err != nil {
+		return nil, errUserIDInvalid
+	}
+
+	b := make([]byte, tokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("could not read random source: %s", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := s.clock().UTC()
+
+	session := Session{
+		ID:         tokenDigest(token),
+		UserID:     in.UserID,
+		UserAgent:  in.UserAgent,
+		IPAddress:  in.IPAddress,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	session.ExpiresAt = s.expiresAt(&session, now)
+
+	if err := s.store.Create(ctx, session); err != nil {
+		s.logger.Error("could not create session", zap.String("operation", "create_session"), zap.Error(err))
+		return nil, fmt.Errorf("could not create session: %s", err)
+	}
+
+	s.logger.Info("session created", zap.String("operation", "create_session"), userIDField(in.UserID))
+
+	return &CreateSessionResponse{Session: &session, Token: token}, nil
+}
+
+// GetSession returns the session of the token. Each use pushes the expiration back by the idle
+// timeout, up to the maximum lifetime of the session. Unknown and revoked sessions fail with errSessionInvalid.
+func (s *DefaultService) GetSession(ctx context.Context, token string) (*Session, error) {
+	if token == "" {
+		return nil, errSessionInvalid
+	}
+
+	id := tokenDigest(token)
+
+	session, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not get session: %s", err)
+	}
+
+	if session == nil {
+		return nil, errSessionInvalid
+	}
+
+	now := s.clock().UTC()
+
+	if !now.Before(session.ExpiresAt) {
+		if err := s.store.Delete(ctx, id); err != nil {
+			s.logger.Error("could not delete expired session", zap.String("operation", "get_session"), zap.Error(err))
+		}
+		return nil, errSessionExpired
+	}
+
+	if expiresAt := s.expiresAt(session, now); expiresAt.Sub(session.ExpiresAt) >= touchInterval {
+		// A failed extension only shortens the session, so the session is still returned
+		if err := s.store.Touch(ctx, id, now, expiresAt); err != nil {
+			s.logger.Error("could not extend session", zap.String("operation", "get_session"), zap.Error(err))
+		} else {
+			session.LastSeenAt, session.ExpiresAt = now, expiresAt
+		}
+	}
+	return session, nil
+}
+
+// ListSessions lists the active sessions of the user, oldest first, letting the user review
+// the devices logged in to their account
+func (s *DefaultService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	if err := validate.ID(userID); err != nil {
+		return nil, errUserIDInvalid
+	}
+
+	storageSessions, err := s.store.ListByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list sessions: %s", err)
+	}
+
+	now := s.clock().UTC()
+
+	sessions := make([]Session, 0, len(storageSessions))
+	for _, session := range storageSessions {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].ID < sessions[j].ID
+		}
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user by id, as listed by ListSessions.
+// Sessions of other users and unknown sessions fail alike with errSessionNotFound.
+func (s *DefaultService) RevokeSession(ctx context.Context, userID, id string) error {
+	if err := validate.ID(userID); err != nil {
+		return errUserIDInvalid
+	}
+
+	session, err := s.store.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("could not get session: %s", err)
+	}
+
+	if session == nil || session.UserID != userID {
+		return errSessionNotFound
+	}
+
+	if err := s.store.Delete(ctx, id); err != nil {
+		s.logger.Error("could not delete session", zap.String("operation", "revoke_session"), zap.Error(err))
+		return fmt.Errorf("could not delete session: %s", err)
+	}
+
+	s.logger.Info("session revoked", zap.String("operation", "revoke_session"), userIDField(userID))
+	return nil
+}
+
+// expiresAt returns the expiration of the session used at now: the idle timeout
+// from now, capped by the maximum lifetime of the session
+func (s *DefaultService) expiresAt(session *Session, now time.Time) time.Time {
+	expiresAt := now.Add(s.idleTimeout)
+	if deadline := session.CreatedAt.Add(s.maxLifetime); deadline.Before(expiresAt) {
+		return deadline
+	}
+	return expiresAt
+}
+
+// tokenDigest returns the hex encoded SHA-256 digest of a session token
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+)
+
+var _ Service = (*MockService)(nil)
+
+type MockService struct {
+	CreateSessionFunc func(ctx context.Context, in CreateSessionInput) (*CreateSessionResponse, error)
+	GetSessionFunc    func(ctx context.Context, token string) (*Session, error)
+	ListSessionsFunc  func(ctx context.Context, userID string) ([]Session, error)
+	RevokeSessionFunc func(ctx context.Context, userID, id string) error
+}
+
+func (m *MockService) CreateSession(ctx context.Context, in CreateSessionInput) (*CreateSessionResponse, error) {
+	if m.CreateSessionFunc == nil {
+		return nil, errors.New("MockService.CreateSessionFunc is nil")
+	}
+	return m.CreateSessionFunc(ctx, in)
+}
+
+func (m *MockService) GetSession(ctx context.Context, token string) (*Session, error) {
+	if m.GetSessionFunc == nil {
+		return nil, errors.New("MockService.GetSessionFunc is nil")
+	}
+	return m.GetSessionFunc(ctx, token)
+}
+
+func (m *MockService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	if m.ListSessionsFunc == nil {
+		return nil, errors.New("MockService.ListSessionsFunc is nil")
+	}
+	return m.ListSessionsFunc(ctx, userID)
+}
+
+func (m *MockService) RevokeSession(ctx context.Context, userID, id string) error {
+	if m.RevokeSessionFunc == nil {
+		return errors.New("MockService.RevokeSessionFunc is nil")
+	}
+	return m.RevokeSessionFunc(ctx, userID, id)
+}
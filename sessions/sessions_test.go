@@ -0,0 +1,225 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCreateSession(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("session is created", func(t *testing.T) {
+		t.Parallel()
+
+		store := NewMemoryStore()
+
+		svc := New(zap.NewNop(), store, WithIdleTimeout(time.Hour))
+		svc.clock = func() time.Time { return now }
+
+		in := CreateSessionInput{UserID: uuid.New().String(), UserAgent: "curl/7.79.1", IPAddress: "127.0.0.1"}
+
+		resp, err := svc.CreateSession(context.Background(), in)
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, resp.Token)
+		assert.Equal(t, in.UserID, resp.UserID)
+		assert.Equal(t, in.UserAgent, resp.UserAgent)
+		assert.Equal(t, now.Add(time.Hour), resp.ExpiresAt)
+
+		// Only the digest of the token is stored
+		assert.Equal(t, tokenDigest(resp.Token), resp.ID)
+
+		stored, err := store.Get(context.Background(), resp.ID)
+		require.NoError(t, err)
+		assert.Equal(t, resp.Session, stored)
+	})
+
+	t.Run("user id is masked in the logs", func(t *testing.T) {
+		t.Parallel()
+
+		core, logs := observer.New(zapcore.DebugLevel)
+
+		in := CreateSessionInput{UserID: uuid.New().String()}
+
+		_, err := New(zap.New(core), NewMemoryStore()).CreateSession(context.Background(), in)
+		require.NoError(t, err)
+
+		entries := logs.FilterField(zap.String("user_id", maskID(in.UserID))).All()
+		require.Len(t, entries, 1)
+		assert.NotContains(t, entries[0].ContextMap()["user_id"], in.UserID)
+	})
+
+	t.Run("expiration is capped by the max lifetime", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), NewMemoryStore(), WithIdleTimeout(time.Hour), WithMaxLifetime(time.Minute))
+		svc.clock = func() time.Time { return now }
+
+		resp, err := svc.CreateSession(context.Background(), CreateSessionInput{UserID: uuid.New().String()})
+		require.NoError(t, err)
+
+		assert.Equal(t, now.Add(time.Minute), resp.ExpiresAt)
+	})
+
+	t.Run("invalid user id", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), NewMemoryStore())
+
+		_, err := svc.CreateSession(context.Background(), CreateSessionInput{UserID: "foo"})
+		assert.Equal(t, errUserIDInvalid, err)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), &storeMock{
+			createFunc: func(ctx context.Context, session Session) error {
+				return errors.New("connection refused")
+			},
+		})
+
+		_, err := svc.CreateSession(context.Background(), CreateSessionInput{UserID: uuid.New().String()})
+		assert.Error(t, err)
+	})
+}
+
+func TestGetSession(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	svc := New(zap.NewNop(), NewMemoryStore(), WithIdleTimeout(time.Hour), WithMaxLifetime(2*time.Hour))
+	svc.clock = func() time.Time { return now }
+
+	created, err := svc.CreateSession(context.Background(), CreateSessionInput{UserID: uuid.New().String()})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name              string
+		givenElapsed      time.Duration
+		expectedExpiresAt time.Time
+		expectedErr       error
+	}{
+		{
+			name:              "recent use doesn't extend the session",
+			givenElapsed:      time.Second,
+			expectedExpiresAt: now.Add(time.Hour),
+		},
+		{
+			name:              "use extends the session",
+			givenElapsed:      50 * time.Minute,
+			expectedExpiresAt: now.Add(110 * time.Minute),
+		},
+		{
+			name:              "extension is capped by the max lifetime",
+			givenElapsed:      100 * time.Minute,
+			expectedExpiresAt: now.Add(2 * time.Hour),
+		},
+		{
+			name:         "session expires",
+			givenElapsed: 2 * time.Hour,
+			expectedErr:  errSessionExpired,
+		},
+		{
+			name:         "expired session is deleted",
+			givenElapsed: 2 * time.Hour,
+			expectedErr:  errSessionInvalid,
+		},
+	}
+
+	// The test cases run in order, each using the session after the previous one
+	for _, tc := range testCases {
+		svc.clock = func() time.Time { return now.Add(tc.givenElapsed) }
+
+		actual, err := svc.GetSession(context.Background(), created.Token)
+		assert.Equal(t, tc.expectedErr, err, tc.name)
+
+		if tc.expectedErr == nil {
+			require.NotNil(t, actual, tc.name)
+			assert.Equal(t, tc.expectedExpiresAt, actual.ExpiresAt, tc.name)
+		}
+	}
+
+	t.Run("unknown token", func(t *testing.T) {
+		_, err := svc.GetSession(context.Background(), "foo")
+		assert.Equal(t, errSessionInvalid, err)
+
+		_, err = svc.GetSession(context.Background(), "")
+		assert.Equal(t, errSessionInvalid, err)
+	})
+}
+
+func TestListSessions(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	userID := uuid.New().String()
+
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	svc := New(zap.NewNop(), store, WithIdleTimeout(time.Hour))
+
+	var created []*CreateSessionResponse
+	for i, userAgent := range []string{"phone", "laptop", "tablet"} {
+		svc.clock = func() time.Time { return now.Add(time.Duration(i) * time.Minute) }
+
+		resp, err := svc.CreateSession(context.Background(), CreateSessionInput{UserID: userID, UserAgent: userAgent})
+		require.NoError(t, err)
+
+		created = append(created, resp)
+	}
+
+	_, err := svc.CreateSession(context.Background(), CreateSessionInput{UserID: uuid.New().String()})
+	require.NoError(t, err)
+
+	// The first session expires
+	svc.clock = func() time.Time { return now.Add(time.Hour) }
+
+	actual, err := svc.ListSessions(context.Background(), userID)
+	require.NoError(t, err)
+
+	require.Len(t, actual, 2)
+	assert.Equal(t, *created[1].Session, actual[0])
+	assert.Equal(t, *created[2].Session, actual[1])
+
+	t.Run("invalid user id", func(t *testing.T) {
+		_, err := svc.ListSessions(context.Background(), "foo")
+		assert.Equal(t, errUserIDInvalid, err)
+	})
+}
+
+func TestRevokeSession(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.New().String()
+
+	svc := New(zap.NewNop(), NewMemoryStore())
+
+	created, err := svc.CreateSession(context.Background(), CreateSessionInput{UserID: userID})
+	require.NoError(t, err)
+
+	// Sessions are only revoked by their user
+	err = svc.RevokeSession(context.Background(), uuid.New().String(), created.ID)
+	assert.Equal(t, errSessionNotFound, err)
+
+	require.NoError(t, svc.RevokeSession(context.Background(), userID, created.ID))
+
+	_, err = svc.GetSession(context.Background(), created.Token)
+	assert.Equal(t, errSessionInvalid, err)
+
+	err = svc.RevokeSession(context.Background(), userID, created.ID)
+	assert.Equal(t, errSessionNotFound, err)
+}
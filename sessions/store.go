@@ -0,0 +1,368 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alesr/stdservices/sessions/repository"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*RedisStore)(nil)
+	_ Store = (*PostgresStore)(nil)
+)
+
+// defaultRedisKeyPrefix prefixes the keys of the sessions stored in Redis
+const defaultRedisKeyPrefix = "sessions:"
+
+type (
+	// Store keeps the sessions. Implementations backed by a shared store (Postgres, Redis)
+	// share the sessions across service replicas.
+	Store interface {
+		// Create stores a new session
+		Create(ctx context.Context, session Session) error
+
+		// Get returns the session with the id, or nil if there is none.
+		// Expired sessions may be returned until they are deleted.
+		Get(ctx context.Context, id string) (*Session, error)
+
+		// ListByUserID lists the sessions of a user, in any order.
+		// Expired sessions may be listed until they are deleted.
+		ListByUserID(ctx context.Context, userID string) ([]Session, error)
+
+		// Touch records the last use of a session and extends its expiration.
+		// Unknown sessions are ignored.
+		Touch(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error
+
+		// Delete deletes a session. Unknown sessions are ignored.
+		Delete(ctx context.Context, id string) error
+	}
+
+	// RedisClient is the subset of a Redis client used by RedisStore,
+	// letting callers adapt the client library of their choice
+	RedisClient interface {
+		// Get returns the value of key, reporting false if key is not set
+		Get(ctx context.Context, key string) (string, bool, error)
+
+		// Set sets key to value, expiring it after ttl
+		Set(ctx context.Context, key, value string, ttl time.Duration) error
+
+		// SetXX sets key to value, expiring it after ttl, only if key is set (SET with XX),
+		// reporting whether it was set
+		SetXX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+
+		// Del deletes key
+		Del(ctx context.Context, key string) error
+
+		// SAdd adds member to the set at key
+		SAdd(ctx context.Context, key, member string) error
+
+		// SRem removes member from the set at key
+		SRem(ctx context.Context, key, member string) error
+
+		// SMembers returns the members of the set at key
+		SMembers(ctx context.Context, key string) ([]string, error)
+	}
+)
+
+// MemoryStore is a Store keeping the sessions in memory, which suits tests and
+// single instance deployments. It is safe for concurrent use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+	now      func() time.Time
+}
+
+// NewMemoryStore returns an empty in-memory session store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]Session),
+		now:      time.Now,
+	}
+}
+
+// Create stores a new session, dropping the expired sessions
+func (m *MemoryStore) Create(_ context.Context, session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for id, s := range m.sessions {
+		if !now.Before(s.ExpiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.sessions[session.ID] = session
+	return nil
+}
+
+// Get returns the session with the id, or nil if there is none
+func (m *MemoryStore) Get(_ context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// ListByUserID lists the sessions of a user
+func (m *MemoryStore) ListByUserID(_ context.Context, userID string) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []Session
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+// Touch records the last use of a session and extends its expiration
+func (m *MemoryStore) Touch(_ context.Context, id string, lastSeenAt, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil
+	}
+
+	session.LastSeenAt, session.ExpiresAt = lastSeenAt, expiresAt
+	m.sessions[id] = session
+	return nil
+}
+
+// Delete deletes a session
+func (m *MemoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// RedisStore is a Store keeping the sessions in Redis, as JSON encoded keys expiring along with
+// the sessions. The ids of the sessions of each user are indexed in a set, from which
+// the expired sessions are removed as the sessions of the user are listed.
+type RedisStore struct {
+	client RedisClient
+	prefix string
+	now    func() time.Time
+}
+
+// NewRedisStore returns a session store backed by the Redis client.
+// Keys are prefixed with prefix, or with "sessions:" when prefix is empty.
+func NewRedisStore(client RedisClient, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+// Create sets the key of the session, expiring it at the session expiration,
+// and indexes the session in the set of its user
+func (r *RedisStore) Create(ctx context.Context, session Session) error {
+	if err := r.set(ctx, session); err != nil {
+		return err
+	}
+
+	if err := r.client.SAdd(ctx, r.userKey(session.UserID), session.ID); err != nil {
+		return fmt.Errorf("could not index session: %w", err)
+	}
+	return nil
+}
+
+// Get returns the session with the id, or nil if its key is not set
+func (r *RedisStore) Get(ctx context.Context, id string) (*Session, error) {
+	value, ok, err := r.client.Get(ctx, r.sessionKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("could not get session: %w", err)
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return nil, fmt.Errorf("could not decode session: %w", err)
+	}
+	return &session, nil
+}
+
+// ListByUserID lists the sessions indexed in the set of the user,
+// removing the expired sessions from the set
+func (r *RedisStore) ListByUserID(ctx context.Context, userID string) ([]Session, error) {
+	ids, err := r.client.SMembers(ctx, r.userKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("could not list sessions: %w", err)
+	}
+
+	var sessions []Session
+	for _, id := range ids {
+		session, err := r.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if session == nil {
+			if err := r.client.SRem(ctx, r.userKey(userID), id); err != nil {
+				return nil, fmt.Errorf("could not unindex session: %w", err)
+			}
+			continue
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+// Touch records the last use of a session and resets the expiration of its key.
+// The key is only overwritten if it is still set, so a session deleted concurrently
+// (e.g. revoked) is not written back.
+func (r *RedisStore) Touch(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error {
+	session, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if session == nil {
+		return nil
+	}
+
+	session.LastSeenAt, session.ExpiresAt = lastSeenAt, expiresAt
+
+	ttl := session.ExpiresAt.Sub(r.now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("could not encode session: %w", err)
+	}
+
+	if _, err := r.client.SetXX(ctx, r.sessionKey(id), string(value), ttl); err != nil {
+		return fmt.Errorf("could not set session: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes the key of a session and removes the session from the set of its user
+func (r *RedisStore) Delete(ctx context.Context, id string) error {
+	session, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if session == nil {
+		return nil
+	}
+
+	if err := r.client.Del(ctx, r.sessionKey(id)); err != nil {
+		return fmt.Errorf("could not delete session: %w", err)
+	}
+
+	if err := r.client.SRem(ctx, r.userKey(session.UserID), id); err != nil {
+		return fmt.Errorf("could not unindex session: %w", err)
+	}
+	return nil
+}
+
+// set sets the key of the session, expiring it at the session expiration.
+// Sessions already expired are not stored.
+func (r *RedisStore) set(ctx context.Context, session Session) error {
+	ttl := session.ExpiresAt.Sub(r.now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("could not encode session: %w", err)
+	}
+
+	if err := r.client.Set(ctx, r.sessionKey(session.ID), string(value), ttl); err != nil {
+		return fmt.Errorf("could not set session: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisStore) sessionKey(id string) string {
+	return r.prefix + "session:" + id
+}
+
+func (r *RedisStore) userKey(userID string) string {
+	return r.prefix + "user:" + userID
+}
+
+// PostgresStore is a Store keeping the sessions in the server_sessions table
+type PostgresStore struct {
+	repo *repository.Postgres
+}
+
+// NewPostgresStore returns a session store backed by the database connection
+func NewPostgresStore(dbConn *sqlx.DB) *PostgresStore {
+	return &PostgresStore{repo: repository.NewPostgres(dbConn)}
+}
+
+// Create inserts a new session
+func (p *PostgresStore) Create(ctx context.Context, session Session) error {
+	return p.repo.Insert(ctx, repository.Session(session))
+}
+
+// Get returns the session with the id, or nil if there is none
+func (p *PostgresStore) Get(ctx context.Context, id string) (*Session, error) {
+	session, err := p.repo.SelectByID(ctx, id)
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	s := Session(*session)
+	return &s, nil
+}
+
+// ListByUserID lists the sessions of a user
+func (p *PostgresStore) ListByUserID(ctx context.Context, userID string) ([]Session, error) {
+	sessions, err := p.repo.SelectByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Session
+	for _, s := range sessions {
+		out = append(out, Session(s))
+	}
+	return out, nil
+}
+
+// Touch records the last use of a session and extends its expiration
+func (p *PostgresStore) Touch(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error {
+	return p.repo.UpdateLastSeenAt(ctx, id, lastSeenAt, expiresAt)
+}
+
+// Delete deletes a session
+func (p *PostgresStore) Delete(ctx context.Context, id string) error {
+	return p.repo.Delete(ctx, id)
+}
+
+// DeleteExpired deletes the sessions expired at now and returns how many were deleted.
+// Expired sessions are otherwise only deleted as they are used, so it is meant to be run periodically.
+func (p *PostgresStore) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return p.repo.DeleteExpired(ctx, now)
+}
@@ -0,0 +1,114 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	_ Store       = (*storeMock)(nil)
+	_ RedisClient = (*redisClientMock)(nil)
+)
+
+type storeMock struct {
+	createFunc       func(ctx context.Context, session Session) error
+	getFunc          func(ctx context.Context, id string) (*Session, error)
+	listByUserIDFunc func(ctx context.Context, userID string) ([]Session, error)
+	touchFunc        func(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error
+	deleteFunc       func(ctx context.Context, id string) error
+}
+
+func (m *storeMock) Create(ctx context.Context, session Session) error {
+	if m.createFunc == nil {
+		return errors.New("storeMock.createFunc is nil")
+	}
+	return m.createFunc(ctx, session)
+}
+
+func (m *storeMock) Get(ctx context.Context, id string) (*Session, error) {
+	if m.getFunc == nil {
+		return nil, errors.New("storeMock.getFunc is nil")
+	}
+	return m.getFunc(ctx, id)
+}
+
+func (m *storeMock) ListByUserID(ctx context.Context, userID string) ([]Session, error) {
+	if m.listByUserIDFunc == nil {
+		return nil, errors.New("storeMock.listByUserIDFunc is nil")
+	}
+	return m.listByUserIDFunc(ctx, userID)
+}
+
+func (m *storeMock) Touch(ctx context.Context, id string, lastSeenAt, expiresAt time.Time) error {
+	if m.touchFunc == nil {
+		return errors.New("storeMock.touchFunc is nil")
+	}
+	return m.touchFunc(ctx, id, lastSeenAt, expiresAt)
+}
+
+func (m *storeMock) Delete(ctx context.Context, id string) error {
+	if m.deleteFunc == nil {
+		return errors.New("storeMock.deleteFunc is nil")
+	}
+	return m.deleteFunc(ctx, id)
+}
+
+type redisClientMock struct {
+	getFunc      func(ctx context.Context, key string) (string, bool, error)
+	setFunc      func(ctx context.Context, key, value string, ttl time.Duration) error
+	setXXFunc    func(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	delFunc      func(ctx context.Context, key string) error
+	sAddFunc     func(ctx context.Context, key, member string) error
+	sRemFunc     func(ctx context.Context, key, member string) error
+	sMembersFunc func(ctx context.Context, key string) ([]string, error)
+}
+
+func (m *redisClientMock) Get(ctx context.Context, key string) (string, bool, error) {
+	if m.getFunc == nil {
+		return "", false, errors.New("redisClientMock.getFunc is nil")
+	}
+	return m.getFunc(ctx, key)
+}
+
+func (m *redisClientMock) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if m.setFunc == nil {
+		return errors.New("redisClientMock.setFunc is nil")
+	}
+	return m.setFunc(ctx, key, value, ttl)
+}
+
+func (m *redisClientMock) SetXX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	if m.setXXFunc == nil {
+		return false, errors.New("redisClientMock.setXXFunc is nil")
+	}
+	return m.setXXFunc(ctx, key, value, ttl)
+}
+
+func (m *redisClientMock) Del(ctx context.Context, key string) error {
+	if m.delFunc == nil {
+		return errors.New("redisClientMock.delFunc is nil")
+	}
+	return m.delFunc(ctx, key)
+}
+
+func (m *redisClientMock) SAdd(ctx context.Context, key, member string) error {
+	if m.sAddFunc == nil {
+		return errors.New("redisClientMock.sAddFunc is nil")
+	}
+	return m.sAddFunc(ctx, key, member)
+}
+
+func (m *redisClientMock) SRem(ctx context.Context, key, member string) error {
+	if m.sRemFunc == nil {
+		return errors.New("redisClientMock.sRemFunc is nil")
+	}
+	return m.sRemFunc(ctx, key, member)
+}
+
+func (m *redisClientMock) SMembers(ctx context.Context, key string) ([]string, error) {
+	if m.sMembersFunc == nil {
+		return nil, errors.New("redisClientMock.sMembersFunc is nil")
+	}
+	return m.sMembersFunc(ctx, key)
+}
@@ -0,0 +1,175 @@
+package sessions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMemoryRedisClient returns a Redis client mock keeping the keys and sets in maps, ignoring expirations
+func newMemoryRedisClient(keys map[string]string, sets map[string]map[string]bool) *redisClientMock {
+	return &redisClientMock{
+		getFunc: func(ctx context.Context, key string) (string, bool, error) {
+			value, ok := keys[key]
+			return value, ok, nil
+		},
+		setFunc: func(ctx context.Context, key, value string, ttl time.Duration) error {
+			keys[key] = value
+			return nil
+		},
+		setXXFunc: func(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+			if _, ok := keys[key]; !ok {
+				return false, nil
+			}
+			keys[key] = value
+			return true, nil
+		},
+		delFunc: func(ctx context.Context, key string) error {
+			delete(keys, key)
+			return nil
+		},
+		sAddFunc: func(ctx context.Context, key, member string) error {
+			if sets[key] == nil {
+				sets[key] = make(map[string]bool)
+			}
+			sets[key][member] = true
+			return nil
+		},
+		sRemFunc: func(ctx context.Context, key, member string) error {
+			delete(sets[key], member)
+			return nil
+		},
+		sMembersFunc: func(ctx context.Context, key string) ([]string, error) {
+			var members []string
+			for member := range sets[key] {
+				members = append(members, member)
+			}
+			return members, nil
+		},
+	}
+}
+
+func TestRedisStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	keys := make(map[string]string)
+	sets := make(map[string]map[string]bool)
+
+	store := NewRedisStore(newMemoryRedisClient(keys, sets), "")
+	store.now = func() time.Time { return now }
+
+	session := Session{
+		ID:         "foo",
+		UserID:     "bar",
+		UserAgent:  "curl/7.79.1",
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+
+	require.NoError(t, store.Create(context.Background(), session))
+
+	assert.Contains(t, keys, "sessions:session:foo")
+	assert.True(t, sets["sessions:user:bar"]["foo"])
+
+	actual, err := store.Get(context.Background(), "foo")
+	require.NoError(t, err)
+	assert.Equal(t, &session, actual)
+
+	t.Run("touch", func(t *testing.T) {
+		require.NoError(t, store.Touch(context.Background(), "foo", now.Add(time.Minute), now.Add(2*time.Hour)))
+
+		actual, err := store.Get(context.Background(), "foo")
+		require.NoError(t, err)
+		assert.Equal(t, now.Add(time.Minute), actual.LastSeenAt)
+		assert.Equal(t, now.Add(2*time.Hour), actual.ExpiresAt)
+
+		// Unknown sessions are not created
+		require.NoError(t, store.Touch(context.Background(), "baz", now, now.Add(time.Hour)))
+		assert.NotContains(t, keys, "sessions:session:baz")
+	})
+
+	t.Run("touch does not restore a session deleted concurrently", func(t *testing.T) {
+		keys := make(map[string]string)
+		sets := make(map[string]map[string]bool)
+
+		client := newMemoryRedisClient(keys, sets)
+
+		store := NewRedisStore(client, "")
+		store.now = func() time.Time { return now }
+
+		require.NoError(t, store.Create(context.Background(), session))
+
+		// The session is deleted right after Touch reads it
+		get := client.getFunc
+		var deleted bool
+		client.getFunc = func(ctx context.Context, key string) (string, bool, error) {
+			value, ok, err := get(ctx, key)
+			if !deleted {
+				deleted = true
+				require.NoError(t, store.Delete(ctx, session.ID))
+			}
+			return value, ok, err
+		}
+
+		require.NoError(t, store.Touch(context.Background(), session.ID, now.Add(time.Minute), now.Add(2*time.Hour)))
+		assert.NotContains(t, keys, "sessions:session:foo")
+	})
+
+	t.Run("list drops the expired sessions from the index", func(t *testing.T) {
+		sets["sessions:user:bar"]["qux"] = true
+
+		actual, err := store.ListByUserID(context.Background(), "bar")
+		require.NoError(t, err)
+
+		require.Len(t, actual, 1)
+		assert.Equal(t, "foo", actual[0].ID)
+		assert.NotContains(t, sets["sessions:user:bar"], "qux")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, store.Delete(context.Background(), "foo"))
+
+		actual, err := store.Get(context.Background(), "foo")
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+		assert.Empty(t, sets["sessions:user:bar"])
+
+		require.NoError(t, store.Delete(context.Background(), "foo"))
+	})
+
+	t.Run("expired sessions are not stored", func(t *testing.T) {
+		expired := session
+		expired.ID, expired.ExpiresAt = "quux", now
+
+		require.NoError(t, store.Create(context.Background(), expired))
+		assert.NotContains(t, keys, "sessions:session:quux")
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Create(context.Background(), Session{ID: "foo", UserID: "bar", ExpiresAt: now.Add(time.Minute)}))
+	require.NoError(t, store.Touch(context.Background(), "foo", now, now.Add(time.Hour)))
+
+	// Expired sessions are dropped as sessions are created
+	store.now = func() time.Time { return now.Add(time.Hour) }
+	require.NoError(t, store.Create(context.Background(), Session{ID: "baz", UserID: "bar", ExpiresAt: now.Add(2 * time.Hour)}))
+
+	actual, err := store.ListByUserID(context.Background(), "bar")
+	require.NoError(t, err)
+
+	require.Len(t, actual, 1)
+	assert.Equal(t, "baz", actual[0].ID)
+}
@@ -0,0 +1,87 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// WithActionEndpoint sets the endpoint the signed action URLs point to
+// (e.g. "https://example.com/actions"). The action, user id, expiration
+// and signature are added to it as query parameters.
+func WithActionEndpoint(endpoint string) ServiceOption {
+	return func(s *DefaultService) {
+		s.actionEndpoint = endpoint
+	}
+}
+
+// GenerateSignedActionURL returns a URL authenticating the action for the user until ttl elapses.
+// The URL is signed with the HMAC key (see WithHMACKey) so it can be verified without storing it.
+func (s *DefaultService) GenerateSignedActionURL(action, userID string, ttl time.Duration) (string, error) {
+	if s.actionEndpoint == "" {
+		return "", errors.New("could not generate signed action url: action endpoint not configured")
+	}
+
+	if action == "" {
+		return "", errors.New("could not generate signed action url: action is empty")
+	}
+
+	if userID == "" {
+		return "", errors.New("could not generate signed action url: user id is empty")
+	}
+
+	u, err := url.Parse(s.actionEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("could not parse action endpoint: %s", err)
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+
+	signature, err := s.signHMAC("action", action+"\n"+userID+"\n"+expires)
+	if err != nil {
+		return "", fmt.Errorf("could not sign action url: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("action", action)
+	q.Set("user_id", userID)
+	q.Set("expires", expires)
+	q.Set("signature", signature)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// VerifySignedAction verifies a URL generated by GenerateSignedActionURL
+// and returns the action and user id it authenticates
+func (s *DefaultService) VerifySignedAction(rawURL string) (action, userID string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse signed action url: %s", err)
+	}
+
+	q := u.Query()
+
+	action, userID, expires := q.Get("action"), q.Get("user_id"), q.Get("expires")
+
+	valid, err := s.verifyHMAC("action", action+"\n"+userID+"\n"+expires, q.Get("signature"))
+	if err != nil {
+		return "", "", fmt.Errorf("could not verify signed action url: %w", err)
+	}
+
+	if !valid {
+		return "", "", errActionSignatureInvalid
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", "", errActionSignatureInvalid
+	}
+
+	if time.Unix(expiresAt, 0).Before(time.Now()) {
+		return "", "", errActionExpired
+	}
+	return action, userID, nil
+}
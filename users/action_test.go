@@ -0,0 +1,105 @@
+package users
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedAction(t *testing.T) {
+	t.Parallel()
+
+	svc := DefaultService{
+		jwtSigningKey:  "jwt-secret",
+		actionEndpoint: "https://test-app:8080/actions?lang=en",
+	}
+
+	givenUserID := uuid.New().String()
+
+	validURL, err := svc.GenerateSignedActionURL("unsubscribe", givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	expiredURL, err := svc.GenerateSignedActionURL("unsubscribe", givenUserID, -time.Hour)
+	require.NoError(t, err)
+
+	tamper := func(rawURL, key, value string) string {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+
+		q := u.Query()
+		q.Set(key, value)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	otherKeySvc := DefaultService{jwtSigningKey: "other-secret", actionEndpoint: svc.actionEndpoint}
+
+	otherKeyURL, err := otherKeySvc.GenerateSignedActionURL("unsubscribe", givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		givenURL       string
+		expectedAction string
+		expectedUserID string
+		expectedError  error
+	}{
+		{
+			name:           "valid url",
+			givenURL:       validURL,
+			expectedAction: "unsubscribe",
+			expectedUserID: givenUserID,
+		},
+		{
+			name:          "expired url",
+			givenURL:      expiredURL,
+			expectedError: errActionExpired,
+		},
+		{
+			name:          "tampered action",
+			givenURL:      tamper(validURL, "action", "approve"),
+			expectedError: errActionSignatureInvalid,
+		},
+		{
+			name:          "tampered user id",
+			givenURL:      tamper(validURL, "user_id", uuid.New().String()),
+			expectedError: errActionSignatureInvalid,
+		},
+		{
+			name:          "tampered expiration",
+			givenURL:      tamper(expiredURL, "expires", "9999999999"),
+			expectedError: errActionSignatureInvalid,
+		},
+		{
+			name:          "signed with another key",
+			givenURL:      otherKeyURL,
+			expectedError: errActionSignatureInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			action, userID, err := svc.VerifySignedAction(tc.givenURL)
+			require.Equal(t, tc.expectedError, err)
+
+			assert.Equal(t, tc.expectedAction, action)
+			assert.Equal(t, tc.expectedUserID, userID)
+		})
+	}
+
+	t.Run("endpoint query is kept", func(t *testing.T) {
+		u, err := url.Parse(validURL)
+		require.NoError(t, err)
+
+		assert.Equal(t, "en", u.Query().Get("lang"))
+	})
+
+	t.Run("endpoint not configured", func(t *testing.T) {
+		_, err := (&DefaultService{jwtSigningKey: "jwt-secret"}).GenerateSignedActionURL("unsubscribe", givenUserID, time.Hour)
+		require.Error(t, err)
+	})
+}
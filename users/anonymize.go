@@ -0,0 +1,42 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alesr/stdservices/users/repository"
+	"go.uber.org/zap"
+)
+
+// anonymizedPrefix prefixes the tombstone replacing the personal data of anonymized users
+const anonymizedPrefix = "anonymized-"
+
+// AnonymizeUser replaces the email, fullname and username of a user with a generated tombstone,
+// clears its password hash and disables its account, for jurisdictions preferring anonymization to deletion.
+// Its sessions, refresh tokens, passkeys, OAuth identities, TOTP secret, API keys and login history are deleted,
+// and its tokens revoked, so the user can't log in by any means anymore.
+// Unlike a hard deletion, the user row and the references to its id remain valid.
+// Soft deleted users can be anonymized as well.
+func (s *DefaultService) AnonymizeUser(ctx context.Context, id string) error {
+	if err := s.validateID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	// The tombstone is random, so it can't be traced back to the user
+	tombstone := anonymizedPrefix + randString(16)
+
+	if err := s.repo.AnonymizeByID(ctx, id, tombstone, s.now()); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errNotFound
+		}
+		s.logger.Error("could not anonymize user", zap.String("operation", "anonymize_user"), userIDField(id), errorField(err))
+		return wrapErr(ctx, "could not anonymize user", err)
+	}
+
+	s.invalidateCachedUser(ctx, id)
+
+	s.logger.Info("user anonymized", zap.String("operation", "anonymize_user"), userIDField(id),
+		zap.String("anonymized_by", maskID(actorFromContext(ctx))))
+	return nil
+}
@@ -0,0 +1,79 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAnonymizeUser(t *testing.T) {
+	t.Parallel()
+
+	givenID := uuid.New().String()
+	givenNow := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name              string
+		givenID           string
+		givenAnonymizeErr error
+		expectedErr       error
+	}{
+		{
+			name:    "user is anonymized",
+			givenID: givenID,
+		},
+		{
+			name:              "user not found",
+			givenID:           givenID,
+			givenAnonymizeErr: repository.ErrRecordNotFound,
+			expectedErr:       errNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var actualTombstone string
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					anonymizeByIDFunc: func(ctx context.Context, id, tombstone string, anonymizedAt time.Time) error {
+						assert.Equal(t, tc.givenID, id)
+						assert.Equal(t, givenNow, anonymizedAt)
+
+						actualTombstone = tombstone
+						return tc.givenAnonymizeErr
+					},
+				},
+			)
+			svc.clock = func() time.Time { return givenNow }
+
+			err := svc.AnonymizeUser(context.TODO(), tc.givenID)
+			if tc.expectedErr != nil {
+				require.True(t, errors.Is(err, tc.expectedErr), err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.True(t, strings.HasPrefix(actualTombstone, anonymizedPrefix))
+			assert.NotContains(t, actualTombstone, tc.givenID)
+		})
+	}
+
+	t.Run("invalid id", func(t *testing.T) {
+		t.Parallel()
+
+		err := New(zap.NewNop(), "jwt-secret", &repositoryMock{}).AnonymizeUser(context.TODO(), "invalid-id")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,79 @@
+package users
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// WithRetryBackoffCeiling caps the wait between the retries of any retried operation
+// (e.g. repository retries). By default the wait keeps doubling after each attempt.
+func WithRetryBackoffCeiling(ceiling time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.retryBackoffCeiling = ceiling
+	}
+}
+
+// WithRetryJitter randomizes the wait between the retries of any retried operation
+// between zero and the exponential backoff ("full jitter"), so that clients failing
+// at the same time don't retry in lockstep and overload the recovering dependency.
+func WithRetryJitter() ServiceOption {
+	return func(s *DefaultService) {
+		s.retryJitter = true
+	}
+}
+
+// backoff retries an operation up to attempts times in total, waiting between attempts
+// an exponentially growing delay starting at initial, optionally capped to ceiling and jittered
+type backoff struct {
+	attempts int
+	initial  time.Duration
+	ceiling  time.Duration
+	jitter   bool
+}
+
+// delay returns the wait after the given failed attempt, starting at 1
+func (b *backoff) delay(attempt int) time.Duration {
+	d := b.initial
+	for i := 1; i < attempt; i++ {
+		if d > math.MaxInt64/2 {
+			d = math.MaxInt64
+			break
+		}
+		d *= 2
+	}
+
+	if b.ceiling > 0 && d > b.ceiling {
+		d = b.ceiling
+	}
+
+	if b.jitter && d > 0 {
+		// Between zero and d included, unless d saturated as n+1 would overflow
+		n := int64(d)
+		if n < math.MaxInt64 {
+			n++
+		}
+		d = time.Duration(rand.Int63n(n))
+	}
+	return d
+}
+
+// retry calls fn until it succeeds, fails with an error for which isRetryable returns false
+// or the attempts are exhausted. It stops waiting and returns the context error as soon as ctx is done.
+func (b *backoff) retry(ctx context.Context, fn func() error, isRetryable func(error) bool) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= b.attempts || !isRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(b.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBackoff_delay(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    backoff
+		attempt  int
+		expected time.Duration
+	}{
+		{
+			name:     "first attempt waits the initial backoff",
+			given:    backoff{initial: time.Second},
+			attempt:  1,
+			expected: time.Second,
+		},
+		{
+			name:     "wait doubles after each attempt",
+			given:    backoff{initial: time.Second},
+			attempt:  4,
+			expected: 8 * time.Second,
+		},
+		{
+			name:     "wait is capped to the ceiling",
+			given:    backoff{initial: time.Second, ceiling: 5 * time.Second},
+			attempt:  4,
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "capped wait does not overflow",
+			given:    backoff{initial: time.Second, ceiling: time.Minute},
+			attempt:  200,
+			expected: time.Minute,
+		},
+		{
+			name:     "uncapped wait saturates instead of overflowing",
+			given:    backoff{initial: time.Second},
+			attempt:  200,
+			expected: time.Duration(math.MaxInt64),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, tc.given.delay(tc.attempt))
+		})
+	}
+}
+
+func TestBackoff_delayJitter(t *testing.T) {
+	t.Parallel()
+
+	b := backoff{initial: time.Second, ceiling: 5 * time.Second, jitter: true}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		for i := 0; i < 50; i++ {
+			d := b.delay(attempt)
+			assert.GreaterOrEqual(t, int64(d), int64(0))
+			assert.LessOrEqual(t, int64(d), int64(5*time.Second))
+		}
+	}
+
+	t.Run("uncapped wait does not overflow", func(t *testing.T) {
+		t.Parallel()
+
+		b := backoff{initial: 100 * time.Millisecond, jitter: true}
+
+		for _, attempt := range []int{37, 64, 200} {
+			var d time.Duration
+			require.NotPanics(t, func() { d = b.delay(attempt) })
+			assert.GreaterOrEqual(t, int64(d), int64(0))
+		}
+	})
+}
+
+func TestBackoff_retry(t *testing.T) {
+	t.Parallel()
+
+	errTransient := errors.New("transient")
+	isRetryable := func(err error) bool { return errors.Is(err, errTransient) }
+
+	t.Run("retries up to the attempts", func(t *testing.T) {
+		t.Parallel()
+
+		b := backoff{attempts: 4, initial: time.Millisecond, ceiling: 2 * time.Millisecond, jitter: true}
+
+		var calls int
+		err := b.retry(context.TODO(), func() error {
+			calls++
+			return errTransient
+		}, isRetryable)
+
+		require.ErrorIs(t, err, errTransient)
+		assert.Equal(t, 4, calls)
+	})
+
+	t.Run("stops on success", func(t *testing.T) {
+		t.Parallel()
+
+		b := backoff{attempts: 4, initial: time.Millisecond}
+
+		var calls int
+		err := b.retry(context.TODO(), func() error {
+			calls++
+			if calls < 2 {
+				return errTransient
+			}
+			return nil
+		}, isRetryable)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestWithRetryBackoffCeilingAndJitter(t *testing.T) {
+	t.Parallel()
+
+	svc := New(
+		zap.NewNop(),
+		"jwt-signing-key",
+		&repositoryMock{},
+		WithRetryJitter(),
+		WithRepoRetry(3, time.Second, nil),
+		WithRetryBackoffCeiling(2*time.Second),
+	)
+
+	require.NotNil(t, svc.repoRetry)
+	assert.Equal(t, 2*time.Second, svc.repoRetry.ceiling)
+	assert.True(t, svc.repoRetry.jitter)
+}
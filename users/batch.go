@@ -0,0 +1,92 @@
+package users
+
+import (
+	"context"
+	"sync"
+)
+
+// SendEmailVerifications sends an email verification to each of the given users, with at most concurrency
+// sends in flight (1 when not positive) so the SMTP server is not overwhelmed. Users already verified are
+// skipped. It returns the number of emails sent and a *VerificationSendError per user the email could not
+// be sent to, in the order of userIDs. Once ctx is done, the remaining users fail with the context error,
+// and without an emailer configured all of them fail.
+func (s *DefaultService) SendEmailVerifications(ctx context.Context, userIDs []string, concurrency int) (int, []error) {
+	if s.emailer == nil {
+		errs := make([]error, 0, len(userIDs))
+		for _, id := range userIDs {
+			errs = append(errs, &VerificationSendError{UserID: id, err: errEmailerNotConfigured})
+		}
+		return 0, errs
+	}
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]error, len(userIDs))
+	sent := make([]bool, len(userIDs))
+
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				sent[j], results[j] = s.sendEmailVerificationTo(ctx, userIDs[j])
+			}
+		}()
+	}
+
+dispatch:
+	for i := range userIDs {
+		select {
+		case <-ctx.Done():
+			for j := i; j < len(userIDs); j++ {
+				results[j] = ctx.Err()
+			}
+			break dispatch
+		case jobs <- i:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var (
+		count int
+		errs  []error
+	)
+
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, &VerificationSendError{UserID: userIDs[i], err: err})
+		}
+
+		if sent[i] {
+			count++
+		}
+	}
+	return count, errs
+}
+
+// sendEmailVerificationTo sends an email verification to a non-verified user and reports whether it was sent
+func (s *DefaultService) sendEmailVerificationTo(ctx context.Context, userID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	user, err := s.FetchByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	if user.EmailVerified {
+		return false, nil
+	}
+
+	if err := s.SendEmailVerification(ctx, user.ID, user.Username, user.Email); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,175 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSendEmailVerifications(t *testing.T) {
+	t.Parallel()
+
+	newUser := func(verified bool) *repository.User {
+		id := uuid.New().String()
+		return &repository.User{ID: id, Username: "user-" + id[:8], Email: id[:8] + "@mail.com", Role: string(RoleUser), EmailVerified: verified}
+	}
+
+	newService := func(users map[string]*repository.User, send func(to string) error) *DefaultService {
+		return New(zap.NewNop(), "jwt-secret", &repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return users[id], nil
+			},
+			insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+				return nil
+			},
+		},
+			WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+				sendFunc: func(from, to string, body []byte) error {
+					return send(to)
+				},
+			}),
+		)
+	}
+
+	t.Run("sends with bounded concurrency", func(t *testing.T) {
+		t.Parallel()
+
+		users := map[string]*repository.User{}
+
+		var givenIDs []string
+		for i := 0; i < 20; i++ {
+			u := newUser(false)
+			users[u.ID] = u
+			givenIDs = append(givenIDs, u.ID)
+		}
+
+		var (
+			mu                    sync.Mutex
+			inFlight, maxInFlight int
+			recipients            []string
+		)
+
+		svc := newService(users, func(to string) error {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			recipients = append(recipients, to)
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return nil
+		})
+
+		sent, errs := svc.SendEmailVerifications(context.Background(), givenIDs, 4)
+		require.Empty(t, errs)
+
+		assert.Equal(t, 20, sent)
+		assert.Len(t, recipients, 20)
+		assert.LessOrEqual(t, maxInFlight, 4)
+		assert.Greater(t, maxInFlight, 1)
+	})
+
+	t.Run("reports per-user errors and skips verified users", func(t *testing.T) {
+		t.Parallel()
+
+		unverified, verified, failing := newUser(false), newUser(true), newUser(false)
+		missingID := uuid.New().String()
+
+		users := map[string]*repository.User{unverified.ID: unverified, verified.ID: verified, failing.ID: failing}
+
+		errSMTP := errors.New("smtp down")
+
+		svc := newService(users, func(to string) error {
+			if to == failing.Email {
+				return errSMTP
+			}
+			return nil
+		})
+
+		sent, errs := svc.SendEmailVerifications(context.Background(), []string{unverified.ID, verified.ID, missingID, failing.ID}, 0)
+		assert.Equal(t, 1, sent)
+		require.Len(t, errs, 2)
+
+		var sendErr *VerificationSendError
+
+		require.True(t, errors.As(errs[0], &sendErr))
+		assert.Equal(t, missingID, sendErr.UserID)
+		assert.ErrorIs(t, errs[0], errNotFound)
+
+		require.True(t, errors.As(errs[1], &sendErr))
+		assert.Equal(t, failing.ID, sendErr.UserID)
+		assert.NotContains(t, errs[1].Error(), failing.ID)
+	})
+
+	t.Run("emailer not configured", func(t *testing.T) {
+		t.Parallel()
+
+		givenIDs := []string{uuid.New().String(), uuid.New().String()}
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+		sent, errs := svc.SendEmailVerifications(context.Background(), givenIDs, 2)
+		assert.Zero(t, sent)
+		require.Len(t, errs, 2)
+
+		for i, err := range errs {
+			var sendErr *VerificationSendError
+			require.True(t, errors.As(err, &sendErr))
+			assert.Equal(t, givenIDs[i], sendErr.UserID)
+			assert.ErrorIs(t, err, errEmailerNotConfigured)
+		}
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		t.Parallel()
+
+		users := map[string]*repository.User{}
+
+		var givenIDs []string
+		for i := 0; i < 10; i++ {
+			u := newUser(false)
+			users[u.ID] = u
+			givenIDs = append(givenIDs, u.ID)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var (
+			mu    sync.Mutex
+			count int
+		)
+
+		svc := newService(users, func(to string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			count++
+			if count == 3 {
+				cancel()
+			}
+			return nil
+		})
+
+		sent, errs := svc.SendEmailVerifications(ctx, givenIDs, 1)
+		assert.Equal(t, 3, sent)
+		require.Len(t, errs, 7)
+
+		for _, err := range errs {
+			assert.ErrorIs(t, err, context.Canceled)
+		}
+	})
+}
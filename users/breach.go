@@ -0,0 +1,109 @@
+package users
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	pwnedPasswordsRangeURL = "https://api.pwnedpasswords.com/range/"
+	breachCheckTimeout     = 3 * time.Second
+)
+
+var _ BreachChecker = (*pwnedPasswordsChecker)(nil)
+
+// BreachChecker reports whether passwords appeared in known data breaches (see WithBreachedPasswordCheck)
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (bool, error)
+}
+
+// WithBreachedPasswordCheck rejects the passwords found in known data breaches on create, password change and reset,
+// such as the checker returned by NewPwnedPasswordsChecker. Checks failing (e.g. the API is down) don't reject the password.
+func WithBreachedPasswordCheck(checker BreachChecker) ServiceOption {
+	return func(s *DefaultService) {
+		s.breachChecker = checker
+	}
+}
+
+type pwnedPasswordsChecker struct {
+	rangeURL string
+	http     *http.Client
+}
+
+// NewPwnedPasswordsChecker returns a checker querying the Have I Been Pwned range API with k-anonymity:
+// only the first 5 characters of the SHA-1 of the password are sent, and the matching suffixes are searched locally.
+// A nil client defaults to a client with a timeout of 3 seconds.
+func NewPwnedPasswordsChecker(client *http.Client) BreachChecker {
+	if client == nil {
+		client = &http.Client{Timeout: breachCheckTimeout}
+	}
+	return &pwnedPasswordsChecker{rangeURL: pwnedPasswordsRangeURL, http: client}
+}
+
+// Breached reports whether the SHA-1 of the password is listed by the range API
+func (c *pwnedPasswordsChecker) Breached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.rangeURL+prefix, nil)
+	if err != nil {
+		return false, fmt.Errorf("could not create range request: %s", err)
+	}
+
+	// Padded responses don't reveal the prefix through their size
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("could not query range: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("could not query range: unexpected status %d", resp.StatusCode)
+	}
+
+	// Each line holds a suffix and its count of occurrences, zero for the padding (e.g. "0018A45C4D1DEF81644B54AB7F969B88D65:10")
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(lineSuffix, suffix) {
+			return count != "0", nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("could not read range: %s", err)
+	}
+	return false, nil
+}
+
+// checkPasswordBreached returns errPasswordBreached if the breached password check is enabled
+// and the password appeared in a data breach
+func (s *DefaultService) checkPasswordBreached(ctx context.Context, password string) error {
+	if s.breachChecker == nil {
+		return nil
+	}
+
+	breached, err := s.breachChecker.Breached(ctx, password)
+	if err != nil {
+		// Don't reject passwords because of API outages
+		s.logger.Warn("could not check breached password", errorField(err))
+		return nil
+	}
+
+	if breached {
+		s.logger.Info("breached password rejected", zap.String("operation", "check_password_breached"))
+		return errPasswordBreached
+	}
+	return nil
+}
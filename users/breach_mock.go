@@ -0,0 +1,19 @@
+package users
+
+import (
+	"context"
+	"errors"
+)
+
+var _ BreachChecker = (*breachCheckerMock)(nil)
+
+type breachCheckerMock struct {
+	breachedFunc func(ctx context.Context, password string) (bool, error)
+}
+
+func (m *breachCheckerMock) Breached(ctx context.Context, password string) (bool, error) {
+	if m.breachedFunc == nil {
+		return false, errors.New("breachCheckerMock.breachedFunc is nil")
+	}
+	return m.breachedFunc(ctx, password)
+}
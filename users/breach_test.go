@@ -0,0 +1,159 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPwnedPasswordsChecker(t *testing.T) {
+	t.Parallel()
+
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/range/5BAA6" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		assert.Equal(t, "true", r.Header.Get("Add-Padding"))
+
+		fmt.Fprint(w, "003D68EB55068C33ACE09247EE4C639306B:3\r\n")
+		fmt.Fprint(w, "1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n")
+		fmt.Fprint(w, "01330C689E5D64F660D6947A93AD634EF8F:0\r\n")
+	}))
+	defer server.Close()
+
+	checker := &pwnedPasswordsChecker{rangeURL: server.URL + "/range/", http: server.Client()}
+
+	testCases := []struct {
+		name        string
+		given       string
+		expected    bool
+		expectedErr bool
+	}{
+		{
+			name:     "breached password",
+			given:    "password",
+			expected: true,
+		},
+		{
+			name:        "api error",
+			given:       "foo",
+			expectedErr: true,
+		},
+	}
+
+	// The server is closed once the test cases ran, so they don't run in parallel
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := checker.Breached(context.Background(), tc.given)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestWithBreachedPasswordCheck(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+	breachedPassword := "breached%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	checker := &breachCheckerMock{
+		breachedFunc: func(ctx context.Context, password string) (bool, error) {
+			switch password {
+			case breachedPassword:
+				return true, nil
+			case "unavailable%&123":
+				return false, errors.New("connection refused")
+			}
+			return false, nil
+		},
+	}
+
+	newService := func() *DefaultService {
+		storedUser := repository.User{
+			ID:           uuid.New().String(),
+			Fullname:     "John Doe",
+			Username:     "jdoe",
+			Email:        "joedoe@mail.com",
+			PasswordHash: string(givenHash),
+			Role:         string(RoleUser),
+		}
+
+		return New(zap.NewNop(), "jwt-secret",
+			&repositoryMock{
+				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					return user, nil
+				},
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					user := storedUser
+					return &user, nil
+				},
+				updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					return user, nil
+				},
+			},
+			WithBreachedPasswordCheck(checker),
+		)
+	}
+
+	testCases := []struct {
+		name          string
+		givenPassword string
+		expectedErr   error
+	}{
+		{
+			name:          "password not breached",
+			givenPassword: "not-breached%&123",
+		},
+		{
+			name:          "breached password",
+			givenPassword: breachedPassword,
+			expectedErr:   errPasswordBreached,
+		},
+		{
+			name:          "checker unavailable",
+			givenPassword: "unavailable%&123",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := newService().Create(context.Background(), CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "jdoe",
+				Birthdate:       "2000-01-01",
+				Email:           "joedoe@mail.com",
+				Password:        tc.givenPassword,
+				ConfirmPassword: tc.givenPassword,
+			})
+			assert.Equal(t, tc.expectedErr, err, "create")
+
+			err = newService().ChangePassword(context.Background(), uuid.New().String(), password, tc.givenPassword)
+			assert.Equal(t, tc.expectedErr, err, "change password")
+		})
+	}
+}
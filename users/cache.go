@@ -0,0 +1,166 @@
+package users
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+var _ UserCache = (*LRUUserCache)(nil)
+
+// defaultUserCacheSize is the number of users kept by the default user cache
+const defaultUserCacheSize = 10000
+
+// UserCache caches the users read by id on hot paths (see WithUserCache).
+// Implementations backed by a shared store (e.g. Redis) keep the invalidations consistent across service replicas.
+type UserCache interface {
+	// Get returns the cached user, or nil when the user is not cached or its entry expired
+	Get(ctx context.Context, id string) (*repository.User, error)
+
+	// Set caches the user for ttl
+	Set(ctx context.Context, user *repository.User, ttl time.Duration) error
+
+	// Delete removes the cached user, if any
+	Delete(ctx context.Context, id string) error
+}
+
+// WithUserCache caches the users looked up by FetchByID and VerifyToken for ttl, sparing
+// the repository reads of the same users on hot paths. Cached users are invalidated when
+// the service modifies or deletes them. When cache is nil, an in-memory LRU cache is used,
+// which only invalidates the users modified by the same service instance.
+func WithUserCache(cache UserCache, ttl time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		if cache == nil {
+			cache = NewLRUUserCache(defaultUserCacheSize)
+		}
+
+		s.userCache = cache
+		s.userCacheTTL = ttl
+	}
+}
+
+// selectUserByID selects a non-deleted user by id through the user cache, when configured.
+// Cache failures are logged and the user is selected from the repository instead.
+func (s *DefaultService) selectUserByID(ctx context.Context, id string) (*repository.User, error) {
+	if s.userCache == nil {
+		return s.repo.SelectByID(ctx, id)
+	}
+
+	cached, err := s.userCache.Get(ctx, id)
+	if err != nil {
+		s.logger.Error("could not get cached user", userIDField(id), errorField(err))
+	}
+
+	if cached != nil {
+		return cached, nil
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, id)
+	if err != nil || storageUser == nil {
+		return storageUser, err
+	}
+
+	if err := s.userCache.Set(ctx, storageUser, s.userCacheTTL); err != nil {
+		s.logger.Error("could not cache user", userIDField(id), errorField(err))
+	}
+	return storageUser, nil
+}
+
+// invalidateCachedUser removes a modified user from the user cache, when configured
+func (s *DefaultService) invalidateCachedUser(ctx context.Context, id string) {
+	if s.userCache == nil {
+		return
+	}
+
+	if err := s.userCache.Delete(ctx, id); err != nil {
+		s.logger.Error("could not invalidate cached user", userIDField(id), errorField(err))
+	}
+}
+
+// LRUUserCache is an in-memory user cache evicting the least recently used users
+// once it holds size users. It is safe for concurrent use.
+type LRUUserCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type lruUserCacheEntry struct {
+	user      repository.User
+	expiresAt time.Time
+}
+
+// NewLRUUserCache instantiates an in-memory user cache holding up to size users
+func NewLRUUserCache(size int) *LRUUserCache {
+	return &LRUUserCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get returns a copy of the cached user, or nil when the user is not cached or its entry expired
+func (c *LRUUserCache) Get(_ context.Context, id string) (*repository.User, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, nil
+	}
+
+	entry := elem.Value.(*lruUserCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, nil
+	}
+
+	c.order.MoveToFront(elem)
+
+	user := entry.user
+	return &user, nil
+}
+
+// Set caches a copy of the user for ttl, evicting the least recently used user when the cache is full
+func (c *LRUUserCache) Set(_ context.Context, user *repository.User, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &lruUserCacheEntry{user: *user, expiresAt: c.now().Add(ttl)}
+
+	if elem, ok := c.entries[user.ID]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return nil
+	}
+
+	c.entries[user.ID] = c.order.PushFront(entry)
+
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+// Delete removes the cached user, if any
+func (c *LRUUserCache) Delete(_ context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+// remove removes an entry, the caller must hold the lock
+func (c *LRUUserCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruUserCacheEntry)
+	delete(c.entries, entry.user.ID)
+}
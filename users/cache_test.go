@@ -0,0 +1,143 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLRUUserCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cache := NewLRUUserCache(2)
+	cache.now = func() time.Time { return now }
+
+	ctx := context.Background()
+
+	require.NoError(t, cache.Set(ctx, &repository.User{ID: "foo"}, time.Minute))
+	require.NoError(t, cache.Set(ctx, &repository.User{ID: "bar"}, time.Minute))
+
+	// foo becomes the most recently used user, so bar is evicted
+	actual, err := cache.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.NotNil(t, actual)
+
+	actual.Username = "changed"
+
+	require.NoError(t, cache.Set(ctx, &repository.User{ID: "baz"}, time.Hour))
+
+	actual, err = cache.Get(ctx, "bar")
+	require.NoError(t, err)
+	assert.Nil(t, actual)
+
+	t.Run("cached users are copies", func(t *testing.T) {
+		actual, err := cache.Get(ctx, "foo")
+		require.NoError(t, err)
+		require.NotNil(t, actual)
+
+		assert.Empty(t, actual.Username)
+	})
+
+	t.Run("expired users are not returned", func(t *testing.T) {
+		now = now.Add(time.Minute)
+
+		actual, err := cache.Get(ctx, "foo")
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+
+		actual, err = cache.Get(ctx, "baz")
+		require.NoError(t, err)
+		assert.NotNil(t, actual)
+	})
+
+	t.Run("deleted users are not returned", func(t *testing.T) {
+		require.NoError(t, cache.Delete(ctx, "baz"))
+
+		actual, err := cache.Get(ctx, "baz")
+		require.NoError(t, err)
+		assert.Nil(t, actual)
+	})
+}
+
+func TestWithUserCache(t *testing.T) {
+	t.Parallel()
+
+	storedUser := repository.User{
+		ID:       uuid.New().String(),
+		Username: "jdoe",
+		Email:    "joedoe@mail.com",
+		Role:     string(RoleUser),
+	}
+
+	var (
+		selectCalls int
+		deleted     bool
+	)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				selectCalls++
+				if deleted {
+					return nil, nil
+				}
+				user := storedUser
+				return &user, nil
+			},
+			updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				storedUser = *user
+				return user, nil
+			},
+			deleteByIDFunc: func(ctx context.Context, id, reason, deletedBy string) error {
+				deleted = true
+				return nil
+			},
+		},
+		WithUserCache(nil, time.Hour),
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	token, err := issueTestToken(context.Background(), svc, storedUser.ID, time.Hour)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+
+	_, err = svc.FetchByID(context.Background(), storedUser.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, selectCalls)
+
+	t.Run("revocation invalidates the cached user", func(t *testing.T) {
+		now = now.Add(time.Second)
+
+		require.NoError(t, svc.RevokeUserTokens(context.Background(), storedUser.ID))
+
+		_, err := svc.VerifyToken(context.Background(), token)
+		assert.Equal(t, errTokenSuperseded, err)
+	})
+
+	t.Run("deletion invalidates the cached user", func(t *testing.T) {
+		_, err := svc.FetchByID(context.Background(), storedUser.ID)
+		require.NoError(t, err)
+
+		require.NoError(t, svc.Delete(context.Background(), storedUser.ID))
+
+		_, err = svc.FetchByID(context.Background(), storedUser.ID)
+		assert.Equal(t, errNotFound, err)
+	})
+}
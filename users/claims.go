@@ -0,0 +1,73 @@
+package users
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/golang-jwt/jwt"
+)
+
+// reservedClaims are the claims set by the service and the registered JWT claims,
+// which enrichers can't set and VerifyTokenResponse.Claims doesn't expose
+var reservedClaims = []string{
+	"user_id", "role", "fph", "rea", "sid", "step_up", "max_session", "mfa",
+	"jti", "iss", "sub", "aud", "exp", "nbf", "iat",
+}
+
+// WithClaimsEnricher sets a function adding application-specific claims (e.g. a tenant id or a plan level)
+// to the tokens issued on login and on RefreshAccessToken. Tokens renewed by RefreshToken or by a sliding
+// session keep the claims of the renewed token. VerifyToken returns the added claims in
+// VerifyTokenResponse.Claims. The reserved claims (the service and registered JWT claims) are dropped,
+// and the claims of WithClaimsBuilder take precedence. An enricher error fails the token issuance.
+func WithClaimsEnricher(fn func(ctx context.Context, user *User) (map[string]interface{}, error)) ServiceOption {
+	return func(s *DefaultService) {
+		s.claimsEnricher = fn
+	}
+}
+
+// enrichClaims sets the claims of the claims enricher, if any, for the user to the extra claims
+func (s *DefaultService) enrichClaims(ctx context.Context, claims *jwtClaim, storageUser *repository.User) error {
+	if s.claimsEnricher == nil {
+		return nil
+	}
+
+	user, err := s.userFromRepository(storageUser)
+	if err != nil {
+		return fmt.Errorf("could not parse storage user to domain model: %s", err)
+	}
+
+	extra, err := s.claimsEnricher(ctx, user)
+	if err != nil {
+		return fmt.Errorf("could not enrich claims: %w", err)
+	}
+
+	claims.Extra = withoutReservedClaims(extra)
+	return nil
+}
+
+// extraClaims returns the claims of a verified token other than the reserved claims, or nil when there are none
+func extraClaims(token string) (map[string]interface{}, error) {
+	mapClaims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, mapClaims); err != nil {
+		return nil, err
+	}
+	return withoutReservedClaims(mapClaims), nil
+}
+
+// withoutReservedClaims returns a copy of the claims without the reserved claims, or nil when none are left
+func withoutReservedClaims(claims map[string]interface{}) map[string]interface{} {
+	extra := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		extra[k] = v
+	}
+
+	for _, k := range reservedClaims {
+		delete(extra, k)
+	}
+
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
+}
@@ -0,0 +1,103 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWithClaimsEnricher(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	storedUser := repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	repoMock := &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			user := storedUser
+			return &user, nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			user := storedUser
+			return &user, nil
+		},
+	}
+
+	enricher := func(ctx context.Context, user *User) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"tenant_id": "tenant-" + user.Username,
+			"plan":      "pro",
+			"role":      string(RoleAdmin),
+		}, nil
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", repoMock, WithClaimsEnricher(enricher))
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	token, err := svc.GenerateToken(context.Background(), storedUser.Email, password)
+	require.NoError(t, err)
+
+	resp, err := svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+
+	// Reserved claims can't be overridden
+	assert.Equal(t, string(RoleUser), resp.Role)
+	assert.Equal(t, map[string]interface{}{"tenant_id": "tenant-jdoe", "plan": "pro"}, resp.Claims)
+
+	t.Run("refreshed token keeps the claims", func(t *testing.T) {
+		refreshed, err := svc.RefreshToken(context.Background(), token)
+		require.NoError(t, err)
+
+		resp, err := svc.VerifyToken(context.Background(), refreshed)
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]interface{}{"tenant_id": "tenant-jdoe", "plan": "pro"}, resp.Claims)
+	})
+
+	t.Run("enricher error", func(t *testing.T) {
+		givenErr := errors.New("some error")
+
+		svc := New(zap.NewNop(), "jwt-secret", repoMock,
+			WithClaimsEnricher(func(ctx context.Context, user *User) (map[string]interface{}, error) {
+				return nil, givenErr
+			}),
+		)
+
+		_, err := svc.GenerateToken(context.Background(), storedUser.Email, password)
+		assert.ErrorIs(t, err, givenErr)
+	})
+
+	t.Run("no extra claims", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", repoMock)
+		svc.clock = func() time.Time { return now }
+
+		token, err := svc.GenerateToken(context.Background(), storedUser.Email, password)
+		require.NoError(t, err)
+
+		resp, err := svc.VerifyToken(context.Background(), token)
+		require.NoError(t, err)
+
+		assert.Nil(t, resp.Claims)
+	})
+}
@@ -0,0 +1,64 @@
+package users
+
+import "unicode"
+
+// latinLookalikes are the Cyrillic and Greek letters rendered like Latin letters
+var latinLookalikes = map[rune]struct{}{
+	// Cyrillic
+	'а': {}, 'в': {}, 'е': {}, 'к': {}, 'м': {}, 'н': {}, 'о': {}, 'р': {}, 'с': {}, 'т': {}, 'у': {}, 'х': {},
+	'і': {}, 'ј': {}, 'ѕ': {}, 'ԁ': {}, 'ԛ': {}, 'ԝ': {}, 'һ': {}, 'ӏ': {},
+	'А': {}, 'В': {}, 'Е': {}, 'К': {}, 'М': {}, 'Н': {}, 'О': {}, 'Р': {}, 'С': {}, 'Т': {}, 'У': {}, 'Х': {},
+	'І': {}, 'Ј': {}, 'Ѕ': {}, 'Ԁ': {}, 'Ԛ': {}, 'Ԝ': {}, 'Һ': {}, 'Ӏ': {},
+	// Greek
+	'α': {}, 'ι': {}, 'κ': {}, 'ν': {}, 'ο': {}, 'ρ': {}, 'υ': {},
+	'Α': {}, 'Β': {}, 'Ε': {}, 'Ζ': {}, 'Η': {}, 'Ι': {}, 'Κ': {}, 'Μ': {}, 'Ν': {}, 'Ο': {}, 'Ρ': {}, 'Τ': {}, 'Υ': {}, 'Χ': {},
+}
+
+// WithConfusableUsernameCheck rejects usernames which could impersonate other users with errUsernameConfusable:
+// usernames mixing scripts (e.g. Latin and Cyrillic letters) and non-Latin usernames made of Latin lookalikes only.
+func WithConfusableUsernameCheck() ServiceOption {
+	return func(s *DefaultService) {
+		s.confusableUsernameCheck = true
+	}
+}
+
+// usernameConfusable reports whether the username mixes scripts
+// or is a non-Latin username made of Latin lookalike letters only
+func usernameConfusable(username string) bool {
+	var (
+		script         string
+		lookalikesOnly = true
+	)
+
+	for _, r := range username {
+		s := runeScript(r)
+		if s == "" {
+			continue
+		}
+
+		if script != "" && s != script {
+			return true
+		}
+		script = s
+
+		if _, ok := latinLookalikes[r]; !ok {
+			lookalikesOnly = false
+		}
+	}
+	return script != "" && script != "Latin" && lookalikesOnly
+}
+
+// runeScript returns the name of the script of r, or an empty string
+// for characters shared by scripts (e.g. spaces and combining marks)
+func runeScript(r rune) string {
+	if unicode.In(r, unicode.Common, unicode.Inherited) {
+		return ""
+	}
+
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
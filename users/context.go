@@ -0,0 +1,86 @@
+package users
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+type contextKey int
+
+const (
+	clientInfoKey contextKey = iota
+	actorKey
+	audienceKey
+)
+
+// ClientInfo holds metadata about the client performing a request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// ContextWithClientInfo returns a copy of ctx carrying the client info
+func ContextWithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey, info)
+}
+
+// clientInfoFromContext returns the client info stored in ctx, if any
+func clientInfoFromContext(ctx context.Context) (ClientInfo, bool) {
+	info, ok := ctx.Value(clientInfoKey).(ClientInfo)
+	return info, ok
+}
+
+// ContextWithActor returns a copy of ctx carrying the id of who performs the request
+// (e.g. an admin or the user itself), recorded on auditable operations such as deletions
+func ContextWithActor(ctx context.Context, actorID string) context.Context {
+	return context.WithValue(ctx, actorKey, actorID)
+}
+
+// actorFromContext returns the actor id stored in ctx or an empty string
+func actorFromContext(ctx context.Context) string {
+	actorID, _ := ctx.Value(actorKey).(string)
+	return actorID
+}
+
+// ContextWithAudience returns a copy of ctx declaring the audience (e.g. "mobile") the verified tokens
+// must be intended for. Verifications without a declared audience accept tokens of any audience.
+func ContextWithAudience(ctx context.Context, audience string) context.Context {
+	return context.WithValue(ctx, audienceKey, audience)
+}
+
+// audienceFromContext returns the audience declared in ctx, if any
+func audienceFromContext(ctx context.Context) (string, bool) {
+	audience, ok := ctx.Value(audienceKey).(string)
+	return audience, ok
+}
+
+// detachedContext carries the values of its parent without its deadline and cancellation,
+// for work outliving the request such as emails sent in the background
+type detachedContext struct{ parent context.Context }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// fingerprint returns a hash identifying the client without exposing its raw attributes
+func (c ClientInfo) fingerprint() string {
+	sum := sha256.Sum256([]byte(c.UserAgent + "|" + c.IP))
+	return hex.EncodeToString(sum[:])
+}
+
+// wrapErr wraps an error of an operation performed with ctx. When ctx is done, the context error
+// is wrapped instead, so callers can tell aborted requests (see context.Canceled and
+// context.DeadlineExceeded) apart from storage failures, which are only described.
+func wrapErr(ctx context.Context, msg string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s: %w", msg, ctxErr)
+	}
+	return fmt.Errorf("%s: %s", msg, err)
+}
@@ -0,0 +1,143 @@
+package users
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+// emailEncryption keeps emails private at rest. The email column holds a keyed hash
+// of the email used for lookups and uniqueness, while the email itself is stored encrypted.
+type emailEncryption struct {
+	lookupKey []byte
+	aead      cipher.AEAD
+}
+
+// WithEmailEncryption stores emails as a deterministic keyed hash (HMAC-SHA256) used for lookups,
+// plus an AES-GCM encrypted copy used for display. The lookup and encryption keys are derived from key.
+// Changing the key makes the stored emails unreadable and the users unable to log in,
+// so the key must be kept as safely as the database backups.
+func WithEmailEncryption(key []byte) ServiceOption {
+	return func(s *DefaultService) {
+		s.emailEncryption = newEmailEncryption(key)
+	}
+}
+
+func newEmailEncryption(key []byte) *emailEncryption {
+	block, err := aes.NewCipher(deriveKey(key, "email-encryption"))
+	if err != nil {
+		// the derived key is always a valid AES-256 key
+		panic(fmt.Sprintf("could not create email cipher: %s", err))
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Sprintf("could not create email aead: %s", err))
+	}
+
+	return &emailEncryption{
+		lookupKey: deriveKey(key, "email-lookup"),
+		aead:      aead,
+	}
+}
+
+// deriveKey derives a 32 bytes key for the given purpose from key
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// lookup returns the deterministic keyed hash of the email stored in place of the email
+func (e *emailEncryption) lookup(email string) string {
+	mac := hmac.New(sha256.New, e.lookupKey)
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// seal encrypts the email binding the ciphertext to its lookup hash
+func (e *emailEncryption) seal(email string) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %s", err)
+	}
+
+	ciphertext := e.aead.Seal(nonce, nonce, []byte(email), []byte(e.lookup(email)))
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// open decrypts the email sealed along with the given lookup hash
+func (e *emailEncryption) open(lookup, sealed string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("could not decode email ciphertext: %s", err)
+	}
+
+	if len(ciphertext) < e.aead.NonceSize() {
+		return "", errors.New("email ciphertext is too short")
+	}
+
+	nonce, ciphertext := ciphertext[:e.aead.NonceSize()], ciphertext[e.aead.NonceSize():]
+
+	email, err := e.aead.Open(nil, nonce, ciphertext, []byte(lookup))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt email: %s", err)
+	}
+	return string(email), nil
+}
+
+// emailLookup returns the value the email is stored and looked up as, normalized
+func (s *DefaultService) emailLookup(email string) string {
+	email = normalizeEmail(email)
+
+	if s.emailEncryption == nil {
+		return email
+	}
+	return s.emailEncryption.lookup(email)
+}
+
+// sealEmail sets the email of the storage user, encrypting it when email encryption is enabled
+func (s *DefaultService) sealEmail(user *repository.User, email string) error {
+	email = normalizeEmail(email)
+
+	if s.emailEncryption == nil {
+		user.Email = email
+		return nil
+	}
+
+	ciphertext, err := s.emailEncryption.seal(email)
+	if err != nil {
+		return err
+	}
+
+	user.Email = s.emailEncryption.lookup(email)
+	user.EmailCiphertext = ciphertext
+	return nil
+}
+
+// userFromRepository parses the storage user to the domain model, decrypting its email
+// when email encryption is enabled
+func (s *DefaultService) userFromRepository(storageUser *repository.User) (*User, error) {
+	user, err := newUserFromRepository(storageUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.emailEncryption != nil {
+		email, err := s.emailEncryption.open(storageUser.Email, storageUser.EmailCiphertext)
+		if err != nil {
+			return nil, err
+		}
+		user.Email = email
+	}
+	return user, nil
+}
@@ -0,0 +1,73 @@
+package users
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWithEmailEncryption(t *testing.T) {
+	t.Parallel()
+
+	givenEmail := "joedoe@mail.com"
+
+	var storedUser *repository.User
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				storedUser = user
+				return user, nil
+			},
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				if storedUser == nil || email != storedUser.Email {
+					return nil, nil
+				}
+				return storedUser, nil
+			},
+		},
+		WithEmailEncryption([]byte("email-secret")),
+	)
+
+	user, err := svc.Create(context.Background(), CreateUserInput{
+		Fullname:        "Joe Doe",
+		Username:        "joedoe",
+		Birthdate:       "2000-01-01",
+		Email:           givenEmail,
+		Password:        "password%&123",
+		ConfirmPassword: "password%&123",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, givenEmail, user.Email)
+
+	require.NotNil(t, storedUser)
+
+	assert.NotContains(t, storedUser.Email, givenEmail)
+	assert.NotContains(t, storedUser.EmailCiphertext, givenEmail)
+	assert.Equal(t, svc.emailLookup(givenEmail), storedUser.Email)
+
+	t.Run("login looks up the hashed email", func(t *testing.T) {
+		_, err := svc.GenerateToken(context.Background(), givenEmail, "password%&123")
+		require.NoError(t, err)
+	})
+
+	t.Run("email can't be decrypted with another key", func(t *testing.T) {
+		otherSvc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithEmailEncryption([]byte("other-secret")))
+
+		_, err := otherSvc.userFromRepository(storedUser)
+		require.Error(t, err)
+	})
+
+	t.Run("ciphertext is bound to its lookup hash", func(t *testing.T) {
+		swapped := *storedUser
+		swapped.Email = svc.emailLookup("janedoe@mail.com")
+
+		_, err := svc.userFromRepository(&swapped)
+		require.Error(t, err)
+	})
+}
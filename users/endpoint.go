@@ -0,0 +1,47 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+)
+
+// WithVerificationEndpointResolver derives the endpoint of the email verification links per request
+// (e.g. from the request host found in the context), for services answering several front-end hosts
+// such as staging or preview deployments. The static endpoint set with WithEmailVerification
+// is used when the resolver returns an empty string. Resolved endpoints must be absolute URLs.
+func WithVerificationEndpointResolver(fn func(ctx context.Context) string) ServiceOption {
+	return func(s *DefaultService) {
+		s.verificationEndpointResolver = fn
+	}
+}
+
+// verificationEndpoint returns the endpoint of the email verification links sent for the request
+func (s *DefaultService) verificationEndpoint(ctx context.Context) (string, error) {
+	if s.verificationEndpointResolver == nil {
+		return s.emailVerificationEndpoint, nil
+	}
+
+	endpoint := s.verificationEndpointResolver(ctx)
+	if endpoint == "" {
+		return s.emailVerificationEndpoint, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", errVerificationURLInvalid
+	}
+	return endpoint, nil
+}
+
+// verificationLink returns the URL of the email verification link for the code, appended to the endpoint path
+func verificationLink(endpoint, code string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("could not parse verification endpoint: %s", err)
+	}
+
+	u.Path = path.Join("/", u.Path, code)
+	return u.String(), nil
+}
@@ -0,0 +1,111 @@
+package users
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSendEmailVerification_endpointResolver(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenResolver func(ctx context.Context) string
+		expectedLink  *regexp.Regexp
+		expectedErr   error
+	}{
+		{
+			name:         "static endpoint without resolver",
+			expectedLink: regexp.MustCompile(`^http://test-app:8080/verify-email/[a-z0-9]{6}$`),
+		},
+		{
+			name: "resolved endpoint",
+			givenResolver: func(ctx context.Context) string {
+				return "https://preview-42.example.com/verify-email"
+			},
+			expectedLink: regexp.MustCompile(`^https://preview-42\.example\.com/verify-email/[a-z0-9]{6}$`),
+		},
+		{
+			name: "static endpoint when nothing is resolved",
+			givenResolver: func(ctx context.Context) string {
+				return ""
+			},
+			expectedLink: regexp.MustCompile(`^http://test-app:8080/verify-email/[a-z0-9]{6}$`),
+		},
+		{
+			name: "endpoint with a trailing slash and a query",
+			givenResolver: func(ctx context.Context) string {
+				return "https://example.com/verify-email/?lang=en"
+			},
+			expectedLink: regexp.MustCompile(`^https://example\.com/verify-email/[a-z0-9]{6}\?lang=en$`),
+		},
+		{
+			name: "relative endpoint",
+			givenResolver: func(ctx context.Context) string {
+				return "/verify-email"
+			},
+			expectedErr: errVerificationURLInvalid,
+		},
+		{
+			name: "malformed endpoint",
+			givenResolver: func(ctx context.Context) string {
+				return "https://%zz/verify-email"
+			},
+			expectedErr: errVerificationURLInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				inserted   bool
+				actualBody string
+			)
+
+			opts := []ServiceOption{
+				WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						actualBody = string(body)
+						return nil
+					},
+				}),
+			}
+
+			if tc.givenResolver != nil {
+				opts = append(opts, WithVerificationEndpointResolver(tc.givenResolver))
+			}
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+						inserted = true
+						return nil
+					},
+				},
+				opts...,
+			)
+
+			err := svc.SendEmailVerification(context.Background(), uuid.New().String(), "jdoe", "joedoe@mail.com")
+			if tc.expectedErr != nil {
+				require.Equal(t, tc.expectedErr, err)
+				assert.False(t, inserted)
+				return
+			}
+			require.NoError(t, err)
+
+			link := strings.TrimSuffix(actualBody[strings.LastIndex(actualBody, " ")+1:], "\r\n")
+			assert.Regexp(t, tc.expectedLink, link)
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package users
 
+import "time"
+
 type ParsableError interface {
 	Error() string
 }
@@ -19,13 +21,125 @@ func (e E) Error() string {
 var (
 	// Enumerate service errors
 
-	errAlreadyExists    = newE("user already exists")
-	errForbidenRole     = newE("user role is forbiden")
-	errNotFound         = newE("user not found")
-	errPasswordInvalid  = newE("user password is invalid")
-	errPasswordMismatch = newE("user password mismatch")
-	errRoleInvalid      = newE("user role is invalid")
-	errTokenEmpty       = newE("user token is empty")
-	errTokenExpired     = newE("user token is expired")
-	errTokenInvalid     = newE("user token is invalid")
+	errActionExpired            = newE("user action url is expired")
+	errActionSignatureInvalid   = newE("user action url signature is invalid")
+	errAlreadyExists            = newE("user already exists")
+	errConcurrentModification   = newE("user was modified concurrently, please retry")
+	errCursorInvalid            = newE("user list cursor is invalid")
+	errEmailDomainUnreachable   = newE("user email domain has no mail servers")
+	errEmailerNotConfigured     = newE("user emailer is not configured")
+	errEmailNotVerified         = newE("user email is not verified")
+	errEmailTaken               = newE("user email is already taken")
+	errForbidenRole             = newE("user role is forbiden")
+	errHMACKeyEmpty             = newE("user hmac key is empty")
+	errInvalidCredentials       = newE("user credentials are invalid")
+	errLoginLinkInvalid         = newE("user login link is invalid or expired")
+	errLoginLinkUnavailable     = newE("user login links are not configured")
+	errMalformedAuthHeader      = newE("user authorization header is malformed")
+	errMFAChallengeInvalid      = newE("user mfa challenge is invalid")
+	errMFARequired              = newE("user second factor verification is required")
+	errNotFound                 = newE("user not found")
+	errOAuthCodeInvalid         = newE("user oauth authorization code is invalid")
+	errOAuthEmailUnverified     = newE("user oauth email is not verified by the provider")
+	errOAuthLinkUnverified      = newE("user email must be verified before linking an oauth identity")
+	errOAuthProfileInvalid      = newE("user oauth profile is invalid")
+	errOAuthProviderUnknown     = newE("user oauth provider is not configured")
+	errPasskeyCeremonyInvalid   = newE("user passkey ceremony is invalid or expired")
+	errPasskeyNotFound          = newE("user passkey not found")
+	errPasskeyTaken             = newE("user passkey is already registered")
+	errPasskeyUnavailable       = newE("user passkeys are not configured")
+	errPasswordAuthDisabled     = newE("user password authentication is disabled")
+	errPasswordBreached         = newE("user password was found in a data breach")
+	errPasswordContainsIdentity = newE("user password must not contain the username or email")
+	errPasswordInvalid          = newE("user password is invalid")
+	errPasswordMismatch         = newE("user password mismatch")
+	errPasswordReused           = newE("user password was used recently")
+	errRateLimited              = newE("user rate limit exceeded")
+	errRefreshTokenExpired      = newE("user refresh token is expired")
+	errRefreshTokenInvalid      = newE("user refresh token is invalid")
+	errRefreshTokenReused       = newE("user refresh token was already used")
+	errRefreshTokenRevoked      = newE("user refresh token was revoked")
+	errResetCodeExpired         = newE("user password reset code is expired")
+	errResetCodeNotFound        = newE("user password reset code not found")
+	errResetCodeUsed            = newE("user password reset code was already used")
+	errResetRateLimited         = newE("user password reset requested too soon")
+	errRoleInvalid              = newE("user role is invalid")
+	errSessionExpired           = newE("user session reached its maximum duration")
+	errSessionNotFound          = newE("user session not found")
+	errSessionRevoked           = newE("user session was revoked")
+	errSigningKeyEmpty          = newE("user token signing key is empty")
+	errSigningKeyIDEmpty        = newE("user token signing key id is empty")
+	errSigningKeyIDTaken        = newE("user token signing key id is already used")
+	errSortInvalid              = newE("user list sort is invalid")
+	errStepUpRequired           = newE("user step-up authentication is required")
+	errTokenAudienceEmpty       = newE("user token audience is empty")
+	errTokenAudienceMismatch    = newE("user token is intended for another audience")
+	errTokenBindingMismatch     = newE("user token is bound to another client")
+	errTokenEmpty               = newE("user token is empty")
+	errTokenExpired             = newE("user token is expired")
+	errTokenIDMissing           = newE("user token has no id to revoke")
+	errTokenInvalid             = newE("user token is invalid")
+	errTokenIssuanceThrottled   = newE("user token issuance rate exceeded")
+	errTokenIssuerUntrusted     = newE("user token issuer is not trusted")
+	errTokenRevoked             = newE("user token was revoked")
+	errTokenSuperseded          = newE("user token was superseded by a password change or revocation")
+	errTooManySessions          = newE("user has too many active sessions")
+	errTOTPAlreadyEnabled       = newE("user totp is already enabled")
+	errTOTPCodeInvalid          = newE("user totp code is invalid")
+	errTOTPNotEnrolled          = newE("user totp is not enrolled")
+	errTOTPUnavailable          = newE("user totp is not configured")
+	errUsernameConfusable       = newE("user username could impersonate another user")
+	errUsernameTaken            = newE("user username is already taken")
+	errVerificationExpired      = newE("user email verification is expired")
+	errVerificationNotFound     = newE("user email verification not found")
+	errVerificationUsed         = newE("user email verification was already used")
+	errVerificationURLInvalid   = newE("user email verification endpoint is invalid")
 )
+
+// VerificationSendError is returned per user an email verification could not be sent to
+// by SendEmailVerifications. It matches the cause of the failure with errors.Is.
+type VerificationSendError struct {
+	UserID string
+
+	err error
+}
+
+func (e *VerificationSendError) Error() string {
+	return "could not send email verification to user " + maskID(e.UserID) + ": " + e.err.Error()
+}
+
+func (e *VerificationSendError) Unwrap() error {
+	return e.err
+}
+
+// LoginAttemptsError is returned for a wrong password when the login rate limit is configured
+// (see WithLoginRateLimit). It matches the wrong password error with errors.Is.
+type LoginAttemptsError struct {
+	// AttemptsRemaining is the number of logins left before the login is locked
+	AttemptsRemaining int
+	// LockedUntil is the time the login is unlocked, set when no attempts remain
+	LockedUntil time.Time
+
+	err error
+}
+
+// newLoginAttemptsError returns the wrong password error for the state of the login rate limit
+func newLoginAttemptsError(limit *RateLimitResult) *LoginAttemptsError {
+	e := LoginAttemptsError{
+		AttemptsRemaining: limit.Remaining,
+		err:               errPasswordInvalid,
+	}
+
+	if limit.Remaining == 0 {
+		e.LockedUntil = limit.ResetAt
+	}
+	return &e
+}
+
+func (e *LoginAttemptsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *LoginAttemptsError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,257 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alesr/stdservices/users/repository"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	_ Hasher = (*bcryptHasher)(nil)
+	_ Hasher = (*argon2idHasher)(nil)
+)
+
+const (
+	argon2idPrefix  = "$argon2id$"
+	argon2idSaltLen = 16
+	argon2idKeyLen  = 32
+)
+
+// DefaultArgon2idParams are the argon2id parameters recommended by OWASP: 19 MiB of memory,
+// 2 iterations and a single thread
+var DefaultArgon2idParams = Argon2idParams{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+type (
+	// Hasher hashes the passwords of the users and verifies the passwords against their hashes (see WithPasswordHasher)
+	Hasher interface {
+		// Hash returns the hash of the password, encoding the algorithm and its parameters along with the salt
+		Hash(password string) (string, error)
+
+		// Verify reports whether the password matches the hash
+		Verify(hash, password string) bool
+
+		// NeedsRehash reports whether the hash was produced with another algorithm or weaker parameters
+		// than the hasher uses, in which case the password is rehashed on the next successful login
+		NeedsRehash(hash string) bool
+	}
+
+	// Argon2idParams are the cost parameters of argon2id. Memory is in KiB.
+	Argon2idParams struct {
+		Memory      uint32
+		Iterations  uint32
+		Parallelism uint8
+	}
+
+	bcryptHasher struct {
+		cost int
+	}
+
+	argon2idHasher struct {
+		params Argon2idParams
+	}
+)
+
+// WithPasswordHasher sets the hasher of the passwords, replacing the default bcrypt hasher with the default cost.
+// Passwords hashed with bcrypt or argon2id by another hasher still verify, and are rehashed with the hasher
+// when the users log in, so existing hashes are upgraded transparently.
+func WithPasswordHasher(hasher Hasher) ServiceOption {
+	return func(s *DefaultService) {
+		s.passwordHasher = hasher
+	}
+}
+
+// WithBcryptCost hashes the passwords with bcrypt at the given cost instead of bcrypt.DefaultCost.
+// Each increment doubles the hashing time, and so the latency of logins (see BenchmarkBcryptHasher).
+// It panics if the cost is outside bcrypt's range of 4 to 31.
+func WithBcryptCost(cost int) ServiceOption {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		panic(fmt.Sprintf("bcrypt cost %d is outside the range %d-%d", cost, bcrypt.MinCost, bcrypt.MaxCost))
+	}
+
+	return func(s *DefaultService) {
+		s.passwordHasher = NewBcryptHasher(cost)
+	}
+}
+
+// NewBcryptHasher returns a bcrypt hasher of the given cost, or of the default cost when cost is not positive
+func NewBcryptHasher(cost int) Hasher {
+	if cost <= 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &bcryptHasher{cost: cost}
+}
+
+// Hash returns the bcrypt hash of the password
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify reports whether the password matches the bcrypt hash
+func (h *bcryptHasher) Verify(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NeedsRehash reports whether the hash is not a bcrypt hash or has a lower cost than the hasher
+func (h *bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.cost
+}
+
+// NewArgon2idHasher returns an argon2id hasher with the parameters (see DefaultArgon2idParams).
+// It panics if Iterations or Parallelism is zero, or Memory is lower than 8 KiB per thread.
+func NewArgon2idHasher(params Argon2idParams) Hasher {
+	if err := params.validate(); err != nil {
+		panic(err.Error())
+	}
+	return &argon2idHasher{params: params}
+}
+
+// validate returns an error for parameters argon2 can't hash with
+func (p Argon2idParams) validate() error {
+	if p.Iterations < 1 || p.Parallelism < 1 || p.Memory < 8*uint32(p.Parallelism) {
+		return fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d: iterations and parallelism must be positive "+
+			"and memory at least 8 KiB per thread", p.Memory, p.Iterations, p.Parallelism)
+	}
+	return nil
+}
+
+// Hash returns the argon2id hash of the password with a random salt, in the PHC string format
+// (e.g. "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>")
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not read random source: %s", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, argon2idKeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether the password matches the argon2id hash, recomputed with the parameters of the hash
+func (h *argon2idHasher) Verify(hash, password string) bool {
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+// NeedsRehash reports whether the hash is not an argon2id hash or has weaker parameters than the hasher
+func (h *argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := parseArgon2idHash(hash)
+	return err != nil || params.Memory < h.params.Memory || params.Iterations < h.params.Iterations ||
+		params.Parallelism < h.params.Parallelism
+}
+
+// parseArgon2idHash parses an argon2id hash in the PHC string format into its parameters, salt and key
+func parseArgon2idHash(hash string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(strings.TrimPrefix(hash, argon2idPrefix), "$")
+	if !strings.HasPrefix(hash, argon2idPrefix) || len(parts) != 4 {
+		return params, nil, nil, errors.New("hash is not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("argon2id version is not supported")
+	}
+
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("could not parse argon2id parameters: %s", err)
+	}
+
+	if err := params.validate(); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("could not decode argon2id salt: %s", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("could not decode argon2id key")
+	}
+	return params, salt, key, nil
+}
+
+// hasher returns the configured password hasher, or the default bcrypt hasher
+func (s *DefaultService) hasher() Hasher {
+	if s.passwordHasher == nil {
+		return NewBcryptHasher(bcrypt.DefaultCost)
+	}
+	return s.passwordHasher
+}
+
+// hashPassword hashes a password with the configured hasher
+func (s *DefaultService) hashPassword(password string) (string, error) {
+	hash, err := s.hasher().Hash(password)
+	if err != nil {
+		return "", fmt.Errorf("could not hash password: %s", err)
+	}
+	return hash, nil
+}
+
+// verifyPassword reports whether the password matches the hash. Hashes of the configured hasher are
+// verified by it, while bcrypt and argon2id hashes of previous hashers are verified by the built-in ones.
+func (s *DefaultService) verifyPassword(hash, password string) bool {
+	hasher := s.hasher()
+
+	if hasher.NeedsRehash(hash) {
+		switch {
+		case strings.HasPrefix(hash, argon2idPrefix):
+			hasher = NewArgon2idHasher(DefaultArgon2idParams)
+		case strings.HasPrefix(hash, "$2"):
+			hasher = NewBcryptHasher(bcrypt.DefaultCost)
+		}
+	}
+	return hasher.Verify(hash, password)
+}
+
+// rehashPassword rehashes the password of a user who just logged in with the configured hasher, when the
+// stored hash was produced with another algorithm or weaker parameters. It is called once the login succeeded,
+// so refused logins (e.g. rate limited) don't write. The hash is only replaced if it didn't change meanwhile.
+// Failures are logged only, the login must not fail because of the rehash.
+func (s *DefaultService) rehashPassword(ctx context.Context, storageUser *repository.User, password string) {
+	if !s.hasher().NeedsRehash(storageUser.PasswordHash) {
+		return
+	}
+
+	hash, err := s.hashPassword(password)
+	if err != nil {
+		s.logger.Error("could not rehash password", userIDField(storageUser.ID), errorField(err))
+		return
+	}
+
+	if err := s.repo.UpdatePasswordHash(ctx, storageUser.ID, storageUser.PasswordHash, hash); err != nil {
+		if !errors.Is(err, repository.ErrRecordNotFound) {
+			s.logger.Error("could not update password hash", userIDField(storageUser.ID), errorField(err))
+		}
+		return
+	}
+
+	storageUser.PasswordHash = hash
+	s.invalidateCachedUser(ctx, storageUser.ID)
+
+	s.logger.Debug("password rehashed", userIDField(storageUser.ID))
+}
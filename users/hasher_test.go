@@ -0,0 +1,353 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams keep the argon2id hashes cheap in the tests
+var testArgon2idParams = Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestHasher(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	testCases := []struct {
+		name           string
+		givenHasher    Hasher
+		expectedPrefix string
+	}{
+		{
+			name:           "bcrypt",
+			givenHasher:    NewBcryptHasher(bcrypt.MinCost),
+			expectedPrefix: "$2a$04$",
+		},
+		{
+			name:           "argon2id",
+			givenHasher:    NewArgon2idHasher(testArgon2idParams),
+			expectedPrefix: "$argon2id$v=19$m=64,t=1,p=1$",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			hash, err := tc.givenHasher.Hash(password)
+			require.NoError(t, err)
+
+			assert.True(t, strings.HasPrefix(hash, tc.expectedPrefix), hash)
+			assert.True(t, tc.givenHasher.Verify(hash, password))
+			assert.False(t, tc.givenHasher.Verify(hash, "wrong-password"))
+			assert.False(t, tc.givenHasher.NeedsRehash(hash))
+
+			// Hashes are salted
+			other, err := tc.givenHasher.Hash(password)
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, other)
+		})
+	}
+}
+
+func TestWithBcryptCost(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenCost     int
+		expectedPanic bool
+	}{
+		{
+			name:      "min cost",
+			givenCost: bcrypt.MinCost,
+		},
+		{
+			name:      "max cost",
+			givenCost: bcrypt.MaxCost,
+		},
+		{
+			name:          "cost below min",
+			givenCost:     bcrypt.MinCost - 1,
+			expectedPanic: true,
+		},
+		{
+			name:          "cost above max",
+			givenCost:     bcrypt.MaxCost + 1,
+			expectedPanic: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.expectedPanic {
+				assert.Panics(t, func() { WithBcryptCost(tc.givenCost) })
+				return
+			}
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithBcryptCost(tc.givenCost))
+			assert.Equal(t, &bcryptHasher{cost: tc.givenCost}, svc.hasher())
+		})
+	}
+}
+
+func TestNewArgon2idHasher(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenParams   Argon2idParams
+		expectedPanic bool
+	}{
+		{
+			name:        "default params",
+			givenParams: DefaultArgon2idParams,
+		},
+		{
+			name:          "zero params",
+			givenParams:   Argon2idParams{},
+			expectedPanic: true,
+		},
+		{
+			name:          "zero iterations",
+			givenParams:   Argon2idParams{Memory: 64, Parallelism: 1},
+			expectedPanic: true,
+		},
+		{
+			name:          "memory below 8 KiB per thread",
+			givenParams:   Argon2idParams{Memory: 8, Iterations: 1, Parallelism: 2},
+			expectedPanic: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.expectedPanic {
+				assert.Panics(t, func() { NewArgon2idHasher(tc.givenParams) })
+				return
+			}
+			assert.Equal(t, &argon2idHasher{params: tc.givenParams}, NewArgon2idHasher(tc.givenParams))
+		})
+	}
+
+	t.Run("hash of zero params", func(t *testing.T) {
+		t.Parallel()
+
+		hash := "$argon2id$v=19$m=0,t=0,p=0$c2FsdA$a2V5"
+		assert.False(t, NewArgon2idHasher(testArgon2idParams).Verify(hash, "password"))
+	})
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	t.Parallel()
+
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("foo")
+	require.NoError(t, err)
+
+	argon2idHash, err := NewArgon2idHasher(testArgon2idParams).Hash("foo")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		givenHasher Hasher
+		givenHash   string
+		expected    bool
+	}{
+		{
+			name:        "bcrypt hash of a lower cost",
+			givenHasher: NewBcryptHasher(bcrypt.MinCost + 1),
+			givenHash:   bcryptHash,
+			expected:    true,
+		},
+		{
+			name:        "bcrypt hash of a higher cost",
+			givenHasher: NewBcryptHasher(bcrypt.MinCost),
+			givenHash:   bcryptHash,
+		},
+		{
+			name:        "argon2id hash for bcrypt",
+			givenHasher: NewBcryptHasher(bcrypt.MinCost),
+			givenHash:   argon2idHash,
+			expected:    true,
+		},
+		{
+			name:        "argon2id hash of less memory",
+			givenHasher: NewArgon2idHasher(Argon2idParams{Memory: 128, Iterations: 1, Parallelism: 1}),
+			givenHash:   argon2idHash,
+			expected:    true,
+		},
+		{
+			name:        "argon2id hash of more iterations",
+			givenHasher: NewArgon2idHasher(Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1}),
+			givenHash:   strings.Replace(argon2idHash, "t=1", "t=2", 1),
+		},
+		{
+			name:        "bcrypt hash for argon2id",
+			givenHasher: NewArgon2idHasher(testArgon2idParams),
+			givenHash:   bcryptHash,
+			expected:    true,
+		},
+		{
+			name:        "malformed argon2id hash",
+			givenHasher: NewArgon2idHasher(testArgon2idParams),
+			givenHash:   "$argon2id$v=19$m=64,t=1,p=1$foo",
+			expected:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, tc.givenHasher.NeedsRehash(tc.givenHash))
+		})
+	}
+}
+
+func TestRehashPasswordOnLogin(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	currentHash, err := NewArgon2idHasher(testArgon2idParams).Hash(password)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		givenHash      string
+		givenPassword  string
+		givenIssued    int
+		expectedRehash bool
+		expectedErr    error
+	}{
+		{
+			name:           "legacy hash is rehashed",
+			givenHash:      string(legacyHash),
+			givenPassword:  password,
+			expectedRehash: true,
+		},
+		{
+			name:          "current hash is kept",
+			givenHash:     currentHash,
+			givenPassword: password,
+		},
+		{
+			name:          "legacy hash is kept on wrong password",
+			givenHash:     string(legacyHash),
+			givenPassword: "wrong%&456",
+			expectedErr:   errPasswordInvalid,
+		},
+		{
+			name:          "legacy hash is kept when the token is refused",
+			givenHash:     string(legacyHash),
+			givenPassword: password,
+			givenIssued:   1,
+			expectedErr:   errTokenIssuanceThrottled,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			givenUser := &repository.User{
+				ID:           uuid.New().String(),
+				Role:         string(RoleUser),
+				Email:        "joedoe@mail.com",
+				PasswordHash: tc.givenHash,
+			}
+
+			var newHash string
+
+			cache := NewLRUUserCache(1)
+			require.NoError(t, cache.Set(context.Background(), givenUser, time.Hour))
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+						return givenUser, nil
+					},
+					updatePasswordHashFunc: func(ctx context.Context, id, currentHash, hash string) error {
+						assert.Equal(t, givenUser.ID, id)
+						assert.Equal(t, tc.givenHash, currentHash)
+						newHash = hash
+						return nil
+					},
+				},
+				WithPasswordHasher(NewArgon2idHasher(testArgon2idParams)),
+				WithTokenIssuanceRate(1, time.Hour),
+				WithUserCache(cache, time.Hour),
+			)
+			svc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+			for i := 0; i < tc.givenIssued; i++ {
+				require.NoError(t, svc.allowTokenIssuance(context.Background(), givenUser.ID))
+			}
+
+			_, err := svc.GenerateToken(context.Background(), givenUser.Email, tc.givenPassword)
+			assert.Equal(t, tc.expectedErr, err)
+
+			if !tc.expectedRehash {
+				assert.Empty(t, newHash)
+				return
+			}
+
+			// Cached users don't keep the replaced hash
+			cached, err := cache.Get(context.Background(), givenUser.ID)
+			require.NoError(t, err)
+			assert.Nil(t, cached)
+
+			require.True(t, strings.HasPrefix(newHash, argon2idPrefix), newHash)
+			assert.True(t, svc.verifyPassword(newHash, password))
+		})
+	}
+}
+
+// BenchmarkBcryptHasher documents the hashing latency per bcrypt cost, which each login pays once:
+//
+//	go test -run ^$ -bench BenchmarkBcryptHasher ./users
+func BenchmarkBcryptHasher(b *testing.B) {
+	for _, cost := range []int{bcrypt.MinCost, bcrypt.DefaultCost, 12, 14} {
+		hasher := NewBcryptHasher(cost)
+
+		b.Run(fmt.Sprintf("cost=%d", cost), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := hasher.Hash("password%&123"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkArgon2idHasher documents the hashing latency of the default argon2id parameters
+func BenchmarkArgon2idHasher(b *testing.B) {
+	hasher := NewArgon2idHasher(DefaultArgon2idParams)
+
+	for i := 0; i < b.N; i++ {
+		if _, err := hasher.Hash("password%&123"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
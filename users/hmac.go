@@ -0,0 +1,51 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// WithHMACKey sets the secret keying the HMACs of the login links, passkey ceremony states, signed action URLs
+// and hashed verification codes, by default the signing key passed to New. It is required when these features are enabled along with a Signer
+// (see WithSigner) and an empty signing key, as nothing could be verified with an empty secret.
+func WithHMACKey(key string) ServiceOption {
+	return func(s *DefaultService) {
+		s.hmacKey = key
+	}
+}
+
+// macKey returns the secret keying the HMACs of the service
+func (s *DefaultService) macKey() string {
+	if s.hmacKey != "" {
+		return s.hmacKey
+	}
+	return s.jwtSigningKey
+}
+
+// hmacRequired reports whether a feature signing its payloads with the HMAC secret is enabled
+func (s *DefaultService) hmacRequired() bool {
+	return s.loginLinkEndpoint != "" || s.webauthn != nil || s.actionEndpoint != "" || s.hashedVerificationCodes
+}
+
+// signHMAC returns the URL safe HMAC of the payload for the purpose, failing with errHMACKeyEmpty
+// rather than signing with an empty secret anyone could sign with
+func (s *DefaultService) signHMAC(purpose, payload string) (string, error) {
+	key := s.macKey()
+	if key == "" {
+		return "", errHMACKeyEmpty
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose + "\n" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyHMAC reports whether the signature is the HMAC of the payload for the purpose
+func (s *DefaultService) verifyHMAC(purpose, payload, signature string) (bool, error) {
+	expected, err := s.signHMAC(purpose, payload)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal([]byte(signature), []byte(expected)), nil
+}
@@ -0,0 +1,64 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WithIDGenerator sets the generator of the ids of new users and the validation of the ids
+// received by the service. When validateID is nil, ids are validated by the Validator
+// (as UUIDs by default, see WithValidator).
+// The PostgreSQL schema stores ids as UUIDs, so generators of other formats (e.g. ULIDs)
+// require a matching schema.
+func WithIDGenerator(generate func() string, validateID func(id string) error) ServiceOption {
+	return func(s *DefaultService) {
+		s.idGenerator = generate
+		s.idValidator = validateID
+	}
+}
+
+// NewUUIDv4 returns a random UUID (version 4). It is the default id generator.
+func NewUUIDv4() string {
+	return uuid.NewString()
+}
+
+// NewUUIDv7 returns a time-ordered UUID (version 7, RFC 9562) made of a millisecond timestamp
+// followed by random bits. Ids created later sort after, so new rows are appended to the end
+// of the primary key index rather than scattered across it, improving the index locality.
+func NewUUIDv7() string {
+	var id uuid.UUID
+
+	// random bits, overwritten below by the timestamp, version and variant
+	if _, err := rand.Read(id[:]); err != nil {
+		// falling back to a random UUID keeps ids unique at the cost of their ordering
+		return uuid.NewString()
+	}
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(id[:6], ts[2:])
+
+	id[6] = id[6]&0x0f | 0x70 // version 7
+	id[8] = id[8]&0x3f | 0x80 // variant RFC 4122
+
+	return id.String()
+}
+
+// newID returns the id of a new user
+func (s *DefaultService) newID() string {
+	if s.idGenerator == nil {
+		return NewUUIDv4()
+	}
+	return s.idGenerator()
+}
+
+// validateID validates the ids received by the service
+func (s *DefaultService) validateID(id string) error {
+	if s.idValidator == nil {
+		return s.validator().ValidateID(id)
+	}
+	return s.idValidator(id)
+}
@@ -0,0 +1,94 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+	t.Parallel()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, NewUUIDv7())
+		time.Sleep(time.Millisecond * 2)
+	}
+
+	for i, id := range ids {
+		parsed, err := uuid.Parse(id)
+		require.NoError(t, err)
+
+		assert.Equal(t, uuid.Version(7), parsed.Version())
+		assert.Equal(t, uuid.RFC4122, parsed.Variant())
+
+		if i > 0 {
+			assert.True(t, ids[i-1] < id, "ids must be time-ordered")
+		}
+	}
+}
+
+func TestWithIDGenerator(t *testing.T) {
+	t.Parallel()
+
+	givenInput := CreateUserInput{
+		Fullname:        "Joe Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	givenRepoMock := &repositoryMock{
+		insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+			return user, nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Role: string(RoleUser)}, nil
+		},
+	}
+
+	t.Run("uuid v7 generator", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", givenRepoMock, WithIDGenerator(NewUUIDv7, nil))
+
+		user, err := svc.Create(context.Background(), givenInput)
+		require.NoError(t, err)
+
+		assert.Equal(t, uuid.Version(7), uuid.MustParse(user.ID).Version())
+
+		_, err = svc.FetchByID(context.Background(), user.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("custom generator and validator", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", givenRepoMock, WithIDGenerator(
+			func() string { return "usr_123" },
+			func(id string) error {
+				if !strings.HasPrefix(id, "usr_") {
+					return errors.New("invalid id")
+				}
+				return nil
+			},
+		))
+
+		user, err := svc.Create(context.Background(), givenInput)
+		require.NoError(t, err)
+
+		assert.Equal(t, "usr_123", user.ID)
+
+		_, err = svc.FetchByID(context.Background(), user.ID)
+		require.NoError(t, err)
+
+		_, err = svc.FetchByID(context.Background(), uuid.New().String())
+		require.Error(t, err)
+	})
+}
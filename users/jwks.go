@@ -0,0 +1,182 @@
+package users
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// jwksMaxAge is how long, in seconds, verifiers may cache the key set served by JWKSHandler.
+// It must stay well below the key rotation grace period, so verifiers fetch a rotated key in time.
+const jwksMaxAge = 300
+
+type (
+	// JWKSet is a RFC 7517 JSON Web Key Set
+	JWKSet struct {
+		Keys []JWK `json:"keys"`
+	}
+
+	// JWK is a RFC 7517 JSON Web Key holding a RSA, ECDSA or Ed25519 public key,
+	// its fields being base64url encoded as in the JSON form
+	JWK struct {
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Kid string `json:"kid,omitempty"`
+		N   string `json:"n,omitempty"`
+		E   string `json:"e,omitempty"`
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		Y   string `json:"y,omitempty"`
+	}
+
+	publicKey struct {
+		kid string
+		key crypto.PublicKey
+	}
+)
+
+// WithPublicKey adds a RSA, ECDSA or Ed25519 public key, identified by kid,
+// to the keys published by JWKS for external token verifiers
+func WithPublicKey(kid string, key crypto.PublicKey) ServiceOption {
+	return func(s *DefaultService) {
+		s.publicKeys = append(s.publicKeys, publicKey{kid: kid, key: key})
+	}
+}
+
+// Keys returns the public keys of the current and retired signers (see WithSigner and RotateSigningKey)
+// and the configured public keys as a RFC 7517 JSON Web Key Set.
+// Tokens signed with a shared secret can't be verified by third parties, so their key is never published.
+func (s *DefaultService) Keys(ctx context.Context) (*JWKSet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var publicKeys []publicKey
+	for _, signer := range s.activeSigners() {
+		if key := signer.PublicKey(); key != nil && !s.hasPublicKey(signer.KeyID()) {
+			publicKeys = append(publicKeys, publicKey{kid: signer.KeyID(), key: key})
+		}
+	}
+	publicKeys = append(publicKeys, s.publicKeys...)
+
+	set := JWKSet{Keys: make([]JWK, 0, len(publicKeys))}
+
+	for _, pk := range publicKeys {
+		key, err := newJWK(pk.kid, pk.key)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode public key '%s': %s", pk.kid, err)
+		}
+		set.Keys = append(set.Keys, *key)
+	}
+	return &set, nil
+}
+
+// JWKS returns the key set returned by Keys in its JSON form, to be served at "/.well-known/jwks.json"
+func (s *DefaultService) JWKS() ([]byte, error) {
+	return s.marshalKeys(context.Background())
+}
+
+// marshalKeys returns the key set returned by Keys in its JSON form
+func (s *DefaultService) marshalKeys(ctx context.Context) ([]byte, error) {
+	set, err := s.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal jwks: %s", err)
+	}
+	return b, nil
+}
+
+// hasPublicKey reports whether a public key identified by kid was configured with WithPublicKey
+func (s *DefaultService) hasPublicKey(kid string) bool {
+	for _, pk := range s.publicKeys {
+		if pk.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// JWKSHandler returns a handler serving the key set returned by Keys, to be mounted at "/.well-known/jwks.json"
+// so downstream services can verify the issued tokens on their own. The key set is built on every request,
+// so rotated keys are served right away.
+func (s *DefaultService) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, err := s.marshalKeys(r.Context())
+		if err != nil {
+			s.logger.Error("could not build jwks", zap.String("operation", "serve_jwks"), errorField(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+		w.Write(b)
+	})
+}
+
+func newJWK(kid string, key crypto.PublicKey) (*JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		var crv string
+		switch k.Curve {
+		case elliptic.P256():
+			crv = "P-256"
+		case elliptic.P384():
+			crv = "P-384"
+		case elliptic.P521():
+			crv = "P-521"
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Curve.Params().Name)
+		}
+
+		// coordinates are padded to the curve size as required by RFC 7518
+		size := (k.Curve.Params().BitSize + 7) / 8
+
+		return &JWK{
+			Kty: "EC",
+			Use: "sig",
+			Kid: kid,
+			Crv: crv,
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			Kty: "OKP",
+			Use: "sig",
+			Kid: kid,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", key)
+	}
+}
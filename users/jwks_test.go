@@ -0,0 +1,188 @@
+package users
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestJWKS(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	decode := func(t *testing.T, s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		require.NoError(t, err)
+		return new(big.Int).SetBytes(b)
+	}
+
+	t.Run("rsa and ecdsa keys", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+			WithPublicKey("rsa-1", &rsaKey.PublicKey),
+			WithPublicKey("ec-1", &ecKey.PublicKey),
+		)
+
+		b, err := svc.JWKS()
+		require.NoError(t, err)
+
+		var actual JWKSet
+		require.NoError(t, json.Unmarshal(b, &actual))
+
+		require.Len(t, actual.Keys, 2)
+
+		assert.Equal(t, "RSA", actual.Keys[0].Kty)
+		assert.Equal(t, "rsa-1", actual.Keys[0].Kid)
+		assert.Equal(t, rsaKey.N, decode(t, actual.Keys[0].N))
+		assert.Equal(t, int64(rsaKey.E), decode(t, actual.Keys[0].E).Int64())
+
+		assert.Equal(t, "EC", actual.Keys[1].Kty)
+		assert.Equal(t, "ec-1", actual.Keys[1].Kid)
+		assert.Equal(t, "P-256", actual.Keys[1].Crv)
+		assert.Equal(t, ecKey.X, decode(t, actual.Keys[1].X))
+		assert.Equal(t, ecKey.Y, decode(t, actual.Keys[1].Y))
+	})
+
+	t.Run("no public keys", func(t *testing.T) {
+		b, err := New(zap.NewNop(), "jwt-secret", &repositoryMock{}).JWKS()
+		require.NoError(t, err)
+
+		assert.JSONEq(t, `{"keys":[]}`, string(b))
+	})
+
+	t.Run("ed25519 key", func(t *testing.T) {
+		edKey, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		b, err := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithPublicKey("ed-1", edKey)).JWKS()
+		require.NoError(t, err)
+
+		var actual JWKSet
+		require.NoError(t, json.Unmarshal(b, &actual))
+
+		require.Len(t, actual.Keys, 1)
+
+		assert.Equal(t, "OKP", actual.Keys[0].Kty)
+		assert.Equal(t, "Ed25519", actual.Keys[0].Crv)
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(edKey), actual.Keys[0].X)
+	})
+
+	t.Run("signer public key", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+			WithSigner(NewRSASigner(rsaKey, "rsa-1")),
+			WithPublicKey("ec-1", &ecKey.PublicKey),
+		)
+
+		b, err := svc.JWKS()
+		require.NoError(t, err)
+
+		var actual JWKSet
+		require.NoError(t, json.Unmarshal(b, &actual))
+
+		require.Len(t, actual.Keys, 2)
+
+		assert.Equal(t, "rsa-1", actual.Keys[0].Kid)
+		assert.Equal(t, "ec-1", actual.Keys[1].Kid)
+	})
+
+	t.Run("typed key set", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+			WithSigner(NewRSASigner(rsaKey, "rsa-1")),
+			WithPublicKey("ec-1", &ecKey.PublicKey),
+		)
+
+		set, err := svc.Keys(context.Background())
+		require.NoError(t, err)
+
+		b, err := svc.JWKS()
+		require.NoError(t, err)
+
+		// JWKS is the JSON form of Keys
+		var actual JWKSet
+		require.NoError(t, json.Unmarshal(b, &actual))
+		assert.Equal(t, *set, actual)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = svc.Keys(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("unsupported key type", func(t *testing.T) {
+		_, err = New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithPublicKey("foo-1", "foo")).JWKS()
+		require.Error(t, err)
+	})
+}
+
+func TestJWKSHandler(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithSigner(NewRSASigner(rsaKey, "rsa-1")))
+
+	testCases := []struct {
+		name               string
+		givenMethod        string
+		expectedStatusCode int
+	}{
+		{
+			name:               "get",
+			givenMethod:        http.MethodGet,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "head",
+			givenMethod:        http.MethodHead,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "post",
+			givenMethod:        http.MethodPost,
+			expectedStatusCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			svc.JWKSHandler().ServeHTTP(rec, httptest.NewRequest(tc.givenMethod, "/.well-known/jwks.json", nil))
+
+			require.Equal(t, tc.expectedStatusCode, rec.Code)
+
+			if tc.givenMethod != http.MethodGet {
+				return
+			}
+
+			assert.Equal(t, "application/jwk-set+json", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+
+			expected, err := svc.JWKS()
+			require.NoError(t, err)
+
+			assert.JSONEq(t, string(expected), rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package users
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+// listCursor is the position a listing resumes from, encoded in the page cursors
+type listCursor struct {
+	SortBy    UserSort  `json:"s"`
+	CreatedAt time.Time `json:"c,omitempty"`
+	Username  string    `json:"u,omitempty"`
+	ID        string    `json:"i"`
+}
+
+// List lists a page of non-deleted users matching the input filters, sorted by creation time
+// (the default) or username. Pages are limited as set with WithPagination and continue from the
+// cursor of the previous page, so listings stay consistent while users are created or deleted.
+func (s *DefaultService) List(ctx context.Context, in ListUsersInput) (*UserPage, error) {
+	if in.SortBy == "" {
+		in.SortBy = UserSortCreatedAt
+	}
+
+	if in.SortBy != UserSortCreatedAt && in.SortBy != UserSortUsername {
+		return nil, errSortInvalid
+	}
+
+	if in.Role != "" && in.Role != RoleUser && in.Role != RoleAdmin {
+		return nil, errRoleInvalid
+	}
+
+	limit := s.pageLimit(in.Limit)
+
+	filter := repository.UserFilter{
+		Role:          string(in.Role),
+		EmailVerified: in.EmailVerified,
+		SortBy:        string(in.SortBy),
+		// One more user tells whether there is a next page
+		Limit: limit + 1,
+	}
+
+	if !in.CreatedAfter.IsZero() {
+		filter.CreatedAfter = &in.CreatedAfter
+	}
+
+	if in.Cursor != "" {
+		cursor, err := decodeListCursor(in.Cursor)
+		if err != nil || cursor.SortBy != in.SortBy {
+			return nil, errCursorInvalid
+		}
+
+		filter.After = &repository.UserCursor{
+			CreatedAt:          cursor.CreatedAt,
+			UsernameNormalized: cursor.Username,
+			ID:                 cursor.ID,
+		}
+	}
+
+	storageUsers, err := s.repo.SelectUsers(ctx, filter)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select users", err)
+	}
+
+	var page UserPage
+
+	if len(storageUsers) > limit {
+		storageUsers = storageUsers[:limit]
+
+		last := storageUsers[limit-1]
+		page.NextCursor = encodeListCursor(listCursor{
+			SortBy:    in.SortBy,
+			CreatedAt: last.CreatedAt,
+			Username:  last.UsernameNormalized,
+			ID:        last.ID,
+		})
+	}
+
+	page.Users = make([]User, 0, len(storageUsers))
+	for i := range storageUsers {
+		user, err := s.userFromRepository(&storageUsers[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+		}
+		page.Users = append(page.Users, *user)
+	}
+	return &page, nil
+}
+
+// encodeListCursor encodes a cursor into an opaque string
+func encodeListCursor(cursor listCursor) string {
+	// Marshaling a struct of strings and a time doesn't fail
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeListCursor decodes a cursor encoded with encodeListCursor
+func decodeListCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, err
+	}
+
+	if cursor.ID == "" {
+		return nil, errCursorInvalid
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,142 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Stored oldest first, as listed by creation time
+	var storedUsers []repository.User
+	for _, username := range []string{"eve", "dave", "carol", "bob", "alice"} {
+		storedUsers = append(storedUsers, repository.User{
+			ID:                 uuid.New().String(),
+			Username:           username,
+			UsernameNormalized: username,
+			Role:               string(RoleUser),
+			CreatedAt:          createdAt,
+		})
+		createdAt = createdAt.Add(time.Hour)
+	}
+
+	var actualFilters []repository.UserFilter
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectUsersFunc: func(ctx context.Context, filter repository.UserFilter) ([]repository.User, error) {
+				actualFilters = append(actualFilters, filter)
+
+				var users []repository.User
+				for _, u := range storedUsers {
+					if filter.After != nil && !u.CreatedAt.After(filter.After.CreatedAt) {
+						continue
+					}
+					if len(users) < filter.Limit {
+						users = append(users, u)
+					}
+				}
+				return users, nil
+			},
+		},
+		WithPagination(2, 10),
+	)
+
+	t.Run("users are paginated", func(t *testing.T) {
+		var (
+			actualUsernames []string
+			cursor          string
+			pages           int
+		)
+
+		for {
+			page, err := svc.List(context.Background(), ListUsersInput{Cursor: cursor})
+			require.NoError(t, err)
+
+			for _, u := range page.Users {
+				actualUsernames = append(actualUsernames, u.Username)
+			}
+
+			pages++
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		assert.Equal(t, 3, pages)
+		assert.Equal(t, []string{"eve", "dave", "carol", "bob", "alice"}, actualUsernames)
+	})
+
+	t.Run("filters are passed to the repository", func(t *testing.T) {
+		verified := true
+		createdAfter := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		_, err := svc.List(context.Background(), ListUsersInput{
+			Role:          RoleAdmin,
+			EmailVerified: &verified,
+			CreatedAfter:  createdAfter,
+			SortBy:        UserSortUsername,
+			Limit:         5,
+		})
+		require.NoError(t, err)
+
+		actual := actualFilters[len(actualFilters)-1]
+
+		assert.Equal(t, repository.UserFilter{
+			Role:          string(RoleAdmin),
+			EmailVerified: &verified,
+			CreatedAfter:  &createdAfter,
+			SortBy:        "username",
+			Limit:         6,
+		}, actual)
+	})
+
+	testCases := []struct {
+		name        string
+		givenInput  ListUsersInput
+		expectedErr error
+	}{
+		{
+			name:        "invalid sort",
+			givenInput:  ListUsersInput{SortBy: "email"},
+			expectedErr: errSortInvalid,
+		},
+		{
+			name:        "invalid role",
+			givenInput:  ListUsersInput{Role: "root"},
+			expectedErr: errRoleInvalid,
+		},
+		{
+			name:        "malformed cursor",
+			givenInput:  ListUsersInput{Cursor: "foo"},
+			expectedErr: errCursorInvalid,
+		},
+		{
+			name: "cursor of another sort",
+			givenInput: ListUsersInput{
+				SortBy: UserSortUsername,
+				Cursor: encodeListCursor(listCursor{SortBy: UserSortCreatedAt, ID: uuid.New().String()}),
+			},
+			expectedErr: errCursorInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.List(context.Background(), tc.givenInput)
+			assert.Equal(t, tc.expectedErr, err)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package users
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// WithLogLevel sets the minimum level of the service logs, on top of the level of the given logger.
+// Failed logins are logged at info level, invalid tokens at debug level and storage failures at error level.
+// Passwords, tokens and hashes are never logged, and user ids and emails are masked (see MaskEmail).
+// Without a logger, logs are discarded whatever the level.
+func WithLogLevel(level zapcore.Level) ServiceOption {
+	return func(s *DefaultService) {
+		if s.logger == nil {
+			s.logger = zap.NewNop()
+			return
+		}
+
+		s.logger = s.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return &levelCore{Core: core, level: level}
+		}))
+	}
+}
+
+// levelCore drops the entries below level
+type levelCore struct {
+	zapcore.Core
+	level zapcore.Level
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return level >= c.level && c.Core.Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// userIDField returns the log field of a user id, masked so that log lines can be
+// correlated without identifying the user
+func userIDField(id string) zap.Field {
+	return zap.String("user_id", maskID(id))
+}
+
+// errorField returns the log field of an error, with the emails in its message masked
+func errorField(err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+	return zap.String("error", maskEmails(err.Error()))
+}
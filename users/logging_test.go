@@ -0,0 +1,139 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWithLogLevel(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	logger := New(zap.New(core), "jwt-secret", &repositoryMock{}, WithLogLevel(zapcore.InfoLevel)).logger
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.With(zap.String("key", "value")).Debug("debug with fields")
+	logger.Error("error")
+
+	var actual []string
+	for _, entry := range logs.All() {
+		actual = append(actual, entry.Message)
+	}
+	assert.Equal(t, []string{"info", "error"}, actual)
+
+	t.Run("nil logger", func(t *testing.T) {
+		t.Parallel()
+
+		var svc DefaultService
+		require.NotPanics(t, func() { WithLogLevel(zapcore.InfoLevel)(&svc) })
+
+		svc.logger.Info("info")
+	})
+}
+
+func TestGenerateToken_logging(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	svc := New(zap.New(core), "jwt-secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return &repository.User{
+				ID:           uuid.New().String(),
+				Role:         string(RoleUser),
+				Email:        email,
+				PasswordHash: string(givenHash),
+			}, nil
+		},
+	})
+
+	_, err = svc.GenerateToken(context.Background(), "joedoe@mail.com", "wrong%&password123")
+	require.Equal(t, errPasswordInvalid, err)
+
+	token, err := svc.GenerateToken(context.Background(), "joedoe@mail.com", password)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), token+"tampered")
+	require.Error(t, err)
+
+	require.Equal(t, 1, logs.FilterMessage("login failed").Len())
+	require.Equal(t, 1, logs.FilterMessage("login succeeded").Len())
+	require.Equal(t, 1, logs.FilterMessage("invalid token").Len())
+
+	for _, entry := range logs.All() {
+		for _, value := range entry.ContextMap() {
+			s, _ := value.(string)
+			assert.NotContains(t, s, password)
+			assert.NotContains(t, s, "wrong%&password123")
+			assert.NotContains(t, s, string(givenHash))
+			assert.NotContains(t, s, token)
+		}
+	}
+}
+
+func TestCreate_loggingMasksPII(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	var givenUserID string
+
+	svc := New(zap.New(core), "jwt-secret",
+		&repositoryMock{
+			insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				givenUserID = user.ID
+				return user, nil
+			},
+			insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+				return nil
+			},
+		},
+		WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error {
+				return fmt.Errorf("550 mailbox %s unavailable", to)
+			},
+		}),
+	)
+
+	_, err := svc.Create(context.Background(), CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	})
+	require.NoError(t, err)
+
+	failures := logs.FilterMessage("could not send email verification").All()
+	require.Len(t, failures, 1)
+
+	fields := failures[0].ContextMap()
+	assert.Equal(t, maskID(givenUserID), fields["user_id"])
+	assert.Contains(t, fields["error"], "j***@mail.com")
+
+	for _, entry := range logs.All() {
+		for _, value := range entry.ContextMap() {
+			s, _ := value.(string)
+			assert.NotContains(t, s, "joedoe@mail.com")
+			assert.NotContains(t, s, givenUserID)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+// WithLoginAlerts emails users when they log in from a device or an IP address not seen in their previous
+// successful logins, so they notice logins they didn't make. The template is executed with a LoginAlertEmailData
+// to produce the message body. The previous logins are read from the login history, which must be enabled
+// (see WithLoginHistory), and the client from the context (see ContextWithClientInfo). The first login of
+// a user raises no alert. Alerts are best-effort and require an emailer (see WithEmailVerification).
+func WithLoginAlerts(tmpl *template.Template) ServiceOption {
+	return func(s *DefaultService) {
+		s.loginAlertTemplate = tmpl
+	}
+}
+
+// alertNewLogin emails the user when the client of the login is not seen in the previous successful logins.
+// It must run before the login is recorded. Failures are logged only, the login must not fail because of the alert.
+func (s *DefaultService) alertNewLogin(ctx context.Context, storageUser *repository.User) {
+	if s.loginAlertTemplate == nil || s.emailer == nil || s.loginHistorySize <= 0 {
+		return
+	}
+
+	info, ok := clientInfoFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	events, err := s.repo.SelectLoginEventsByOutcome(ctx, storageUser.ID, string(LoginSucceeded), s.loginHistorySize)
+	if err != nil {
+		s.logger.Error("could not select login events", userIDField(storageUser.ID), errorField(err))
+		return
+	}
+
+	var seen, seenIP, seenDevice bool
+	for _, e := range events {
+		seen = true
+		seenIP = seenIP || e.IP == info.IP
+		seenDevice = seenDevice || e.UserAgent == info.UserAgent
+	}
+
+	if !seen || seenIP && seenDevice {
+		return
+	}
+
+	user, err := s.userFromRepository(storageUser)
+	if err != nil {
+		s.logger.Error("could not parse login alert recipient", userIDField(storageUser.ID), errorField(err))
+		return
+	}
+
+	data := LoginAlertEmailData{
+		Fullname:   user.Fullname,
+		Username:   user.Username,
+		IP:         info.IP,
+		UserAgent:  info.UserAgent,
+		LoggedInAt: s.now().UTC(),
+	}
+
+	var msg bytes.Buffer
+	if err := s.loginAlertTemplate.Execute(&msg, data); err != nil {
+		s.logger.Error("could not execute login alert email template", userIDField(user.ID), errorField(err))
+		return
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: New sign-in to your %s account\r\n\r\n%s\r\n",
+		s.emailVerificationSenderAddr, user.Email, s.emailVerificationSenderName, msg.String())
+
+	if err := s.sendEmail(ctx, user.Email, []byte(body)); err != nil {
+		s.logger.Error("could not send login alert email", userIDField(user.ID), errorField(err))
+	}
+}
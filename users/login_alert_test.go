@@ -0,0 +1,144 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWithLoginAlerts(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:           uuid.New().String(),
+		Fullname:     "John Doe",
+		Username:     "jdoe",
+		Birthdate:    "2000-01-01",
+		Role:         string(RoleUser),
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+	}
+
+	givenTemplate := template.Must(template.New("login_alert").Parse("Hello {{.Fullname}}, new sign-in from {{.IP}} ({{.UserAgent}})"))
+
+	knownEvent := repository.LoginEvent{UserID: givenUser.ID, IP: "203.0.113.7", UserAgent: "Mozilla/5.0", Outcome: string(LoginSucceeded)}
+
+	testCases := []struct {
+		name          string
+		givenEvents   []repository.LoginEvent
+		givenInfo     *ClientInfo
+		expectedAlert bool
+	}{
+		{
+			name:          "login from a new ip",
+			givenEvents:   []repository.LoginEvent{knownEvent},
+			givenInfo:     &ClientInfo{IP: "198.51.100.1", UserAgent: "Mozilla/5.0"},
+			expectedAlert: true,
+		},
+		{
+			name:          "login from a new device",
+			givenEvents:   []repository.LoginEvent{knownEvent},
+			givenInfo:     &ClientInfo{IP: "203.0.113.7", UserAgent: "curl/7.79"},
+			expectedAlert: true,
+		},
+		{
+			name:        "login from a known client",
+			givenEvents: []repository.LoginEvent{knownEvent},
+			givenInfo:   &ClientInfo{IP: "203.0.113.7", UserAgent: "Mozilla/5.0"},
+		},
+		{
+			name:      "first login",
+			givenInfo: &ClientInfo{IP: "198.51.100.1", UserAgent: "curl/7.79"},
+		},
+		{
+			name: "only failed logins",
+			givenEvents: []repository.LoginEvent{
+				{UserID: givenUser.ID, IP: "203.0.113.7", UserAgent: "Mozilla/5.0", Outcome: string(LoginFailedInvalidPassword)},
+			},
+			givenInfo: &ClientInfo{IP: "198.51.100.1", UserAgent: "curl/7.79"},
+		},
+		{
+			name: "failed logins newer than the successful ones",
+			givenEvents: []repository.LoginEvent{
+				{UserID: givenUser.ID, IP: "198.51.100.1", UserAgent: "curl/7.79", Outcome: string(LoginFailedInvalidPassword)},
+				knownEvent,
+			},
+			givenInfo:     &ClientInfo{IP: "198.51.100.1", UserAgent: "curl/7.79"},
+			expectedAlert: true,
+		},
+		{
+			name:        "no client info",
+			givenEvents: []repository.LoginEvent{knownEvent},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var bodies []string
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+						return givenUser, nil
+					},
+					selectLoginEventsByOutcomeFunc: func(ctx context.Context, userID, outcome string, limit int) ([]repository.LoginEvent, error) {
+						assert.Equal(t, givenUser.ID, userID)
+
+						var events []repository.LoginEvent
+						for _, e := range tc.givenEvents {
+							if e.Outcome == outcome {
+								events = append(events, e)
+							}
+						}
+						return events, nil
+					},
+					insertLoginEventFunc: func(ctx context.Context, in repository.LoginEvent, keep int) error {
+						return nil
+					},
+				},
+				WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						bodies = append(bodies, string(body))
+						return nil
+					},
+				}),
+				WithLoginHistory(10),
+				WithLoginAlerts(givenTemplate),
+			)
+			svc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+			ctx := context.Background()
+			if tc.givenInfo != nil {
+				ctx = ContextWithClientInfo(ctx, *tc.givenInfo)
+			}
+
+			_, err := svc.GenerateToken(ctx, givenUser.Email, password)
+			require.NoError(t, err)
+
+			if !tc.expectedAlert {
+				assert.Empty(t, bodies)
+				return
+			}
+
+			require.Len(t, bodies, 1)
+			assert.Contains(t, bodies[0], "Subject: New sign-in to your test-app account")
+			assert.Contains(t, bodies[0], "Hello John Doe, new sign-in from "+tc.givenInfo.IP+" ("+tc.givenInfo.UserAgent+")")
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+// WithLoginHistory records the IP and user-agent found in the context (see ContextWithClientInfo)
+// of each login attempt of a known user, successful or failed with a wrong password, a disabled password
+// or an unverified email, keeping the newest size attempts per user and outcome (see LoginHistory),
+// so a flood of failed attempts doesn't evict the successful logins.
+// Recording is best-effort: a failure is logged and doesn't fail the login.
+func WithLoginHistory(size int) ServiceOption {
+	return func(s *DefaultService) {
+		s.loginHistorySize = size
+	}
+}
+
+// recordLogin records a login attempt of the user with its outcome when the login history is enabled
+func (s *DefaultService) recordLogin(ctx context.Context, userID string, outcome LoginOutcome) {
+	if s.loginHistorySize <= 0 {
+		return
+	}
+
+	info, _ := clientInfoFromContext(ctx)
+
+	event := repository.LoginEvent{
+		UserID:    userID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		Outcome:   string(outcome),
+		CreatedAt: s.now().UTC(),
+	}
+
+	if err := s.repo.InsertLoginEvent(ctx, event, s.loginHistorySize); err != nil {
+		s.logger.Error("could not record login", userIDField(userID), errorField(err))
+	}
+}
+
+// ListLoginHistory lists the newest login attempts of the user, up to limit, newest first
+// (e.g. for a "recent sign-in activity" page). A non-positive limit lists the whole kept history,
+// which is empty when the login history is disabled (see WithLoginHistory).
+func (s *DefaultService) ListLoginHistory(ctx context.Context, userID string, limit int) ([]LoginEvent, error) {
+	if err := s.validateID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	// Nothing is kept when the history is disabled
+	if s.loginHistorySize <= 0 {
+		return []LoginEvent{}, nil
+	}
+
+	if limit <= 0 || limit > s.loginHistorySize {
+		limit = s.loginHistorySize
+	}
+
+	storageEvents, err := s.repo.SelectLoginEvents(ctx, userID, nil, limit)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select login events", err)
+	}
+
+	events := make([]LoginEvent, 0, len(storageEvents))
+	for _, e := range storageEvents {
+		events = append(events, loginEventFromRepository(e))
+	}
+	return events, nil
+}
+
+// loginHistoryCursor is the position a login history listing resumes from, encoded in the page cursors
+type loginHistoryCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        int64     `json:"i"`
+}
+
+// LoginHistory lists a page of the recorded login attempts of the user, newest first, for users reviewing
+// their account activity or admins investigating it. Pages are limited as set with WithPagination and
+// continue from the cursor of the previous page. It is empty when the login history is disabled.
+func (s *DefaultService) LoginHistory(ctx context.Context, userID string, page LoginHistoryInput) (*LoginHistoryPage, error) {
+	if err := s.validateID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	var before *repository.LoginEventCursor
+	if page.Cursor != "" {
+		cursor, err := decodeLoginHistoryCursor(page.Cursor)
+		if err != nil {
+			return nil, errCursorInvalid
+		}
+		before = &repository.LoginEventCursor{CreatedAt: cursor.CreatedAt, ID: cursor.ID}
+	}
+
+	// Nothing is kept when the history is disabled
+	if s.loginHistorySize <= 0 {
+		return &LoginHistoryPage{Events: []LoginEvent{}}, nil
+	}
+
+	limit := s.pageLimit(page.Limit)
+
+	// One more event tells whether there is a next page
+	storageEvents, err := s.repo.SelectLoginEvents(ctx, userID, before, limit+1)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select login events", err)
+	}
+
+	var result LoginHistoryPage
+
+	if len(storageEvents) > limit {
+		storageEvents = storageEvents[:limit]
+
+		last := storageEvents[limit-1]
+		result.NextCursor = encodeLoginHistoryCursor(loginHistoryCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	result.Events = make([]LoginEvent, 0, len(storageEvents))
+	for _, e := range storageEvents {
+		result.Events = append(result.Events, loginEventFromRepository(e))
+	}
+	return &result, nil
+}
+
+func loginEventFromRepository(e repository.LoginEvent) LoginEvent {
+	return LoginEvent{
+		IP:        e.IP,
+		UserAgent: e.UserAgent,
+		Outcome:   LoginOutcome(e.Outcome),
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+// encodeLoginHistoryCursor encodes a cursor into an opaque string
+func encodeLoginHistoryCursor(cursor loginHistoryCursor) string {
+	return encodeCursor(cursor)
+}
+
+// decodeLoginHistoryCursor decodes a cursor encoded with encodeLoginHistoryCursor
+func decodeLoginHistoryCursor(s string) (*loginHistoryCursor, error) {
+	var cursor loginHistoryCursor
+	if err := decodeCursor(s, &cursor); err != nil {
+		return nil, err
+	}
+
+	if cursor.ID <= 0 {
+		return nil, errCursorInvalid
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,263 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWithLoginHistory(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Role:         string(RoleUser),
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+	}
+
+	givenInfo := ClientInfo{IP: "203.0.113.7", UserAgent: "Mozilla/5.0"}
+
+	testCases := []struct {
+		name           string
+		givenOpts      []ServiceOption
+		givenInsertErr error
+		expectedEvents int
+	}{
+		{
+			name:           "login is recorded",
+			givenOpts:      []ServiceOption{WithLoginHistory(10)},
+			expectedEvents: 2,
+		},
+		{
+			name:           "recording failure does not fail the login",
+			givenOpts:      []ServiceOption{WithLoginHistory(10)},
+			givenInsertErr: errors.New("db down"),
+			expectedEvents: 2,
+		},
+		{
+			name:           "history disabled",
+			expectedEvents: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				events []repository.LoginEvent
+				keeps  []int
+			)
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return givenUser, nil
+				},
+				selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+					return givenUser, nil
+				},
+				insertLoginEventFunc: func(ctx context.Context, in repository.LoginEvent, keep int) error {
+					events = append(events, in)
+					keeps = append(keeps, keep)
+					return tc.givenInsertErr
+				},
+			}, tc.givenOpts...)
+			svc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+			ctx := ContextWithClientInfo(context.Background(), givenInfo)
+
+			_, err := svc.GenerateToken(ctx, givenUser.Email, password)
+			require.NoError(t, err)
+
+			_, err = svc.Authenticate(ctx, givenUser.Username, password)
+			require.NoError(t, err)
+
+			require.Len(t, events, tc.expectedEvents)
+			for i, e := range events {
+				assert.Equal(t, givenUser.ID, e.UserID)
+				assert.Equal(t, givenInfo.IP, e.IP)
+				assert.Equal(t, givenInfo.UserAgent, e.UserAgent)
+				assert.Equal(t, string(LoginSucceeded), e.Outcome)
+				assert.Equal(t, 10, keeps[i])
+			}
+		})
+	}
+
+	t.Run("failed logins are recorded", func(t *testing.T) {
+		t.Parallel()
+
+		var events []repository.LoginEvent
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				return givenUser, nil
+			},
+			insertLoginEventFunc: func(ctx context.Context, in repository.LoginEvent, keep int) error {
+				events = append(events, in)
+				return nil
+			},
+		}, WithLoginHistory(10))
+
+		_, err := svc.GenerateToken(context.Background(), givenUser.Email, "wrong%&password123")
+		require.Equal(t, errPasswordInvalid, err)
+
+		require.Len(t, events, 1)
+		assert.Equal(t, givenUser.ID, events[0].UserID)
+		assert.Equal(t, string(LoginFailedInvalidPassword), events[0].Outcome)
+	})
+}
+
+func TestListLoginHistory(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	givenEvents := []repository.LoginEvent{
+		{UserID: givenUserID, IP: "203.0.113.7", UserAgent: "Mozilla/5.0", CreatedAt: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{UserID: givenUserID, IP: "198.51.100.1", UserAgent: "curl/7.79", CreatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	testCases := []struct {
+		name          string
+		givenOpts     []ServiceOption
+		givenLimit    int
+		expectedLimit int
+		expected      []LoginEvent
+	}{
+		{
+			name:          "limit within the history size",
+			givenOpts:     []ServiceOption{WithLoginHistory(10)},
+			givenLimit:    5,
+			expectedLimit: 5,
+			expected: []LoginEvent{
+				{IP: "203.0.113.7", UserAgent: "Mozilla/5.0", CreatedAt: givenEvents[0].CreatedAt},
+				{IP: "198.51.100.1", UserAgent: "curl/7.79", CreatedAt: givenEvents[1].CreatedAt},
+			},
+		},
+		{
+			name:          "limit capped to the history size",
+			givenOpts:     []ServiceOption{WithLoginHistory(10)},
+			givenLimit:    50,
+			expectedLimit: 10,
+			expected: []LoginEvent{
+				{IP: "203.0.113.7", UserAgent: "Mozilla/5.0", CreatedAt: givenEvents[0].CreatedAt},
+				{IP: "198.51.100.1", UserAgent: "curl/7.79", CreatedAt: givenEvents[1].CreatedAt},
+			},
+		},
+		{
+			name:       "history disabled",
+			givenLimit: 5,
+			expected:   []LoginEvent{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectLoginEventsFunc: func(ctx context.Context, userID string, before *repository.LoginEventCursor, limit int) ([]repository.LoginEvent, error) {
+					assert.Equal(t, givenUserID, userID)
+					assert.Nil(t, before)
+					assert.Equal(t, tc.expectedLimit, limit)
+					return givenEvents, nil
+				},
+			}, tc.givenOpts...)
+
+			actual, err := svc.ListLoginHistory(context.Background(), givenUserID, tc.givenLimit)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestLoginHistory(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	var givenEvents []repository.LoginEvent
+	for i := 0; i < 5; i++ {
+		givenEvents = append(givenEvents, repository.LoginEvent{
+			ID:        int64(5 - i),
+			UserID:    givenUserID,
+			IP:        "203.0.113.7",
+			Outcome:   string(LoginFailedInvalidPassword),
+			CreatedAt: time.Date(2022, 1, 5-i, 0, 0, 0, 0, time.UTC),
+		})
+	}
+
+	// selectEvents returns the events older than the cursor, as the repository does
+	selectEvents := func(ctx context.Context, userID string, before *repository.LoginEventCursor, limit int) ([]repository.LoginEvent, error) {
+		var events []repository.LoginEvent
+		for _, e := range givenEvents {
+			if before == nil || e.ID < before.ID {
+				events = append(events, e)
+			}
+		}
+
+		if len(events) > limit {
+			events = events[:limit]
+		}
+		return events, nil
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectLoginEventsFunc: selectEvents,
+	}, WithLoginHistory(10), WithPagination(2, 10))
+
+	var outcomes []LoginOutcome
+
+	page, err := svc.LoginHistory(context.Background(), givenUserID, LoginHistoryInput{})
+	require.NoError(t, err)
+
+	for pages := 1; ; pages++ {
+		require.LessOrEqual(t, pages, 3)
+
+		for _, e := range page.Events {
+			outcomes = append(outcomes, e.Outcome)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+
+		page, err = svc.LoginHistory(context.Background(), givenUserID, LoginHistoryInput{Cursor: page.NextCursor})
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, outcomes, 5)
+	assert.Equal(t, LoginFailedInvalidPassword, outcomes[0])
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := svc.LoginHistory(context.Background(), givenUserID, LoginHistoryInput{Cursor: "foo"})
+		assert.Equal(t, errCursorInvalid, err)
+	})
+
+	t.Run("history disabled", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+		actual, err := svc.LoginHistory(context.Background(), givenUserID, LoginHistoryInput{})
+		require.NoError(t, err)
+		assert.Empty(t, actual.Events)
+		assert.Empty(t, actual.NextCursor)
+	})
+}
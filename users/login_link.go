@@ -0,0 +1,221 @@
+package users
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// loginLinkTTL is the time a login link can be used for
+	loginLinkTTL time.Duration = 15 * time.Minute
+
+	// loginLinkSendTimeout bounds the background sending of a login link
+	loginLinkSendTimeout time.Duration = time.Minute
+)
+
+// loginLink is the payload of a login link token, signed so it can be verified without storing it
+type loginLink struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// WithLoginLinkEndpoint enables passwordless logins with login links (see RequestLoginLink) pointing to the endpoint
+// (e.g. "https://example.com/login/link"). The login link token is added to it as the "token" query parameter.
+func WithLoginLinkEndpoint(endpoint string) ServiceOption {
+	return func(s *DefaultService) {
+		s.loginLinkEndpoint = endpoint
+	}
+}
+
+// RequestLoginLink emails a login link to the user with the given email, to be used once with ConsumeLoginLink
+// within 15 minutes. The link token is signed with the HMAC key (see WithHMACKey) so it can be verified without storing it.
+// To avoid disclosing which emails are registered, the link is sent in the background, so requests for known
+// and unknown emails take as long and succeed alike: unknown emails, requests exceeding the login rate limit
+// (see WithLoginRateLimit) and failed sends are only logged.
+func (s *DefaultService) RequestLoginLink(ctx context.Context, email string) error {
+	if s.emailer == nil {
+		return errEmailerNotConfigured
+	}
+
+	if s.loginLinkEndpoint == "" {
+		return errLoginLinkUnavailable
+	}
+
+	// Links signed with an empty key would let anyone log in as any user
+	if s.macKey() == "" {
+		return errHMACKeyEmpty
+	}
+
+	if err := s.validator().ValidateEmail(email); err != nil {
+		return fmt.Errorf("could not validate email: %w", err)
+	}
+
+	storageUser, err := s.repo.SelectByEmail(ctx, s.emailLookup(email))
+	if err != nil {
+		return wrapErr(ctx, "could not select user by email", err)
+	}
+
+	if storageUser == nil {
+		s.logger.Debug("login link requested for unknown email", zap.String("operation", "request_login_link"))
+		return nil
+	}
+
+	s.background.Add(1)
+
+	go func() {
+		defer s.background.Done()
+
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, loginLinkSendTimeout)
+		defer cancel()
+
+		if err := s.sendLoginLink(ctx, storageUser.ID, email); err != nil {
+			s.logger.Error("could not send login link", zap.String("operation", "request_login_link"), userIDField(storageUser.ID), errorField(err))
+		}
+	}()
+	return nil
+}
+
+// sendLoginLink emails a new login link to the user, unless rate limited
+func (s *DefaultService) sendLoginLink(ctx context.Context, userID, email string) error {
+	limit, err := s.allow(ctx, "login_link:"+userID, s.loginRateLimit)
+	if err != nil {
+		return err
+	}
+
+	if limit != nil && !limit.Allowed {
+		s.logger.Info("login link rate limited", zap.String("operation", "request_login_link"), userIDField(userID))
+		return nil
+	}
+
+	link, err := s.newLoginLink(userID)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s Login Link\r\n\r\nUse the following link to log in within 15 minutes: %s\r\nIf you did not request a login link, you can ignore this email.\r\n",
+		s.emailVerificationSenderAddr, email, s.emailVerificationSenderName, link)
+
+	if err := s.sendEmail(ctx, email, []byte(body)); err != nil {
+		return wrapErr(ctx, "could not send login link", err)
+	}
+
+	s.logger.Info("login link requested", zap.String("operation", "request_login_link"), userIDField(userID))
+	return nil
+}
+
+// ConsumeLoginLink generates a JWT token for the user the login link token was sent to, as password logins do,
+// consuming the token. Following the link proves holding the email, so it is not required to be verified.
+// Tokens issued before the tokens of the user were revoked (see RevokeUserTokens) are rejected.
+func (s *DefaultService) ConsumeLoginLink(ctx context.Context, token string) (string, error) {
+	link, err := s.parseLoginLink(token)
+	if err != nil {
+		return "", err
+	}
+
+	storageUser, err := s.selectUserByID(ctx, link.UserID)
+	if err != nil {
+		return "", wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return "", errNotFound
+	}
+
+	if tokenSuperseded(link.IssuedAt, storageUser.TokensValidAfter) {
+		return "", errLoginLinkInvalid
+	}
+
+	consumed, err := s.consumeOnce(ctx, "login_link:"+link.ID, time.Unix(link.ExpiresAt, 0))
+	if err != nil {
+		return "", wrapErr(ctx, "could not consume login link", err)
+	}
+
+	if !consumed {
+		return "", errLoginLinkInvalid
+	}
+
+	if err := s.allowTokenIssuance(ctx, storageUser.ID); err != nil {
+		s.logger.Info("login failed", zap.String("operation", "consume_login_link"), userIDField(storageUser.ID), errorField(err))
+		return "", err
+	}
+
+	issued, err := s.issueLoginToken(ctx, storageUser, s.tokenTTLOrDefault(), "", mfaChallengeToken, "consume_login_link")
+	if err != nil {
+		return "", err
+	}
+	return issued.token, nil
+}
+
+// newLoginLink returns the login link URL of a new signed token for the user
+func (s *DefaultService) newLoginLink(userID string) (string, error) {
+	u, err := url.Parse(s.loginLinkEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("could not parse login link endpoint: %s", err)
+	}
+
+	now := s.now()
+
+	b, err := json.Marshal(loginLink{
+		ID:        randString(32),
+		UserID:    userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(loginLinkTTL).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal login link: %s", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	signature, err := s.signHMAC("login_link", payload)
+	if err != nil {
+		return "", fmt.Errorf("could not sign login link: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("token", payload+"."+signature)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// parseLoginLink verifies the signature and expiration of a login link token and returns its payload
+func (s *DefaultService) parseLoginLink(token string) (*loginLink, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errLoginLinkInvalid
+	}
+
+	valid, err := s.verifyHMAC("login_link", payload, signature)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify login link: %w", err)
+	}
+
+	if !valid {
+		return nil, errLoginLinkInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errLoginLinkInvalid
+	}
+
+	var link loginLink
+	if err := json.Unmarshal(b, &link); err != nil {
+		return nil, errLoginLinkInvalid
+	}
+
+	if !s.now().Before(time.Unix(link.ExpiresAt, 0)) {
+		return nil, errLoginLinkInvalid
+	}
+	return &link, nil
+}
@@ -0,0 +1,184 @@
+package users
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLoginLink(t *testing.T) {
+	t.Parallel()
+
+	storedUser := repository.User{
+		ID:                   uuid.New().String(),
+		Fullname:             "John Doe",
+		Username:             "jdoe",
+		Email:                "joedoe@mail.com",
+		Role:                 string(RoleUser),
+		PasswordAuthDisabled: true,
+	}
+
+	var (
+		sentBodies []string
+		sendErr    error
+	)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				if email != storedUser.Email {
+					return nil, nil
+				}
+				user := storedUser
+				return &user, nil
+			},
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				user := storedUser
+				return &user, nil
+			},
+			insertSessionFunc: func(ctx context.Context, in repository.Session, maxActive int, evictOldest bool) error {
+				return nil
+			},
+			insertLoginEventFunc: func(ctx context.Context, in repository.LoginEvent, keep int) error {
+				return nil
+			},
+		},
+		WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error {
+				if sendErr != nil {
+					return sendErr
+				}
+				sentBodies = append(sentBodies, string(body))
+				return nil
+			},
+		}),
+		WithLoginLinkEndpoint("https://example.com/login/link"),
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	linkPattern := regexp.MustCompile(`within 15 minutes: (\S+)`)
+
+	requestToken := func(t *testing.T) string {
+		require.NoError(t, svc.RequestLoginLink(context.Background(), storedUser.Email))
+		svc.background.Wait()
+
+		match := linkPattern.FindStringSubmatch(sentBodies[len(sentBodies)-1])
+		require.Len(t, match, 2)
+
+		u, err := url.Parse(match[1])
+		require.NoError(t, err)
+		assert.Equal(t, "example.com", u.Host)
+
+		return u.Query().Get("token")
+	}
+
+	t.Run("unknown email sends no link", func(t *testing.T) {
+		require.NoError(t, svc.RequestLoginLink(context.Background(), "unknown@mail.com"))
+		svc.background.Wait()
+		assert.Empty(t, sentBodies)
+	})
+
+	t.Run("failed send is not disclosed", func(t *testing.T) {
+		sendErr = errors.New("connection refused")
+		defer func() { sendErr = nil }()
+
+		require.NoError(t, svc.RequestLoginLink(context.Background(), storedUser.Email))
+		svc.background.Wait()
+	})
+
+	t.Run("login link is consumed once", func(t *testing.T) {
+		token := requestToken(t)
+
+		jwtToken, err := svc.ConsumeLoginLink(context.Background(), token)
+		require.NoError(t, err)
+
+		resp, err := svc.VerifyToken(context.Background(), jwtToken)
+		require.NoError(t, err)
+		assert.Equal(t, storedUser.ID, resp.ID)
+
+		_, err = svc.ConsumeLoginLink(context.Background(), token)
+		assert.Equal(t, errLoginLinkInvalid, err)
+	})
+
+	t.Run("tampered token", func(t *testing.T) {
+		token := requestToken(t)
+
+		_, err := svc.ConsumeLoginLink(context.Background(), token+"x")
+		assert.Equal(t, errLoginLinkInvalid, err)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		token := requestToken(t)
+
+		svc := *svc
+		svc.clock = func() time.Time { return now.Add(loginLinkTTL) }
+
+		_, err := svc.ConsumeLoginLink(context.Background(), token)
+		assert.Equal(t, errLoginLinkInvalid, err)
+	})
+
+	t.Run("token issued before the user tokens were revoked", func(t *testing.T) {
+		token := requestToken(t)
+
+		validAfter := now.Add(time.Second)
+		storedUser.TokensValidAfter = &validAfter
+		defer func() { storedUser.TokensValidAfter = nil }()
+
+		_, err := svc.ConsumeLoginLink(context.Background(), token)
+		assert.Equal(t, errLoginLinkInvalid, err)
+	})
+}
+
+func TestRequestLoginLink_unavailable(t *testing.T) {
+	t.Parallel()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+		WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{}),
+	)
+
+	err := svc.RequestLoginLink(context.Background(), "joedoe@mail.com")
+	assert.Equal(t, errLoginLinkUnavailable, err)
+}
+
+func TestLoginLink_emptyHMACKey(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	assert.Panics(t, func() {
+		New(zap.NewNop(), "", &repositoryMock{},
+			WithSigner(NewEd25519Signer(edKey, "ed-1")),
+			WithLoginLinkEndpoint("https://example.com/login/link"),
+		)
+	})
+
+	// Services built without New fail on use
+	svc := DefaultService{
+		logger:            zap.NewNop(),
+		signer:            NewEd25519Signer(edKey, "ed-1"),
+		loginLinkEndpoint: "https://example.com/login/link",
+		emailer:           &emailerMock{},
+		repo:              &repositoryMock{},
+	}
+
+	err = svc.RequestLoginLink(context.Background(), "joedoe@mail.com")
+	assert.Equal(t, errHMACKeyEmpty, err)
+
+	_, err = svc.ConsumeLoginLink(context.Background(), "foo.bar")
+	assert.ErrorIs(t, err, errHMACKeyEmpty)
+}
@@ -0,0 +1,37 @@
+package users
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// emailPattern matches the email addresses embedded in a text (e.g. an SMTP error)
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// MaskEmail masks the local part of an email address but its first character (e.g. "j***@mail.com"),
+// hiding its length as well. Handlers can use it to echo emails in responses.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
+
+// maskEmails masks the email addresses embedded in s
+func maskEmails(s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// maskID replaces an id with the first 12 hex characters of its SHA-256, which is enough to correlate log lines
+// without identifying the user. Prefixes of the id aren't used as the first group of a UUIDv7 holds its creation time.
+func maskID(id string) string {
+	if id == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,98 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskEmail(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{
+			name:     "regular email",
+			given:    "joedoe@mail.com",
+			expected: "j***@mail.com",
+		},
+		{
+			name:     "single character local part",
+			given:    "j@mail.com",
+			expected: "j***@mail.com",
+		},
+		{
+			name:     "empty local part",
+			given:    "@mail.com",
+			expected: "***",
+		},
+		{
+			name:     "not an email",
+			given:    "joedoe",
+			expected: "***",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, MaskEmail(tc.given))
+		})
+	}
+}
+
+func TestMaskEmails(t *testing.T) {
+	t.Parallel()
+
+	given := "could not send email: 550 mailbox joedoe@mail.com unavailable, cc jane.doe+x@corp.example.org"
+
+	assert.Equal(t, "could not send email: 550 mailbox j***@mail.com unavailable, cc j***@corp.example.org", maskEmails(given))
+}
+
+func TestMaskID(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{
+			name:     "uuid",
+			given:    "2b1c9b3e-4f6a-4d8e-9c1a-0e3f5b7d9a11",
+			expected: "cd6d4bbb2902",
+		},
+		{
+			name:     "short id",
+			given:    "abc",
+			expected: "ba7816bf8f01",
+		},
+		{
+			name:     "empty id",
+			given:    "",
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.expected, maskID(tc.given))
+		})
+	}
+
+	t.Run("uuidv7 created at the same time", func(t *testing.T) {
+		t.Parallel()
+
+		// Both ids share the timestamp of their first 48 bits
+		first, second := "018f3a2c-7b10-7c4e-9a1d-2f6e8b0c4d11", "018f3a2c-7b10-7d2a-8e5f-6a1b3c9d0e22"
+
+		assert.NotEqual(t, maskID(first), maskID(second))
+		assert.NotContains(t, maskID(first), "018f3a2c")
+	})
+}
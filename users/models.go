@@ -1,9 +1,13 @@
 package users
 
 import (
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/alesr/stdservices/pkg/validate"
+	"github.com/alesr/stdservices/users/webauthn"
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
@@ -13,8 +17,74 @@ const (
 	RoleUser  role = "user"
 )
 
+// TokenResponse represents an OAuth-style token response
+type TokenResponse struct {
+	AccessToken string `json:"access_token,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
+	// ExpiresIn is the token lifetime in seconds
+	ExpiresIn int64 `json:"expires_in"`
+	// MFARequired is set when the user enabled a second factor. AccessToken is then empty and MFAToken
+	// is a challenge token to be exchanged with a TOTP code by VerifyTOTP.
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// TokenPair represents a JWT access token along with the refresh token used to renew it
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresIn is the access token lifetime in seconds
+	ExpiresIn int64 `json:"expires_in"`
+	// MFARequired is set when the user enabled a second factor. AccessToken is then a challenge token
+	// to be exchanged with a TOTP code by VerifyTOTP, and RefreshToken is empty.
+	MFARequired bool `json:"mfa_required,omitempty"`
+}
+
+// PasskeyRegistration represents a started passkey registration (see BeginPasskeyRegistration)
+type PasskeyRegistration struct {
+	// Options are passed to navigator.credentials.create
+	Options *webauthn.CreationOptions `json:"options"`
+	// State is passed back to FinishPasskeyRegistration
+	State string `json:"state"`
+}
+
+// PasskeyLogin represents a started passkey login (see BeginPasskeyLogin)
+type PasskeyLogin struct {
+	// Options are passed to navigator.credentials.get
+	Options *webauthn.RequestOptions `json:"options"`
+	// State is passed back to GenerateTokenWithPasskey
+	State string `json:"state"`
+}
+
+// TOTPEnrollment represents a TOTP secret generated by EnrollTOTP, to be shown once to the user
+type TOTPEnrollment struct {
+	// Secret is the base32 encoded secret, for manual entry in authenticator apps
+	Secret string `json:"secret"`
+	// URI is the otpauth:// provisioning URI, usually rendered as a QR code
+	URI string `json:"uri"`
+}
+
+// OAuthProfile represents the profile of a user at an OAuth2 provider, as returned by OAuthProvider
+type OAuthProfile struct {
+	// Subject is the id of the user at the provider
+	Subject string
+	Email   string
+	// EmailVerified reports whether the provider verified the user holds the email
+	EmailVerified bool
+	Name          string
+	// Username is the handle of the user at the provider, if any (e.g. the GitHub login)
+	Username string
+}
+
 type VerifyTokenResponse struct {
 	ID, Username, Role string
+	// ReactivationRequired is set for users deleted within the deletion grace period
+	ReactivationRequired bool
+	// RefreshedToken is the token reissued with a fresh expiration for sliding sessions
+	// (see WithSlidingSession), to be sent back to the client
+	RefreshedToken string
+	// Claims holds the application-specific claims of the token (see WithClaimsEnricher)
+	Claims map[string]interface{}
 }
 
 type role string
@@ -45,6 +115,113 @@ type User struct {
 	Role          role
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+	// DeletedAt, DeletionReason and DeletedBy are only set for soft deleted users
+	DeletedAt      *time.Time
+	DeletionReason string
+	DeletedBy      string
+	// PasswordAuthDisabled is set for users which cannot authenticate with a password (e.g. SSO-only accounts)
+	PasswordAuthDisabled bool
+	PasswordChangedAt    time.Time
+}
+
+// UserSort is the order users are listed in
+type UserSort string
+
+const (
+	// UserSortCreatedAt lists users oldest first
+	UserSortCreatedAt UserSort = "created_at"
+	// UserSortUsername lists users by username, case-insensitively
+	UserSortUsername UserSort = "username"
+)
+
+// ListUsersInput represents the input for listing users.
+// Zero filters match all users. Cursor is the NextCursor of the previous page, empty for the first page.
+type ListUsersInput struct {
+	Role          role
+	EmailVerified *bool
+	CreatedAfter  time.Time
+	SortBy        UserSort
+	Cursor        string
+	Limit         int
+}
+
+// UserPage represents a page of users. NextCursor is empty on the last page.
+type UserPage struct {
+	Users      []User
+	NextCursor string
+}
+
+// UserStats represents aggregate statistics about non-deleted users
+type UserStats struct {
+	Total             int
+	Verified          int
+	Unverified        int
+	CreatedLast7Days  int
+	CreatedLast30Days int
+	ByRole            map[role]int
+}
+
+// EmailVerification describes an email verification sent to a user
+type EmailVerification struct {
+	// MaskedCode only reveals the last characters of the code
+	MaskedCode string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	UsedAt     *time.Time
+}
+
+// LoginOutcome represents the outcome of a login attempt
+type LoginOutcome string
+
+const (
+	LoginSucceeded                  LoginOutcome = "success"
+	LoginFailedInvalidPassword      LoginOutcome = "invalid_password"
+	LoginFailedPasswordAuthDisabled LoginOutcome = "password_auth_disabled"
+	LoginFailedEmailNotVerified     LoginOutcome = "email_not_verified"
+)
+
+// LoginEvent represents a login attempt, its outcome and the client it came from (see ContextWithClientInfo)
+type LoginEvent struct {
+	IP        string
+	UserAgent string
+	Outcome   LoginOutcome
+	CreatedAt time.Time
+}
+
+// LoginHistoryInput represents the input for listing a page of the login history.
+// Cursor is the NextCursor of the previous page, empty for the first page.
+type LoginHistoryInput struct {
+	Cursor string
+	Limit  int
+}
+
+// LoginHistoryPage represents a page of the login history. NextCursor is empty on the last page.
+type LoginHistoryPage struct {
+	Events     []LoginEvent
+	NextCursor string
+}
+
+// StalePasswordsInput represents the input for listing a page of the users with stale passwords.
+// Cursor is the NextCursor of the previous page, empty for the first page.
+type StalePasswordsInput struct {
+	Cursor string
+	Limit  int
+}
+
+// StalePasswordsPage represents a page of the users with stale passwords. NextCursor is empty on the last page.
+type StalePasswordsPage struct {
+	Users      []User
+	NextCursor string
+}
+
+// Session represents an active login session of a user, along with the device it was started from.
+// ID is the sid claim of the tokens of the session.
+type Session struct {
+	ID        string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
 
 // CreateUserInput represents the input data for creating a user
@@ -57,29 +234,144 @@ type CreateUserInput struct {
 	ConfirmPassword string
 }
 
-func (in *CreateUserInput) validate() error {
+// UpdateUserInput represents the input data for a partial update of a user.
+// A nil field leaves the field unchanged, while a non-nil field sets it, empty strings included.
+// Only the birthdate can be emptied, when birthdates are optional (see WithOptionalBirthdate).
+type UpdateUserInput struct {
+	Fullname  *string
+	Username  *string
+	Birthdate *string
+}
+
+// WelcomeEmailData is the data the welcome email template is executed with
+type WelcomeEmailData struct {
+	Fullname string
+	Username string
+}
+
+// DeletionConfirmationEmailData is the data the deletion confirmation email template is executed with
+type DeletionConfirmationEmailData struct {
+	Fullname  string
+	Username  string
+	DeletedAt time.Time
+	// RecoverableUntil is the end of the deletion grace period (see WithDeletionGracePeriod),
+	// zero when deleted users can't recover their account
+	RecoverableUntil time.Time
+}
+
+// LoginAlertEmailData is the data the login alert email template is executed with
+type LoginAlertEmailData struct {
+	Fullname   string
+	Username   string
+	IP         string
+	UserAgent  string
+	LoggedInAt time.Time
+}
+
+// CreateUserResponse represents the created user along with the side effects of its creation
+type CreateUserResponse struct {
+	*User
+	// VerificationEmailSent reports whether the verification email was sent to the user
+	VerificationEmailSent bool
+	// VerificationEmailRecipient is the masked address the verification email was sent to, if any
+	VerificationEmailRecipient string
+}
+
+// maskCode masks all but the last two characters of a verification code
+func maskCode(code string) string {
+	if len(code) <= 2 {
+		return strings.Repeat("*", len(code))
+	}
+	return strings.Repeat("*", len(code)-2) + code[len(code)-2:]
+}
+
+// normalizeUsername returns the canonical form of a username used to enforce
+// case-insensitive uniqueness, lowercased and in NFC. The original username is kept for display.
+func normalizeUsername(username string) string {
+	return strings.ToLower(norm.NFC.String(username))
+}
+
+// normalizeEmail returns the canonical form of an email, lowercased, which emails are stored and looked up as
+// so that emails differing only by case belong to the same account
+func normalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+// validate validates the input, its email and password with v. The birthdate is required
+// unless birthdateOptional is set, in which case only a provided birthdate is validated.
+func (in *CreateUserInput) validate(v Validator, birthdateOptional bool) error {
 	if err := validate.Fullname(in.Fullname); err != nil {
 		return newE(err.Error())
 	}
 
-	if err := validate.Fullname(in.Username); err != nil {
+	if err := validate.Username(in.Username); err != nil {
 		return newE(err.Error())
 	}
 
-	if err := validate.Birthdate(in.Birthdate); err != nil {
-		return newE(err.Error())
+	if in.Birthdate != "" || !birthdateOptional {
+		if err := validate.Birthdate(in.Birthdate); err != nil {
+			return newE(err.Error())
+		}
 	}
 
-	if err := validate.Email(in.Email); err != nil {
+	if err := v.ValidateEmail(in.Email); err != nil {
 		return newE(err.Error())
 	}
 
-	if err := validate.Password(in.Password); err != nil {
+	if err := v.ValidatePassword(in.Password); err != nil {
 		return newE(err.Error())
 	}
 
+	if passwordContainsIdentity(in.Password, in.Username, in.Email) {
+		return errPasswordContainsIdentity
+	}
+
 	if in.Password != in.ConfirmPassword {
 		return errPasswordMismatch
 	}
 	return nil
 }
+
+// validate validates the fields set on the input. An empty birthdate is accepted when birthdateOptional is set.
+func (in *UpdateUserInput) validate(birthdateOptional bool) error {
+	if in.Fullname != nil {
+		if err := validate.Fullname(*in.Fullname); err != nil {
+			return newE(err.Error())
+		}
+	}
+
+	if in.Username != nil {
+		if err := validate.Username(*in.Username); err != nil {
+			return newE(err.Error())
+		}
+	}
+
+	if in.Birthdate != nil && (*in.Birthdate != "" || !birthdateOptional) {
+		if err := validate.Birthdate(*in.Birthdate); err != nil {
+			return newE(err.Error())
+		}
+	}
+	return nil
+}
+
+// minIdentityPartLength is the number of characters below which usernames and email local parts
+// are not searched in passwords, as they would reject most passwords
+const minIdentityPartLength = 3
+
+// passwordContainsIdentity reports whether the password equals or contains, case-insensitively,
+// the username or the local part of the email, unless shorter than minIdentityPartLength
+func passwordContainsIdentity(password, username, email string) bool {
+	password = strings.ToLower(password)
+
+	localPart := email
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		localPart = email[:i]
+	}
+
+	for _, part := range []string{username, localPart} {
+		if utf8.RuneCountInString(part) >= minIdentityPartLength && strings.Contains(password, strings.ToLower(part)) {
+			return true
+		}
+	}
+	return false
+}
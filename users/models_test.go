@@ -10,9 +10,10 @@ func TestCreateUserInput_validate(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
-		name          string
-		given         CreateUserInput
-		expectedError bool
+		name                   string
+		given                  CreateUserInput
+		givenBirthdateOptional bool
+		expectedError          bool
 	}{
 		{
 			name: "valid",
@@ -62,6 +63,32 @@ func TestCreateUserInput_validate(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "missing optional birthdate",
+			given: CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "johndoe",
+				Birthdate:       "",
+				Email:           "joedoe@mail.com",
+				Password:        "1234%6abc",
+				ConfirmPassword: "1234%6abc",
+			},
+			givenBirthdateOptional: true,
+			expectedError:          false,
+		},
+		{
+			name: "invalid optional birthdate",
+			given: CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "johndoe",
+				Birthdate:       "01/01/1990",
+				Email:           "joedoe@mail.com",
+				Password:        "1234%6abc",
+				ConfirmPassword: "1234%6abc",
+			},
+			givenBirthdateOptional: true,
+			expectedError:          true,
+		},
 		{
 			name: "missing email",
 			given: CreateUserInput{
@@ -98,6 +125,30 @@ func TestCreateUserInput_validate(t *testing.T) {
 			},
 			expectedError: true,
 		},
+		{
+			name: "password contains username",
+			given: CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "johndoe",
+				Birthdate:       "1990-01-01",
+				Email:           "jd@mail.com",
+				Password:        "JohnDoe%123",
+				ConfirmPassword: "JohnDoe%123",
+			},
+			expectedError: true,
+		},
+		{
+			name: "password contains email local part",
+			given: CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "johndoe",
+				Birthdate:       "1990-01-01",
+				Email:           "jdoe99@mail.com",
+				Password:        "1%jdoe99",
+				ConfirmPassword: "1%jdoe99",
+			},
+			expectedError: true,
+		},
 		{
 			name: "password mismatch",
 			given: CreateUserInput{
@@ -114,7 +165,7 @@ func TestCreateUserInput_validate(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actual := tc.given.validate()
+			actual := tc.given.validate(DefaultValidator{}, tc.givenBirthdateOptional)
 
 			if tc.expectedError {
 				assert.Error(t, actual)
@@ -124,3 +175,113 @@ func TestCreateUserInput_validate(t *testing.T) {
 		})
 	}
 }
+
+func TestPasswordContainsIdentity(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenPassword string
+		givenUsername string
+		givenEmail    string
+		expected      bool
+	}{
+		{
+			name:          "unrelated password",
+			givenPassword: "1234%6abc",
+			givenUsername: "johndoe",
+			givenEmail:    "joedoe@mail.com",
+			expected:      false,
+		},
+		{
+			name:          "equals username",
+			givenPassword: "johndoe",
+			givenUsername: "johndoe",
+			givenEmail:    "jd@mail.com",
+			expected:      true,
+		},
+		{
+			name:          "contains username",
+			givenPassword: "johndoe%123",
+			givenUsername: "johndoe",
+			givenEmail:    "jd@mail.com",
+			expected:      true,
+		},
+		{
+			name:          "contains username with different case",
+			givenPassword: "JOHNDOE%123",
+			givenUsername: "JohnDoe",
+			givenEmail:    "jd@mail.com",
+			expected:      true,
+		},
+		{
+			name:          "equals email local part",
+			givenPassword: "joedoe",
+			givenUsername: "johndoe",
+			givenEmail:    "joedoe@mail.com",
+			expected:      true,
+		},
+		{
+			name:          "contains email local part with different case",
+			givenPassword: "1%JoeDoe",
+			givenUsername: "johndoe",
+			givenEmail:    "joedoe@mail.com",
+			expected:      true,
+		},
+		{
+			name:          "contains full email",
+			givenPassword: "joedoe@mail.com1",
+			givenUsername: "johndoe",
+			givenEmail:    "joedoe@mail.com",
+			expected:      true,
+		},
+		{
+			name:          "short username and email local part",
+			givenPassword: "jo%abc123",
+			givenUsername: "jo",
+			givenEmail:    "a@mail.com",
+			expected:      false,
+		},
+		{
+			name:          "username of the minimum length",
+			givenPassword: "joe%abc123",
+			givenUsername: "joe",
+			givenEmail:    "a@mail.com",
+			expected:      true,
+		},
+		{
+			name:          "empty identity",
+			givenPassword: "1234%6abc",
+			givenUsername: "",
+			givenEmail:    "",
+			expected:      false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := passwordContainsIdentity(tc.givenPassword, tc.givenUsername, tc.givenEmail)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestMaskCode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{name: "code", given: "ABC123", expected: "****23"},
+		{name: "short code", given: "AB", expected: "**"},
+		{name: "empty code", given: "", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, maskCode(tc.given))
+		})
+	}
+}
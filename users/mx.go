@@ -0,0 +1,108 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	mxLookupTimeout = 3 * time.Second
+	mxCacheTTL      = 10 * time.Minute
+)
+
+// WithEmailMXValidation rejects on create the emails whose domain has no mail servers (MX records),
+// reducing fake signups. Lookups are bounded by a timeout and their results cached for a few minutes.
+// Lookups failing for other reasons than a missing domain or MX records (e.g. DNS timeouts) don't reject the email.
+func WithEmailMXValidation() ServiceOption {
+	return func(s *DefaultService) {
+		s.mxValidator = newMXValidator(net.DefaultResolver.LookupMX)
+	}
+}
+
+// mxValidator checks that email domains have mail servers, caching the lookup results
+type mxValidator struct {
+	lookupMX func(ctx context.Context, domain string) ([]*net.MX, error)
+	timeout  time.Duration
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]mxCacheEntry
+}
+
+type mxCacheEntry struct {
+	reachable bool
+	expiresAt time.Time
+}
+
+func newMXValidator(lookupMX func(ctx context.Context, domain string) ([]*net.MX, error)) *mxValidator {
+	return &mxValidator{
+		lookupMX: lookupMX,
+		timeout:  mxLookupTimeout,
+		ttl:      mxCacheTTL,
+		now:      time.Now,
+		cache:    make(map[string]mxCacheEntry),
+	}
+}
+
+// reachable reports whether the domain has mail servers.
+// Lookup errors other than a not found domain are returned and not cached.
+func (v *mxValidator) reachable(ctx context.Context, domain string) (bool, error) {
+	domain = strings.ToLower(domain)
+
+	v.mu.Lock()
+	entry, ok := v.cache[domain]
+	v.mu.Unlock()
+
+	if ok && v.now().Before(entry.expiresAt) {
+		return entry.reachable, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	records, err := v.lookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return false, err
+		}
+	}
+
+	// A single "." record is a null MX, explicitly stating the domain accepts no email (RFC 7505)
+	reachable := len(records) > 0 && !(len(records) == 1 && records[0].Host == ".")
+
+	v.mu.Lock()
+	v.cache[domain] = mxCacheEntry{reachable: reachable, expiresAt: v.now().Add(v.ttl)}
+	v.mu.Unlock()
+
+	return reachable, nil
+}
+
+// validateEmailDomain returns errEmailDomainUnreachable if MX validation is enabled
+// and the domain of the email has no mail servers
+func (s *DefaultService) validateEmailDomain(ctx context.Context, email string) error {
+	if s.mxValidator == nil {
+		return nil
+	}
+
+	_, domain, _ := strings.Cut(email, "@")
+
+	reachable, err := s.mxValidator.reachable(ctx, domain)
+	if err != nil {
+		// Don't reject signups because of DNS outages
+		s.logger.Warn("could not lookup mx records", zap.String("domain", domain), errorField(err))
+		return nil
+	}
+
+	if !reachable {
+		return errEmailDomainUnreachable
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMXValidator_reachable(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenRecords  []*net.MX
+		givenErr      error
+		expected      bool
+		expectedError bool
+	}{
+		{
+			name:         "domain has mail servers",
+			givenRecords: []*net.MX{{Host: "mx.mail.com.", Pref: 10}},
+			expected:     true,
+		},
+		{
+			name:     "domain does not exist",
+			givenErr: &net.DNSError{Err: "no such host", Name: "mail.com", IsNotFound: true},
+			expected: false,
+		},
+		{
+			name:         "domain has no mail servers",
+			givenRecords: []*net.MX{},
+			expected:     false,
+		},
+		{
+			name:         "domain has a null mx",
+			givenRecords: []*net.MX{{Host: ".", Pref: 0}},
+			expected:     false,
+		},
+		{
+			name:          "lookup error",
+			givenErr:      &net.DNSError{Err: "i/o timeout", Name: "mail.com", IsTimeout: true},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			v := newMXValidator(func(ctx context.Context, domain string) ([]*net.MX, error) {
+				_, ok := ctx.Deadline()
+				assert.True(t, ok)
+				return tc.givenRecords, tc.givenErr
+			})
+
+			actual, err := v.reachable(context.TODO(), "mail.com")
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestMXValidator_reachableCache(t *testing.T) {
+	t.Parallel()
+
+	var lookups int
+
+	v := newMXValidator(func(ctx context.Context, domain string) ([]*net.MX, error) {
+		lookups++
+		return []*net.MX{{Host: "mx.mail.com.", Pref: 10}}, nil
+	})
+
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		reachable, err := v.reachable(context.TODO(), "Mail.com")
+		require.NoError(t, err)
+		assert.True(t, reachable)
+	}
+
+	assert.Equal(t, 1, lookups)
+
+	now = now.Add(mxCacheTTL)
+
+	_, err := v.reachable(context.TODO(), "mail.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, lookups)
+}
+
+func TestCreate_emailMXValidation(t *testing.T) {
+	t.Parallel()
+
+	given := CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	testCases := []struct {
+		name          string
+		givenRecords  []*net.MX
+		givenErr      error
+		expectedError error
+	}{
+		{
+			name:         "domain has mail servers",
+			givenRecords: []*net.MX{{Host: "mx.mail.com.", Pref: 10}},
+		},
+		{
+			name:          "domain has no mail servers",
+			givenErr:      &net.DNSError{Err: "no such host", Name: "mail.com", IsNotFound: true},
+			expectedError: errEmailDomainUnreachable,
+		},
+		{
+			name:     "lookup error does not reject the email",
+			givenErr: errors.New("some error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+						return user, nil
+					},
+				},
+				WithEmailMXValidation(),
+			)
+
+			svc.mxValidator.lookupMX = func(ctx context.Context, domain string) ([]*net.MX, error) {
+				assert.Equal(t, "mail.com", domain)
+				return tc.givenRecords, tc.givenErr
+			}
+
+			_, err := svc.Create(context.TODO(), given)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
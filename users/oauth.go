@@ -0,0 +1,242 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/alesr/stdservices/pkg/validate"
+	"github.com/alesr/stdservices/users/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	// oauthUsernameAttempts is the number of usernames tried for a new OAuth user
+	// before giving up on username collisions
+	oauthUsernameAttempts = 3
+
+	// oauthUsernameSuffixLen is the number of random letters appended to colliding usernames
+	oauthUsernameSuffixLen = 6
+
+	// oauthFallbackUsername is the username of new OAuth users whose profile has no usable username
+	oauthFallbackUsername = "user"
+)
+
+// WithOAuthProvider enables the OAuth2 logins of the provider under the given name (e.g. "google"),
+// passed to AuthenticateWithOAuth along with the authorization codes. It can be given once per provider.
+func WithOAuthProvider(name string, provider OAuthProvider) ServiceOption {
+	return func(s *DefaultService) {
+		if s.oauthProviders == nil {
+			s.oauthProviders = make(map[string]OAuthProvider)
+		}
+		s.oauthProviders[name] = provider
+	}
+}
+
+// AuthenticateWithOAuth exchanges the authorization code of the provider for the profile of the user
+// and generates a JWT token for the user linked to the provider identity, as password logins do.
+//
+// Identities not linked yet are linked to the user holding the email of the profile, so a user can log in
+// with a password and several providers, or to a new user without password created from the profile.
+// Either requires the email to be verified by the provider, as linking an unverified email would let
+// anyone claiming it take over the account. Linking to a user also requires the user to have verified its email,
+// as anyone could have registered it with a password beforehand to take over the account once linked.
+// Users created this way can set a password with RequestPasswordReset.
+func (s *DefaultService) AuthenticateWithOAuth(ctx context.Context, provider, code string) (string, error) {
+	oauthProvider, ok := s.oauthProviders[provider]
+	if !ok {
+		return "", errOAuthProviderUnknown
+	}
+
+	if code == "" {
+		return "", errOAuthCodeInvalid
+	}
+
+	profile, err := oauthProvider.Exchange(ctx, code)
+	if err != nil {
+		if errors.Is(err, errOAuthCodeInvalid) {
+			s.logger.Info("login failed", zap.String("operation", "authenticate_with_oauth"), zap.String("provider", provider), errorField(err))
+			return "", errOAuthCodeInvalid
+		}
+		s.logger.Error("could not exchange oauth code", zap.String("operation", "authenticate_with_oauth"), zap.String("provider", provider), errorField(err))
+		return "", wrapErr(ctx, "could not exchange oauth code", err)
+	}
+
+	if profile.Subject == "" {
+		return "", errOAuthProfileInvalid
+	}
+
+	storageUser, err := s.oauthUser(ctx, provider, profile)
+	if err != nil {
+		s.logger.Info("login failed", zap.String("operation", "authenticate_with_oauth"), zap.String("provider", provider), errorField(err))
+		return "", err
+	}
+
+	if s.emailVerificationRequired(storageUser) {
+		return "", errEmailNotVerified
+	}
+
+	if err := s.allowTokenIssuance(ctx, storageUser.ID); err != nil {
+		s.logger.Info("login failed", zap.String("operation", "authenticate_with_oauth"), userIDField(storageUser.ID), errorField(err))
+		return "", err
+	}
+
+	issued, err := s.issueLoginToken(ctx, storageUser, s.tokenTTLOrDefault(), "", mfaChallengeToken, "authenticate_with_oauth")
+	if err != nil {
+		return "", err
+	}
+	return issued.token, nil
+}
+
+// oauthUser returns the user linked to the provider identity of the profile,
+// linking the identity to the user holding its verified email, or to a new user, if not linked yet
+func (s *DefaultService) oauthUser(ctx context.Context, provider string, profile *OAuthProfile) (*repository.User, error) {
+	identity, err := s.repo.SelectIdentity(ctx, provider, profile.Subject)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select identity", err)
+	}
+
+	if identity != nil {
+		storageUser, err := s.selectUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, wrapErr(ctx, "could not select user by id", err)
+		}
+
+		if storageUser == nil {
+			return nil, errNotFound
+		}
+		return storageUser, nil
+	}
+
+	if !profile.EmailVerified {
+		return nil, errOAuthEmailUnverified
+	}
+
+	if err := s.validator().ValidateEmail(profile.Email); err != nil {
+		return nil, errOAuthProfileInvalid
+	}
+
+	storageUser, err := s.repo.SelectByEmail(ctx, s.emailLookup(profile.Email))
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by email", err)
+	}
+
+	if storageUser == nil {
+		if storageUser, err = s.insertOAuthUser(ctx, profile); err != nil {
+			return nil, err
+		}
+	} else if !storageUser.EmailVerified {
+		return nil, errOAuthLinkUnverified
+	}
+
+	in := repository.Identity{
+		Provider:  provider,
+		Subject:   profile.Subject,
+		UserID:    storageUser.ID,
+		CreatedAt: s.now().UTC(),
+	}
+
+	if err := s.repo.InsertIdentity(ctx, in); err != nil {
+		// The identity was linked by a concurrent login
+		if errors.Is(err, repository.ErrDuplicateRecord) {
+			return nil, errConcurrentModification
+		}
+		s.logger.Error("could not insert identity", zap.String("operation", "authenticate_with_oauth"), userIDField(storageUser.ID), errorField(err))
+		return nil, wrapErr(ctx, "could not insert identity", err)
+	}
+
+	s.logger.Info("oauth identity linked", zap.String("operation", "authenticate_with_oauth"), zap.String("provider", provider), userIDField(storageUser.ID))
+	return storageUser, nil
+}
+
+// insertOAuthUser creates a user without password from the profile, with a verified email.
+// Colliding usernames are retried with a random suffix.
+func (s *DefaultService) insertOAuthUser(ctx context.Context, profile *OAuthProfile) (*repository.User, error) {
+	username := oauthUsername(profile)
+
+	fullname := profile.Name
+	if validate.Fullname(fullname) != nil {
+		fullname = username
+	}
+
+	newUser := repository.User{
+		ID:                s.newID(),
+		Fullname:          fullname,
+		EmailVerified:     true,
+		Role:              string(RoleUser),
+		CreatedAt:         s.now(),
+		UpdatedAt:         s.now(),
+		PasswordChangedAt: s.now(),
+	}
+
+	if err := s.sealEmail(&newUser, profile.Email); err != nil {
+		return nil, fmt.Errorf("could not encrypt email: %s", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		newUser.Username = username
+		newUser.UsernameNormalized = normalizeUsername(username)
+
+		insertedUser, err := s.repo.Insert(ctx, &newUser)
+		if err == nil {
+			s.logger.Info("user created", zap.String("operation", "authenticate_with_oauth"), userIDField(insertedUser.ID))
+
+			user, err := s.userFromRepository(insertedUser)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+			}
+
+			s.sendWelcomeEmail(ctx, user)
+			return insertedUser, nil
+		}
+
+		switch {
+		case errors.Is(err, repository.ErrDuplicateUsername) && attempt < oauthUsernameAttempts:
+			username = oauthUsername(profile) + oauthUsernameSuffix()
+		case errors.Is(err, repository.ErrDuplicateEmail):
+			return nil, errEmailTaken
+		case errors.Is(err, repository.ErrDuplicateUsername):
+			return nil, errUsernameTaken
+		case errors.Is(err, repository.ErrDuplicateRecord):
+			return nil, errAlreadyExists
+		default:
+			s.logger.Error("could not insert user", zap.String("operation", "authenticate_with_oauth"), errorField(err))
+			return nil, wrapErr(ctx, "could not insert user", err)
+		}
+	}
+}
+
+// oauthUsername returns the letters of the provider username of the profile, or of its email local part,
+// as usernames only hold letters. Unusable usernames fall back to oauthFallbackUsername.
+func oauthUsername(profile *OAuthProfile) string {
+	name := profile.Username
+	if name == "" {
+		name, _, _ = strings.Cut(profile.Email, "@")
+	}
+
+	username := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return r
+		}
+		return -1
+	}, name)
+
+	// Leave room for the suffix of colliding usernames
+	if validate.Username(username) != nil || validate.Username(username+strings.Repeat("x", oauthUsernameSuffixLen)) != nil {
+		return oauthFallbackUsername
+	}
+	return username
+}
+
+// oauthUsernameSuffix returns random letters telling apart the users created with the same username
+func oauthUsernameSuffix() string {
+	// Usernames only hold letters, so the digits are mapped to letters
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return 'a' + r - '0'
+		}
+		return r
+	}, randString(oauthUsernameSuffixLen))
+}
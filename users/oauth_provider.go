@@ -0,0 +1,230 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	oauthRequestTimeout = 10 * time.Second
+
+	// oauthMaxResponseSize bounds the responses read from the providers
+	oauthMaxResponseSize = 1 << 20
+)
+
+var (
+	_ OAuthProvider = (*googleProvider)(nil)
+	_ OAuthProvider = (*githubProvider)(nil)
+)
+
+type (
+	// OAuthProvider exchanges the authorization codes of an OAuth2 provider for the profile of their user
+	// (see WithOAuthProvider). GoogleOAuthProvider and GitHubOAuthProvider are provided.
+	OAuthProvider interface {
+		// Exchange exchanges the authorization code for an access token and returns the profile of its user
+		Exchange(ctx context.Context, code string) (*OAuthProfile, error)
+	}
+
+	// oauthClient implements the authorization code grant of an OAuth2 provider (RFC 6749)
+	oauthClient struct {
+		clientID     string
+		clientSecret string
+		redirectURL  string
+		tokenURL     string
+		http         *http.Client
+	}
+
+	googleProvider struct {
+		oauthClient
+		userInfoURL string
+	}
+
+	githubProvider struct {
+		oauthClient
+		userURL   string
+		emailsURL string
+	}
+)
+
+// GoogleOAuthProvider returns the provider of Google accounts for the OAuth2 client.
+// The authorization requests must ask for the "openid email profile" scopes.
+func GoogleOAuthProvider(clientID, clientSecret, redirectURL string) OAuthProvider {
+	return &googleProvider{
+		oauthClient: newOAuthClient(clientID, clientSecret, redirectURL, "https://oauth2.googleapis.com/token"),
+		userInfoURL: "https://openidconnect.googleapis.com/v1/userinfo",
+	}
+}
+
+// GitHubOAuthProvider returns the provider of GitHub accounts for the OAuth2 app.
+// The authorization requests must ask for the "user:email" scope, so the primary email is readable.
+func GitHubOAuthProvider(clientID, clientSecret, redirectURL string) OAuthProvider {
+	return &githubProvider{
+		oauthClient: newOAuthClient(clientID, clientSecret, redirectURL, "https://github.com/login/oauth/access_token"),
+		userURL:     "https://api.github.com/user",
+		emailsURL:   "https://api.github.com/user/emails",
+	}
+}
+
+func newOAuthClient(clientID, clientSecret, redirectURL, tokenURL string) oauthClient {
+	return oauthClient{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		tokenURL:     tokenURL,
+		http:         &http.Client{Timeout: oauthRequestTimeout},
+	}
+}
+
+func (p *googleProvider) Exchange(ctx context.Context, code string) (*OAuthProfile, error) {
+	accessToken, err := p.exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var userInfo struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+
+	if err := p.get(ctx, p.userInfoURL, accessToken, &userInfo); err != nil {
+		return nil, fmt.Errorf("could not get google userinfo: %w", err)
+	}
+
+	return &OAuthProfile{
+		Subject:       userInfo.Sub,
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.EmailVerified,
+		Name:          userInfo.Name,
+	}, nil
+}
+
+func (p *githubProvider) Exchange(ctx context.Context, code string) (*OAuthProfile, error) {
+	accessToken, err := p.exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+
+	if err := p.get(ctx, p.userURL, accessToken, &user); err != nil {
+		return nil, fmt.Errorf("could not get github user: %w", err)
+	}
+
+	// The email of the user profile is only set when public, and is not known to be verified
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	if err := p.get(ctx, p.emailsURL, accessToken, &emails); err != nil {
+		return nil, fmt.Errorf("could not get github user emails: %w", err)
+	}
+
+	profile := OAuthProfile{
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+		Username: user.Login,
+	}
+
+	for _, e := range emails {
+		if e.Primary {
+			profile.Email, profile.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return &profile, nil
+}
+
+// exchange exchanges the authorization code for an access token.
+// It returns errOAuthCodeInvalid if the provider rejects the code.
+func (c *oauthClient) exchange(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.redirectURL},
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("could not create token request: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+
+	status, err := c.do(req, &token)
+	if err != nil && status == 0 {
+		return "", fmt.Errorf("could not exchange code: %w", err)
+	}
+
+	// Rejected codes fail with an error response (RFC 6749 section 5.2),
+	// which GitHub returns with a 200 status
+	if token.Error != "" || status == http.StatusBadRequest || status == http.StatusUnauthorized {
+		return "", errOAuthCodeInvalid
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("could not exchange code: %w", err)
+	}
+
+	if token.AccessToken == "" {
+		return "", errors.New("could not exchange code: no access token returned")
+	}
+	return token.AccessToken, nil
+}
+
+// get gets the JSON resource at the url with the access token
+func (c *oauthClient) get(ctx context.Context, resourceURL, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %s", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	_, err = c.do(req, v)
+	return err
+}
+
+// do sends the request and decodes the JSON response into v, returning the status of the response.
+// Error responses are decoded too, and reported along with their status.
+func (c *oauthClient) do(req *http.Request, v interface{}) (int, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, oauthMaxResponseSize)).Decode(v)
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if decodeErr != nil {
+		return resp.StatusCode, fmt.Errorf("could not decode response: %s", decodeErr)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,19 @@
+package users
+
+import (
+	"context"
+	"errors"
+)
+
+var _ OAuthProvider = (*oauthProviderMock)(nil)
+
+type oauthProviderMock struct {
+	exchangeFunc func(ctx context.Context, code string) (*OAuthProfile, error)
+}
+
+func (m *oauthProviderMock) Exchange(ctx context.Context, code string) (*OAuthProfile, error) {
+	if m.exchangeFunc == nil {
+		return nil, errors.New("oauthProviderMock.exchangeFunc is nil")
+	}
+	return m.exchangeFunc(ctx, code)
+}
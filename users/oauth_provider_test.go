@@ -0,0 +1,116 @@
+package users
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newOAuthServer returns a provider server issuing the access token "token" for the code "code"
+// and serving the JSON resources at their paths to the holders of the token
+func newOAuthServer(t *testing.T, resources map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/token":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "authorization_code", r.PostForm.Get("grant_type"))
+			assert.Equal(t, "client-id", r.PostForm.Get("client_id"))
+			assert.Equal(t, "https://example.com/callback", r.PostForm.Get("redirect_uri"))
+
+			switch r.PostForm.Get("code") {
+			case "code":
+				w.Write([]byte(`{"access_token": "token", "token_type": "bearer"}`))
+			case "github-style":
+				w.Write([]byte(`{"error": "bad_verification_code"}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "invalid_grant"}`))
+			}
+		default:
+			resource, ok := resources[r.URL.Path]
+			if !ok || r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(resource))
+		}
+	}))
+
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGoogleOAuthProvider(t *testing.T) {
+	t.Parallel()
+
+	server := newOAuthServer(t, map[string]string{
+		"/userinfo": `{"sub": "123", "email": "joedoe@mail.com", "email_verified": true, "name": "John Doe"}`,
+	})
+
+	provider := GoogleOAuthProvider("client-id", "client-secret", "https://example.com/callback").(*googleProvider)
+	provider.tokenURL = server.URL + "/token"
+	provider.userInfoURL = server.URL + "/userinfo"
+
+	profile, err := provider.Exchange(context.Background(), "code")
+	require.NoError(t, err)
+
+	expected := OAuthProfile{
+		Subject:       "123",
+		Email:         "joedoe@mail.com",
+		EmailVerified: true,
+		Name:          "John Doe",
+	}
+	assert.Equal(t, expected, *profile)
+
+	_, err = provider.Exchange(context.Background(), "expired")
+	assert.Equal(t, errOAuthCodeInvalid, err)
+}
+
+func TestGitHubOAuthProvider(t *testing.T) {
+	t.Parallel()
+
+	server := newOAuthServer(t, map[string]string{
+		"/user": `{"id": 123, "login": "jdoe", "name": "John Doe", "email": null}`,
+		"/user/emails": `[
+			{"email": "old@mail.com", "primary": false, "verified": true},
+			{"email": "joedoe@mail.com", "primary": true, "verified": true}
+		]`,
+	})
+
+	provider := GitHubOAuthProvider("client-id", "client-secret", "https://example.com/callback").(*githubProvider)
+	provider.tokenURL = server.URL + "/token"
+	provider.userURL = server.URL + "/user"
+	provider.emailsURL = server.URL + "/user/emails"
+
+	profile, err := provider.Exchange(context.Background(), "code")
+	require.NoError(t, err)
+
+	expected := OAuthProfile{
+		Subject:       "123",
+		Email:         "joedoe@mail.com",
+		EmailVerified: true,
+		Name:          "John Doe",
+		Username:      "jdoe",
+	}
+	assert.Equal(t, expected, *profile)
+
+	// GitHub rejects codes with a 200 status
+	_, err = provider.Exchange(context.Background(), "github-style")
+	assert.Equal(t, errOAuthCodeInvalid, err)
+
+	t.Run("resource unavailable", func(t *testing.T) {
+		provider := *provider
+		provider.emailsURL = server.URL + "/missing"
+
+		_, err := provider.Exchange(context.Background(), "code")
+		assert.Error(t, err)
+	})
+}
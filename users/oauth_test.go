@@ -0,0 +1,248 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAuthenticateWithOAuth(t *testing.T) {
+	t.Parallel()
+
+	existingUser := repository.User{
+		ID:                 uuid.New().String(),
+		Fullname:           "John Doe",
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Email:              "joedoe@mail.com",
+		Role:               string(RoleUser),
+		EmailVerified:      true,
+	}
+
+	unverifiedUser := existingUser
+	unverifiedUser.ID = uuid.New().String()
+	unverifiedUser.Email = "unverified@mail.com"
+	unverifiedUser.EmailVerified = false
+
+	testCases := []struct {
+		name               string
+		givenProvider      string
+		givenProfile       *OAuthProfile
+		givenExchangeErr   error
+		givenIdentity      *repository.Identity
+		givenTakenUsername string
+		expectedUserID     string
+		expectedLinked     bool
+		expectedInserted   bool
+		expectedErr        error
+	}{
+		{
+			name:           "linked identity",
+			givenProvider:  "google",
+			givenProfile:   &OAuthProfile{Subject: "123", Email: "other@mail.com", EmailVerified: true},
+			givenIdentity:  &repository.Identity{Provider: "google", Subject: "123", UserID: existingUser.ID},
+			expectedUserID: existingUser.ID,
+		},
+		{
+			name:           "identity linked to the user holding the email",
+			givenProvider:  "github",
+			givenProfile:   &OAuthProfile{Subject: "123", Email: "joedoe@mail.com", EmailVerified: true},
+			expectedUserID: existingUser.ID,
+			expectedLinked: true,
+		},
+		{
+			name:             "identity linked to a new user",
+			givenProvider:    "github",
+			givenProfile:     &OAuthProfile{Subject: "456", Email: "jane@mail.com", EmailVerified: true, Name: "Jane Doe", Username: "jane-doe"},
+			expectedLinked:   true,
+			expectedInserted: true,
+		},
+		{
+			name:               "username taken",
+			givenProvider:      "github",
+			givenProfile:       &OAuthProfile{Subject: "456", Email: "jane@mail.com", EmailVerified: true, Username: "jdoe"},
+			givenTakenUsername: "jdoe",
+			expectedLinked:     true,
+			expectedInserted:   true,
+		},
+		{
+			name:          "unverified email",
+			givenProvider: "github",
+			givenProfile:  &OAuthProfile{Subject: "123", Email: "joedoe@mail.com"},
+			expectedErr:   errOAuthEmailUnverified,
+		},
+		{
+			name:          "user holding the email without verified email",
+			givenProvider: "github",
+			givenProfile:  &OAuthProfile{Subject: "123", Email: "unverified@mail.com", EmailVerified: true},
+			expectedErr:   errOAuthLinkUnverified,
+		},
+		{
+			name:          "profile without subject",
+			givenProvider: "google",
+			givenProfile:  &OAuthProfile{Email: "joedoe@mail.com", EmailVerified: true},
+			expectedErr:   errOAuthProfileInvalid,
+		},
+		{
+			name:             "code rejected",
+			givenProvider:    "google",
+			givenExchangeErr: errOAuthCodeInvalid,
+			expectedErr:      errOAuthCodeInvalid,
+		},
+		{
+			name:          "unknown provider",
+			givenProvider: "facebook",
+			expectedErr:   errOAuthProviderUnknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				insertedUser *repository.User
+				linked       *repository.Identity
+			)
+
+			repo := &repositoryMock{
+				selectIdentityFunc: func(ctx context.Context, provider, subject string) (*repository.Identity, error) {
+					return tc.givenIdentity, nil
+				},
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					if id == existingUser.ID {
+						user := existingUser
+						return &user, nil
+					}
+					if insertedUser != nil && id == insertedUser.ID {
+						return insertedUser, nil
+					}
+					return nil, nil
+				},
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					switch email {
+					case existingUser.Email:
+						user := existingUser
+						return &user, nil
+					case unverifiedUser.Email:
+						user := unverifiedUser
+						return &user, nil
+					}
+					return nil, nil
+				},
+				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					if user.UsernameNormalized == tc.givenTakenUsername {
+						return nil, repository.ErrDuplicateUsername
+					}
+					insertedUser = user
+					return user, nil
+				},
+				insertIdentityFunc: func(ctx context.Context, in repository.Identity) error {
+					linked = &in
+					return nil
+				},
+				insertSessionFunc: func(ctx context.Context, in repository.Session, maxActive int, evictOldest bool) error {
+					return nil
+				},
+				insertLoginEventFunc: func(ctx context.Context, in repository.LoginEvent, keep int) error {
+					return nil
+				},
+			}
+
+			provider := &oauthProviderMock{
+				exchangeFunc: func(ctx context.Context, code string) (*OAuthProfile, error) {
+					assert.Equal(t, "code", code)
+					return tc.givenProfile, tc.givenExchangeErr
+				},
+			}
+
+			svc := New(zap.NewNop(), "jwt-secret", repo,
+				WithOAuthProvider("google", provider),
+				WithOAuthProvider("github", provider),
+			)
+
+			// The jwt library rejects tokens issued in the future
+			now := time.Now().Add(-time.Minute)
+			svc.clock = func() time.Time { return now }
+
+			token, err := svc.AuthenticateWithOAuth(context.Background(), tc.givenProvider, "code")
+			assert.Equal(t, tc.expectedErr, err)
+
+			if tc.expectedErr != nil {
+				assert.Empty(t, token)
+				assert.Nil(t, linked)
+				return
+			}
+
+			resp, err := svc.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+
+			if tc.expectedInserted {
+				require.NotNil(t, insertedUser)
+				assert.Equal(t, insertedUser.ID, resp.ID)
+
+				assert.Equal(t, tc.givenProfile.Email, insertedUser.Email)
+				assert.True(t, insertedUser.EmailVerified)
+				assert.Empty(t, insertedUser.PasswordHash)
+				assert.NotEqual(t, tc.givenTakenUsername, insertedUser.UsernameNormalized)
+			} else {
+				assert.Nil(t, insertedUser)
+				assert.Equal(t, tc.expectedUserID, resp.ID)
+			}
+
+			if !tc.expectedLinked {
+				assert.Nil(t, linked)
+				return
+			}
+
+			require.NotNil(t, linked)
+			assert.Equal(t, tc.givenProvider, linked.Provider)
+			assert.Equal(t, tc.givenProfile.Subject, linked.Subject)
+			assert.Equal(t, resp.ID, linked.UserID)
+		})
+	}
+}
+
+func TestOAuthUsername(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    *OAuthProfile
+		expected string
+	}{
+		{
+			name:     "provider username",
+			given:    &OAuthProfile{Username: "jane-doe42", Email: "jane@mail.com"},
+			expected: "janedoe",
+		},
+		{
+			name:     "email local part",
+			given:    &OAuthProfile{Email: "jane.doe@mail.com"},
+			expected: "janedoe",
+		},
+		{
+			name:     "too short",
+			given:    &OAuthProfile{Email: "j1@mail.com"},
+			expected: oauthFallbackUsername,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, oauthUsername(tc.given))
+		})
+	}
+
+	assert.Regexp(t, "^[a-z]{6}$", oauthUsernameSuffix())
+}
@@ -0,0 +1,60 @@
+package users
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+const (
+	defaultPageLimit    int = 20
+	defaultMaxPageLimit int = 100
+)
+
+type pagination struct {
+	defaultLimit int
+	maxLimit     int
+}
+
+// WithPagination sets the page size applied by list methods when the caller sets no limit,
+// and the maximum page size callers can request. They default to 20 and 100.
+func WithPagination(defaultLimit, maxLimit int) ServiceOption {
+	return func(s *DefaultService) {
+		s.pagination = pagination{defaultLimit: defaultLimit, maxLimit: maxLimit}
+	}
+}
+
+// pageLimit returns the default limit for non-positive values and caps limit to the maximum page size
+func (s *DefaultService) pageLimit(limit int) int {
+	maxLimit := s.pagination.maxLimit
+	if maxLimit <= 0 {
+		maxLimit = defaultMaxPageLimit
+	}
+
+	if limit <= 0 {
+		limit = s.pagination.defaultLimit
+		if limit <= 0 {
+			limit = defaultPageLimit
+		}
+	}
+
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+// encodeCursor encodes the position a listing resumes from into an opaque string
+func encodeCursor(cursor interface{}) string {
+	// Cursors hold times, strings and integers, which don't fail to marshal
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor decodes a cursor encoded with encodeCursor into the value pointed to by cursor
+func decodeCursor(s string, cursor interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, cursor)
+}
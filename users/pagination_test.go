@@ -0,0 +1,67 @@
+package users
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageLimit(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenOptions  []ServiceOption
+		givenLimit    int
+		expectedLimit int
+	}{
+		{
+			name:          "default limit",
+			givenOptions:  []ServiceOption{WithPagination(10, 50)},
+			givenLimit:    0,
+			expectedLimit: 10,
+		},
+		{
+			name:          "negative limit falls back to the default",
+			givenOptions:  []ServiceOption{WithPagination(10, 50)},
+			givenLimit:    -1,
+			expectedLimit: 10,
+		},
+		{
+			name:          "limit is clamped to max",
+			givenOptions:  []ServiceOption{WithPagination(10, 50)},
+			givenLimit:    1000,
+			expectedLimit: 50,
+		},
+		{
+			name:          "explicit limit",
+			givenOptions:  []ServiceOption{WithPagination(10, 50)},
+			givenLimit:    25,
+			expectedLimit: 25,
+		},
+		{
+			name:          "default limit without pagination option",
+			givenLimit:    0,
+			expectedLimit: defaultPageLimit,
+		},
+		{
+			name:          "limit is clamped to max without pagination option",
+			givenLimit:    1000,
+			expectedLimit: defaultMaxPageLimit,
+		},
+		{
+			name:          "default limit above max is clamped",
+			givenOptions:  []ServiceOption{WithPagination(100, 50)},
+			givenLimit:    0,
+			expectedLimit: 50,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := New(nil, "jwt-secret", &repositoryMock{}, tc.givenOptions...)
+
+			assert.Equal(t, tc.expectedLimit, svc.pageLimit(tc.givenLimit))
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/alesr/stdservices/users/webauthn"
+	"go.uber.org/zap"
+)
+
+const (
+	// passkeyChallengeSize is the size of the random challenges signed by the authenticators
+	passkeyChallengeSize = 32
+
+	// Enumerate the kinds of passkey ceremonies
+
+	passkeyRegistration = "registration"
+	passkeyLogin        = "login"
+)
+
+// passkeyCeremony is the state of a passkey ceremony, handed to the client along with the options
+// and signed so it can be verified without storing it
+type passkeyCeremony struct {
+	Kind      string `json:"kind"`
+	Challenge []byte `json:"challenge"`
+	UserID    string `json:"user_id,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// WithWebAuthn enables passkeys (WebAuthn discoverable credentials) scoped to the relying party,
+// registered with BeginPasskeyRegistration and used to log in with GenerateTokenWithPasskey
+func WithWebAuthn(rp *webauthn.RelyingParty) ServiceOption {
+	return func(s *DefaultService) {
+		s.webauthn = rp
+	}
+}
+
+// BeginPasskeyRegistration starts the registration of a passkey for the user. The options are passed to
+// navigator.credentials.create, and the state along with the credential it returns to FinishPasskeyRegistration.
+// The passkeys already registered by the user are excluded, so an authenticator is not registered twice.
+func (s *DefaultService) BeginPasskeyRegistration(ctx context.Context, userID string) (*PasskeyRegistration, error) {
+	if s.webauthn == nil {
+		return nil, errPasskeyUnavailable
+	}
+
+	if err := s.validateID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageUser, err := s.selectUserByID(ctx, userID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return nil, errNotFound
+	}
+
+	passkeys, err := s.repo.SelectPasskeysByUserID(ctx, userID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select passkeys", err)
+	}
+
+	exclude := make([][]byte, 0, len(passkeys))
+	for _, p := range passkeys {
+		id, err := base64.RawURLEncoding.DecodeString(p.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode passkey id: %s", err)
+		}
+		exclude = append(exclude, id)
+	}
+
+	ceremony, state, err := s.newPasskeyCeremony(passkeyRegistration, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	user := webauthn.User{
+		ID:          []byte(storageUser.ID),
+		Name:        storageUser.Username,
+		DisplayName: storageUser.Fullname,
+	}
+
+	return &PasskeyRegistration{
+		Options: s.webauthn.CreationOptions(ceremony.Challenge, user, exclude),
+		State:   state,
+	}, nil
+}
+
+// FinishPasskeyRegistration verifies the credential returned by navigator.credentials.create,
+// JSON encoded as by PublicKeyCredential.toJSON, and stores the passkey of the user.
+// The state is the one returned by BeginPasskeyRegistration, and is only accepted once.
+func (s *DefaultService) FinishPasskeyRegistration(ctx context.Context, state string, response []byte) error {
+	if s.webauthn == nil {
+		return errPasskeyUnavailable
+	}
+
+	ceremony, err := s.consumePasskeyCeremony(ctx, state, passkeyRegistration)
+	if err != nil {
+		return err
+	}
+
+	credential, err := s.webauthn.VerifyRegistration(ceremony.Challenge, response)
+	if err != nil {
+		s.logger.Info("passkey registration failed", zap.String("operation", "finish_passkey_registration"), userIDField(ceremony.UserID), errorField(err))
+		return fmt.Errorf("could not verify passkey registration: %w", err)
+	}
+
+	in := repository.Passkey{
+		ID:        base64.RawURLEncoding.EncodeToString(credential.ID),
+		UserID:    ceremony.UserID,
+		PublicKey: credential.PublicKey,
+		SignCount: int64(credential.SignCount),
+		CreatedAt: s.now().UTC(),
+	}
+
+	if err := s.repo.InsertPasskey(ctx, in); err != nil {
+		if errors.Is(err, repository.ErrDuplicateRecord) {
+			return errPasskeyTaken
+		}
+		s.logger.Error("could not insert passkey", zap.String("operation", "finish_passkey_registration"), userIDField(ceremony.UserID), errorField(err))
+		return wrapErr(ctx, "could not insert passkey", err)
+	}
+
+	s.logger.Info("passkey registered", zap.String("operation", "finish_passkey_registration"), userIDField(ceremony.UserID))
+	return nil
+}
+
+// BeginPasskeyLogin starts a passkey login. The options are passed to navigator.credentials.get,
+// and the state along with the credential it returns to GenerateTokenWithPasskey.
+// No user is needed, as the authenticator offers the passkeys it holds for the relying party.
+func (s *DefaultService) BeginPasskeyLogin(ctx context.Context) (*PasskeyLogin, error) {
+	if s.webauthn == nil {
+		return nil, errPasskeyUnavailable
+	}
+
+	ceremony, state, err := s.newPasskeyCeremony(passkeyLogin, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyLogin{
+		Options: s.webauthn.RequestOptions(ceremony.Challenge),
+		State:   state,
+	}, nil
+}
+
+// GenerateTokenWithPasskey generates a JWT token for the user of the passkey asserted by
+// navigator.credentials.get, JSON encoded as by PublicKeyCredential.toJSON, as password logins do.
+// The state is the one returned by BeginPasskeyLogin, and is only accepted once.
+// Passkeys verify the user on the authenticator, so the login is not challenged for a TOTP code.
+func (s *DefaultService) GenerateTokenWithPasskey(ctx context.Context, state string, response []byte) (string, error) {
+	if s.webauthn == nil {
+		return "", errPasskeyUnavailable
+	}
+
+	ceremony, err := s.consumePasskeyCeremony(ctx, state, passkeyLogin)
+	if err != nil {
+		return "", err
+	}
+
+	assertion, err := webauthn.ParseAssertion(response)
+	if err != nil {
+		return "", fmt.Errorf("could not parse passkey assertion: %w", err)
+	}
+
+	storagePasskey, err := s.repo.SelectPasskey(ctx, base64.RawURLEncoding.EncodeToString(assertion.CredentialID))
+	if err != nil {
+		return "", wrapErr(ctx, "could not select passkey", err)
+	}
+
+	// Discoverable credentials return the user handle they were registered for
+	if storagePasskey == nil || len(assertion.UserHandle) > 0 && string(assertion.UserHandle) != storagePasskey.UserID {
+		s.logger.Info("login failed", zap.String("operation", "generate_token_with_passkey"), errorField(errPasskeyNotFound))
+		return "", errPasskeyNotFound
+	}
+
+	credential := webauthn.Credential{
+		ID:        assertion.CredentialID,
+		PublicKey: storagePasskey.PublicKey,
+		SignCount: uint32(storagePasskey.SignCount),
+	}
+
+	signCount, err := s.webauthn.VerifyAssertion(ceremony.Challenge, assertion, credential)
+	if err != nil {
+		s.logger.Info("login failed", zap.String("operation", "generate_token_with_passkey"), userIDField(storagePasskey.UserID), errorField(err))
+		return "", fmt.Errorf("could not verify passkey assertion: %w", err)
+	}
+
+	if err := s.repo.UpdatePasskeySignCount(ctx, storagePasskey.ID, int64(signCount), s.now().UTC()); err != nil {
+		return "", wrapErr(ctx, "could not update passkey sign count", err)
+	}
+
+	storageUser, err := s.selectUserByID(ctx, storagePasskey.UserID)
+	if err != nil {
+		return "", wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return "", errNotFound
+	}
+
+	if s.emailVerificationRequired(storageUser) {
+		return "", errEmailNotVerified
+	}
+
+	if err := s.allowTokenIssuance(ctx, storageUser.ID); err != nil {
+		s.logger.Info("login failed", zap.String("operation", "generate_token_with_passkey"), userIDField(storageUser.ID), errorField(err))
+		return "", err
+	}
+
+	issued, err := s.startLoginSession(ctx, storageUser, s.tokenTTLOrDefault(), "", "generate_token_with_passkey")
+	if err != nil {
+		return "", err
+	}
+	return issued.token, nil
+}
+
+// newPasskeyCeremony returns a new ceremony of the kind with a random challenge, along with its signed state
+func (s *DefaultService) newPasskeyCeremony(kind, userID string) (*passkeyCeremony, string, error) {
+	challenge := make([]byte, passkeyChallengeSize)
+	if _, err := io.ReadFull(rand.Reader, challenge); err != nil {
+		return nil, "", fmt.Errorf("could not generate passkey challenge: %s", err)
+	}
+
+	ceremony := passkeyCeremony{
+		Kind:      kind,
+		Challenge: challenge,
+		UserID:    userID,
+		ExpiresAt: s.now().Add(webauthn.CeremonyTimeout).Unix(),
+	}
+
+	b, err := json.Marshal(ceremony)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not marshal passkey ceremony: %s", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	signature, err := s.signHMAC("passkey", payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not sign passkey ceremony: %w", err)
+	}
+	return &ceremony, payload + "." + signature, nil
+}
+
+// consumePasskeyCeremony verifies the signed state of a ceremony of the kind and returns the ceremony,
+// recording it in the token revocation store so it is only accepted once
+func (s *DefaultService) consumePasskeyCeremony(ctx context.Context, state, kind string) (*passkeyCeremony, error) {
+	payload, signature, ok := strings.Cut(state, ".")
+	if !ok {
+		return nil, errPasskeyCeremonyInvalid
+	}
+
+	valid, err := s.verifyHMAC("passkey", payload, signature)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify passkey ceremony: %w", err)
+	}
+
+	if !valid {
+		return nil, errPasskeyCeremonyInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errPasskeyCeremonyInvalid
+	}
+
+	var ceremony passkeyCeremony
+	if err := json.Unmarshal(b, &ceremony); err != nil {
+		return nil, errPasskeyCeremonyInvalid
+	}
+
+	expiresAt := time.Unix(ceremony.ExpiresAt, 0)
+	if ceremony.Kind != kind || !s.now().Before(expiresAt) {
+		return nil, errPasskeyCeremonyInvalid
+	}
+
+	consumed, err := s.consumeOnce(ctx, "passkey:"+base64.RawURLEncoding.EncodeToString(ceremony.Challenge), expiresAt)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not consume passkey ceremony", err)
+	}
+
+	if !consumed {
+		return nil, errPasskeyCeremonyInvalid
+	}
+	return &ceremony, nil
+}
@@ -0,0 +1,191 @@
+package users
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/alesr/stdservices/users/webauthn"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPasskey(t *testing.T) {
+	t.Parallel()
+
+	storedUser := repository.User{
+		ID:       uuid.New().String(),
+		Fullname: "John Doe",
+		Username: "jdoe",
+		Email:    "joedoe@mail.com",
+		Role:     string(RoleUser),
+	}
+
+	passkeys := make(map[string]repository.Passkey)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				if id != storedUser.ID {
+					return nil, nil
+				}
+				user := storedUser
+				return &user, nil
+			},
+			insertPasskeyFunc: func(ctx context.Context, in repository.Passkey) error {
+				if _, ok := passkeys[in.ID]; ok {
+					return repository.ErrDuplicateRecord
+				}
+				passkeys[in.ID] = in
+				return nil
+			},
+			selectPasskeyFunc: func(ctx context.Context, id string) (*repository.Passkey, error) {
+				passkey, ok := passkeys[id]
+				if !ok {
+					return nil, nil
+				}
+				return &passkey, nil
+			},
+			selectPasskeysByUserIDFunc: func(ctx context.Context, userID string) ([]repository.Passkey, error) {
+				var found []repository.Passkey
+				for _, passkey := range passkeys {
+					if passkey.UserID == userID {
+						found = append(found, passkey)
+					}
+				}
+				return found, nil
+			},
+			updatePasskeySignCountFunc: func(ctx context.Context, id string, signCount int64, usedAt time.Time) error {
+				passkey := passkeys[id]
+				passkey.SignCount = signCount
+				passkey.LastUsedAt = &usedAt
+				passkeys[id] = passkey
+				return nil
+			},
+		},
+		WithWebAuthn(webauthn.NewRelyingParty("example.com", "Example")),
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	authenticator, err := webauthn.NewMockAuthenticator("example.com", "https://example.com")
+	require.NoError(t, err)
+
+	registration, err := svc.BeginPasskeyRegistration(context.Background(), storedUser.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "jdoe", registration.Options.User.Name)
+	assert.Empty(t, registration.Options.ExcludeCredentials)
+
+	response, err := authenticator.Register(registration.Options)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.FinishPasskeyRegistration(context.Background(), registration.State, response))
+
+	passkeyID := base64.RawURLEncoding.EncodeToString(authenticator.CredentialID())
+	require.Contains(t, passkeys, passkeyID)
+	assert.Equal(t, storedUser.ID, passkeys[passkeyID].UserID)
+
+	t.Run("registration state is only accepted once", func(t *testing.T) {
+		err := svc.FinishPasskeyRegistration(context.Background(), registration.State, response)
+		assert.Equal(t, errPasskeyCeremonyInvalid, err)
+	})
+
+	t.Run("registered passkeys are excluded", func(t *testing.T) {
+		registration, err := svc.BeginPasskeyRegistration(context.Background(), storedUser.ID)
+		require.NoError(t, err)
+
+		require.Len(t, registration.Options.ExcludeCredentials, 1)
+	})
+
+	t.Run("login with passkey", func(t *testing.T) {
+		login, err := svc.BeginPasskeyLogin(context.Background())
+		require.NoError(t, err)
+
+		response, err := authenticator.Assert(login.Options)
+		require.NoError(t, err)
+
+		// The state of a registration is not accepted for a login
+		_, err = svc.GenerateTokenWithPasskey(context.Background(), registration.State, response)
+		assert.Equal(t, errPasskeyCeremonyInvalid, err)
+
+		token, err := svc.GenerateTokenWithPasskey(context.Background(), login.State, response)
+		require.NoError(t, err)
+
+		resp, err := svc.VerifyToken(context.Background(), token)
+		require.NoError(t, err)
+		assert.Equal(t, storedUser.ID, resp.ID)
+
+		assert.Equal(t, int64(1), passkeys[passkeyID].SignCount)
+
+		_, err = svc.GenerateTokenWithPasskey(context.Background(), login.State, response)
+		assert.Equal(t, errPasskeyCeremonyInvalid, err)
+	})
+
+	t.Run("tampered state", func(t *testing.T) {
+		login, err := svc.BeginPasskeyLogin(context.Background())
+		require.NoError(t, err)
+
+		response, err := authenticator.Assert(login.Options)
+		require.NoError(t, err)
+
+		_, err = svc.GenerateTokenWithPasskey(context.Background(), login.State+"x", response)
+		assert.Equal(t, errPasskeyCeremonyInvalid, err)
+	})
+
+	t.Run("unknown passkey", func(t *testing.T) {
+		other, err := webauthn.NewMockAuthenticator("example.com", "https://example.com")
+		require.NoError(t, err)
+
+		registration, err := svc.BeginPasskeyRegistration(context.Background(), storedUser.ID)
+		require.NoError(t, err)
+
+		// Registered with the authenticator only
+		_, err = other.Register(registration.Options)
+		require.NoError(t, err)
+
+		login, err := svc.BeginPasskeyLogin(context.Background())
+		require.NoError(t, err)
+
+		response, err := other.Assert(login.Options)
+		require.NoError(t, err)
+
+		_, err = svc.GenerateTokenWithPasskey(context.Background(), login.State, response)
+		assert.Equal(t, errPasskeyNotFound, err)
+	})
+
+	t.Run("expired ceremony", func(t *testing.T) {
+		login, err := svc.BeginPasskeyLogin(context.Background())
+		require.NoError(t, err)
+
+		response, err := authenticator.Assert(login.Options)
+		require.NoError(t, err)
+
+		svc := *svc
+		svc.clock = func() time.Time { return now.Add(webauthn.CeremonyTimeout) }
+
+		_, err = svc.GenerateTokenWithPasskey(context.Background(), login.State, response)
+		assert.Equal(t, errPasskeyCeremonyInvalid, err)
+	})
+}
+
+func TestPasskey_unavailable(t *testing.T) {
+	t.Parallel()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+	_, err := svc.BeginPasskeyRegistration(context.Background(), uuid.New().String())
+	assert.Equal(t, errPasskeyUnavailable, err)
+
+	_, err = svc.BeginPasskeyLogin(context.Background())
+	assert.Equal(t, errPasskeyUnavailable, err)
+
+	_, err = svc.GenerateTokenWithPasskey(context.Background(), "state", []byte("{}"))
+	assert.Equal(t, errPasskeyUnavailable, err)
+}
@@ -0,0 +1,58 @@
+package users
+
+import (
+	"context"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+// WithPasswordHistory remembers the hashes of the last size passwords each user replaced, and rejects
+// a new password on change and reset when it matches the current password or one of the remembered ones.
+// The history is trimmed to the newest size hashes as passwords change.
+// Recording is best-effort: a failure is logged and doesn't fail the password change.
+func WithPasswordHistory(size int) ServiceOption {
+	return func(s *DefaultService) {
+		s.passwordHistorySize = size
+	}
+}
+
+// checkPasswordReuse returns errPasswordReused if the password history is enabled and the new password
+// matches the current password of the user or one of its remembered passwords
+func (s *DefaultService) checkPasswordReuse(ctx context.Context, storageUser *repository.User, newPassword string) error {
+	if s.passwordHistorySize <= 0 {
+		return nil
+	}
+
+	if storageUser.PasswordHash != "" && s.verifyPassword(storageUser.PasswordHash, newPassword) {
+		return errPasswordReused
+	}
+
+	hashes, err := s.repo.SelectPasswordHistory(ctx, storageUser.ID)
+	if err != nil {
+		return wrapErr(ctx, "could not select password history", err)
+	}
+
+	// The history holds more hashes until the next change when the size was lowered
+	if len(hashes) > s.passwordHistorySize {
+		hashes = hashes[:s.passwordHistorySize]
+	}
+
+	for _, hash := range hashes {
+		if s.verifyPassword(hash, newPassword) {
+			return errPasswordReused
+		}
+	}
+	return nil
+}
+
+// recordPasswordHistory records the replaced password hash of the user when the password history is enabled
+func (s *DefaultService) recordPasswordHistory(ctx context.Context, userID, replacedHash string) {
+	// Users without password (e.g. social logins) have nothing to remember
+	if s.passwordHistorySize <= 0 || replacedHash == "" {
+		return
+	}
+
+	if err := s.repo.InsertPasswordHistory(ctx, userID, replacedHash, s.now().UTC(), s.passwordHistorySize); err != nil {
+		s.logger.Error("could not record password history", userIDField(userID), errorField(err))
+	}
+}
@@ -0,0 +1,108 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWithPasswordHistory(t *testing.T) {
+	t.Parallel()
+
+	hash := func(password string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		require.NoError(t, err)
+		return string(h)
+	}
+
+	password := "current%&123"
+
+	// The history is newest first, with an extra hash beyond the size of 2
+	givenHistory := []string{hash("previous%&123"), hash("older%&123"), hash("oldest%&123")}
+
+	testCases := []struct {
+		name           string
+		givenPassword  string
+		expectedErr    error
+		expectedRecord bool
+	}{
+		{
+			name:           "new password",
+			givenPassword:  "brand-new%&123",
+			expectedRecord: true,
+		},
+		{
+			name:          "current password",
+			givenPassword: password,
+			expectedErr:   errPasswordReused,
+		},
+		{
+			name:          "remembered password",
+			givenPassword: "older%&123",
+			expectedErr:   errPasswordReused,
+		},
+		{
+			name:           "password beyond the history size",
+			givenPassword:  "oldest%&123",
+			expectedRecord: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			storedUser := repository.User{
+				ID:           uuid.New().String(),
+				Fullname:     "John Doe",
+				Username:     "jdoe",
+				Email:        "joedoe@mail.com",
+				PasswordHash: hash(password),
+				Role:         string(RoleUser),
+			}
+
+			var recorded []string
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+						user := storedUser
+						return &user, nil
+					},
+					updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+						return user, nil
+					},
+					selectPasswordHistoryFunc: func(ctx context.Context, userID string) ([]string, error) {
+						assert.Equal(t, storedUser.ID, userID)
+						return givenHistory, nil
+					},
+					insertPasswordHistoryFunc: func(ctx context.Context, userID, passwordHash string, createdAt time.Time, keep int) error {
+						assert.Equal(t, 2, keep)
+						recorded = append(recorded, passwordHash)
+						return nil
+					},
+				},
+				WithPasswordHistory(2),
+			)
+
+			err := svc.ChangePassword(context.Background(), storedUser.ID, password, tc.givenPassword)
+			assert.Equal(t, tc.expectedErr, err)
+
+			if !tc.expectedRecord {
+				assert.Empty(t, recorded)
+				return
+			}
+
+			// The replaced hash is remembered
+			assert.Equal(t, []string{storedUser.PasswordHash}, recorded)
+		})
+	}
+}
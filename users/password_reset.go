@@ -0,0 +1,159 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	// passwordResetTTL is the time a password reset code can be used for
+	passwordResetTTL time.Duration = time.Hour
+
+	// passwordResetSendTimeout bounds the background sending of a password reset code
+	passwordResetSendTimeout time.Duration = time.Minute
+)
+
+// RequestPasswordReset emails a single-use password reset code to the user with the given email,
+// to be used with ResetPassword within an hour. Only the digest of the code is stored.
+// To avoid disclosing which emails are registered, the code is sent in the background, so requests
+// for known and unknown emails take as long and succeed alike: unknown emails, requests exceeding
+// the password reset rate limit (see WithPasswordResetRateLimit) and failed sends are only logged.
+func (s *DefaultService) RequestPasswordReset(ctx context.Context, email string) error {
+	if s.emailer == nil {
+		return errEmailerNotConfigured
+	}
+
+	if err := s.validator().ValidateEmail(email); err != nil {
+		return fmt.Errorf("could not validate email: %w", err)
+	}
+
+	storageUser, err := s.repo.SelectByEmail(ctx, s.emailLookup(email))
+	if err != nil {
+		return wrapErr(ctx, "could not select user by email", err)
+	}
+
+	if storageUser == nil {
+		s.logger.Debug("password reset requested for unknown email", zap.String("operation", "request_password_reset"))
+		return nil
+	}
+
+	s.background.Add(1)
+
+	go func() {
+		defer s.background.Done()
+
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, passwordResetSendTimeout)
+		defer cancel()
+
+		if err := s.sendPasswordReset(ctx, storageUser.ID, email); err != nil {
+			s.logger.Error("could not send password reset", zap.String("operation", "request_password_reset"), userIDField(storageUser.ID), errorField(err))
+		}
+	}()
+	return nil
+}
+
+// sendPasswordReset stores and emails a new password reset code for the user, unless rate limited
+func (s *DefaultService) sendPasswordReset(ctx context.Context, userID, email string) error {
+	if err := s.allowPasswordReset(ctx, userID); err != nil {
+		if errors.Is(err, errResetRateLimited) {
+			s.logger.Info("password reset rate limited", zap.String("operation", "request_password_reset"), userIDField(userID))
+			return nil
+		}
+		return err
+	}
+
+	code := randString(32)
+	now := s.now().UTC()
+
+	in := repository.PasswordReset{
+		CodeHash:  tokenDigest(code),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s Password Reset\r\n\r\nUse the following code to reset your password within an hour: %s\r\nIf you did not request a password reset, you can ignore this email.\r\n",
+		s.emailVerificationSenderAddr, email, s.emailVerificationSenderName, code)
+
+	// Awaited before storing the code, so delayed emails don't leave codes behind
+	if err := s.awaitDomainSendRate(ctx, email); err != nil {
+		return wrapErr(ctx, "could not send password reset", err)
+	}
+
+	if err := s.repo.InsertPasswordReset(ctx, in); err != nil {
+		return wrapErr(ctx, "could not insert password reset", err)
+	}
+
+	if err := s.emailer.Send(s.emailVerificationSenderName, email, []byte(body)); err != nil {
+		return wrapErr(ctx, "could not send password reset", err)
+	}
+
+	s.logger.Info("password reset requested", zap.String("operation", "request_password_reset"), userIDField(userID))
+	return nil
+}
+
+// ResetPassword sets the password of the user the password reset code was sent to, consuming the code.
+// The code is consumed once the password is updated, so it can be retried after a failed update.
+// As with ChangePassword, the tokens issued before the reset are rejected on verification.
+func (s *DefaultService) ResetPassword(ctx context.Context, code, newPassword string) error {
+	if err := s.newPasswordValidator().ValidatePassword(newPassword); err != nil {
+		return fmt.Errorf("could not validate password: %w", newE(err.Error()))
+	}
+
+	codeHash := tokenDigest(code)
+
+	reset, err := s.repo.SelectPasswordReset(ctx, codeHash)
+	if err != nil {
+		return wrapErr(ctx, "could not select password reset", err)
+	}
+
+	if reset == nil {
+		return errResetCodeNotFound
+	}
+
+	if reset.UsedAt != nil {
+		return errResetCodeUsed
+	}
+
+	if !s.now().Before(reset.ExpiresAt) {
+		return errResetCodeExpired
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, reset.UserID)
+	if err != nil {
+		return wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return errNotFound
+	}
+
+	user, err := s.userFromRepository(storageUser)
+	if err != nil {
+		return fmt.Errorf("could not parse storage user to domain model: %s", err)
+	}
+
+	// Checked before consuming the code, so users can retry with another password
+	if err := s.checkNewPassword(ctx, user, newPassword); err != nil {
+		return err
+	}
+
+	if err := s.checkPasswordReuse(ctx, storageUser, newPassword); err != nil {
+		return err
+	}
+
+	// Concurrent resets with the same code are rejected by the versioned update
+	if err := s.updatePassword(ctx, storageUser, user, newPassword, "reset_password"); err != nil {
+		return err
+	}
+
+	if err := s.repo.MarkPasswordResetUsed(ctx, codeHash, s.now().UTC()); err != nil && !errors.Is(err, repository.ErrRecordNotFound) {
+		return wrapErr(ctx, "could not mark password reset used", err)
+	}
+	return nil
+}
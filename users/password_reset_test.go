@@ -0,0 +1,169 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestResetPassword(t *testing.T) {
+	t.Parallel()
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte("password%&123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	storedUser := repository.User{
+		ID:           uuid.New().String(),
+		Fullname:     "John Doe",
+		Username:     "jdoe",
+		Birthdate:    "2000-01-01",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	resets := make(map[string]repository.PasswordReset)
+
+	var (
+		sentBodies []string
+		sendErr    error
+		updateErr  error
+	)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				if email != storedUser.Email {
+					return nil, nil
+				}
+				user := storedUser
+				return &user, nil
+			},
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				user := storedUser
+				return &user, nil
+			},
+			updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				if updateErr != nil {
+					return nil, updateErr
+				}
+				storedUser = *user
+				return user, nil
+			},
+			insertPasswordResetFunc: func(ctx context.Context, in repository.PasswordReset) error {
+				resets[in.CodeHash] = in
+				return nil
+			},
+			selectPasswordResetFunc: func(ctx context.Context, codeHash string) (*repository.PasswordReset, error) {
+				reset, ok := resets[codeHash]
+				if !ok {
+					return nil, nil
+				}
+				return &reset, nil
+			},
+			markPasswordResetUsedFunc: func(ctx context.Context, codeHash string, usedAt time.Time) error {
+				reset := resets[codeHash]
+				reset.UsedAt = &usedAt
+				resets[codeHash] = reset
+				return nil
+			},
+		},
+		WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error {
+				if sendErr != nil {
+					return sendErr
+				}
+				sentBodies = append(sentBodies, string(body))
+				return nil
+			},
+		}),
+	)
+
+	codePattern := regexp.MustCompile(`within an hour: (\w+)`)
+
+	requestCode := func(t *testing.T) string {
+		require.NoError(t, svc.RequestPasswordReset(context.Background(), storedUser.Email))
+		svc.background.Wait()
+
+		match := codePattern.FindStringSubmatch(sentBodies[len(sentBodies)-1])
+		require.Len(t, match, 2)
+		return match[1]
+	}
+
+	t.Run("password is reset", func(t *testing.T) {
+		code := requestCode(t)
+
+		assert.NotContains(t, resets, code)
+
+		require.NoError(t, svc.ResetPassword(context.Background(), code, "new%&password123"))
+
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(storedUser.PasswordHash), []byte("new%&password123")))
+		assert.NotNil(t, storedUser.TokensValidAfter)
+
+		err := svc.ResetPassword(context.Background(), code, "other%&password123")
+		assert.Equal(t, errResetCodeUsed, err)
+	})
+
+	t.Run("unknown email", func(t *testing.T) {
+		sent := len(sentBodies)
+
+		require.NoError(t, svc.RequestPasswordReset(context.Background(), "unknown@mail.com"))
+		svc.background.Wait()
+
+		assert.Len(t, sentBodies, sent)
+	})
+
+	t.Run("failed send is not disclosed", func(t *testing.T) {
+		sendErr = errors.New("connection refused")
+		defer func() { sendErr = nil }()
+
+		require.NoError(t, svc.RequestPasswordReset(context.Background(), storedUser.Email))
+		svc.background.Wait()
+	})
+
+	t.Run("failed update keeps the code usable", func(t *testing.T) {
+		code := requestCode(t)
+
+		updateErr = errors.New("connection refused")
+		err := svc.ResetPassword(context.Background(), code, "new%&password789")
+		updateErr = nil
+		require.Error(t, err)
+
+		require.NoError(t, svc.ResetPassword(context.Background(), code, "new%&password789"))
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		err := svc.ResetPassword(context.Background(), "foo", "new%&password123")
+		assert.Equal(t, errResetCodeNotFound, err)
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		code := requestCode(t)
+
+		codeHash := tokenDigest(code)
+		reset := resets[codeHash]
+		reset.ExpiresAt = time.Now().Add(-time.Second)
+		resets[codeHash] = reset
+
+		err := svc.ResetPassword(context.Background(), code, "new%&password123")
+		assert.Equal(t, errResetCodeExpired, err)
+	})
+
+	t.Run("password containing the username keeps the code usable", func(t *testing.T) {
+		code := requestCode(t)
+
+		err := svc.ResetPassword(context.Background(), code, "jdoe%&password123")
+		assert.Equal(t, errPasswordContainsIdentity, err)
+
+		require.NoError(t, svc.ResetPassword(context.Background(), code, "new%&password456"))
+	})
+}
@@ -0,0 +1,273 @@
+package users
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ RateLimiter = (*StoreRateLimiter)(nil)
+	_ RateLimiter = (*memoryRateLimiter)(nil)
+)
+
+type (
+	// RateLimiter enforces fixed-window rate limits.
+	// Implementations backed by a shared store (database, Redis) enforce limits across service replicas.
+	RateLimiter interface {
+		// Allow records a hit for key and reports whether it is within limit hits per window
+		Allow(ctx context.Context, key string, limit int, window time.Duration) (*RateLimitResult, error)
+
+		// Reset clears the hits recorded for key
+		Reset(ctx context.Context, key string) error
+	}
+
+	// RateLimitResult describes the state of a rate limit after a hit
+	RateLimitResult struct {
+		Allowed   bool
+		Remaining int
+		ResetAt   time.Time
+	}
+
+	rateLimitStore interface {
+		IncrementRateLimit(ctx context.Context, key string, windowStart time.Time) (int, error)
+		DeleteRateLimit(ctx context.Context, key string) error
+	}
+
+	rateLimit struct {
+		limit int
+		per   time.Duration
+	}
+)
+
+// WithRateLimiter sets the rate limiter used to enforce the configured rate limits.
+// When rate limits are configured without a rate limiter, an in-memory one is used,
+// which only enforces limits per service instance.
+func WithRateLimiter(limiter RateLimiter) ServiceOption {
+	return func(s *DefaultService) {
+		s.rateLimiter = limiter
+	}
+}
+
+// WithLoginRateLimit limits the login attempts per user to limit attempts per window,
+// whether they log in by email or by username. A successful login clears the attempts.
+func WithLoginRateLimit(limit int, per time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.loginRateLimit = rateLimit{limit: limit, per: per}
+	}
+}
+
+// WithTokenIssuanceRate limits the tokens issued per user by GenerateToken to limit tokens per window.
+// Unlike the login rate limit, it applies to valid credentials, protecting against token request floods.
+func WithTokenIssuanceRate(limit int, per time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.tokenIssuanceRate = rateLimit{limit: limit, per: per}
+	}
+}
+
+// WithVerificationRateLimit limits the verification emails sent per user to limit emails per window
+func WithVerificationRateLimit(limit int, per time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.verificationRateLimit = rateLimit{limit: limit, per: per}
+	}
+}
+
+// WithPasswordResetRateLimit sets the minimum interval between two password reset requests of a user.
+// The time of the last request is stored with the user, so the limit holds across service replicas.
+func WithPasswordResetRateLimit(d time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.passwordResetInterval = d
+	}
+}
+
+// WithPerDomainSendRate paces the emails sent to each recipient domain to rate emails per window,
+// which keeps the sending within the throttling limits of email providers. Emails exceeding the rate
+// are delayed until a later window, and fail with the context error if their context is done before.
+// Codes and links are only stored once their email can be sent, so delayed emails leave none behind.
+func WithPerDomainSendRate(rate int, per time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.perDomainSendRate = rateLimit{limit: rate, per: per}
+	}
+}
+
+// allow records a hit for key against the given limit.
+// It returns a nil result when the limit is not configured.
+func (s *DefaultService) allow(ctx context.Context, key string, limit rateLimit) (*RateLimitResult, error) {
+	if s.rateLimiter == nil || limit.limit <= 0 {
+		return nil, nil
+	}
+
+	res, err := s.rateLimiter.Allow(ctx, key, limit.limit, limit.per)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not check rate limit", err)
+	}
+	return res, nil
+}
+
+// awaitDomainSendRate records an email sent to the recipient domain, waiting for the next window
+// as long as the per domain send rate is exceeded. It returns the context error once ctx is done.
+func (s *DefaultService) awaitDomainSendRate(ctx context.Context, to string) error {
+	key := "send-domain:" + strings.ToLower(to[strings.LastIndex(to, "@")+1:])
+
+	for {
+		res, err := s.allow(ctx, key, s.perDomainSendRate)
+		if err != nil {
+			return err
+		}
+
+		if res == nil || res.Allowed {
+			return nil
+		}
+
+		timer := time.NewTimer(time.Until(res.ResetAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// allowTokenIssuance records a token issued to the user and returns errTokenIssuanceThrottled
+// when the token issuance rate is exceeded
+func (s *DefaultService) allowTokenIssuance(ctx context.Context, userID string) error {
+	res, err := s.allow(ctx, "token:"+userID, s.tokenIssuanceRate)
+	if err != nil {
+		return err
+	}
+
+	if res != nil && !res.Allowed {
+		return errTokenIssuanceThrottled
+	}
+	return nil
+}
+
+// allowPasswordReset records a password reset request of the user and returns errResetRateLimited
+// when the previous request is more recent than the password reset interval.
+// Callers answering unauthenticated requests should not disclose the error, as it reveals the account exists.
+func (s *DefaultService) allowPasswordReset(ctx context.Context, userID string) error {
+	if s.passwordResetInterval <= 0 {
+		return nil
+	}
+
+	now := s.now()
+
+	recorded, err := s.repo.MarkPasswordResetRequested(ctx, userID, now, now.Add(-s.passwordResetInterval))
+	if err != nil {
+		return wrapErr(ctx, "could not mark password reset requested", err)
+	}
+
+	if !recorded {
+		return errResetRateLimited
+	}
+	return nil
+}
+
+// StoreRateLimiter is a rate limiter that keeps its counters in a storage shared by all service replicas
+type StoreRateLimiter struct {
+	store rateLimitStore
+}
+
+// NewStoreRateLimiter instantiates a rate limiter backed by the given store.
+// The repository Postgres implementation can be used as store.
+func NewStoreRateLimiter(store rateLimitStore) *StoreRateLimiter {
+	return &StoreRateLimiter{store: store}
+}
+
+// Allow atomically increments the hits for key in the current window
+func (l *StoreRateLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (*RateLimitResult, error) {
+	windowStart := time.Now().UTC().Truncate(window)
+
+	hits, err := l.store.IncrementRateLimit(ctx, key, windowStart)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not increment rate limit", err)
+	}
+	return newRateLimitResult(hits, limit, windowStart.Add(window)), nil
+}
+
+// Reset clears the hits recorded for key
+func (l *StoreRateLimiter) Reset(ctx context.Context, key string) error {
+	if err := l.store.DeleteRateLimit(ctx, key); err != nil {
+		return wrapErr(ctx, "could not delete rate limit", err)
+	}
+	return nil
+}
+
+// memoryRateLimiterSweepInterval is the minimum interval between two evictions of the expired windows
+const memoryRateLimiterSweepInterval = time.Minute
+
+type memoryWindow struct {
+	start time.Time
+	end   time.Time
+	hits  int
+}
+
+// memoryRateLimiter is a rate limiter that keeps its counters in memory.
+// Expired windows are evicted periodically, so keys chosen by clients don't grow it without bound.
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*memoryWindow
+	swept   time.Time
+	now     func() time.Time
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{
+		windows: make(map[string]*memoryWindow),
+		now:     time.Now,
+	}
+}
+
+func (l *memoryRateLimiter) Allow(_ context.Context, key string, limit int, window time.Duration) (*RateLimitResult, error) {
+	now := l.now().UTC()
+	windowStart := now.Truncate(window)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) >= memoryRateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	w, ok := l.windows[key]
+	if !ok || !w.start.Equal(windowStart) {
+		w = &memoryWindow{start: windowStart, end: windowStart.Add(window)}
+		l.windows[key] = w
+	}
+	w.hits++
+
+	return newRateLimitResult(w.hits, limit, w.end), nil
+}
+
+// sweep evicts the windows ended at now. It must be called with the lock held.
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	for key, w := range l.windows {
+		if !now.Before(w.end) {
+			delete(l.windows, key)
+		}
+	}
+	l.swept = now
+}
+
+func (l *memoryRateLimiter) Reset(_ context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.windows, key)
+	return nil
+}
+
+func newRateLimitResult(hits, limit int, resetAt time.Time) *RateLimitResult {
+	remaining := limit - hits
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &RateLimitResult{
+		Allowed:   hits <= limit,
+		Remaining: remaining,
+		ResetAt:   resetAt,
+	}
+}
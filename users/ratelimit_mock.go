@@ -0,0 +1,28 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var _ rateLimitStore = (*rateLimitStoreMock)(nil)
+
+type rateLimitStoreMock struct {
+	incrementRateLimitFunc func(ctx context.Context, key string, windowStart time.Time) (int, error)
+	deleteRateLimitFunc    func(ctx context.Context, key string) error
+}
+
+func (m *rateLimitStoreMock) IncrementRateLimit(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	if m.incrementRateLimitFunc == nil {
+		return 0, errors.New("rateLimitStoreMock.incrementRateLimitFunc is nil")
+	}
+	return m.incrementRateLimitFunc(ctx, key, windowStart)
+}
+
+func (m *rateLimitStoreMock) DeleteRateLimit(ctx context.Context, key string) error {
+	if m.deleteRateLimitFunc == nil {
+		return errors.New("rateLimitStoreMock.deleteRateLimitFunc is nil")
+	}
+	return m.deleteRateLimitFunc(ctx, key)
+}
@@ -0,0 +1,366 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := newMemoryRateLimiter()
+
+	for i := 1; i <= 3; i++ {
+		res, err := limiter.Allow(context.Background(), "key", 2, time.Hour)
+		require.NoError(t, err)
+
+		assert.Equal(t, i <= 2, res.Allowed)
+	}
+
+	otherRes, err := limiter.Allow(context.Background(), "other-key", 2, time.Hour)
+	require.NoError(t, err)
+
+	assert.True(t, otherRes.Allowed)
+	assert.Equal(t, 1, otherRes.Remaining)
+
+	require.NoError(t, limiter.Reset(context.Background(), "key"))
+
+	res, err := limiter.Allow(context.Background(), "key", 2, time.Hour)
+	require.NoError(t, err)
+
+	assert.True(t, res.Allowed)
+
+	t.Run("expired windows are evicted", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		limiter := newMemoryRateLimiter()
+		limiter.now = func() time.Time { return now }
+
+		for _, key := range []string{"foo", "bar", "baz"} {
+			_, err := limiter.Allow(context.Background(), key, 2, time.Minute)
+			require.NoError(t, err)
+		}
+
+		_, err := limiter.Allow(context.Background(), "qux", 2, time.Hour)
+		require.NoError(t, err)
+
+		assert.Len(t, limiter.windows, 4)
+
+		now = now.Add(memoryRateLimiterSweepInterval)
+
+		_, err = limiter.Allow(context.Background(), "quux", 2, time.Minute)
+		require.NoError(t, err)
+
+		assert.Len(t, limiter.windows, 2)
+		assert.Contains(t, limiter.windows, "qux")
+	})
+}
+
+func TestStoreRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		givenStoreMock *rateLimitStoreMock
+		expectedResult *RateLimitResult
+		expectedError  bool
+	}{
+		{
+			name: "within limit",
+			givenStoreMock: &rateLimitStoreMock{
+				incrementRateLimitFunc: func(ctx context.Context, key string, windowStart time.Time) (int, error) {
+					return 1, nil
+				},
+			},
+			expectedResult: &RateLimitResult{Allowed: true, Remaining: 1},
+		},
+		{
+			name: "limit exceeded",
+			givenStoreMock: &rateLimitStoreMock{
+				incrementRateLimitFunc: func(ctx context.Context, key string, windowStart time.Time) (int, error) {
+					return 3, nil
+				},
+			},
+			expectedResult: &RateLimitResult{Allowed: false, Remaining: 0},
+		},
+		{
+			name: "store error",
+			givenStoreMock: &rateLimitStoreMock{
+				incrementRateLimitFunc: func(ctx context.Context, key string, windowStart time.Time) (int, error) {
+					return 0, errors.New("some error")
+				},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := NewStoreRateLimiter(tc.givenStoreMock)
+
+			res, err := limiter.Allow(context.Background(), "key", 2, time.Minute)
+			require.Equal(t, tc.expectedError, err != nil)
+
+			if tc.expectedResult != nil {
+				assert.Equal(t, tc.expectedResult.Allowed, res.Allowed)
+				assert.Equal(t, tc.expectedResult.Remaining, res.Remaining)
+				assert.False(t, res.ResetAt.IsZero())
+			}
+		})
+	}
+}
+
+func TestGenerateToken_loginRateLimit(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	users := map[string]*repository.User{
+		"joedoe@mail.com": {ID: uuid.New().String(), Username: "jdoe", Role: string(RoleUser), Email: "joedoe@mail.com", PasswordHash: string(givenHash)},
+		"other@mail.com":  {ID: uuid.New().String(), Username: "other", Role: string(RoleUser), Email: "other@mail.com", PasswordHash: string(givenHash)},
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				return users[email], nil
+			},
+			selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+				for _, u := range users {
+					if u.Username == username {
+						return u, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		WithLoginRateLimit(2, time.Hour),
+	)
+
+	// Attempts by email in another case and by username share the limit of the user
+	attempts := []func() (*repository.User, error){
+		func() (*repository.User, error) {
+			return svc.authenticate(context.Background(), "JOEDOE@mail.com", "wrong%&password123")
+		},
+		func() (*repository.User, error) {
+			return svc.authenticateUsername(context.Background(), "jdoe", "wrong%&password123")
+		},
+	}
+
+	for i, attempt := range attempts {
+		_, err := attempt()
+		require.ErrorIs(t, err, errPasswordInvalid)
+
+		var attemptsErr *LoginAttemptsError
+		require.True(t, errors.As(err, &attemptsErr))
+
+		assert.Equal(t, 1-i, attemptsErr.AttemptsRemaining)
+		assert.Equal(t, i == 1, !attemptsErr.LockedUntil.IsZero())
+	}
+
+	_, err = svc.GenerateToken(context.Background(), "joedoe@mail.com", password)
+	require.Equal(t, errRateLimited, err)
+
+	_, err = svc.authenticateUsername(context.Background(), "jdoe", password)
+	require.Equal(t, errRateLimited, err)
+
+	t.Run("successful login clears the attempts", func(t *testing.T) {
+		_, err := svc.GenerateToken(context.Background(), "other@mail.com", password)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err := svc.GenerateToken(context.Background(), "other@mail.com", password)
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestGenerateToken_tokenIssuanceRate(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	userIDs := map[string]string{
+		"joedoe@mail.com": uuid.New().String(),
+		"other@mail.com":  uuid.New().String(),
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				return &repository.User{
+					ID:           userIDs[email],
+					Role:         string(RoleUser),
+					Email:        email,
+					PasswordHash: string(givenHash),
+				}, nil
+			},
+		},
+		WithTokenIssuanceRate(2, time.Hour),
+	)
+
+	for i := 0; i < 2; i++ {
+		_, err := svc.GenerateToken(context.Background(), "joedoe@mail.com", password)
+		require.NoError(t, err)
+	}
+
+	_, err = svc.GenerateToken(context.Background(), "joedoe@mail.com", password)
+	require.Equal(t, errTokenIssuanceThrottled, err)
+
+	t.Run("identifier logins are throttled", func(t *testing.T) {
+		_, err := svc.Authenticate(context.Background(), "joedoe@mail.com", password)
+		require.Equal(t, errTokenIssuanceThrottled, err)
+	})
+
+	t.Run("other users are not throttled", func(t *testing.T) {
+		_, err := svc.GenerateToken(context.Background(), "other@mail.com", password)
+		require.NoError(t, err)
+	})
+}
+
+func TestSendEmailVerification_perDomainSendRate(t *testing.T) {
+	t.Parallel()
+
+	newService := func(per time.Duration) (*DefaultService, *[]string, *int) {
+		var (
+			sent     []string
+			inserted int
+		)
+
+		svc := New(zap.NewNop(), "jwt-secret",
+			&repositoryMock{
+				insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+					inserted++
+					return nil
+				},
+			},
+			WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+				sendFunc: func(from, to string, body []byte) error {
+					sent = append(sent, to)
+					return nil
+				},
+			}),
+			WithPerDomainSendRate(1, per),
+		)
+		return svc, &sent, &inserted
+	}
+
+	t.Run("same domain is delayed until the context is done", func(t *testing.T) {
+		t.Parallel()
+
+		svc, sent, inserted := newService(time.Hour)
+
+		err := svc.SendEmailVerification(context.Background(), uuid.New().String(), "jdoe", "joedoe@mail.com")
+		require.NoError(t, err)
+
+		err = svc.SendEmailVerification(context.Background(), uuid.New().String(), "jdoe", "joedoe@other.com")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err = svc.SendEmailVerification(ctx, uuid.New().String(), "jdoe", "janedoe@MAIL.com")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		assert.Equal(t, []string{"joedoe@mail.com", "joedoe@other.com"}, *sent)
+
+		// No code is stored for the delayed email
+		assert.Equal(t, 2, *inserted)
+	})
+
+	t.Run("same domain is sent in a later window", func(t *testing.T) {
+		t.Parallel()
+
+		svc, sent, _ := newService(20 * time.Millisecond)
+
+		for _, to := range []string{"joedoe@mail.com", "janedoe@mail.com"} {
+			err := svc.SendEmailVerification(context.Background(), uuid.New().String(), "jdoe", to)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, []string{"joedoe@mail.com", "janedoe@mail.com"}, *sent)
+	})
+}
+
+func TestSendEmailVerification_rateLimit(t *testing.T) {
+	t.Parallel()
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+				return nil
+			},
+		},
+		WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+			sendFunc: func(from, to string, body []byte) error {
+				return nil
+			},
+		}),
+		WithVerificationRateLimit(1, time.Hour),
+	)
+
+	givenUserID := uuid.New().String()
+
+	err := svc.SendEmailVerification(context.Background(), givenUserID, "jdoe", "joedoe@mail.com")
+	require.NoError(t, err)
+
+	err = svc.SendEmailVerification(context.Background(), givenUserID, "jdoe", "joedoe@mail.com")
+	assert.Equal(t, errRateLimited, err)
+}
+
+func TestAllowPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	lastRequests := make(map[string]time.Time)
+
+	repo := &repositoryMock{
+		markPasswordResetRequestedFunc: func(ctx context.Context, id string, requestedAt, notBefore time.Time) (bool, error) {
+			if last, ok := lastRequests[id]; ok && last.After(notBefore) {
+				return false, nil
+			}
+			lastRequests[id] = requestedAt
+			return true, nil
+		},
+	}
+
+	now := time.Now()
+
+	svc := New(zap.NewNop(), "jwt-secret", repo, WithPasswordResetRateLimit(time.Hour))
+	svc.clock = func() time.Time { return now }
+
+	givenUserID := uuid.New().String()
+
+	require.NoError(t, svc.allowPasswordReset(context.Background(), givenUserID))
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, errResetRateLimited, svc.allowPasswordReset(context.Background(), givenUserID))
+
+	// Other users are not limited
+	assert.NoError(t, svc.allowPasswordReset(context.Background(), uuid.New().String()))
+
+	now = now.Add(time.Hour)
+	assert.NoError(t, svc.allowPasswordReset(context.Background(), givenUserID))
+
+	t.Run("limit not configured", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+		assert.NoError(t, svc.allowPasswordReset(context.Background(), givenUserID))
+	})
+}
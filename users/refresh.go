@@ -0,0 +1,228 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"go.uber.org/zap"
+)
+
+// defaultRefreshTokenTTL is the lifetime of the refresh tokens unless set with WithRefreshTokenTTL
+const defaultRefreshTokenTTL time.Duration = time.Hour * 24 * 30
+
+// WithRefreshTokenTTL sets the lifetime of the refresh tokens issued by GenerateTokenPair
+func WithRefreshTokenTTL(ttl time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.refreshTokenTTL = ttl
+	}
+}
+
+// GenerateTokenPair generates a JWT access token along with a refresh token for the user,
+// so clients can renew the access token with RefreshAccessToken without prompting for the password again.
+// Only the digest of the refresh token is stored, so it can be revoked (see RevokeRefreshToken).
+func (s *DefaultService) GenerateTokenPair(ctx context.Context, email, password string) (*TokenPair, error) {
+	issued, storageUser, err := s.login(ctx, email, password, s.tokenTTLOrDefault(), "", mfaChallengePair)
+	if err != nil {
+		return nil, err
+	}
+
+	// The refresh token is issued by VerifyTOTP once the second factor is verified
+	if issued.challenged {
+		return &TokenPair{
+			AccessToken: issued.token,
+			ExpiresIn:   int64(issued.ttl / time.Second),
+			MFARequired: true,
+		}, nil
+	}
+
+	// Each login starts a new family of refresh tokens, bound to the session of the login
+	refreshToken, err := s.issueRefreshToken(ctx, storageUser.ID, NewUUIDv4(), issued.sessionID)
+	if err != nil {
+		s.logger.Error("could not issue refresh token", zap.String("operation", "generate_token_pair"), userIDField(storageUser.ID), errorField(err))
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  issued.token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(issued.ttl / time.Second),
+	}, nil
+}
+
+// RefreshAccessToken exchanges a refresh token for a new JWT access token and a new refresh token
+// of the same family. Each refresh token can only be used once: presenting a used refresh token again,
+// as happens when a stolen token is used, revokes the whole family and fails with errRefreshTokenReused.
+// Unlike RefreshToken, it doesn't require an unexpired access token. Refresh tokens issued before
+// the tokens of the user were revoked (e.g. by a password change) are rejected with errTokenSuperseded.
+// The new access token belongs to the session of the login, which is extended; refresh tokens of
+// revoked sessions are rejected with errSessionRevoked.
+func (s *DefaultService) RefreshAccessToken(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, errRefreshTokenInvalid
+	}
+
+	storageToken, err := s.repo.SelectRefreshToken(ctx, tokenDigest(refreshToken))
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select refresh token", err)
+	}
+
+	if storageToken == nil {
+		return nil, errRefreshTokenInvalid
+	}
+
+	if storageToken.ConsumedAt != nil {
+		return nil, s.refreshTokenReused(ctx, storageToken)
+	}
+
+	if storageToken.RevokedAt != nil {
+		return nil, errRefreshTokenRevoked
+	}
+
+	if !s.now().Before(storageToken.ExpiresAt) {
+		return nil, errRefreshTokenExpired
+	}
+
+	storageUser, err := s.selectUserByID(ctx, storageToken.UserID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return nil, errNotFound
+	}
+
+	if tokenSuperseded(storageToken.CreatedAt.Unix(), storageUser.TokensValidAfter) {
+		return nil, errTokenSuperseded
+	}
+
+	if err := s.checkSession(ctx, storageToken.SessionID); err != nil {
+		return nil, err
+	}
+
+	// Consuming is conditional, so only one of concurrent uses of the same token succeeds
+	if err := s.repo.ConsumeRefreshToken(ctx, storageToken.TokenHash, s.now().UTC()); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return nil, s.refreshTokenReused(ctx, storageToken)
+		}
+		return nil, wrapErr(ctx, "could not consume refresh token", err)
+	}
+
+	ttl := s.clampTokenTTL(s.tokenTTLOrDefault())
+
+	claims, err := s.newJWTClaims(storageUser.ID, role(storageUser.Role), ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.extendSession(ctx, storageToken.SessionID, ttl); err != nil {
+		return nil, err
+	}
+	claims.SessionID = storageToken.SessionID
+
+	if err := s.enrichClaims(ctx, claims, storageUser); err != nil {
+		s.logger.Error("could not enrich claims", zap.String("operation", "refresh_access_token"), userIDField(storageUser.ID), errorField(err))
+		return nil, err
+	}
+
+	accessToken, err := s.signJWT(ctx, *claims)
+	if err != nil {
+		s.logger.Error("could not generate jwt", zap.String("operation", "refresh_access_token"), userIDField(storageUser.ID), errorField(err))
+		return nil, wrapErr(ctx, "could not generate jwt", err)
+	}
+
+	nextRefreshToken, err := s.issueRefreshToken(ctx, storageUser.ID, storageToken.FamilyID, storageToken.SessionID)
+	if err != nil {
+		s.logger.Error("could not issue refresh token", zap.String("operation", "refresh_access_token"), userIDField(storageUser.ID), errorField(err))
+		return nil, err
+	}
+
+	s.logger.Debug("access token refreshed", zap.String("operation", "refresh_access_token"), userIDField(storageUser.ID))
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: nextRefreshToken,
+		ExpiresIn:    int64(ttl / time.Second),
+	}, nil
+}
+
+// refreshTokenReused revokes the family of a refresh token presented after it was used
+// and returns errRefreshTokenReused. Either the legitimate client or an attacker holds
+// a stolen token, so neither is trusted until the user logs in again.
+func (s *DefaultService) refreshTokenReused(ctx context.Context, storageToken *repository.RefreshToken) error {
+	s.logger.Warn("refresh token reuse detected, revoking token family",
+		zap.String("operation", "refresh_access_token"), userIDField(storageToken.UserID), zap.String("family_id", storageToken.FamilyID))
+
+	if err := s.repo.RevokeRefreshTokenFamily(ctx, storageToken.FamilyID, s.now().UTC()); err != nil {
+		s.logger.Error("could not revoke refresh token family", zap.String("operation", "refresh_access_token"), userIDField(storageToken.UserID), errorField(err))
+		return wrapErr(ctx, "could not revoke refresh token family", err)
+	}
+	return errRefreshTokenReused
+}
+
+// issueRefreshToken stores the digest of a new refresh token of the family and session, valid for the refresh token TTL,
+// and returns the refresh token
+func (s *DefaultService) issueRefreshToken(ctx context.Context, userID, familyID, sessionID string) (string, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	refreshTTL := s.refreshTokenTTL
+	if refreshTTL <= 0 {
+		refreshTTL = defaultRefreshTokenTTL
+	}
+
+	now := s.now().UTC()
+
+	in := repository.RefreshToken{
+		TokenHash: tokenDigest(refreshToken),
+		UserID:    userID,
+		FamilyID:  familyID,
+		SessionID: sessionID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTTL),
+	}
+
+	if err := s.repo.InsertRefreshToken(ctx, in); err != nil {
+		return "", wrapErr(ctx, "could not insert refresh token", err)
+	}
+	return refreshToken, nil
+}
+
+// RevokeRefreshToken revokes a refresh token, e.g. on logout.
+// All the refresh tokens of a user are revoked with RevokeUserTokens.
+func (s *DefaultService) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return errRefreshTokenInvalid
+	}
+
+	if err := s.repo.RevokeRefreshToken(ctx, tokenDigest(refreshToken), s.now().UTC()); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errRefreshTokenInvalid
+		}
+		return wrapErr(ctx, "could not revoke refresh token", err)
+	}
+	return nil
+}
+
+// newRefreshToken returns a random refresh token
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate refresh token: %s", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tokenDigest returns the hex encoded SHA-256 digest secret tokens and codes are stored as
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
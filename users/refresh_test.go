@@ -0,0 +1,178 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRefreshAccessToken(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	storedUser := repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	refreshTokens := make(map[string]repository.RefreshToken)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				user := storedUser
+				return &user, nil
+			},
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				user := storedUser
+				return &user, nil
+			},
+			updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				storedUser = *user
+				return user, nil
+			},
+			insertRefreshTokenFunc: func(ctx context.Context, in repository.RefreshToken) error {
+				refreshTokens[in.TokenHash] = in
+				return nil
+			},
+			selectRefreshTokenFunc: func(ctx context.Context, tokenHash string) (*repository.RefreshToken, error) {
+				token, ok := refreshTokens[tokenHash]
+				if !ok {
+					return nil, nil
+				}
+				return &token, nil
+			},
+			revokeRefreshTokenFunc: func(ctx context.Context, tokenHash string, revokedAt time.Time) error {
+				token, ok := refreshTokens[tokenHash]
+				if !ok || token.RevokedAt != nil {
+					return repository.ErrRecordNotFound
+				}
+				token.RevokedAt = &revokedAt
+				refreshTokens[tokenHash] = token
+				return nil
+			},
+			consumeRefreshTokenFunc: func(ctx context.Context, tokenHash string, consumedAt time.Time) error {
+				token, ok := refreshTokens[tokenHash]
+				if !ok || token.ConsumedAt != nil || token.RevokedAt != nil {
+					return repository.ErrRecordNotFound
+				}
+				token.ConsumedAt = &consumedAt
+				refreshTokens[tokenHash] = token
+				return nil
+			},
+			revokeRefreshTokenFamilyFunc: func(ctx context.Context, familyID string, revokedAt time.Time) error {
+				for tokenHash, token := range refreshTokens {
+					if token.FamilyID == familyID && token.RevokedAt == nil {
+						token.RevokedAt = &revokedAt
+						refreshTokens[tokenHash] = token
+					}
+				}
+				return nil
+			},
+		},
+		WithRefreshTokenTTL(time.Hour),
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	newPair := func(t *testing.T) *TokenPair {
+		pair, err := svc.GenerateTokenPair(context.Background(), storedUser.Email, password)
+		require.NoError(t, err)
+		return pair
+	}
+
+	t.Run("access token is refreshed", func(t *testing.T) {
+		pair := newPair(t)
+
+		assert.NotEmpty(t, pair.AccessToken)
+		assert.NotContains(t, refreshTokens, pair.RefreshToken)
+
+		refreshed, err := svc.RefreshAccessToken(context.Background(), pair.RefreshToken)
+		require.NoError(t, err)
+
+		resp, err := svc.VerifyToken(context.Background(), refreshed.AccessToken)
+		require.NoError(t, err)
+
+		assert.Equal(t, storedUser.ID, resp.ID)
+
+		// The refresh token is rotated within its family
+		assert.NotEqual(t, pair.RefreshToken, refreshed.RefreshToken)
+		assert.Equal(t, refreshTokens[tokenDigest(pair.RefreshToken)].FamilyID, refreshTokens[tokenDigest(refreshed.RefreshToken)].FamilyID)
+
+		_, err = svc.RefreshAccessToken(context.Background(), refreshed.RefreshToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("reused refresh token revokes the family", func(t *testing.T) {
+		pair := newPair(t)
+		other := newPair(t)
+
+		refreshed, err := svc.RefreshAccessToken(context.Background(), pair.RefreshToken)
+		require.NoError(t, err)
+
+		_, err = svc.RefreshAccessToken(context.Background(), pair.RefreshToken)
+		assert.Equal(t, errRefreshTokenReused, err)
+
+		_, err = svc.RefreshAccessToken(context.Background(), refreshed.RefreshToken)
+		assert.Equal(t, errRefreshTokenRevoked, err)
+
+		// Other families are left untouched
+		_, err = svc.RefreshAccessToken(context.Background(), other.RefreshToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("unknown refresh token", func(t *testing.T) {
+		_, err := svc.RefreshAccessToken(context.Background(), "foo")
+		assert.Equal(t, errRefreshTokenInvalid, err)
+	})
+
+	t.Run("revoked refresh token", func(t *testing.T) {
+		pair := newPair(t)
+
+		require.NoError(t, svc.RevokeRefreshToken(context.Background(), pair.RefreshToken))
+
+		_, err := svc.RefreshAccessToken(context.Background(), pair.RefreshToken)
+		assert.Equal(t, errRefreshTokenRevoked, err)
+
+		err = svc.RevokeRefreshToken(context.Background(), pair.RefreshToken)
+		assert.Equal(t, errRefreshTokenInvalid, err)
+	})
+
+	t.Run("expired refresh token", func(t *testing.T) {
+		pair := newPair(t)
+
+		tokenHash := tokenDigest(pair.RefreshToken)
+		token := refreshTokens[tokenHash]
+		token.ExpiresAt = now
+		refreshTokens[tokenHash] = token
+
+		_, err := svc.RefreshAccessToken(context.Background(), pair.RefreshToken)
+		assert.Equal(t, errRefreshTokenExpired, err)
+	})
+
+	t.Run("user tokens revoked", func(t *testing.T) {
+		pair := newPair(t)
+
+		now = now.Add(time.Second)
+		require.NoError(t, svc.RevokeUserTokens(context.Background(), storedUser.ID))
+
+		_, err := svc.RefreshAccessToken(context.Background(), pair.RefreshToken)
+		assert.Equal(t, errTokenSuperseded, err)
+	})
+}
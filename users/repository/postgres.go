@@ -3,33 +3,242 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/pgtype"
 	"github.com/jmoiron/sqlx"
 )
 
+// anonymizedUserTables are the tables whose rows of a user are deleted when the user is anonymized,
+// holding its credentials (e.g. passkeys, OAuth identities) or personal data (e.g. login IPs)
+var anonymizedUserTables = []string{
+	"login_events",
+	"password_history",
+	"sessions",
+	"refresh_tokens",
+	"passkeys",
+	"identities",
+	"user_totp",
+	"email_verifications",
+	"password_resets",
+	"api_keys",
+}
+
 const (
 	// Enumerate postgresql query strings
 
-	insertQuery string = `INSERT INTO users (id,fullname,username,birthdate,email,email_verified,password_hash,
-	role,created_at,updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING 
-	id,fullname,username,birthdate,email,email_verified,password_hash,role,created_at,updated_at;`
+	userColumns string = `id,fullname,username,username_normalized,birthdate,email,email_ciphertext,
+	email_verified,password_hash,role,created_at,updated_at,deleted_at,deletion_reason,deleted_by,tokens_valid_after,version,
+	password_auth_disabled,password_changed_at`
+
+	insertQuery string = `INSERT INTO users (id,fullname,username,username_normalized,birthdate,email,
+	email_ciphertext,email_verified,password_hash,role,created_at,updated_at,tokens_valid_after,password_auth_disabled,
+	password_changed_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15) RETURNING ` + userColumns + `;`
+
+	updateQuery string = `UPDATE users SET fullname = $2, username = $3, username_normalized = $4, birthdate = $5, 
+	email = $6, email_ciphertext = $7, email_verified = $8, password_hash = $9, role = $10, updated_at = $11, 
+	tokens_valid_after = $12, password_auth_disabled = $14, 
+	password_changed_at = $15, version = version + 1 WHERE id = $1 AND version = $13 AND deleted_at IS NULL RETURNING ` + userColumns + `;`
+
+	updatePasswordHashQuery string = "UPDATE users SET password_hash = $3 WHERE id = $1 AND password_hash = $2;"
+
+	selectVersionByIDQuery string = "SELECT version FROM users WHERE id = $1 AND deleted_at IS NULL;"
+
+	selectByIDWithDeletedQuery string = "SELECT " + userColumns + " FROM users WHERE id = $1;"
+
+	selectByIDQuery string = "SELECT " + userColumns + " FROM users WHERE id = $1 AND deleted_at IS NULL;"
 
-	selectByIDQuery string = `SELECT id,fullname,username,birthdate,email,email_verified,
-	password_hash,role,created_at,updated_at FROM users WHERE id = $1 AND deleted_at IS NULL;`
+	selectRoleByIDQuery string = "SELECT role FROM users WHERE id = $1 AND deleted_at IS NULL;"
 
-	selectByEmailQuery string = `SELECT id,fullname,username,birthdate,email,email_verified,
-	password_hash,role,created_at,updated_at FROM users WHERE email = $1 AND deleted_at IS NULL;`
+	selectByEmailQuery string = "SELECT " + userColumns + " FROM users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL;"
 
-	deleteByIDQuery string = "UPDATE users SET deleted_at = NOW() WHERE id = $1;"
+	selectByEmailWithDeletedQuery string = "SELECT " + userColumns + " FROM users WHERE LOWER(email) = LOWER($1);"
+
+	selectByPasswordChangedBeforeQuery string = "SELECT " + userColumns + ` FROM users 
+	WHERE password_changed_at < $1 AND password_hash <> '' AND deleted_at IS NULL 
+	AND ($2::timestamp IS NULL OR (password_changed_at, id) > ($2, $3::uuid)) ORDER BY password_changed_at, id LIMIT $4;`
+
+	selectExistingEmailsQuery string = "SELECT DISTINCT LOWER(email) FROM users WHERE LOWER(email) = ANY($1);"
+
+	selectByUsernameQuery string = "SELECT " + userColumns + " FROM users WHERE username_normalized = $1 AND deleted_at IS NULL;"
+
+	deleteByIDQuery string = `UPDATE users SET deleted_at = NOW(), deletion_reason = $2, deleted_by = $3, 
+	tokens_valid_after = NOW(), version = version + 1 WHERE id = $1;`
+
+	restoreByIDQuery string = `UPDATE users SET deleted_at = NULL, deletion_reason = '', deleted_by = '', 
+	version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL;`
+
+	anonymizeByIDQuery string = `UPDATE users SET fullname = $2, username = $2, username_normalized = $2, birthdate = '', 
+	email = $2, email_ciphertext = '', email_verified = FALSE, password_hash = '', password_auth_disabled = TRUE, 
+	tokens_valid_after = $3, updated_at = $3, version = version + 1 WHERE id = $1;`
+
+	markPasswordResetRequestedQuery string = `UPDATE users SET password_reset_requested_at = $2 
+	WHERE id = $1 AND deleted_at IS NULL AND (password_reset_requested_at IS NULL OR password_reset_requested_at <= $3);`
 
 	insertEmailVerificationQuery string = `INSERT INTO email_verifications 
-	(code,user_id,created_at,expires_at) VALUES ($1,$2,$3,$4);`
+	(code,user_id,created_at,expires_at,pending_email,pending_email_ciphertext) VALUES ($1,$2,$3,$4,$5,$6);`
+
+	emailVerificationColumns string = "code,user_id,created_at,expires_at,used_at,pending_email,pending_email_ciphertext"
+
+	selectEmailVerificationQuery string = "SELECT " + emailVerificationColumns + " FROM email_verifications WHERE code = $1;"
+
+	selectEmailVerificationForUserQuery string = "SELECT " + emailVerificationColumns + ` FROM email_verifications 
+	WHERE code = $1 AND user_id = $2;`
+
+	selectEmailVerificationsByUserIDQuery string = "SELECT " + emailVerificationColumns + ` FROM email_verifications 
+	WHERE user_id = $1 ORDER BY created_at DESC;`
+
+	markEmailVerificationUsedQuery string = `UPDATE email_verifications SET used_at = NOW() 
+	WHERE code = $1 AND used_at IS NULL RETURNING user_id,pending_email,pending_email_ciphertext;`
+
+	changeUserEmailQuery string = `UPDATE users SET email = $2, email_ciphertext = $3, email_verified = TRUE, 
+	updated_at = NOW(), version = version + 1 WHERE id = $1;`
+
+	markUserEmailVerifiedQuery string = `UPDATE users SET email_verified = TRUE, updated_at = NOW(), 
+	version = version + 1 WHERE id = $1;`
+
+	selectUserStatsQuery string = `SELECT COUNT(*),COUNT(*) FILTER (WHERE email_verified),
+	COUNT(*) FILTER (WHERE created_at >= NOW() - INTERVAL '7 days'),
+	COUNT(*) FILTER (WHERE created_at >= NOW() - INTERVAL '30 days')
+	FROM users WHERE deleted_at IS NULL;`
+
+	selectUserCountByRoleQuery string = "SELECT role,COUNT(*) FROM users WHERE deleted_at IS NULL GROUP BY role;"
+
+	insertSessionQuery string = "INSERT INTO sessions (id,user_id,ip,user_agent,created_at,expires_at) VALUES ($1,$2,$3,$4,$5,$6);"
+
+	sessionColumns string = "id,user_id,ip,user_agent,created_at,expires_at"
+
+	selectSessionQuery string = "SELECT " + sessionColumns + " FROM sessions WHERE id = $1;"
+
+	selectActiveSessionsByUserIDQuery string = "SELECT " + sessionColumns + ` FROM sessions 
+	WHERE user_id = $1 AND expires_at > $2 ORDER BY created_at ASC;`
+
+	updateSessionExpiresAtQuery string = "UPDATE sessions SET expires_at = $2 WHERE id = $1;"
+
+	deleteSessionQuery string = "DELETE FROM sessions WHERE id = $1;"
+
+	lockUserQuery string = "SELECT id FROM users WHERE id = $1 FOR UPDATE;"
+
+	selectActiveSessionIDsByUserIDQuery string = "SELECT id FROM sessions WHERE user_id = $1 AND expires_at > $2 ORDER BY created_at ASC;"
+
+	insertRefreshTokenQuery string = `INSERT INTO refresh_tokens (token_hash,user_id,family_id,session_id,created_at,expires_at) 
+	VALUES ($1,$2,$3,$4,$5,$6);`
+
+	selectRefreshTokenQuery string = `SELECT token_hash,user_id,family_id,session_id,created_at,expires_at,consumed_at,revoked_at 
+	FROM refresh_tokens WHERE token_hash = $1;`
+
+	consumeRefreshTokenQuery string = `UPDATE refresh_tokens SET consumed_at = $2 
+	WHERE token_hash = $1 AND consumed_at IS NULL AND revoked_at IS NULL;`
+
+	revokeRefreshTokenFamilyQuery string = `UPDATE refresh_tokens SET revoked_at = $2 
+	WHERE family_id = $1 AND revoked_at IS NULL;`
+
+	revokeRefreshTokensBySessionIDQuery string = `UPDATE refresh_tokens SET revoked_at = $2 
+	WHERE session_id = $1 AND revoked_at IS NULL;`
+
+	revokeRefreshTokenQuery string = `UPDATE refresh_tokens SET revoked_at = $2 
+	WHERE token_hash = $1 AND revoked_at IS NULL;`
+
+	upsertTOTPQuery string = `INSERT INTO user_totp (user_id,secret_ciphertext,created_at) VALUES ($1,$2,$3) 
+	ON CONFLICT (user_id) DO UPDATE SET secret_ciphertext = $2, created_at = $3, confirmed_at = NULL, last_used_step = 0;`
+
+	selectTOTPQuery string = `SELECT user_id,secret_ciphertext,created_at,confirmed_at,last_used_step FROM user_totp 
+	WHERE user_id = $1;`
+
+	confirmTOTPQuery string = `UPDATE user_totp SET confirmed_at = $2 
+	WHERE user_id = $1 AND confirmed_at IS NULL;`
+
+	useTOTPStepQuery string = `UPDATE user_totp SET last_used_step = $2 
+	WHERE user_id = $1 AND last_used_step < $2;`
+
+	deleteTOTPQuery string = "DELETE FROM user_totp WHERE user_id = $1;"
+
+	insertPasskeyQuery string = `INSERT INTO passkeys (id,user_id,public_key,sign_count,created_at) 
+	VALUES ($1,$2,$3,$4,$5);`
+
+	selectPasskeyQuery string = `SELECT id,user_id,public_key,sign_count,created_at,last_used_at FROM passkeys 
+	WHERE id = $1;`
+
+	selectPasskeysByUserIDQuery string = `SELECT id,user_id,public_key,sign_count,created_at,last_used_at FROM passkeys 
+	WHERE user_id = $1 ORDER BY created_at ASC;`
+
+	updatePasskeySignCountQuery string = `UPDATE passkeys SET sign_count = $2, last_used_at = $3 
+	WHERE id = $1;`
+
+	insertIdentityQuery string = `INSERT INTO identities (provider,subject,user_id,created_at) 
+	VALUES ($1,$2,$3,$4);`
+
+	selectIdentityQuery string = `SELECT provider,subject,user_id,created_at FROM identities 
+	WHERE provider = $1 AND subject = $2;`
+
+	insertPasswordResetQuery string = `INSERT INTO password_resets (code_hash,user_id,created_at,expires_at) 
+	VALUES ($1,$2,$3,$4);`
+
+	selectPasswordResetQuery string = `SELECT code_hash,user_id,created_at,expires_at,used_at FROM password_resets 
+	WHERE code_hash = $1;`
+
+	markPasswordResetUsedQuery string = `UPDATE password_resets SET used_at = $2 
+	WHERE code_hash = $1 AND used_at IS NULL;`
+
+	insertLoginEventQuery string = `INSERT INTO login_events (user_id,ip,user_agent,outcome,created_at) 
+	VALUES ($1,$2,$3,$4,$5);`
+
+	trimLoginEventsQuery string = `DELETE FROM login_events WHERE user_id = $1 AND outcome = $3 AND id NOT IN 
+	(SELECT id FROM login_events WHERE user_id = $1 AND outcome = $3 ORDER BY created_at DESC, id DESC LIMIT $2);`
+
+	selectLoginEventsQuery string = `SELECT id,user_id,ip,user_agent,outcome,created_at FROM login_events 
+	WHERE user_id = $1 AND ($2::timestamp IS NULL OR (created_at, id) < ($2, $3)) 
+	ORDER BY created_at DESC, id DESC LIMIT $4;`
+
+	selectLoginEventsByOutcomeQuery string = `SELECT id,user_id,ip,user_agent,outcome,created_at FROM login_events 
+	WHERE user_id = $1 AND outcome = $2 ORDER BY created_at DESC, id DESC LIMIT $3;`
+
+	insertPasswordHistoryQuery string = `INSERT INTO password_history (user_id,password_hash,created_at) 
+	VALUES ($1,$2,$3);`
+
+	trimPasswordHistoryQuery string = `DELETE FROM password_history WHERE user_id = $1 AND id NOT IN 
+	(SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2);`
+
+	selectPasswordHistoryQuery string = `SELECT password_hash FROM password_history 
+	WHERE user_id = $1 ORDER BY created_at DESC, id DESC;`
+
+	incrementRateLimitQuery string = `INSERT INTO rate_limits (key,window_start,hits) VALUES ($1,$2,1) 
+	ON CONFLICT (key,window_start) DO UPDATE SET hits = rate_limits.hits + 1 RETURNING hits;`
+
+	deleteStaleRateLimitsQuery string = "DELETE FROM rate_limits WHERE key = $1 AND window_start < $2;"
+
+	deleteRateLimitQuery string = "DELETE FROM rate_limits WHERE key = $1;"
 )
 
+// IsTransient reports whether err is a transient database error worth retrying,
+// such as connection failures, serialization failures and deadlocks
+func IsTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var e interface{ SQLState() string }
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	code := e.SQLState()
+	switch {
+	case pgerrcode.IsConnectionException(code), pgerrcode.IsTransactionRollback(code):
+		return true
+	case code == pgerrcode.AdminShutdown, code == pgerrcode.CrashShutdown, code == pgerrcode.CannotConnectNow:
+		return true
+	default:
+		return false
+	}
+}
+
 // Postgres represents a user repository instance with the given database connection
 type Postgres struct{ *sqlx.DB }
 
@@ -39,78 +248,1052 @@ func NewPostgres(dbConn *sqlx.DB) *Postgres {
 }
 
 func (p *Postgres) Insert(ctx context.Context, u *User) (*User, error) {
-	var res User
-
-	if err := p.QueryRowContext(
-		ctx, insertQuery, u.ID, u.Fullname, u.Username,
-		u.Birthdate, u.Email, u.EmailVerified, u.PasswordHash,
-		u.Role, u.CreatedAt, u.UpdatedAt,
-	).Scan(
-		&res.ID, &res.Fullname, &res.Username, &res.Birthdate, &res.Email,
-		&res.EmailVerified, &res.PasswordHash, &res.Role, &res.CreatedAt, &res.UpdatedAt,
-	); err != nil {
+	res, err := scanUser(p.QueryRowContext(
+		ctx, insertQuery, u.ID, u.Fullname, u.Username, u.UsernameNormalized,
+		u.Birthdate, u.Email, u.EmailCiphertext, u.EmailVerified, u.PasswordHash,
+		u.Role, u.CreatedAt, u.UpdatedAt, u.TokensValidAfter, u.PasswordAuthDisabled, u.PasswordChangedAt,
+	))
+	if err != nil {
 		var e *pgconn.PgError
 		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
-			return nil, ErrDuplicateRecord
+			return nil, duplicateError(e.ConstraintName)
 		}
 		return nil, fmt.Errorf("could not scan inserted user: %s", err)
 	}
-	return &res, nil
+	return res, nil
+}
+
+// Update updates the mutable fields of a non-deleted user and returns the updated user with its version incremented.
+// The update only applies if the stored version still is the version of u, i.e. the user was not updated
+// since it was read, and returns ErrVersionConflict otherwise. It returns ErrRecordNotFound if the user
+// does not exist and a duplicate error if the new email or username is already taken.
+func (p *Postgres) Update(ctx context.Context, u *User) (*User, error) {
+	res, err := scanUser(p.QueryRowContext(
+		ctx, updateQuery, u.ID, u.Fullname, u.Username, u.UsernameNormalized,
+		u.Birthdate, u.Email, u.EmailCiphertext, u.EmailVerified, u.PasswordHash,
+		u.Role, u.UpdatedAt, u.TokensValidAfter, u.Version, u.PasswordAuthDisabled, u.PasswordChangedAt,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, p.updateNoRowsError(ctx, u.ID)
+		}
+
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return nil, duplicateError(e.ConstraintName)
+		}
+		return nil, fmt.Errorf("could not scan updated user: %w", err)
+	}
+	return res, nil
+}
+
+// UpdatePasswordHash replaces the password hash of a user with newHash, only if it still is currentHash
+// (e.g. to upgrade the hash on login without racing a password change). Unlike Update, it doesn't bump
+// the version of the user. It returns ErrRecordNotFound if the user does not exist or the hash changed.
+func (p *Postgres) UpdatePasswordHash(ctx context.Context, id, currentHash, newHash string) error {
+	res, err := p.ExecContext(ctx, updatePasswordHashQuery, id, currentHash, newHash)
+	if err != nil {
+		return fmt.Errorf("could not update password hash: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// updateNoRowsError tells apart a missing user from a version conflict when an update matched no rows
+func (p *Postgres) updateNoRowsError(ctx context.Context, id string) error {
+	var version int
+	if err := p.QueryRowContext(ctx, selectVersionByIDQuery, id).Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("could not select user version: %w", err)
+	}
+	return ErrVersionConflict
+}
+
+// duplicateError returns the duplicate error matching the violated unique constraint
+func duplicateError(constraint string) error {
+	switch constraint {
+	case "users_email_key":
+		return ErrDuplicateEmail
+	case "users_username_key", "users_username_normalized_key":
+		return ErrDuplicateUsername
+	default:
+		return ErrDuplicateRecord
+	}
 }
 
 // SelectByID selects a user by id and returns the user
 func (p *Postgres) SelectByID(ctx context.Context, id string) (*User, error) {
 	user, err := p.selectUser(ctx, selectByIDQuery, id)
 	if err != nil {
-		return nil, fmt.Errorf("could not select user by id: %s", err)
+		return nil, fmt.Errorf("could not select user by id: %w", err)
 	}
 	return user, nil
 }
 
+// SelectRoleByID selects the role of a non-deleted user by id.
+// It returns ErrRecordNotFound if the user does not exist or is deleted.
+func (p *Postgres) SelectRoleByID(ctx context.Context, id string) (string, error) {
+	var role string
+	if err := p.QueryRowContext(ctx, selectRoleByIDQuery, id).Scan(&role); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrRecordNotFound
+		}
+		return "", fmt.Errorf("could not select role by id: %w", err)
+	}
+	return role, nil
+}
+
+// SelectByEmail selects a non-deleted user by email, compared case-insensitively
 func (p *Postgres) SelectByEmail(ctx context.Context, email string) (*User, error) {
 	user, err := p.selectUser(ctx, selectByEmailQuery, email)
 	if err != nil {
-		return nil, fmt.Errorf("could not select user by email: %s", err)
+		return nil, fmt.Errorf("could not select user by email: %w", err)
+	}
+	return user, nil
+}
+
+// SelectByIDWithDeleted selects a user by id, including soft deleted users
+func (p *Postgres) SelectByIDWithDeleted(ctx context.Context, id string) (*User, error) {
+	user, err := p.selectUser(ctx, selectByIDWithDeletedQuery, id)
+	if err != nil {
+		return nil, fmt.Errorf("could not select user by id with deleted: %w", err)
+	}
+	return user, nil
+}
+
+// SelectByEmailWithDeleted selects a user by email, compared case-insensitively, including soft deleted users
+func (p *Postgres) SelectByEmailWithDeleted(ctx context.Context, email string) (*User, error) {
+	user, err := p.selectUser(ctx, selectByEmailWithDeletedQuery, email)
+	if err != nil {
+		return nil, fmt.Errorf("could not select user by email with deleted: %w", err)
+	}
+	return user, nil
+}
+
+// SelectByUsername selects a user by its normalized username and returns the user
+func (p *Postgres) SelectByUsername(ctx context.Context, usernameNormalized string) (*User, error) {
+	user, err := p.selectUser(ctx, selectByUsernameQuery, usernameNormalized)
+	if err != nil {
+		return nil, fmt.Errorf("could not select user by username: %w", err)
 	}
 	return user, nil
 }
 
 // selectUser executes the given query and returns the user
 func (p *Postgres) selectUser(ctx context.Context, query, arg string) (*User, error) {
-	var u User
-	if err := p.QueryRowContext(ctx, query, arg).Scan(
-		&u.ID, &u.Fullname, &u.Username, &u.Birthdate, &u.Email,
-		&u.EmailVerified, &u.PasswordHash, &u.Role, &u.CreatedAt, &u.UpdatedAt,
-	); err != nil {
+	u, err := scanUser(p.QueryRowContext(ctx, query, arg))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
-		return nil, fmt.Errorf("could not select user: %s", err)
+		return nil, fmt.Errorf("could not select user: %w", err)
+	}
+	return u, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row holding the user columns
+func scanUser(row scanner) (*User, error) {
+	var u User
+	if err := row.Scan(
+		&u.ID, &u.Fullname, &u.Username, &u.UsernameNormalized, &u.Birthdate, &u.Email, &u.EmailCiphertext,
+		&u.EmailVerified, &u.PasswordHash, &u.Role, &u.CreatedAt, &u.UpdatedAt,
+		&u.DeletedAt, &u.DeletionReason, &u.DeletedBy, &u.TokensValidAfter, &u.Version,
+		&u.PasswordAuthDisabled, &u.PasswordChangedAt,
+	); err != nil {
+		return nil, err
 	}
 	return &u, nil
 }
 
-func (p *Postgres) DeleteByID(ctx context.Context, id string) error {
-	res, err := p.ExecContext(ctx, deleteByIDQuery, id)
+// DeleteByID soft deletes a user by id, recording the reason and who deleted it,
+// and invalidates the tokens issued to the user so far
+func (p *Postgres) DeleteByID(ctx context.Context, id, reason, deletedBy string) error {
+	res, err := p.ExecContext(ctx, deleteByIDQuery, id, reason, deletedBy)
+	if err != nil {
+		return fmt.Errorf("could not delete user: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RestoreByID restores a soft deleted user by id, clearing its deletion details
+func (p *Postgres) RestoreByID(ctx context.Context, id string) error {
+	res, err := p.ExecContext(ctx, restoreByIDQuery, id)
+	if err != nil {
+		return fmt.Errorf("could not restore user: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// AnonymizeByID overwrites the personal data of a user, soft deleted or not, with the tombstone,
+// clears its password and revokes its tokens issued before anonymizedAt.
+// The login events of the user, holding client addresses, are deleted in the same transaction.
+func (p *Postgres) AnonymizeByID(ctx context.Context, id, tombstone string, anonymizedAt time.Time) error {
+	tx, err := p.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("could not delete user: %s", err)
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, anonymizeByIDQuery, id, tombstone, anonymizedAt)
+	if err != nil {
+		return fmt.Errorf("could not anonymize user: %w", err)
 	}
 
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("could not get rows affected: %s", err)
+		return fmt.Errorf("could not get rows affected: %w", err)
 	}
 
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
+
+	// The credentials of the user go along with its personal data, so nothing can log in as the user anymore
+	for _, table := range anonymizedUserTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = $1;", id); err != nil {
+			return fmt.Errorf("could not delete %s: %w", strings.ReplaceAll(table, "_", " "), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
 	return nil
 }
 
+// SelectUsers selects a page of non-deleted users matching the filter, in the order of filter.SortBy
+func (p *Postgres) SelectUsers(ctx context.Context, filter UserFilter) ([]User, error) {
+	var (
+		conds = []string{"deleted_at IS NULL"}
+		args  []interface{}
+	)
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Role != "" {
+		conds = append(conds, "role = "+arg(filter.Role))
+	}
+
+	if filter.EmailVerified != nil {
+		conds = append(conds, "email_verified = "+arg(*filter.EmailVerified))
+	}
+
+	if filter.CreatedAfter != nil {
+		conds = append(conds, "created_at > "+arg(*filter.CreatedAfter))
+	}
+
+	sortColumn := "created_at"
+	if filter.SortBy == "username" {
+		sortColumn = "username_normalized"
+	}
+
+	if filter.After != nil {
+		var after interface{} = filter.After.CreatedAt
+		if sortColumn == "username_normalized" {
+			after = filter.After.UsernameNormalized
+		}
+		conds = append(conds, fmt.Sprintf("(%s, id) > (%s, %s)", sortColumn, arg(after), arg(filter.After.ID)))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM users WHERE %s ORDER BY %s, id LIMIT %s;",
+		userColumns, strings.Join(conds, " AND "), sortColumn, arg(filter.Limit))
+
+	rows, err := p.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not select users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan user: %w", err)
+		}
+		users = append(users, *u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate users: %w", err)
+	}
+	return users, nil
+}
+
+// SelectByPasswordChangedBefore selects the non-deleted users with a password last changed before the given time,
+// oldest password first, up to limit and after the cursor if any. Users without password (e.g. created through OAuth)
+// are not selected.
+func (p *Postgres) SelectByPasswordChangedBefore(ctx context.Context, before time.Time, after *PasswordChangedCursor, limit int) ([]User, error) {
+	var (
+		afterChangedAt *time.Time
+		afterID        *string
+	)
+
+	if after != nil {
+		afterChangedAt, afterID = &after.PasswordChangedAt, &after.ID
+	}
+
+	rows, err := p.QueryContext(ctx, selectByPasswordChangedBeforeQuery, before, afterChangedAt, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not select users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan user: %w", err)
+		}
+		users = append(users, *u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate users: %w", err)
+	}
+	return users, nil
+}
+
+// MarkPasswordResetRequested records a password reset request of the user at requestedAt,
+// unless the previous request happened after notBefore. It reports whether the request was recorded,
+// which is false as well when the user does not exist.
+func (p *Postgres) MarkPasswordResetRequested(ctx context.Context, id string, requestedAt, notBefore time.Time) (bool, error) {
+	res, err := p.ExecContext(ctx, markPasswordResetRequestedQuery, id, requestedAt, notBefore)
+	if err != nil {
+		return false, fmt.Errorf("could not mark password reset requested: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
 func (p *Postgres) InsertEmailVerification(ctx context.Context, in EmailVerification) error {
-	_, err := p.ExecContext(ctx, insertEmailVerificationQuery, in.Code, in.UserID, in.CreatedAt, in.ExpiresAt)
+	_, err := p.ExecContext(ctx, insertEmailVerificationQuery, in.Code, in.UserID, in.CreatedAt, in.ExpiresAt,
+		in.PendingEmail, in.PendingEmailCiphertext)
 	if err != nil {
 		return fmt.Errorf("could not insert email verification: %s", err)
 	}
 	return nil
 }
+
+// SelectEmailVerification selects an email verification by code
+func (p *Postgres) SelectEmailVerification(ctx context.Context, code string) (*EmailVerification, error) {
+	return p.selectEmailVerification(ctx, selectEmailVerificationQuery, code)
+}
+
+// SelectEmailVerificationForUser selects an email verification by code, scoped to the given user
+func (p *Postgres) SelectEmailVerificationForUser(ctx context.Context, userID, code string) (*EmailVerification, error) {
+	return p.selectEmailVerification(ctx, selectEmailVerificationForUserQuery, code, userID)
+}
+
+// SelectEmailVerificationsByUserID selects all the email verifications of a user, newest first
+func (p *Postgres) SelectEmailVerificationsByUserID(ctx context.Context, userID string) ([]EmailVerification, error) {
+	rows, err := p.QueryContext(ctx, selectEmailVerificationsByUserIDQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select email verifications: %w", err)
+	}
+	defer rows.Close()
+
+	var verifications []EmailVerification
+	for rows.Next() {
+		var v EmailVerification
+		if err := rows.Scan(
+			&v.Code, &v.UserID, &v.CreatedAt, &v.ExpiresAt, &v.UsedAt, &v.PendingEmail, &v.PendingEmailCiphertext,
+		); err != nil {
+			return nil, fmt.Errorf("could not scan email verification: %w", err)
+		}
+		verifications = append(verifications, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate email verifications: %w", err)
+	}
+	return verifications, nil
+}
+
+func (p *Postgres) selectEmailVerification(ctx context.Context, query string, args ...interface{}) (*EmailVerification, error) {
+	var v EmailVerification
+	if err := p.QueryRowContext(ctx, query, args...).Scan(
+		&v.Code, &v.UserID, &v.CreatedAt, &v.ExpiresAt, &v.UsedAt, &v.PendingEmail, &v.PendingEmailCiphertext,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select email verification: %w", err)
+	}
+	return &v, nil
+}
+
+// MarkEmailVerified marks the email verification as used and the email of its user as verified
+// in a single transaction. For email changes, the pending email replaces the email of the user.
+// It returns ErrRecordNotFound if the code does not exist or was already used
+// and ErrDuplicateEmail if the pending email was taken meanwhile.
+func (p *Postgres) MarkEmailVerified(ctx context.Context, code string) error {
+	tx, err := p.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID, pendingEmail, pendingEmailCiphertext string
+	if err := tx.QueryRowContext(ctx, markEmailVerificationUsedQuery, code).Scan(
+		&userID, &pendingEmail, &pendingEmailCiphertext,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("could not mark email verification as used: %w", err)
+	}
+
+	if pendingEmail != "" {
+		if _, err := tx.ExecContext(ctx, changeUserEmailQuery, userID, pendingEmail, pendingEmailCiphertext); err != nil {
+			var e *pgconn.PgError
+			if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+				return duplicateError(e.ConstraintName)
+			}
+			return fmt.Errorf("could not change user email: %w", err)
+		}
+	} else if _, err := tx.ExecContext(ctx, markUserEmailVerifiedQuery, userID); err != nil {
+		return fmt.Errorf("could not mark user email as verified: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SelectExistingEmails returns, lowercased, the given emails held by a user, deleted users included.
+// Emails are compared case-insensitively.
+func (p *Postgres) SelectExistingEmails(ctx context.Context, emails []string) ([]string, error) {
+	var arg pgtype.TextArray
+	if err := arg.Set(emails); err != nil {
+		return nil, fmt.Errorf("could not encode emails: %w", err)
+	}
+
+	rows, err := p.QueryContext(ctx, selectExistingEmailsQuery, &arg)
+	if err != nil {
+		return nil, fmt.Errorf("could not select existing emails: %w", err)
+	}
+	defer rows.Close()
+
+	var existing []string
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, fmt.Errorf("could not scan email: %w", err)
+		}
+		existing = append(existing, email)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate emails: %w", err)
+	}
+	return existing, nil
+}
+
+// SelectUserStats returns aggregate counts over non-deleted users
+func (p *Postgres) SelectUserStats(ctx context.Context) (*UserStats, error) {
+	stats := UserStats{ByRole: make(map[string]int)}
+
+	if err := p.QueryRowContext(ctx, selectUserStatsQuery).Scan(
+		&stats.Total, &stats.Verified, &stats.CreatedLast7Days, &stats.CreatedLast30Days,
+	); err != nil {
+		return nil, fmt.Errorf("could not scan user stats: %w", err)
+	}
+
+	rows, err := p.QueryContext(ctx, selectUserCountByRoleQuery)
+	if err != nil {
+		return nil, fmt.Errorf("could not select user count by role: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			role  string
+			count int
+		)
+		if err := rows.Scan(&role, &count); err != nil {
+			return nil, fmt.Errorf("could not scan user count by role: %w", err)
+		}
+		stats.ByRole[role] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate user count by role: %w", err)
+	}
+	return &stats, nil
+}
+
+// InsertSession inserts a session. With maxActive greater than zero, the sessions of the user active at the creation
+// of the session are counted under a lock of the user row, so concurrent logins can't exceed maxActive.
+// When the user reached maxActive, its oldest sessions are deleted to make room for the new one if evictOldest is set,
+// otherwise ErrSessionLimitReached is returned. The count, the deletions and the insert run in a single transaction.
+func (p *Postgres) InsertSession(ctx context.Context, in Session, maxActive int, evictOldest bool) error {
+	tx, err := p.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if maxActive > 0 {
+		if _, err := tx.ExecContext(ctx, lockUserQuery, in.UserID); err != nil {
+			return fmt.Errorf("could not lock user: %w", err)
+		}
+
+		var ids []string
+		if err := tx.SelectContext(ctx, &ids, selectActiveSessionIDsByUserIDQuery, in.UserID, in.CreatedAt); err != nil {
+			return fmt.Errorf("could not select active sessions: %w", err)
+		}
+
+		if excess := len(ids) - maxActive + 1; excess > 0 {
+			if !evictOldest {
+				return ErrSessionLimitReached
+			}
+
+			// Sessions are sorted oldest first
+			for _, id := range ids[:excess] {
+				if _, err := tx.ExecContext(ctx, deleteSessionQuery, id); err != nil {
+					return fmt.Errorf("could not delete session: %w", err)
+				}
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, insertSessionQuery, in.ID, in.UserID, in.IP, in.UserAgent, in.CreatedAt, in.ExpiresAt); err != nil {
+		return fmt.Errorf("could not insert session: %s", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SelectSession selects a session by id. It returns nil if the session does not exist.
+func (p *Postgres) SelectSession(ctx context.Context, id string) (*Session, error) {
+	var s Session
+	if err := p.QueryRowContext(ctx, selectSessionQuery, id).Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.ExpiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select session: %w", err)
+	}
+	return &s, nil
+}
+
+// SelectActiveSessionsByUserID selects the sessions of a user not expired at now, oldest first
+func (p *Postgres) SelectActiveSessionsByUserID(ctx context.Context, userID string, now time.Time) ([]Session, error) {
+	rows, err := p.QueryContext(ctx, selectActiveSessionsByUserIDQuery, userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not select active sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("could not scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// UpdateSessionExpiresAt extends a session until expiresAt.
+// It returns ErrRecordNotFound if the session does not exist.
+func (p *Postgres) UpdateSessionExpiresAt(ctx context.Context, id string, expiresAt time.Time) error {
+	res, err := p.ExecContext(ctx, updateSessionExpiresAtQuery, id, expiresAt)
+	if err != nil {
+		return fmt.Errorf("could not update session: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteSession deletes a session by id
+func (p *Postgres) DeleteSession(ctx context.Context, id string) error {
+	if _, err := p.ExecContext(ctx, deleteSessionQuery, id); err != nil {
+		return fmt.Errorf("could not delete session: %s", err)
+	}
+	return nil
+}
+
+// IncrementRateLimit atomically increments the hits for key in the window starting at windowStart
+// and returns the hits in that window. Counters of previous windows for the same key are discarded.
+func (p *Postgres) IncrementRateLimit(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	var hits int
+	if err := p.QueryRowContext(ctx, incrementRateLimitQuery, key, windowStart).Scan(&hits); err != nil {
+		return 0, fmt.Errorf("could not increment rate limit: %s", err)
+	}
+
+	if _, err := p.ExecContext(ctx, deleteStaleRateLimitsQuery, key, windowStart); err != nil {
+		return 0, fmt.Errorf("could not delete stale rate limits: %s", err)
+	}
+	return hits, nil
+}
+
+// DeleteRateLimit deletes all counters for key
+func (p *Postgres) DeleteRateLimit(ctx context.Context, key string) error {
+	if _, err := p.ExecContext(ctx, deleteRateLimitQuery, key); err != nil {
+		return fmt.Errorf("could not delete rate limit: %s", err)
+	}
+	return nil
+}
+
+// InsertRefreshToken inserts a refresh token
+func (p *Postgres) InsertRefreshToken(ctx context.Context, in RefreshToken) error {
+	if _, err := p.ExecContext(ctx, insertRefreshTokenQuery, in.TokenHash, in.UserID, in.FamilyID, in.SessionID, in.CreatedAt, in.ExpiresAt); err != nil {
+		return fmt.Errorf("could not insert refresh token: %w", err)
+	}
+	return nil
+}
+
+// SelectRefreshToken selects a refresh token by the digest of the token. It returns nil if the token does not exist.
+func (p *Postgres) SelectRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	var t RefreshToken
+	if err := p.QueryRowContext(ctx, selectRefreshTokenQuery, tokenHash).Scan(
+		&t.TokenHash, &t.UserID, &t.FamilyID, &t.SessionID, &t.CreatedAt, &t.ExpiresAt, &t.ConsumedAt, &t.RevokedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select refresh token: %w", err)
+	}
+	return &t, nil
+}
+
+// RevokeRefreshToken revokes a refresh token by the digest of the token at revokedAt.
+// It returns ErrRecordNotFound if the token does not exist or was already revoked.
+func (p *Postgres) RevokeRefreshToken(ctx context.Context, tokenHash string, revokedAt time.Time) error {
+	res, err := p.ExecContext(ctx, revokeRefreshTokenQuery, tokenHash, revokedAt)
+	if err != nil {
+		return fmt.Errorf("could not revoke refresh token: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ConsumeRefreshToken marks a refresh token as exchanged for its successor at consumedAt.
+// It returns ErrRecordNotFound if the token does not exist or was already consumed or revoked.
+func (p *Postgres) ConsumeRefreshToken(ctx context.Context, tokenHash string, consumedAt time.Time) error {
+	res, err := p.ExecContext(ctx, consumeRefreshTokenQuery, tokenHash, consumedAt)
+	if err != nil {
+		return fmt.Errorf("could not consume refresh token: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes the refresh tokens of a family not revoked yet at revokedAt
+func (p *Postgres) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	if _, err := p.ExecContext(ctx, revokeRefreshTokenFamilyQuery, familyID, revokedAt); err != nil {
+		return fmt.Errorf("could not revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RevokeRefreshTokensBySessionID revokes the refresh tokens of a session not revoked yet at revokedAt
+func (p *Postgres) RevokeRefreshTokensBySessionID(ctx context.Context, sessionID string, revokedAt time.Time) error {
+	if _, err := p.ExecContext(ctx, revokeRefreshTokensBySessionIDQuery, sessionID, revokedAt); err != nil {
+		return fmt.Errorf("could not revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// UpsertTOTP inserts the TOTP secret of a user, replacing the previous secret and unconfirming it
+func (p *Postgres) UpsertTOTP(ctx context.Context, in TOTP) error {
+	if _, err := p.ExecContext(ctx, upsertTOTPQuery, in.UserID, in.SecretCiphertext, in.CreatedAt); err != nil {
+		return fmt.Errorf("could not upsert totp: %w", err)
+	}
+	return nil
+}
+
+// SelectTOTP selects the TOTP secret of a user. It returns nil if the user has no secret.
+func (p *Postgres) SelectTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	var t TOTP
+	if err := p.QueryRowContext(ctx, selectTOTPQuery, userID).Scan(
+		&t.UserID, &t.SecretCiphertext, &t.CreatedAt, &t.ConfirmedAt, &t.LastUsedStep,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select totp: %w", err)
+	}
+	return &t, nil
+}
+
+// ConfirmTOTP confirms the TOTP secret of a user at confirmedAt.
+// It returns ErrRecordNotFound if the user has no secret or it was already confirmed.
+func (p *Postgres) ConfirmTOTP(ctx context.Context, userID string, confirmedAt time.Time) error {
+	res, err := p.ExecContext(ctx, confirmTOTPQuery, userID, confirmedAt)
+	if err != nil {
+		return fmt.Errorf("could not confirm totp: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// UseTOTPStep records step as the time step of the last accepted code of a user.
+// It returns ErrRecordNotFound if the user has no secret or a code of the same or a later step was accepted,
+// so each code is only accepted once.
+func (p *Postgres) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	res, err := p.ExecContext(ctx, useTOTPStepQuery, userID, step)
+	if err != nil {
+		return fmt.Errorf("could not use totp step: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteTOTP deletes the TOTP secret of a user. It returns ErrRecordNotFound if the user has no secret.
+func (p *Postgres) DeleteTOTP(ctx context.Context, userID string) error {
+	res, err := p.ExecContext(ctx, deleteTOTPQuery, userID)
+	if err != nil {
+		return fmt.Errorf("could not delete totp: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// InsertPasskey inserts a passkey. It returns ErrDuplicateRecord if the passkey is already registered.
+func (p *Postgres) InsertPasskey(ctx context.Context, in Passkey) error {
+	if _, err := p.ExecContext(ctx, insertPasskeyQuery, in.ID, in.UserID, in.PublicKey, in.SignCount, in.CreatedAt); err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicateRecord
+		}
+		return fmt.Errorf("could not insert passkey: %w", err)
+	}
+	return nil
+}
+
+// SelectPasskey selects a passkey by its id. It returns nil if the passkey does not exist.
+func (p *Postgres) SelectPasskey(ctx context.Context, id string) (*Passkey, error) {
+	var k Passkey
+	if err := p.QueryRowContext(ctx, selectPasskeyQuery, id).Scan(
+		&k.ID, &k.UserID, &k.PublicKey, &k.SignCount, &k.CreatedAt, &k.LastUsedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select passkey: %w", err)
+	}
+	return &k, nil
+}
+
+// SelectPasskeysByUserID selects the passkeys of a user, oldest first
+func (p *Postgres) SelectPasskeysByUserID(ctx context.Context, userID string) ([]Passkey, error) {
+	rows, err := p.QueryContext(ctx, selectPasskeysByUserIDQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		var k Passkey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.PublicKey, &k.SignCount, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("could not scan passkey: %w", err)
+		}
+		passkeys = append(passkeys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate passkeys: %w", err)
+	}
+	return passkeys, nil
+}
+
+// UpdatePasskeySignCount sets the sign count of a passkey used at usedAt.
+// It returns ErrRecordNotFound if the passkey does not exist.
+func (p *Postgres) UpdatePasskeySignCount(ctx context.Context, id string, signCount int64, usedAt time.Time) error {
+	res, err := p.ExecContext(ctx, updatePasskeySignCountQuery, id, signCount, usedAt)
+	if err != nil {
+		return fmt.Errorf("could not update passkey sign count: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// InsertIdentity links an identity to its user. It returns ErrDuplicateRecord if the identity is already linked.
+func (p *Postgres) InsertIdentity(ctx context.Context, in Identity) error {
+	if _, err := p.ExecContext(ctx, insertIdentityQuery, in.Provider, in.Subject, in.UserID, in.CreatedAt); err != nil {
+		var e *pgconn.PgError
+		if errors.As(err, &e) && e.Code == pgerrcode.UniqueViolation {
+			return ErrDuplicateRecord
+		}
+		return fmt.Errorf("could not insert identity: %w", err)
+	}
+	return nil
+}
+
+// SelectIdentity selects an identity by its provider and subject. It returns nil if the identity is not linked.
+func (p *Postgres) SelectIdentity(ctx context.Context, provider, subject string) (*Identity, error) {
+	var i Identity
+	if err := p.QueryRowContext(ctx, selectIdentityQuery, provider, subject).Scan(
+		&i.Provider, &i.Subject, &i.UserID, &i.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select identity: %w", err)
+	}
+	return &i, nil
+}
+
+// InsertPasswordReset inserts a password reset request
+func (p *Postgres) InsertPasswordReset(ctx context.Context, in PasswordReset) error {
+	if _, err := p.ExecContext(ctx, insertPasswordResetQuery, in.CodeHash, in.UserID, in.CreatedAt, in.ExpiresAt); err != nil {
+		return fmt.Errorf("could not insert password reset: %w", err)
+	}
+	return nil
+}
+
+// SelectPasswordReset selects a password reset request by the digest of its code.
+// It returns nil if the request does not exist.
+func (p *Postgres) SelectPasswordReset(ctx context.Context, codeHash string) (*PasswordReset, error) {
+	var r PasswordReset
+	if err := p.QueryRowContext(ctx, selectPasswordResetQuery, codeHash).Scan(
+		&r.CodeHash, &r.UserID, &r.CreatedAt, &r.ExpiresAt, &r.UsedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not select password reset: %w", err)
+	}
+	return &r, nil
+}
+
+// MarkPasswordResetUsed marks a password reset request as used at usedAt.
+// It returns ErrRecordNotFound if the request does not exist or was already used.
+func (p *Postgres) MarkPasswordResetUsed(ctx context.Context, codeHash string, usedAt time.Time) error {
+	res, err := p.ExecContext(ctx, markPasswordResetUsedQuery, codeHash, usedAt)
+	if err != nil {
+		return fmt.Errorf("could not mark password reset used: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// InsertLoginEvent inserts a login event and deletes the events of the user with the same outcome
+// but the newest keep ones in a single transaction, so failed logins don't evict the successful ones
+func (p *Postgres) InsertLoginEvent(ctx context.Context, in LoginEvent, keep int) error {
+	tx, err := p.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, insertLoginEventQuery, in.UserID, in.IP, in.UserAgent, in.Outcome, in.CreatedAt); err != nil {
+		return fmt.Errorf("could not insert login event: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, trimLoginEventsQuery, in.UserID, keep, in.Outcome); err != nil {
+		return fmt.Errorf("could not trim login events: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// InsertPasswordHistory inserts a replaced password hash of a user and deletes the hashes of the user
+// but the newest keep ones in a single transaction
+func (p *Postgres) InsertPasswordHistory(ctx context.Context, userID, passwordHash string, createdAt time.Time, keep int) error {
+	tx, err := p.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, insertPasswordHistoryQuery, userID, passwordHash, createdAt); err != nil {
+		return fmt.Errorf("could not insert password history: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, trimPasswordHistoryQuery, userID, keep); err != nil {
+		return fmt.Errorf("could not trim password history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SelectPasswordHistory selects the replaced password hashes of a user, newest first
+func (p *Postgres) SelectPasswordHistory(ctx context.Context, userID string) ([]string, error) {
+	rows, err := p.QueryContext(ctx, selectPasswordHistoryQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not select password history: %w", err)
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, fmt.Errorf("could not scan password hash: %w", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate password history: %w", err)
+	}
+	return hashes, nil
+}
+
+// SelectLoginEvents selects the newest login events of a user, up to limit, newest first.
+// When before is not nil, only the events older than the cursor are selected.
+func (p *Postgres) SelectLoginEvents(ctx context.Context, userID string, before *LoginEventCursor, limit int) ([]LoginEvent, error) {
+	var (
+		beforeCreatedAt *time.Time
+		beforeID        int64
+	)
+
+	if before != nil {
+		beforeCreatedAt, beforeID = &before.CreatedAt, before.ID
+	}
+
+	rows, err := p.QueryContext(ctx, selectLoginEventsQuery, userID, beforeCreatedAt, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not select login events: %w", err)
+	}
+	return scanLoginEvents(rows)
+}
+
+// SelectLoginEventsByOutcome selects the newest login events of a user with the given outcome, up to limit, newest first
+func (p *Postgres) SelectLoginEventsByOutcome(ctx context.Context, userID, outcome string, limit int) ([]LoginEvent, error) {
+	rows, err := p.QueryContext(ctx, selectLoginEventsByOutcomeQuery, userID, outcome, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not select login events: %w", err)
+	}
+	return scanLoginEvents(rows)
+}
+
+// scanLoginEvents scans and closes the rows of a login events query
+func scanLoginEvents(rows *sql.Rows) ([]LoginEvent, error) {
+	defer rows.Close()
+
+	var events []LoginEvent
+	for rows.Next() {
+		var e LoginEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.IP, &e.UserAgent, &e.Outcome, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan login event: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not iterate login events: %w", err)
+	}
+	return events, nil
+}
@@ -2,31 +2,172 @@ package repository
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
 var (
-	ErrDuplicateRecord error = errors.New("duplicate record")
-	ErrRecordNotFound  error = errors.New("record not found")
+	ErrDuplicateRecord     error = errors.New("duplicate record")
+	ErrRecordNotFound      error = errors.New("record not found")
+	ErrSessionLimitReached error = errors.New("session limit reached")
+	ErrVersionConflict     error = errors.New("version conflict")
+
+	// ErrDuplicateEmail and ErrDuplicateUsername tell which field collided and wrap ErrDuplicateRecord
+	ErrDuplicateEmail    error = fmt.Errorf("%w: email", ErrDuplicateRecord)
+	ErrDuplicateUsername error = fmt.Errorf("%w: username", ErrDuplicateRecord)
 )
 
-// User represents a user in the database table
+// User represents a user in the database table.
+// UsernameNormalized is the canonical form of the username used for lookups and uniqueness,
+// and must be backed by a unique index so usernames differing only by case collide.
+// When the service encrypts emails at rest, Email holds a keyed hash of the email used for
+// lookups and EmailCiphertext the encrypted email.
 type User struct {
-	ID            string
-	Fullname      string
-	Username      string
-	Birthdate     string
-	Email         string
-	PasswordHash  string
+	ID                 string
+	Fullname           string
+	Username           string
+	UsernameNormalized string
+	Birthdate          string
+	Email              string
+	EmailCiphertext    string
+	PasswordHash       string
+	Role               string
+	EmailVerified      bool
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	DeletedAt          *time.Time
+	DeletionReason     string
+	DeletedBy          string
+	TokensValidAfter   *time.Time
+	Version            int
+	// PasswordAuthDisabled prevents password logins, e.g. for SSO-only accounts
+	PasswordAuthDisabled bool
+	PasswordChangedAt    time.Time
+}
+
+// UserFilter selects a page of non-deleted users sorted by SortBy, "created_at" or "username", then by id.
+// Zero fields don't filter. After, when set, resumes the listing after the given user.
+type UserFilter struct {
 	Role          string
-	EmailVerified bool
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	EmailVerified *bool
+	CreatedAfter  *time.Time
+	SortBy        string
+	After         *UserCursor
+	Limit         int
 }
 
-type EmailVerification struct {
-	Code      string
+// UserCursor identifies the position of a user in a listing, by its sort key and id
+type UserCursor struct {
+	CreatedAt          time.Time
+	UsernameNormalized string
+	ID                 string
+}
+
+// UserStats represents aggregate counts over non-deleted users
+type UserStats struct {
+	Total             int
+	Verified          int
+	CreatedLast7Days  int
+	CreatedLast30Days int
+	ByRole            map[string]int
+}
+
+// LoginEvent represents a login attempt, its outcome and the client it came from
+type LoginEvent struct {
+	ID        int64
+	UserID    string
+	IP        string
+	UserAgent string
+	Outcome   string
+	CreatedAt time.Time
+}
+
+// PasswordChangedCursor identifies the position of a user in a listing by password change time,
+// by its password change time and id
+type PasswordChangedCursor struct {
+	PasswordChangedAt time.Time
+	ID                string
+}
+
+// LoginEventCursor identifies the position of a login event in a listing, by its creation time and id
+type LoginEventCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+// RefreshToken represents an issued refresh token, identified by the SHA-256 digest of the token.
+// SessionID is the session started by the login of its family, empty when sessions are disabled.
+type RefreshToken struct {
+	TokenHash  string
+	UserID     string
+	FamilyID   string
+	SessionID  string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	ConsumedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// TOTP represents the time-based one-time password secret of a user, encrypted by the service.
+// ConfirmedAt is set once the user proved holding the secret, enabling the second factor.
+// LastUsedStep is the time step of the last accepted code.
+type TOTP struct {
+	UserID           string
+	SecretCiphertext string
+	CreatedAt        time.Time
+	ConfirmedAt      *time.Time
+	LastUsedStep     int64
+}
+
+// Passkey represents a WebAuthn credential registered by a user, identified by the base64url encoding of its id.
+// PublicKey is the COSE_Key encoded public key of the credential.
+type Passkey struct {
+	ID         string
+	UserID     string
+	PublicKey  []byte
+	SignCount  int64
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// Identity represents the account of a user at an OAuth2 provider, identified by the provider
+// and the subject, the id of the account at the provider. A user can link several identities.
+type Identity struct {
+	Provider  string
+	Subject   string
+	UserID    string
+	CreatedAt time.Time
+}
+
+// PasswordReset represents a password reset request, identified by the SHA-256 digest of its code
+type PasswordReset struct {
+	CodeHash  string
 	UserID    string
 	CreatedAt time.Time
 	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// Session represents a login session, identified by the sid claim of its tokens.
+// IP and UserAgent identify the device the session was started from.
+type Session struct {
+	ID        string
+	UserID    string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// EmailVerification represents an email verification code sent to a user.
+// PendingEmail is set for email changes, the new email replacing the current one once verified.
+// As for users, PendingEmailCiphertext holds the encrypted email when emails are encrypted at rest.
+type EmailVerification struct {
+	Code                   string
+	UserID                 string
+	CreatedAt              time.Time
+	ExpiresAt              time.Time
+	UsedAt                 *time.Time
+	PendingEmail           string
+	PendingEmailCiphertext string
 }
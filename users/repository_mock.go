@@ -3,6 +3,7 @@ package users
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/alesr/stdservices/users/repository"
 )
@@ -10,11 +11,58 @@ import (
 var _ repo = (*repositoryMock)(nil)
 
 type repositoryMock struct {
-	insertFunc                  func(ctx context.Context, user *repository.User) (*repository.User, error)
-	selectByIDFunc              func(ctx context.Context, id string) (*repository.User, error)
-	selectByEmailFunc           func(ctx context.Context, email string) (*repository.User, error)
-	deleteByIDFunc              func(ctx context.Context, id string) error
-	insertEmailVerificationFunc func(ctx context.Context, in repository.EmailVerification) error
+	insertFunc                           func(ctx context.Context, user *repository.User) (*repository.User, error)
+	selectByIDFunc                       func(ctx context.Context, id string) (*repository.User, error)
+	selectByEmailFunc                    func(ctx context.Context, email string) (*repository.User, error)
+	updateFunc                           func(ctx context.Context, user *repository.User) (*repository.User, error)
+	updatePasswordHashFunc               func(ctx context.Context, id, currentHash, newHash string) error
+	selectByIDWithDeletedFunc            func(ctx context.Context, id string) (*repository.User, error)
+	selectRoleByIDFunc                   func(ctx context.Context, id string) (string, error)
+	selectByUsernameFunc                 func(ctx context.Context, username string) (*repository.User, error)
+	selectByEmailWithDeletedFunc         func(ctx context.Context, email string) (*repository.User, error)
+	selectEmailVerificationFunc          func(ctx context.Context, code string) (*repository.EmailVerification, error)
+	selectEmailVerificationForUserFunc   func(ctx context.Context, userID, code string) (*repository.EmailVerification, error)
+	selectEmailVerificationsByUserIDFunc func(ctx context.Context, userID string) ([]repository.EmailVerification, error)
+	markEmailVerifiedFunc                func(ctx context.Context, code string) error
+	deleteByIDFunc                       func(ctx context.Context, id, reason, deletedBy string) error
+	restoreByIDFunc                      func(ctx context.Context, id string) error
+	anonymizeByIDFunc                    func(ctx context.Context, id, tombstone string, anonymizedAt time.Time) error
+	markPasswordResetRequestedFunc       func(ctx context.Context, id string, requestedAt, notBefore time.Time) (bool, error)
+	insertPasswordResetFunc              func(ctx context.Context, in repository.PasswordReset) error
+	selectPasswordResetFunc              func(ctx context.Context, codeHash string) (*repository.PasswordReset, error)
+	markPasswordResetUsedFunc            func(ctx context.Context, codeHash string, usedAt time.Time) error
+	insertEmailVerificationFunc          func(ctx context.Context, in repository.EmailVerification) error
+	selectUserStatsFunc                  func(ctx context.Context) (*repository.UserStats, error)
+	selectUsersFunc                      func(ctx context.Context, filter repository.UserFilter) ([]repository.User, error)
+	selectExistingEmailsFunc             func(ctx context.Context, emails []string) ([]string, error)
+	selectByPasswordChangedBeforeFunc    func(ctx context.Context, before time.Time, after *repository.PasswordChangedCursor, limit int) ([]repository.User, error)
+	insertSessionFunc                    func(ctx context.Context, in repository.Session, maxActive int, evictOldest bool) error
+	selectSessionFunc                    func(ctx context.Context, id string) (*repository.Session, error)
+	selectActiveSessionsByUserIDFunc     func(ctx context.Context, userID string, now time.Time) ([]repository.Session, error)
+	updateSessionExpiresAtFunc           func(ctx context.Context, id string, expiresAt time.Time) error
+	deleteSessionFunc                    func(ctx context.Context, id string) error
+	insertRefreshTokenFunc               func(ctx context.Context, in repository.RefreshToken) error
+	selectRefreshTokenFunc               func(ctx context.Context, tokenHash string) (*repository.RefreshToken, error)
+	revokeRefreshTokenFunc               func(ctx context.Context, tokenHash string, revokedAt time.Time) error
+	consumeRefreshTokenFunc              func(ctx context.Context, tokenHash string, consumedAt time.Time) error
+	revokeRefreshTokenFamilyFunc         func(ctx context.Context, familyID string, revokedAt time.Time) error
+	revokeRefreshTokensBySessionIDFunc   func(ctx context.Context, sessionID string, revokedAt time.Time) error
+	upsertTOTPFunc                       func(ctx context.Context, in repository.TOTP) error
+	selectTOTPFunc                       func(ctx context.Context, userID string) (*repository.TOTP, error)
+	confirmTOTPFunc                      func(ctx context.Context, userID string, confirmedAt time.Time) error
+	useTOTPStepFunc                      func(ctx context.Context, userID string, step int64) error
+	deleteTOTPFunc                       func(ctx context.Context, userID string) error
+	insertPasskeyFunc                    func(ctx context.Context, in repository.Passkey) error
+	selectPasskeyFunc                    func(ctx context.Context, id string) (*repository.Passkey, error)
+	selectPasskeysByUserIDFunc           func(ctx context.Context, userID string) ([]repository.Passkey, error)
+	updatePasskeySignCountFunc           func(ctx context.Context, id string, signCount int64, usedAt time.Time) error
+	insertIdentityFunc                   func(ctx context.Context, in repository.Identity) error
+	selectIdentityFunc                   func(ctx context.Context, provider, subject string) (*repository.Identity, error)
+	insertLoginEventFunc                 func(ctx context.Context, in repository.LoginEvent, keep int) error
+	selectLoginEventsFunc                func(ctx context.Context, userID string, before *repository.LoginEventCursor, limit int) ([]repository.LoginEvent, error)
+	selectLoginEventsByOutcomeFunc       func(ctx context.Context, userID, outcome string, limit int) ([]repository.LoginEvent, error)
+	insertPasswordHistoryFunc            func(ctx context.Context, userID, passwordHash string, createdAt time.Time, keep int) error
+	selectPasswordHistoryFunc            func(ctx context.Context, userID string) ([]string, error)
 }
 
 func (m *repositoryMock) Insert(ctx context.Context, user *repository.User) (*repository.User, error) {
@@ -38,11 +86,123 @@ func (m *repositoryMock) SelectByEmail(ctx context.Context, email string) (*repo
 	return m.selectByEmailFunc(ctx, email)
 }
 
-func (m *repositoryMock) DeleteByID(ctx context.Context, id string) error {
+func (m *repositoryMock) Update(ctx context.Context, user *repository.User) (*repository.User, error) {
+	if m.updateFunc == nil {
+		return nil, errors.New("repositoryMock.updateFunc is nil")
+	}
+	return m.updateFunc(ctx, user)
+}
+
+func (m *repositoryMock) UpdatePasswordHash(ctx context.Context, id, currentHash, newHash string) error {
+	if m.updatePasswordHashFunc == nil {
+		return errors.New("repositoryMock.updatePasswordHashFunc is nil")
+	}
+	return m.updatePasswordHashFunc(ctx, id, currentHash, newHash)
+}
+
+func (m *repositoryMock) SelectByIDWithDeleted(ctx context.Context, id string) (*repository.User, error) {
+	if m.selectByIDWithDeletedFunc == nil {
+		return nil, errors.New("repositoryMock.selectByIDWithDeletedFunc is nil")
+	}
+	return m.selectByIDWithDeletedFunc(ctx, id)
+}
+
+func (m *repositoryMock) SelectRoleByID(ctx context.Context, id string) (string, error) {
+	if m.selectRoleByIDFunc == nil {
+		return "", errors.New("repositoryMock.selectRoleByIDFunc is nil")
+	}
+	return m.selectRoleByIDFunc(ctx, id)
+}
+
+func (m *repositoryMock) SelectByUsername(ctx context.Context, username string) (*repository.User, error) {
+	if m.selectByUsernameFunc == nil {
+		return nil, errors.New("repositoryMock.selectByUsernameFunc is nil")
+	}
+	return m.selectByUsernameFunc(ctx, username)
+}
+
+func (m *repositoryMock) SelectByEmailWithDeleted(ctx context.Context, email string) (*repository.User, error) {
+	if m.selectByEmailWithDeletedFunc == nil {
+		return nil, errors.New("repositoryMock.selectByEmailWithDeletedFunc is nil")
+	}
+	return m.selectByEmailWithDeletedFunc(ctx, email)
+}
+
+func (m *repositoryMock) RestoreByID(ctx context.Context, id string) error {
+	if m.restoreByIDFunc == nil {
+		return errors.New("repositoryMock.restoreByIDFunc is nil")
+	}
+	return m.restoreByIDFunc(ctx, id)
+}
+
+func (m *repositoryMock) AnonymizeByID(ctx context.Context, id, tombstone string, anonymizedAt time.Time) error {
+	if m.anonymizeByIDFunc == nil {
+		return errors.New("repositoryMock.anonymizeByIDFunc is nil")
+	}
+	return m.anonymizeByIDFunc(ctx, id, tombstone, anonymizedAt)
+}
+
+func (m *repositoryMock) MarkPasswordResetRequested(ctx context.Context, id string, requestedAt, notBefore time.Time) (bool, error) {
+	if m.markPasswordResetRequestedFunc == nil {
+		return false, errors.New("repositoryMock.markPasswordResetRequestedFunc is nil")
+	}
+	return m.markPasswordResetRequestedFunc(ctx, id, requestedAt, notBefore)
+}
+
+func (m *repositoryMock) SelectEmailVerification(ctx context.Context, code string) (*repository.EmailVerification, error) {
+	if m.selectEmailVerificationFunc == nil {
+		return nil, errors.New("repositoryMock.selectEmailVerificationFunc is nil")
+	}
+	return m.selectEmailVerificationFunc(ctx, code)
+}
+
+func (m *repositoryMock) SelectEmailVerificationForUser(ctx context.Context, userID, code string) (*repository.EmailVerification, error) {
+	if m.selectEmailVerificationForUserFunc == nil {
+		return nil, errors.New("repositoryMock.selectEmailVerificationForUserFunc is nil")
+	}
+	return m.selectEmailVerificationForUserFunc(ctx, userID, code)
+}
+
+func (m *repositoryMock) SelectEmailVerificationsByUserID(ctx context.Context, userID string) ([]repository.EmailVerification, error) {
+	if m.selectEmailVerificationsByUserIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectEmailVerificationsByUserIDFunc is nil")
+	}
+	return m.selectEmailVerificationsByUserIDFunc(ctx, userID)
+}
+
+func (m *repositoryMock) MarkEmailVerified(ctx context.Context, code string) error {
+	if m.markEmailVerifiedFunc == nil {
+		return errors.New("repositoryMock.markEmailVerifiedFunc is nil")
+	}
+	return m.markEmailVerifiedFunc(ctx, code)
+}
+
+func (m *repositoryMock) DeleteByID(ctx context.Context, id, reason, deletedBy string) error {
 	if m.deleteByIDFunc == nil {
 		return errors.New("repositoryMock.deleteByIDfunc is nil")
 	}
-	return m.deleteByIDFunc(ctx, id)
+	return m.deleteByIDFunc(ctx, id, reason, deletedBy)
+}
+
+func (m *repositoryMock) InsertPasswordReset(ctx context.Context, in repository.PasswordReset) error {
+	if m.insertPasswordResetFunc == nil {
+		return errors.New("repositoryMock.insertPasswordResetFunc is nil")
+	}
+	return m.insertPasswordResetFunc(ctx, in)
+}
+
+func (m *repositoryMock) SelectPasswordReset(ctx context.Context, codeHash string) (*repository.PasswordReset, error) {
+	if m.selectPasswordResetFunc == nil {
+		return nil, errors.New("repositoryMock.selectPasswordResetFunc is nil")
+	}
+	return m.selectPasswordResetFunc(ctx, codeHash)
+}
+
+func (m *repositoryMock) MarkPasswordResetUsed(ctx context.Context, codeHash string, usedAt time.Time) error {
+	if m.markPasswordResetUsedFunc == nil {
+		return errors.New("repositoryMock.markPasswordResetUsedFunc is nil")
+	}
+	return m.markPasswordResetUsedFunc(ctx, codeHash, usedAt)
 }
 
 func (m *repositoryMock) InsertEmailVerification(ctx context.Context, in repository.EmailVerification) error {
@@ -51,3 +211,220 @@ func (m *repositoryMock) InsertEmailVerification(ctx context.Context, in reposit
 	}
 	return m.insertEmailVerificationFunc(ctx, in)
 }
+
+func (m *repositoryMock) SelectUserStats(ctx context.Context) (*repository.UserStats, error) {
+	if m.selectUserStatsFunc == nil {
+		return nil, errors.New("repositoryMock.selectUserStatsFunc is nil")
+	}
+	return m.selectUserStatsFunc(ctx)
+}
+
+func (m *repositoryMock) SelectExistingEmails(ctx context.Context, emails []string) ([]string, error) {
+	if m.selectExistingEmailsFunc == nil {
+		return nil, errors.New("repositoryMock.selectExistingEmailsFunc is nil")
+	}
+	return m.selectExistingEmailsFunc(ctx, emails)
+}
+
+func (m *repositoryMock) SelectByPasswordChangedBefore(ctx context.Context, before time.Time, after *repository.PasswordChangedCursor, limit int) ([]repository.User, error) {
+	if m.selectByPasswordChangedBeforeFunc == nil {
+		return nil, errors.New("repositoryMock.selectByPasswordChangedBeforeFunc is nil")
+	}
+	return m.selectByPasswordChangedBeforeFunc(ctx, before, after, limit)
+}
+
+func (m *repositoryMock) InsertSession(ctx context.Context, in repository.Session, maxActive int, evictOldest bool) error {
+	if m.insertSessionFunc == nil {
+		return errors.New("repositoryMock.insertSessionFunc is nil")
+	}
+	return m.insertSessionFunc(ctx, in, maxActive, evictOldest)
+}
+
+func (m *repositoryMock) SelectSession(ctx context.Context, id string) (*repository.Session, error) {
+	if m.selectSessionFunc == nil {
+		return nil, errors.New("repositoryMock.selectSessionFunc is nil")
+	}
+	return m.selectSessionFunc(ctx, id)
+}
+
+func (m *repositoryMock) SelectActiveSessionsByUserID(ctx context.Context, userID string, now time.Time) ([]repository.Session, error) {
+	if m.selectActiveSessionsByUserIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectActiveSessionsByUserIDFunc is nil")
+	}
+	return m.selectActiveSessionsByUserIDFunc(ctx, userID, now)
+}
+
+func (m *repositoryMock) UpdateSessionExpiresAt(ctx context.Context, id string, expiresAt time.Time) error {
+	if m.updateSessionExpiresAtFunc == nil {
+		return errors.New("repositoryMock.updateSessionExpiresAtFunc is nil")
+	}
+	return m.updateSessionExpiresAtFunc(ctx, id, expiresAt)
+}
+
+func (m *repositoryMock) DeleteSession(ctx context.Context, id string) error {
+	if m.deleteSessionFunc == nil {
+		return errors.New("repositoryMock.deleteSessionFunc is nil")
+	}
+	return m.deleteSessionFunc(ctx, id)
+}
+
+func (m *repositoryMock) InsertLoginEvent(ctx context.Context, in repository.LoginEvent, keep int) error {
+	if m.insertLoginEventFunc == nil {
+		return errors.New("repositoryMock.insertLoginEventFunc is nil")
+	}
+	return m.insertLoginEventFunc(ctx, in, keep)
+}
+
+func (m *repositoryMock) SelectLoginEvents(ctx context.Context, userID string, before *repository.LoginEventCursor, limit int) ([]repository.LoginEvent, error) {
+	if m.selectLoginEventsFunc == nil {
+		return nil, errors.New("repositoryMock.selectLoginEventsFunc is nil")
+	}
+	return m.selectLoginEventsFunc(ctx, userID, before, limit)
+}
+
+func (m *repositoryMock) SelectLoginEventsByOutcome(ctx context.Context, userID, outcome string, limit int) ([]repository.LoginEvent, error) {
+	if m.selectLoginEventsByOutcomeFunc == nil {
+		return nil, errors.New("repositoryMock.selectLoginEventsByOutcomeFunc is nil")
+	}
+	return m.selectLoginEventsByOutcomeFunc(ctx, userID, outcome, limit)
+}
+
+func (m *repositoryMock) InsertPasswordHistory(ctx context.Context, userID, passwordHash string, createdAt time.Time, keep int) error {
+	if m.insertPasswordHistoryFunc == nil {
+		return errors.New("repositoryMock.insertPasswordHistoryFunc is nil")
+	}
+	return m.insertPasswordHistoryFunc(ctx, userID, passwordHash, createdAt, keep)
+}
+
+func (m *repositoryMock) SelectPasswordHistory(ctx context.Context, userID string) ([]string, error) {
+	if m.selectPasswordHistoryFunc == nil {
+		return nil, errors.New("repositoryMock.selectPasswordHistoryFunc is nil")
+	}
+	return m.selectPasswordHistoryFunc(ctx, userID)
+}
+
+func (m *repositoryMock) InsertRefreshToken(ctx context.Context, in repository.RefreshToken) error {
+	if m.insertRefreshTokenFunc == nil {
+		return errors.New("repositoryMock.insertRefreshTokenFunc is nil")
+	}
+	return m.insertRefreshTokenFunc(ctx, in)
+}
+
+func (m *repositoryMock) SelectRefreshToken(ctx context.Context, tokenHash string) (*repository.RefreshToken, error) {
+	if m.selectRefreshTokenFunc == nil {
+		return nil, errors.New("repositoryMock.selectRefreshTokenFunc is nil")
+	}
+	return m.selectRefreshTokenFunc(ctx, tokenHash)
+}
+
+func (m *repositoryMock) RevokeRefreshToken(ctx context.Context, tokenHash string, revokedAt time.Time) error {
+	if m.revokeRefreshTokenFunc == nil {
+		return errors.New("repositoryMock.revokeRefreshTokenFunc is nil")
+	}
+	return m.revokeRefreshTokenFunc(ctx, tokenHash, revokedAt)
+}
+
+func (m *repositoryMock) ConsumeRefreshToken(ctx context.Context, tokenHash string, consumedAt time.Time) error {
+	if m.consumeRefreshTokenFunc == nil {
+		return errors.New("repositoryMock.consumeRefreshTokenFunc is nil")
+	}
+	return m.consumeRefreshTokenFunc(ctx, tokenHash, consumedAt)
+}
+
+func (m *repositoryMock) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	if m.revokeRefreshTokenFamilyFunc == nil {
+		return errors.New("repositoryMock.revokeRefreshTokenFamilyFunc is nil")
+	}
+	return m.revokeRefreshTokenFamilyFunc(ctx, familyID, revokedAt)
+}
+
+func (m *repositoryMock) RevokeRefreshTokensBySessionID(ctx context.Context, sessionID string, revokedAt time.Time) error {
+	if m.revokeRefreshTokensBySessionIDFunc == nil {
+		return errors.New("repositoryMock.revokeRefreshTokensBySessionIDFunc is nil")
+	}
+	return m.revokeRefreshTokensBySessionIDFunc(ctx, sessionID, revokedAt)
+}
+
+func (m *repositoryMock) UpsertTOTP(ctx context.Context, in repository.TOTP) error {
+	if m.upsertTOTPFunc == nil {
+		return errors.New("repositoryMock.upsertTOTPFunc is nil")
+	}
+	return m.upsertTOTPFunc(ctx, in)
+}
+
+func (m *repositoryMock) SelectTOTP(ctx context.Context, userID string) (*repository.TOTP, error) {
+	if m.selectTOTPFunc == nil {
+		return nil, errors.New("repositoryMock.selectTOTPFunc is nil")
+	}
+	return m.selectTOTPFunc(ctx, userID)
+}
+
+func (m *repositoryMock) ConfirmTOTP(ctx context.Context, userID string, confirmedAt time.Time) error {
+	if m.confirmTOTPFunc == nil {
+		return errors.New("repositoryMock.confirmTOTPFunc is nil")
+	}
+	return m.confirmTOTPFunc(ctx, userID, confirmedAt)
+}
+
+func (m *repositoryMock) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	if m.useTOTPStepFunc == nil {
+		return errors.New("repositoryMock.useTOTPStepFunc is nil")
+	}
+	return m.useTOTPStepFunc(ctx, userID, step)
+}
+
+func (m *repositoryMock) DeleteTOTP(ctx context.Context, userID string) error {
+	if m.deleteTOTPFunc == nil {
+		return errors.New("repositoryMock.deleteTOTPFunc is nil")
+	}
+	return m.deleteTOTPFunc(ctx, userID)
+}
+
+func (m *repositoryMock) InsertPasskey(ctx context.Context, in repository.Passkey) error {
+	if m.insertPasskeyFunc == nil {
+		return errors.New("repositoryMock.insertPasskeyFunc is nil")
+	}
+	return m.insertPasskeyFunc(ctx, in)
+}
+
+func (m *repositoryMock) SelectPasskey(ctx context.Context, id string) (*repository.Passkey, error) {
+	if m.selectPasskeyFunc == nil {
+		return nil, errors.New("repositoryMock.selectPasskeyFunc is nil")
+	}
+	return m.selectPasskeyFunc(ctx, id)
+}
+
+func (m *repositoryMock) SelectPasskeysByUserID(ctx context.Context, userID string) ([]repository.Passkey, error) {
+	if m.selectPasskeysByUserIDFunc == nil {
+		return nil, errors.New("repositoryMock.selectPasskeysByUserIDFunc is nil")
+	}
+	return m.selectPasskeysByUserIDFunc(ctx, userID)
+}
+
+func (m *repositoryMock) UpdatePasskeySignCount(ctx context.Context, id string, signCount int64, usedAt time.Time) error {
+	if m.updatePasskeySignCountFunc == nil {
+		return errors.New("repositoryMock.updatePasskeySignCountFunc is nil")
+	}
+	return m.updatePasskeySignCountFunc(ctx, id, signCount, usedAt)
+}
+
+func (m *repositoryMock) InsertIdentity(ctx context.Context, in repository.Identity) error {
+	if m.insertIdentityFunc == nil {
+		return errors.New("repositoryMock.insertIdentityFunc is nil")
+	}
+	return m.insertIdentityFunc(ctx, in)
+}
+
+func (m *repositoryMock) SelectIdentity(ctx context.Context, provider, subject string) (*repository.Identity, error) {
+	if m.selectIdentityFunc == nil {
+		return nil, errors.New("repositoryMock.selectIdentityFunc is nil")
+	}
+	return m.selectIdentityFunc(ctx, provider, subject)
+}
+
+func (m *repositoryMock) SelectUsers(ctx context.Context, filter repository.UserFilter) ([]repository.User, error) {
+	if m.selectUsersFunc == nil {
+		return nil, errors.New("repositoryMock.selectUsersFunc is nil")
+	}
+	return m.selectUsersFunc(ctx, filter)
+}
@@ -0,0 +1,293 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+var _ repo = (*retryRepo)(nil)
+
+// WithRepoRetry retries repository reads and idempotent writes failing with an error
+// for which isRetryable returns true, up to attempts times in total.
+// The wait between attempts starts at initialBackoff and doubles after each attempt
+// (see WithRetryBackoffCeiling and WithRetryJitter).
+// When isRetryable is nil, transient Postgres errors are retried.
+func WithRepoRetry(attempts int, initialBackoff time.Duration, isRetryable func(error) bool) ServiceOption {
+	return func(s *DefaultService) {
+		if isRetryable == nil {
+			isRetryable = repository.IsTransient
+		}
+
+		s.repoRetry = &retryPolicy{
+			backoff:     backoff{attempts: attempts, initial: initialBackoff},
+			isRetryable: isRetryable,
+		}
+	}
+}
+
+type retryPolicy struct {
+	backoff
+	isRetryable func(error) bool
+}
+
+// do calls fn until it succeeds, fails with a non-retryable error or the attempts are exhausted.
+// It stops waiting and returns the context error as soon as ctx is done.
+func (p *retryPolicy) do(ctx context.Context, fn func() error) error {
+	return p.retry(ctx, fn, p.isRetryable)
+}
+
+// retryRepo wraps a repository and retries its reads and idempotent writes.
+// Non-idempotent writes are passed through untouched: inserts, and the writes whose retry
+// after a lost commit would fail or overwrite the first attempt (e.g. RestoreByID, DeleteByID).
+type retryRepo struct {
+	repo
+	policy *retryPolicy
+}
+
+func (r *retryRepo) SelectByID(ctx context.Context, id string) (*repository.User, error) {
+	var user *repository.User
+	err := r.policy.do(ctx, func() (err error) {
+		user, err = r.repo.SelectByID(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (r *retryRepo) SelectByEmail(ctx context.Context, email string) (*repository.User, error) {
+	var user *repository.User
+	err := r.policy.do(ctx, func() (err error) {
+		user, err = r.repo.SelectByEmail(ctx, email)
+		return err
+	})
+	return user, err
+}
+
+func (r *retryRepo) SelectByUsername(ctx context.Context, username string) (*repository.User, error) {
+	var user *repository.User
+	err := r.policy.do(ctx, func() (err error) {
+		user, err = r.repo.SelectByUsername(ctx, username)
+		return err
+	})
+	return user, err
+}
+
+func (r *retryRepo) SelectByIDWithDeleted(ctx context.Context, id string) (*repository.User, error) {
+	var user *repository.User
+	err := r.policy.do(ctx, func() (err error) {
+		user, err = r.repo.SelectByIDWithDeleted(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (r *retryRepo) SelectRoleByID(ctx context.Context, id string) (string, error) {
+	var role string
+	err := r.policy.do(ctx, func() (err error) {
+		role, err = r.repo.SelectRoleByID(ctx, id)
+		return err
+	})
+	return role, err
+}
+
+func (r *retryRepo) SelectByEmailWithDeleted(ctx context.Context, email string) (*repository.User, error) {
+	var user *repository.User
+	err := r.policy.do(ctx, func() (err error) {
+		user, err = r.repo.SelectByEmailWithDeleted(ctx, email)
+		return err
+	})
+	return user, err
+}
+
+func (r *retryRepo) SelectEmailVerification(ctx context.Context, code string) (*repository.EmailVerification, error) {
+	var verification *repository.EmailVerification
+	err := r.policy.do(ctx, func() (err error) {
+		verification, err = r.repo.SelectEmailVerification(ctx, code)
+		return err
+	})
+	return verification, err
+}
+
+func (r *retryRepo) SelectEmailVerificationForUser(ctx context.Context, userID, code string) (*repository.EmailVerification, error) {
+	var verification *repository.EmailVerification
+	err := r.policy.do(ctx, func() (err error) {
+		verification, err = r.repo.SelectEmailVerificationForUser(ctx, userID, code)
+		return err
+	})
+	return verification, err
+}
+
+func (r *retryRepo) SelectEmailVerificationsByUserID(ctx context.Context, userID string) ([]repository.EmailVerification, error) {
+	var verifications []repository.EmailVerification
+	err := r.policy.do(ctx, func() (err error) {
+		verifications, err = r.repo.SelectEmailVerificationsByUserID(ctx, userID)
+		return err
+	})
+	return verifications, err
+}
+
+func (r *retryRepo) SelectUserStats(ctx context.Context) (*repository.UserStats, error) {
+	var stats *repository.UserStats
+	err := r.policy.do(ctx, func() (err error) {
+		stats, err = r.repo.SelectUserStats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (r *retryRepo) SelectUsers(ctx context.Context, filter repository.UserFilter) ([]repository.User, error) {
+	var users []repository.User
+	err := r.policy.do(ctx, func() (err error) {
+		users, err = r.repo.SelectUsers(ctx, filter)
+		return err
+	})
+	return users, err
+}
+
+func (r *retryRepo) SelectExistingEmails(ctx context.Context, emails []string) ([]string, error) {
+	var existing []string
+	err := r.policy.do(ctx, func() (err error) {
+		existing, err = r.repo.SelectExistingEmails(ctx, emails)
+		return err
+	})
+	return existing, err
+}
+
+func (r *retryRepo) SelectByPasswordChangedBefore(ctx context.Context, before time.Time, after *repository.PasswordChangedCursor, limit int) ([]repository.User, error) {
+	var users []repository.User
+	err := r.policy.do(ctx, func() (err error) {
+		users, err = r.repo.SelectByPasswordChangedBefore(ctx, before, after, limit)
+		return err
+	})
+	return users, err
+}
+
+func (r *retryRepo) SelectSession(ctx context.Context, id string) (*repository.Session, error) {
+	var session *repository.Session
+	err := r.policy.do(ctx, func() (err error) {
+		session, err = r.repo.SelectSession(ctx, id)
+		return err
+	})
+	return session, err
+}
+
+func (r *retryRepo) SelectActiveSessionsByUserID(ctx context.Context, userID string, now time.Time) ([]repository.Session, error) {
+	var sessions []repository.Session
+	err := r.policy.do(ctx, func() (err error) {
+		sessions, err = r.repo.SelectActiveSessionsByUserID(ctx, userID, now)
+		return err
+	})
+	return sessions, err
+}
+
+func (r *retryRepo) UpdateSessionExpiresAt(ctx context.Context, id string, expiresAt time.Time) error {
+	return r.policy.do(ctx, func() error {
+		return r.repo.UpdateSessionExpiresAt(ctx, id, expiresAt)
+	})
+}
+
+func (r *retryRepo) DeleteSession(ctx context.Context, id string) error {
+	return r.policy.do(ctx, func() error {
+		return r.repo.DeleteSession(ctx, id)
+	})
+}
+
+func (r *retryRepo) SelectLoginEvents(ctx context.Context, userID string, before *repository.LoginEventCursor, limit int) ([]repository.LoginEvent, error) {
+	var events []repository.LoginEvent
+	err := r.policy.do(ctx, func() (err error) {
+		events, err = r.repo.SelectLoginEvents(ctx, userID, before, limit)
+		return err
+	})
+	return events, err
+}
+
+func (r *retryRepo) SelectLoginEventsByOutcome(ctx context.Context, userID, outcome string, limit int) ([]repository.LoginEvent, error) {
+	var events []repository.LoginEvent
+	err := r.policy.do(ctx, func() (err error) {
+		events, err = r.repo.SelectLoginEventsByOutcome(ctx, userID, outcome, limit)
+		return err
+	})
+	return events, err
+}
+
+func (r *retryRepo) SelectPasswordHistory(ctx context.Context, userID string) ([]string, error) {
+	var hashes []string
+	err := r.policy.do(ctx, func() (err error) {
+		hashes, err = r.repo.SelectPasswordHistory(ctx, userID)
+		return err
+	})
+	return hashes, err
+}
+
+func (r *retryRepo) SelectRefreshToken(ctx context.Context, tokenHash string) (*repository.RefreshToken, error) {
+	var token *repository.RefreshToken
+	err := r.policy.do(ctx, func() (err error) {
+		token, err = r.repo.SelectRefreshToken(ctx, tokenHash)
+		return err
+	})
+	return token, err
+}
+
+func (r *retryRepo) SelectPasswordReset(ctx context.Context, codeHash string) (*repository.PasswordReset, error) {
+	var reset *repository.PasswordReset
+	err := r.policy.do(ctx, func() (err error) {
+		reset, err = r.repo.SelectPasswordReset(ctx, codeHash)
+		return err
+	})
+	return reset, err
+}
+
+func (r *retryRepo) SelectTOTP(ctx context.Context, userID string) (*repository.TOTP, error) {
+	var totp *repository.TOTP
+	err := r.policy.do(ctx, func() (err error) {
+		totp, err = r.repo.SelectTOTP(ctx, userID)
+		return err
+	})
+	return totp, err
+}
+
+func (r *retryRepo) SelectPasskey(ctx context.Context, id string) (*repository.Passkey, error) {
+	var passkey *repository.Passkey
+	err := r.policy.do(ctx, func() (err error) {
+		passkey, err = r.repo.SelectPasskey(ctx, id)
+		return err
+	})
+	return passkey, err
+}
+
+func (r *retryRepo) SelectPasskeysByUserID(ctx context.Context, userID string) ([]repository.Passkey, error) {
+	var passkeys []repository.Passkey
+	err := r.policy.do(ctx, func() (err error) {
+		passkeys, err = r.repo.SelectPasskeysByUserID(ctx, userID)
+		return err
+	})
+	return passkeys, err
+}
+
+func (r *retryRepo) UpdatePasskeySignCount(ctx context.Context, id string, signCount int64, usedAt time.Time) error {
+	return r.policy.do(ctx, func() error {
+		return r.repo.UpdatePasskeySignCount(ctx, id, signCount, usedAt)
+	})
+}
+
+func (r *retryRepo) SelectIdentity(ctx context.Context, provider, subject string) (*repository.Identity, error) {
+	var identity *repository.Identity
+	err := r.policy.do(ctx, func() (err error) {
+		identity, err = r.repo.SelectIdentity(ctx, provider, subject)
+		return err
+	})
+	return identity, err
+}
+
+func (r *retryRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return r.policy.do(ctx, func() error {
+		return r.repo.RevokeRefreshTokenFamily(ctx, familyID, revokedAt)
+	})
+}
+
+func (r *retryRepo) RevokeRefreshTokensBySessionID(ctx context.Context, sessionID string, revokedAt time.Time) error {
+	return r.policy.do(ctx, func() error {
+		return r.repo.RevokeRefreshTokensBySessionID(ctx, sessionID, revokedAt)
+	})
+}
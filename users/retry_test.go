@@ -0,0 +1,145 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRetryPolicy_do(t *testing.T) {
+	t.Parallel()
+
+	errTransient := errors.New("transient error")
+
+	isRetryable := func(err error) bool {
+		return errors.Is(err, errTransient)
+	}
+
+	testCases := []struct {
+		name          string
+		givenErrs     []error
+		expectedCalls int
+		expectedError error
+	}{
+		{
+			name:          "success on first attempt",
+			givenErrs:     []error{nil},
+			expectedCalls: 1,
+			expectedError: nil,
+		},
+		{
+			name:          "success after transient errors",
+			givenErrs:     []error{errTransient, errTransient, nil},
+			expectedCalls: 3,
+			expectedError: nil,
+		},
+		{
+			name:          "attempts exhausted",
+			givenErrs:     []error{errTransient, errTransient, errTransient, nil},
+			expectedCalls: 3,
+			expectedError: errTransient,
+		},
+		{
+			name:          "non-retryable error",
+			givenErrs:     []error{errors.New("some error"), nil},
+			expectedCalls: 1,
+			expectedError: errors.New("some error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := retryPolicy{backoff: backoff{attempts: 3, initial: time.Millisecond}, isRetryable: isRetryable}
+
+			var calls int
+			err := policy.do(context.Background(), func() error {
+				err := tc.givenErrs[calls]
+				calls++
+				return err
+			})
+
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedCalls, calls)
+		})
+	}
+
+	t.Run("context is cancelled between attempts", func(t *testing.T) {
+		policy := retryPolicy{backoff: backoff{attempts: 3, initial: time.Hour}, isRetryable: isRetryable}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var calls int
+		err := policy.do(ctx, func() error {
+			calls++
+			cancel()
+			return errTransient
+		})
+
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestWithRepoRetry(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				calls++
+				if calls == 1 {
+					return nil, &pgconn.PgError{Code: pgerrcode.DeadlockDetected}
+				}
+				return &repository.User{ID: id, Role: string(RoleUser)}, nil
+			},
+		},
+		WithRepoRetry(3, time.Millisecond, nil),
+	)
+
+	givenID := uuid.New().String()
+
+	user, err := svc.FetchByID(context.Background(), givenID)
+	require.NoError(t, err)
+
+	assert.Equal(t, givenID, user.ID)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryRepo_nonIdempotentWrites(t *testing.T) {
+	t.Parallel()
+
+	var restoreCalls, deleteCalls int
+
+	r := &retryRepo{
+		repo: &repositoryMock{
+			restoreByIDFunc: func(ctx context.Context, id string) error {
+				restoreCalls++
+				return &pgconn.PgError{Code: pgerrcode.DeadlockDetected}
+			},
+			deleteByIDFunc: func(ctx context.Context, id, reason, deletedBy string) error {
+				deleteCalls++
+				return &pgconn.PgError{Code: pgerrcode.DeadlockDetected}
+			},
+		},
+		policy: &retryPolicy{backoff: backoff{attempts: 3, initial: time.Millisecond}, isRetryable: repository.IsTransient},
+	}
+
+	// A retry after a lost commit would report a restored user as not found
+	require.Error(t, r.RestoreByID(context.Background(), uuid.New().String()))
+	assert.Equal(t, 1, restoreCalls)
+
+	// A retry after a lost commit would overwrite the deletion details
+	require.Error(t, r.DeleteByID(context.Background(), uuid.New().String(), "spam", "admin"))
+	assert.Equal(t, 1, deleteCalls)
+}
@@ -0,0 +1,248 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	_ TokenRevocationStore = (*MemoryTokenRevocationStore)(nil)
+	_ TokenRevocationStore = (*RedisTokenRevocationStore)(nil)
+)
+
+// defaultRevocationKeyPrefix prefixes the keys of the revoked tokens stored in Redis
+const defaultRevocationKeyPrefix = "users:revoked-token:"
+
+type (
+	// TokenRevocationStore records the ids of the tokens revoked before their expiration (see RevokeToken).
+	// Implementations backed by a shared store (e.g. Redis) reject revoked tokens across service replicas.
+	TokenRevocationStore interface {
+		// Revoke records the token id as revoked. The record is only needed until expiresAt,
+		// after which the token is rejected as expired anyway.
+		Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error
+
+		// IsRevoked reports whether the token id was revoked
+		IsRevoked(ctx context.Context, tokenID string) (bool, error)
+
+		// Consume atomically records the id of a single-use credential as used until expiresAt,
+		// reporting false when it was already recorded. Concurrent calls for an id succeed only once.
+		Consume(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+	}
+
+	// RedisClient is the subset of a Redis client used by RedisTokenRevocationStore,
+	// letting callers adapt the client library of their choice
+	RedisClient interface {
+		// Set sets key to value, expiring it after ttl
+		Set(ctx context.Context, key, value string, ttl time.Duration) error
+
+		// Exists reports whether key is set
+		Exists(ctx context.Context, key string) (bool, error)
+
+		// SetNX sets key to value, expiring it after ttl, only if key is not set (SET NX),
+		// reporting whether key was set
+		SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	}
+)
+
+// WithTokenRevocationStore sets the store of the tokens revoked with RevokeToken, which VerifyToken
+// and RefreshToken consult, and of the single-use credentials consumed (login links and passkey ceremonies).
+// When not set, an in-memory store is used, which only rejects the tokens revoked and the credentials consumed
+// through the same service instance. A shared store is required to use login links or passkeys with several
+// replicas, as they could otherwise be replayed on another replica or after a restart.
+func WithTokenRevocationStore(store TokenRevocationStore) ServiceOption {
+	return func(s *DefaultService) {
+		s.revocationStore = store
+	}
+}
+
+// RevokeToken revokes a single token before its expiration (e.g. on logout), leaving the other
+// tokens of the user valid. Expired tokens need no revocation and are ignored. Revoking a token of
+// a sliding session (see WithSlidingSession) revokes the tokens reissued from the same login as well.
+// All the tokens of a user are revoked with RevokeUserTokens.
+func (s *DefaultService) RevokeToken(ctx context.Context, token string) error {
+	claims, err := s.parseAndValidateClaims(token)
+	if err != nil {
+		if errors.Is(err, errTokenExpired) || errors.Is(err, errSessionExpired) {
+			return nil
+		}
+		return err
+	}
+
+	// Tokens issued before tokens had ids can only be revoked along with the other tokens of the user
+	if claims.Id == "" {
+		return errTokenIDMissing
+	}
+
+	if s.revocationStore == nil {
+		return errors.New("could not revoke token: token revocation store not configured")
+	}
+
+	// The tokens reissued by a sliding session share the id of the revoked one and can last until its deadline
+	expiresAt := claims.ExpiresAt
+	if claims.MaxSession > expiresAt {
+		expiresAt = claims.MaxSession
+	}
+
+	if err := s.revocationStore.Revoke(ctx, claims.Id, time.Unix(expiresAt, 0)); err != nil {
+		s.logger.Error("could not revoke token", zap.String("operation", "revoke_token"), userIDField(claims.UserID), errorField(err))
+		return wrapErr(ctx, "could not revoke token", err)
+	}
+
+	s.logger.Info("user token revoked", zap.String("operation", "revoke_token"), userIDField(claims.UserID))
+	return nil
+}
+
+// checkTokenRevoked returns errTokenRevoked when the token was revoked with RevokeToken
+func (s *DefaultService) checkTokenRevoked(ctx context.Context, claims *jwtClaim) error {
+	if s.revocationStore == nil || claims.Id == "" {
+		return nil
+	}
+
+	revoked, err := s.revocationStore.IsRevoked(ctx, claims.Id)
+	if err != nil {
+		s.logger.Error("could not check token revocation", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(err))
+		return wrapErr(ctx, "could not check token revocation", err)
+	}
+
+	if revoked {
+		return errTokenRevoked
+	}
+	return nil
+}
+
+// consumeOnce records the id of a single-use credential (e.g. a passkey ceremony) in the token revocation
+// store until expiresAt, reporting false when the id was already recorded. It fails without a store,
+// rather than accepting credentials it can't record as used.
+func (s *DefaultService) consumeOnce(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	if s.revocationStore == nil {
+		return false, errors.New("could not consume credential: token revocation store not configured")
+	}
+	return s.revocationStore.Consume(ctx, id, expiresAt)
+}
+
+// MemoryTokenRevocationStore is a TokenRevocationStore keeping the revoked tokens in memory
+// until they expire. It is safe for concurrent use.
+type MemoryTokenRevocationStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	now     func() time.Time
+}
+
+// NewMemoryTokenRevocationStore returns an empty in-memory token revocation store
+func NewMemoryTokenRevocationStore() *MemoryTokenRevocationStore {
+	return &MemoryTokenRevocationStore{
+		revoked: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Revoke records the token id as revoked until expiresAt, dropping the expired records
+func (m *MemoryTokenRevocationStore) Revoke(_ context.Context, tokenID string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+
+	m.revoked[tokenID] = expiresAt
+	return nil
+}
+
+// prune drops the expired records. The caller must hold the lock.
+func (m *MemoryTokenRevocationStore) prune() {
+	now := m.now()
+	for id, exp := range m.revoked {
+		if !now.Before(exp) {
+			delete(m.revoked, id)
+		}
+	}
+}
+
+// IsRevoked reports whether the token id was revoked and its record has not expired
+func (m *MemoryTokenRevocationStore) IsRevoked(_ context.Context, tokenID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt, ok := m.revoked[tokenID]
+	return ok && m.now().Before(expiresAt), nil
+}
+
+// Consume records the id as used until expiresAt, reporting false when it was already recorded.
+// Expired ids can't be consumed.
+func (m *MemoryTokenRevocationStore) Consume(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+
+	if _, ok := m.revoked[id]; ok || !m.now().Before(expiresAt) {
+		return false, nil
+	}
+
+	m.revoked[id] = expiresAt
+	return true, nil
+}
+
+// RedisTokenRevocationStore is a TokenRevocationStore keeping the revoked tokens in Redis,
+// as keys expiring along with the tokens
+type RedisTokenRevocationStore struct {
+	client RedisClient
+	prefix string
+	now    func() time.Time
+}
+
+// NewRedisTokenRevocationStore returns a token revocation store backed by the Redis client.
+// Keys are prefixed with prefix, or with "users:revoked-token:" when prefix is empty.
+func NewRedisTokenRevocationStore(client RedisClient, prefix string) *RedisTokenRevocationStore {
+	if prefix == "" {
+		prefix = defaultRevocationKeyPrefix
+	}
+
+	return &RedisTokenRevocationStore{
+		client: client,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+// Revoke sets the key of the token id, expiring it at expiresAt.
+// Tokens already expired are not recorded.
+func (r *RedisTokenRevocationStore) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ttl := expiresAt.Sub(r.now())
+	if ttl <= 0 {
+		return nil
+	}
+
+	if err := r.client.Set(ctx, r.prefix+tokenID, "1", ttl); err != nil {
+		return fmt.Errorf("could not set revoked token: %w", err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether the key of the token id is set
+func (r *RedisTokenRevocationStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	revoked, err := r.client.Exists(ctx, r.prefix+tokenID)
+	if err != nil {
+		return false, fmt.Errorf("could not check revoked token: %w", err)
+	}
+	return revoked, nil
+}
+
+// Consume sets the key of the id only if it is not set, expiring it at expiresAt, so that a single
+// caller across all the replicas consumes the id. Expired ids can't be consumed.
+func (r *RedisTokenRevocationStore) Consume(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	ttl := expiresAt.Sub(r.now())
+	if ttl <= 0 {
+		return false, nil
+	}
+
+	set, err := r.client.SetNX(ctx, r.prefix+id, "1", ttl)
+	if err != nil {
+		return false, fmt.Errorf("could not set consumed id: %w", err)
+	}
+	return set, nil
+}
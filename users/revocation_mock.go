@@ -0,0 +1,66 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	_ TokenRevocationStore = (*tokenRevocationStoreMock)(nil)
+	_ RedisClient          = (*redisClientMock)(nil)
+)
+
+type tokenRevocationStoreMock struct {
+	revokeFunc    func(ctx context.Context, tokenID string, expiresAt time.Time) error
+	isRevokedFunc func(ctx context.Context, tokenID string) (bool, error)
+	consumeFunc   func(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+}
+
+func (m *tokenRevocationStoreMock) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if m.revokeFunc == nil {
+		return errors.New("tokenRevocationStoreMock.revokeFunc is nil")
+	}
+	return m.revokeFunc(ctx, tokenID, expiresAt)
+}
+
+func (m *tokenRevocationStoreMock) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	if m.isRevokedFunc == nil {
+		return false, errors.New("tokenRevocationStoreMock.isRevokedFunc is nil")
+	}
+	return m.isRevokedFunc(ctx, tokenID)
+}
+
+func (m *tokenRevocationStoreMock) Consume(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	if m.consumeFunc == nil {
+		return false, errors.New("tokenRevocationStoreMock.consumeFunc is nil")
+	}
+	return m.consumeFunc(ctx, id, expiresAt)
+}
+
+type redisClientMock struct {
+	setFunc    func(ctx context.Context, key, value string, ttl time.Duration) error
+	existsFunc func(ctx context.Context, key string) (bool, error)
+	setNXFunc  func(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+}
+
+func (m *redisClientMock) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if m.setFunc == nil {
+		return errors.New("redisClientMock.setFunc is nil")
+	}
+	return m.setFunc(ctx, key, value, ttl)
+}
+
+func (m *redisClientMock) Exists(ctx context.Context, key string) (bool, error) {
+	if m.existsFunc == nil {
+		return false, errors.New("redisClientMock.existsFunc is nil")
+	}
+	return m.existsFunc(ctx, key)
+}
+
+func (m *redisClientMock) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	if m.setNXFunc == nil {
+		return false, errors.New("redisClientMock.setNXFunc is nil")
+	}
+	return m.setNXFunc(ctx, key, value, ttl)
+}
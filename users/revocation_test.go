@@ -0,0 +1,254 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRevokeToken(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+			},
+		},
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	revokedToken, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	otherToken, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.RevokeToken(context.Background(), revokedToken))
+
+	_, err = svc.VerifyToken(context.Background(), revokedToken)
+	assert.Equal(t, errTokenRevoked, err)
+
+	_, err = svc.RefreshToken(context.Background(), revokedToken)
+	assert.Equal(t, errTokenRevoked, err)
+
+	_, err = svc.VerifyToken(context.Background(), otherToken)
+	assert.NoError(t, err)
+
+	t.Run("expired token is ignored", func(t *testing.T) {
+		expiredToken, err := issueTestToken(context.Background(), svc, givenUserID, time.Second)
+		require.NoError(t, err)
+
+		later := now.Add(time.Minute)
+		svc.clock = func() time.Time { return later }
+		defer func() { svc.clock = func() time.Time { return now } }()
+
+		assert.NoError(t, svc.RevokeToken(context.Background(), expiredToken))
+	})
+
+	t.Run("empty token", func(t *testing.T) {
+		assert.Equal(t, errTokenEmpty, svc.RevokeToken(context.Background(), ""))
+	})
+}
+
+func TestRevokeToken_storeError(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	store := &tokenRevocationStoreMock{
+		revokeFunc: func(ctx context.Context, tokenID string, expiresAt time.Time) error {
+			return errors.New("some error")
+		},
+		isRevokedFunc: func(ctx context.Context, tokenID string) (bool, error) {
+			return false, errors.New("some error")
+		},
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+			},
+		},
+		WithTokenRevocationStore(store),
+	)
+
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	token, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	err = svc.RevokeToken(context.Background(), token)
+	assert.EqualError(t, err, "could not revoke token: some error")
+
+	// Tokens are rejected when their revocation can't be checked
+	_, err = svc.VerifyToken(context.Background(), token)
+	assert.EqualError(t, err, "could not check token revocation: some error")
+}
+
+func TestMemoryTokenRevocationStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	store := NewMemoryTokenRevocationStore()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Revoke(context.Background(), "token-1", now.Add(time.Minute)))
+
+	revoked, err := store.IsRevoked(context.Background(), "token-1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = store.IsRevoked(context.Background(), "token-2")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	// Records are dropped once the token expires
+	now = now.Add(time.Minute)
+
+	revoked, err = store.IsRevoked(context.Background(), "token-1")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, store.Revoke(context.Background(), "token-2", now.Add(time.Minute)))
+	assert.Len(t, store.revoked, 1)
+}
+
+func TestRedisTokenRevocationStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	keys := make(map[string]time.Duration)
+
+	client := &redisClientMock{
+		setFunc: func(ctx context.Context, key, value string, ttl time.Duration) error {
+			keys[key] = ttl
+			return nil
+		},
+		existsFunc: func(ctx context.Context, key string) (bool, error) {
+			_, ok := keys[key]
+			return ok, nil
+		},
+	}
+
+	store := NewRedisTokenRevocationStore(client, "")
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Revoke(context.Background(), "token-1", now.Add(time.Minute)))
+	assert.Equal(t, time.Minute, keys["users:revoked-token:token-1"])
+
+	revoked, err := store.IsRevoked(context.Background(), "token-1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// Expired tokens are not recorded
+	require.NoError(t, store.Revoke(context.Background(), "token-2", now))
+
+	revoked, err = store.IsRevoked(context.Background(), "token-2")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestTokenRevocationStore_consume(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	keys := make(map[string]bool)
+	var mu sync.Mutex
+
+	redisStore := NewRedisTokenRevocationStore(&redisClientMock{
+		setNXFunc: func(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if keys[key] {
+				return false, nil
+			}
+			keys[key] = true
+			return true, nil
+		},
+	}, "")
+	redisStore.now = func() time.Time { return now }
+
+	memoryStore := NewMemoryTokenRevocationStore()
+	memoryStore.now = func() time.Time { return now }
+
+	testCases := []struct {
+		name       string
+		givenStore TokenRevocationStore
+	}{
+		{
+			name:       "memory store",
+			givenStore: memoryStore,
+		},
+		{
+			name:       "redis store",
+			givenStore: redisStore,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				wg       sync.WaitGroup
+				consumed int32
+			)
+
+			// Concurrent consumers of the same id, a single one succeeds
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					ok, err := tc.givenStore.Consume(context.Background(), "link-1", now.Add(time.Minute))
+					assert.NoError(t, err)
+
+					if ok {
+						atomic.AddInt32(&consumed, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, int32(1), consumed)
+
+			// Expired ids can't be consumed
+			ok, err := tc.givenStore.Consume(context.Background(), "link-2", now)
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestConsumeOnce_storeNotConfigured(t *testing.T) {
+	t.Parallel()
+
+	// Services built without New have no store
+	svc := DefaultService{logger: zap.NewNop()}
+
+	ok, err := svc.consumeOnce(context.Background(), "link-1", time.Now().Add(time.Minute))
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,155 @@
+package users
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type (
+	// keyRing holds the signer the tokens are signed with, replaced by RotateSigningKey,
+	// and the retired signers still verifying the tokens issued before the rotation
+	keyRing struct {
+		mu      sync.RWMutex
+		current Signer
+		retired []retiredSigner
+	}
+
+	retiredSigner struct {
+		signer Signer
+		until  time.Time
+	}
+)
+
+// WithKeyRotationGracePeriod sets how long the tokens signed with a key replaced by RotateSigningKey
+// remain valid. It defaults to the maximum token TTL, so rotating keys logs no one out.
+func WithKeyRotationGracePeriod(d time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.keyRotationGracePeriod = d
+	}
+}
+
+// RotateSigningKey signs the tokens issued from now on with the signer. The tokens signed with the
+// replaced key are verified, selected by their "kid" header, until the key rotation grace period
+// elapses. The signer must be identified by a key id not used by the current or retired keys.
+func (s *DefaultService) RotateSigningKey(signer Signer) error {
+	if s.keys == nil {
+		return errors.New("could not rotate signing key: service not created with New")
+	}
+
+	if signer.KeyID() == "" {
+		return errSigningKeyIDEmpty
+	}
+
+	s.keys.mu.Lock()
+	defer s.keys.mu.Unlock()
+
+	now := s.now()
+
+	// Drop the retired keys whose grace period is over
+	retired := s.keys.retired[:0]
+	for _, r := range s.keys.retired {
+		if now.Before(r.until) {
+			retired = append(retired, r)
+		}
+	}
+	s.keys.retired = retired
+
+	previous := s.currentSigner()
+
+	if signer.KeyID() == previous.KeyID() || s.retiredSigner(signer.KeyID()) != nil {
+		return errSigningKeyIDTaken
+	}
+
+	// By default, the grace period outlives the tokens signed with the previous key
+	gracePeriod := s.keyRotationGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = s.maxTokenTTL
+	}
+
+	if gracePeriod <= 0 {
+		gracePeriod = s.tokenTTLOrDefault()
+	}
+
+	s.keys.retired = append(s.keys.retired, retiredSigner{signer: previous, until: now.Add(gracePeriod)})
+	s.keys.current = signer
+
+	s.logger.Info("signing key rotated", zap.String("kid", signer.KeyID()), zap.String("previous_kid", previous.KeyID()))
+	return nil
+}
+
+// tokenSigner returns the signer the tokens are signed with
+func (s *DefaultService) tokenSigner() Signer {
+	if s.keys != nil {
+		s.keys.mu.RLock()
+		defer s.keys.mu.RUnlock()
+	}
+	return s.currentSigner()
+}
+
+// verificationSigner returns the signer verifying the tokens carrying the key id:
+// the current signer, or a retired signer within the key rotation grace period.
+// It returns nil when no signer matches.
+func (s *DefaultService) verificationSigner(kid string) Signer {
+	if s.keys != nil {
+		s.keys.mu.RLock()
+		defer s.keys.mu.RUnlock()
+	}
+
+	if current := s.currentSigner(); kid == current.KeyID() {
+		return current
+	}
+
+	if r := s.retiredSigner(kid); r != nil && s.now().Before(r.until) {
+		return r.signer
+	}
+	return nil
+}
+
+// activeSigners returns the current signer followed by the retired signers within the key rotation grace period
+func (s *DefaultService) activeSigners() []Signer {
+	if s.keys != nil {
+		s.keys.mu.RLock()
+		defer s.keys.mu.RUnlock()
+	}
+
+	signers := []Signer{s.currentSigner()}
+
+	if s.keys != nil {
+		for i := len(s.keys.retired) - 1; i >= 0; i-- {
+			if r := s.keys.retired[i]; s.now().Before(r.until) {
+				signers = append(signers, r.signer)
+			}
+		}
+	}
+	return signers
+}
+
+// currentSigner returns the signer set by RotateSigningKey, the signer set with WithSigner
+// or the default HS512 signer keyed with the signing key. The caller must hold the key ring lock.
+func (s *DefaultService) currentSigner() Signer {
+	if s.keys != nil && s.keys.current != nil {
+		return s.keys.current
+	}
+
+	if s.signer != nil {
+		return s.signer
+	}
+	return NewHMACSigner(s.jwtSigningKey, s.keyID)
+}
+
+// retiredSigner returns the retired signer identified by kid, if any. The caller must hold the key ring lock.
+func (s *DefaultService) retiredSigner(kid string) *retiredSigner {
+	if s.keys == nil {
+		return nil
+	}
+
+	for i := range s.keys.retired {
+		if s.keys.retired[i].signer.KeyID() == kid {
+			return &s.keys.retired[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRotateSigningKey(t *testing.T) {
+	t.Parallel()
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+			},
+		},
+		WithKeyRotationGracePeriod(time.Minute),
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Hour)
+	svc.clock = func() time.Time { return now }
+
+	generate := func(t *testing.T) string {
+		token, err := issueTestToken(context.Background(), svc, uuid.New().String(), time.Hour*2)
+		require.NoError(t, err)
+		return token
+	}
+
+	oldToken := generate(t)
+
+	require.NoError(t, svc.RotateSigningKey(NewHMACSigner("other-secret", "key-2")))
+
+	newToken := generate(t)
+
+	// Tokens signed with the replaced key are verified during the grace period
+	_, err := svc.VerifyToken(context.Background(), oldToken)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), newToken)
+	require.NoError(t, err)
+
+	now = now.Add(time.Minute)
+
+	// Once it's over, they are verified with the current key and fail
+	_, err = svc.VerifyToken(context.Background(), oldToken)
+	assert.EqualError(t, err, "could not parse token: signature is invalid")
+
+	_, err = svc.VerifyToken(context.Background(), newToken)
+	require.NoError(t, err)
+
+	t.Run("key id is required", func(t *testing.T) {
+		assert.Equal(t, errSigningKeyIDEmpty, svc.RotateSigningKey(NewHMACSigner("another-secret", "")))
+	})
+
+	t.Run("key id is taken", func(t *testing.T) {
+		assert.Equal(t, errSigningKeyIDTaken, svc.RotateSigningKey(NewHMACSigner("another-secret", "key-2")))
+	})
+}
+
+func TestRotateSigningKey_jwks(t *testing.T) {
+	t.Parallel()
+
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+		WithSigner(NewRSASigner(oldKey, "key-1")),
+		WithKeyRotationGracePeriod(time.Minute),
+	)
+
+	now := time.Now()
+	svc.clock = func() time.Time { return now }
+
+	require.NoError(t, svc.RotateSigningKey(NewRSASigner(newKey, "key-2")))
+
+	kids := func(t *testing.T) []string {
+		b, err := svc.JWKS()
+		require.NoError(t, err)
+
+		var set JWKSet
+		require.NoError(t, json.Unmarshal(b, &set))
+
+		var kids []string
+		for _, key := range set.Keys {
+			kids = append(kids, key.Kid)
+		}
+		return kids
+	}
+
+	assert.Equal(t, []string{"key-2", "key-1"}, kids(t))
+
+	now = now.Add(time.Minute)
+
+	assert.Equal(t, []string{"key-2"}, kids(t))
+}
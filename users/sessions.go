@@ -0,0 +1,171 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+)
+
+// SessionLimitPolicy defines what happens on login when a user reached the maximum active sessions
+type SessionLimitPolicy int
+
+const (
+	// EvictOldestSession revokes the oldest sessions of the user to make room for the new one
+	EvictOldestSession SessionLimitPolicy = iota
+	// RejectNewSession rejects the login with errTooManySessions
+	RejectNewSession
+)
+
+// WithMaxActiveSessions limits the concurrent sessions per user to n, e.g. to limit credential sharing.
+// Each login starts a session, identified by the sid claim of its tokens and lasting as long as its token.
+// Refreshing a token extends its session instead of starting a new one.
+// Tokens of revoked sessions are rejected on verification, which then requires a repository lookup.
+func WithMaxActiveSessions(n int, policy SessionLimitPolicy) ServiceOption {
+	return func(s *DefaultService) {
+		s.maxActiveSessions = n
+		s.sessionLimitPolicy = policy
+	}
+}
+
+// WithSessionTracking starts a session on each login without limiting the sessions per user,
+// so users can review the devices they are logged in from and sign them out (see ListSessions).
+// As with WithMaxActiveSessions, verifying a token then requires a repository lookup.
+func WithSessionTracking() ServiceOption {
+	return func(s *DefaultService) {
+		s.sessionTracking = true
+	}
+}
+
+// sessionsEnabled reports whether logins start sessions
+func (s *DefaultService) sessionsEnabled() bool {
+	return s.maxActiveSessions > 0 || s.sessionTracking
+}
+
+// ListSessions lists the active sessions of the user, oldest first, along with the IP and user-agent
+// found in the context (see ContextWithClientInfo) of the logins that started them
+// (e.g. for a "where you're logged in" page). It is empty when sessions are disabled.
+func (s *DefaultService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	if err := s.validateID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if !s.sessionsEnabled() {
+		return []Session{}, nil
+	}
+
+	storageSessions, err := s.repo.SelectActiveSessionsByUserID(ctx, userID, s.now().UTC())
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select active sessions", err)
+	}
+
+	sessions := make([]Session, 0, len(storageSessions))
+	for _, session := range storageSessions {
+		sessions = append(sessions, Session{
+			ID:        session.ID,
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user, as listed by ListSessions, signing its device out:
+// the tokens of the session, refresh tokens included, are rejected from then on.
+// Sessions of other users fail with errSessionNotFound.
+func (s *DefaultService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if !s.sessionsEnabled() {
+		return errSessionNotFound
+	}
+
+	session, err := s.repo.SelectSession(ctx, sessionID)
+	if err != nil {
+		return wrapErr(ctx, "could not select session", err)
+	}
+
+	if session == nil || session.UserID != userID {
+		return errSessionNotFound
+	}
+
+	if err := s.repo.DeleteSession(ctx, sessionID); err != nil {
+		return wrapErr(ctx, "could not delete session", err)
+	}
+
+	if err := s.repo.RevokeRefreshTokensBySessionID(ctx, sessionID, s.now().UTC()); err != nil {
+		return wrapErr(ctx, "could not revoke refresh tokens", err)
+	}
+
+	s.logger.Info("session revoked", userIDField(userID))
+	return nil
+}
+
+// startSession starts a session for the user lasting ttl, enforcing the maximum active sessions.
+// It returns an empty session id when sessions are disabled.
+func (s *DefaultService) startSession(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	if !s.sessionsEnabled() {
+		return "", nil
+	}
+
+	now := s.now().UTC()
+
+	info, _ := clientInfoFromContext(ctx)
+
+	session := repository.Session{
+		ID:        s.newID(),
+		UserID:    userID,
+		IP:        info.IP,
+		UserAgent: info.UserAgent,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	// The repository enforces the limit atomically, so concurrent logins can't exceed it
+	if err := s.repo.InsertSession(ctx, session, s.maxActiveSessions, s.sessionLimitPolicy == EvictOldestSession); err != nil {
+		if errors.Is(err, repository.ErrSessionLimitReached) {
+			return "", errTooManySessions
+		}
+		return "", wrapErr(ctx, "could not insert session", err)
+	}
+	return session.ID, nil
+}
+
+// checkSession returns errSessionRevoked if the session of a token was revoked or expired.
+// Tokens without session, e.g. issued before sessions were limited, are not checked.
+func (s *DefaultService) checkSession(ctx context.Context, sessionID string) error {
+	if !s.sessionsEnabled() || sessionID == "" {
+		return nil
+	}
+
+	session, err := s.repo.SelectSession(ctx, sessionID)
+	if err != nil {
+		return wrapErr(ctx, "could not select session", err)
+	}
+
+	if session == nil || !s.now().Before(session.ExpiresAt) {
+		return errSessionRevoked
+	}
+	return nil
+}
+
+// extendSession extends the session of a refreshed token for ttl
+func (s *DefaultService) extendSession(ctx context.Context, sessionID string, ttl time.Duration) error {
+	if !s.sessionsEnabled() || sessionID == "" {
+		return nil
+	}
+
+	if err := s.repo.UpdateSessionExpiresAt(ctx, sessionID, s.now().UTC().Add(ttl)); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errSessionRevoked
+		}
+		return wrapErr(ctx, "could not update session", err)
+	}
+	return nil
+}
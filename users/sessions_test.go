@@ -0,0 +1,349 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sessionStore is an in-memory session repository keeping the sessions in insertion order
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions []repository.Session
+}
+
+func (st *sessionStore) mock(m *repositoryMock) *repositoryMock {
+	m.insertSessionFunc = func(ctx context.Context, in repository.Session, maxActive int, evictOldest bool) error {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+
+		if maxActive > 0 {
+			var active []string
+			for _, s := range st.sessions {
+				if s.UserID == in.UserID && s.ExpiresAt.After(in.CreatedAt) {
+					active = append(active, s.ID)
+				}
+			}
+
+			if excess := len(active) - maxActive + 1; excess > 0 {
+				if !evictOldest {
+					return repository.ErrSessionLimitReached
+				}
+
+				for _, id := range active[:excess] {
+					for i := range st.sessions {
+						if st.sessions[i].ID == id {
+							st.sessions = append(st.sessions[:i], st.sessions[i+1:]...)
+							break
+						}
+					}
+				}
+			}
+		}
+
+		st.sessions = append(st.sessions, in)
+		return nil
+	}
+	m.selectSessionFunc = func(ctx context.Context, id string) (*repository.Session, error) {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+
+		for _, s := range st.sessions {
+			if s.ID == id {
+				return &s, nil
+			}
+		}
+		return nil, nil
+	}
+	m.selectActiveSessionsByUserIDFunc = func(ctx context.Context, userID string, now time.Time) ([]repository.Session, error) {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+
+		var active []repository.Session
+		for _, s := range st.sessions {
+			if s.UserID == userID && s.ExpiresAt.After(now) {
+				active = append(active, s)
+			}
+		}
+		return active, nil
+	}
+	m.updateSessionExpiresAtFunc = func(ctx context.Context, id string, expiresAt time.Time) error {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+
+		for i := range st.sessions {
+			if st.sessions[i].ID == id {
+				st.sessions[i].ExpiresAt = expiresAt
+				return nil
+			}
+		}
+		return repository.ErrRecordNotFound
+	}
+	m.deleteSessionFunc = func(ctx context.Context, id string) error {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+
+		for i := range st.sessions {
+			if st.sessions[i].ID == id {
+				st.sessions = append(st.sessions[:i], st.sessions[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	return m
+}
+
+func TestWithMaxActiveSessions(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	newService := func(policy SessionLimitPolicy) *DefaultService {
+		store := &sessionStore{}
+
+		return New(zap.NewNop(), "jwt-secret",
+			store.mock(&repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return givenUser, nil
+				},
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return givenUser, nil
+				},
+			}),
+			WithMaxActiveSessions(2, policy),
+		)
+	}
+
+	t.Run("oldest session is evicted", func(t *testing.T) {
+		t.Parallel()
+
+		svc := newService(EvictOldestSession)
+
+		var tokens []string
+		for i := 0; i < 3; i++ {
+			token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+			require.NoError(t, err)
+
+			tokens = append(tokens, token)
+		}
+
+		_, err := svc.VerifyToken(context.Background(), tokens[0])
+		assert.Equal(t, errSessionRevoked, err)
+
+		_, err = svc.RefreshToken(context.Background(), tokens[0])
+		assert.Equal(t, errSessionRevoked, err)
+
+		for _, token := range tokens[1:] {
+			_, err := svc.VerifyToken(context.Background(), token)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("new session is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		svc := newService(RejectNewSession)
+
+		var tokens []string
+		for i := 0; i < 2; i++ {
+			token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+			require.NoError(t, err)
+
+			tokens = append(tokens, token)
+		}
+
+		_, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+		assert.Equal(t, errTooManySessions, err)
+
+		for _, token := range tokens {
+			_, err := svc.VerifyToken(context.Background(), token)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("refresh keeps the session", func(t *testing.T) {
+		t.Parallel()
+
+		svc := newService(RejectNewSession)
+
+		token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			token, err = svc.RefreshToken(context.Background(), token)
+			require.NoError(t, err)
+		}
+
+		_, err = svc.VerifyToken(context.Background(), token)
+		assert.NoError(t, err)
+
+		// A single session was started
+		_, err = svc.GenerateToken(context.Background(), givenUser.Email, password)
+		assert.NoError(t, err)
+	})
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	store := &sessionStore{}
+
+	var (
+		mu            sync.Mutex
+		refreshTokens = make(map[string]repository.RefreshToken)
+	)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		store.mock(&repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				return givenUser, nil
+			},
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return givenUser, nil
+			},
+			insertRefreshTokenFunc: func(ctx context.Context, in repository.RefreshToken) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				refreshTokens[in.TokenHash] = in
+				return nil
+			},
+			selectRefreshTokenFunc: func(ctx context.Context, tokenHash string) (*repository.RefreshToken, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				token := refreshTokens[tokenHash]
+				return &token, nil
+			},
+			consumeRefreshTokenFunc: func(ctx context.Context, tokenHash string, consumedAt time.Time) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				token := refreshTokens[tokenHash]
+				token.ConsumedAt = &consumedAt
+				refreshTokens[tokenHash] = token
+				return nil
+			},
+			revokeRefreshTokensBySessionIDFunc: func(ctx context.Context, sessionID string, revokedAt time.Time) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				for hash, token := range refreshTokens {
+					if token.SessionID == sessionID {
+						token.RevokedAt = &revokedAt
+						refreshTokens[hash] = token
+					}
+				}
+				return nil
+			},
+		}),
+		WithSessionTracking(),
+	)
+
+	var (
+		tokens []string
+		pairs  []*TokenPair
+	)
+	for _, userAgent := range []string{"phone", "laptop"} {
+		ctx := ContextWithClientInfo(context.Background(), ClientInfo{IP: "127.0.0.1", UserAgent: userAgent})
+
+		pair, err := svc.GenerateTokenPair(ctx, givenUser.Email, password)
+		require.NoError(t, err)
+
+		tokens = append(tokens, pair.AccessToken)
+		pairs = append(pairs, pair)
+	}
+
+	sessions, err := svc.ListSessions(context.Background(), givenUser.ID)
+	require.NoError(t, err)
+
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "phone", sessions[0].UserAgent)
+	assert.Equal(t, "laptop", sessions[1].UserAgent)
+	assert.Equal(t, "127.0.0.1", sessions[0].IP)
+
+	t.Run("sessions of other users are not revoked", func(t *testing.T) {
+		err := svc.RevokeSession(context.Background(), uuid.New().String(), sessions[0].ID)
+		assert.Equal(t, errSessionNotFound, err)
+	})
+
+	t.Run("refreshed tokens stay in their session", func(t *testing.T) {
+		refreshed, err := svc.RefreshAccessToken(context.Background(), pairs[1].RefreshToken)
+		require.NoError(t, err)
+
+		claims, err := svc.parseAndValidateClaims(refreshed.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, sessions[1].ID, claims.SessionID)
+
+		pairs[1] = refreshed
+	})
+
+	t.Run("revoked session signs its device out", func(t *testing.T) {
+		require.NoError(t, svc.RevokeSession(context.Background(), givenUser.ID, sessions[0].ID))
+
+		_, err := svc.VerifyToken(context.Background(), tokens[0])
+		assert.Equal(t, errSessionRevoked, err)
+
+		_, err = svc.RefreshAccessToken(context.Background(), pairs[0].RefreshToken)
+		assert.Equal(t, errRefreshTokenRevoked, err)
+
+		_, err = svc.RefreshAccessToken(context.Background(), pairs[1].RefreshToken)
+		assert.NoError(t, err)
+
+		_, err = svc.VerifyToken(context.Background(), tokens[1])
+		assert.NoError(t, err)
+
+		actual, err := svc.ListSessions(context.Background(), givenUser.ID)
+		require.NoError(t, err)
+
+		require.Len(t, actual, 1)
+		assert.Equal(t, sessions[1].ID, actual[0].ID)
+
+		err = svc.RevokeSession(context.Background(), givenUser.ID, sessions[0].ID)
+		assert.Equal(t, errSessionNotFound, err)
+	})
+
+	t.Run("sessions disabled", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+		actual, err := svc.ListSessions(context.Background(), givenUser.ID)
+		require.NoError(t, err)
+		assert.Empty(t, actual)
+
+		err = svc.RevokeSession(context.Background(), givenUser.ID, sessions[1].ID)
+		assert.Equal(t, errSessionNotFound, err)
+	})
+}
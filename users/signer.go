@@ -0,0 +1,108 @@
+package users
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var _ Signer = (*signer)(nil)
+
+type (
+	// Signer signs the tokens issued by the service and verifies the presented tokens (see WithSigner).
+	// With an asymmetric signer, services only verifying tokens need the public key alone.
+	Signer interface {
+		// Method returns the JWT signing method (e.g. RS256)
+		Method() jwt.SigningMethod
+
+		// KeyID returns the id of the key set in the "kid" header of the tokens, if any
+		KeyID() string
+
+		// SigningKey returns the key the tokens are signed with
+		SigningKey() interface{}
+
+		// VerificationKey returns the key the tokens are verified with
+		VerificationKey() interface{}
+
+		// PublicKey returns the public key published by JWKS, or nil for a shared secret
+		PublicKey() crypto.PublicKey
+	}
+
+	signer struct {
+		method          jwt.SigningMethod
+		kid             string
+		signingKey      interface{}
+		verificationKey interface{}
+		publicKey       crypto.PublicKey
+	}
+)
+
+// WithSigner sets the signer of the tokens, replacing the default HS512 signer keyed with the
+// signing key passed to New. The signing key passed to New still keys the HMACs of the login links,
+// passkey ceremonies and action urls, unless another key is set with WithHMACKey.
+func WithSigner(signer Signer) ServiceOption {
+	return func(s *DefaultService) {
+		s.signer = signer
+	}
+}
+
+// NewHMACSigner returns a HS512 signer keyed with the shared secret, identified by kid
+func NewHMACSigner(secret, kid string) Signer {
+	return &signer{
+		method:          jwtSigningMethod,
+		kid:             kid,
+		signingKey:      []byte(secret),
+		verificationKey: []byte(secret),
+	}
+}
+
+// NewRSASigner returns a RS256 signer of the private key, identified by kid
+func NewRSASigner(key *rsa.PrivateKey, kid string) Signer {
+	return &signer{
+		method:          jwt.SigningMethodRS256,
+		kid:             kid,
+		signingKey:      key,
+		verificationKey: &key.PublicKey,
+		publicKey:       &key.PublicKey,
+	}
+}
+
+// NewEd25519Signer returns an EdDSA signer of the Ed25519 private key, identified by kid
+func NewEd25519Signer(key ed25519.PrivateKey, kid string) Signer {
+	publicKey := key.Public()
+
+	return &signer{
+		method:          jwt.SigningMethodEdDSA,
+		kid:             kid,
+		signingKey:      key,
+		verificationKey: publicKey,
+		publicKey:       publicKey,
+	}
+}
+
+// Method returns the JWT signing method
+func (s *signer) Method() jwt.SigningMethod {
+	return s.method
+}
+
+// KeyID returns the id of the key
+func (s *signer) KeyID() string {
+	return s.kid
+}
+
+// SigningKey returns the key the tokens are signed with
+func (s *signer) SigningKey() interface{} {
+	return s.signingKey
+}
+
+// VerificationKey returns the key the tokens are verified with
+func (s *signer) VerificationKey() interface{} {
+	return s.verificationKey
+}
+
+// PublicKey returns the public key, or nil for a shared secret
+func (s *signer) PublicKey() crypto.PublicKey {
+	return s.publicKey
+}
@@ -0,0 +1,176 @@
+package users
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWithSigner(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	repoMock := &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		givenSigner Signer
+		expectedAlg string
+	}{
+		{
+			name:        "rsa signer",
+			givenSigner: NewRSASigner(rsaKey, "rsa-1"),
+			expectedAlg: "RS256",
+		},
+		{
+			name:        "ed25519 signer",
+			givenSigner: NewEd25519Signer(edKey, "ed-1"),
+			expectedAlg: "EdDSA",
+		},
+		{
+			name:        "hmac signer",
+			givenSigner: NewHMACSigner("other-secret", "hs-1"),
+			expectedAlg: "HS512",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret", repoMock, WithSigner(tc.givenSigner))
+
+			// The jwt library rejects tokens issued in the future
+			now := time.Now().Add(-time.Minute)
+			svc.clock = func() time.Time { return now }
+
+			token, err := issueTestToken(context.Background(), svc, uuid.New().String(), time.Hour)
+			require.NoError(t, err)
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwtClaim{})
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedAlg, parsed.Header["alg"])
+			assert.Equal(t, tc.givenSigner.KeyID(), parsed.Header["kid"])
+
+			_, err = svc.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+
+			// Tokens signed with the signing key passed to New are rejected
+			defaultSigned, err := issueTestToken(context.Background(), New(zap.NewNop(), "jwt-secret", repoMock), uuid.New().String(), time.Hour)
+			require.NoError(t, err)
+
+			_, err = svc.VerifyToken(context.Background(), defaultSigned)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestWithSigner_publicKeyAsHMACSecret(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithSigner(NewRSASigner(rsaKey, "rsa-1")))
+
+	b, err := svc.JWKS()
+	require.NoError(t, err)
+
+	// A token forged with the published key as HMAC secret must not verify
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaim{
+		UserID:         uuid.New().String(),
+		Role:           string(RoleAdmin),
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}).SignedString(b)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), forged)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected signing method")
+}
+
+func TestWithSigner_emptyHMACKey(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer := WithSigner(NewEd25519Signer(edKey, "ed-1"))
+
+	t.Run("features keyed with the hmac key are rejected", func(t *testing.T) {
+		t.Parallel()
+
+		for _, opt := range []ServiceOption{
+			WithLoginLinkEndpoint("https://example.com/login/link"),
+			WithActionEndpoint("https://example.com/actions"),
+		} {
+			assert.PanicsWithValue(t, "invalid signing config: user hmac key is empty", func() {
+				New(zap.NewNop(), "", &repositoryMock{}, signer, opt)
+			})
+		}
+	})
+
+	t.Run("signing config validation", func(t *testing.T) {
+		t.Parallel()
+
+		svc := DefaultService{signer: NewEd25519Signer(edKey, "ed-1"), loginLinkEndpoint: "https://example.com/login/link"}
+		assert.Equal(t, errHMACKeyEmpty, svc.ValidateSigningConfig())
+
+		svc.hmacKey = "hmac-secret"
+		assert.NoError(t, svc.ValidateSigningConfig())
+	})
+
+	t.Run("links forged with the empty key are rejected", func(t *testing.T) {
+		t.Parallel()
+
+		svc := DefaultService{logger: zap.NewNop(), signer: NewEd25519Signer(edKey, "ed-1"), loginLinkEndpoint: "https://example.com/login/link"}
+
+		// A link signed with the empty key, as anyone could
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"id":"foo","user_id":"` + uuid.New().String() + `","exp":` +
+			strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + `}`))
+
+		mac := hmac.New(sha256.New, nil)
+		mac.Write([]byte("login_link\n" + payload))
+
+		_, err := svc.ConsumeLoginLink(context.Background(), payload+"."+base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+		assert.ErrorIs(t, err, errHMACKeyEmpty)
+	})
+
+	t.Run("dedicated hmac key", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), "", &repositoryMock{}, signer, WithHMACKey("hmac-secret"),
+			WithActionEndpoint("https://example.com/actions"))
+
+		u, err := svc.GenerateSignedActionURL("unsubscribe", uuid.New().String(), time.Hour)
+		require.NoError(t, err)
+
+		_, _, err = svc.VerifySignedAction(u)
+		assert.NoError(t, err)
+	})
+}
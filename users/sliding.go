@@ -0,0 +1,87 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WithSlidingSession issues tokens valid for idleTTL, reissued with a fresh expiration each time they are
+// verified (see VerifyTokenResponse.RefreshedToken), so sessions last as long as they are active.
+// Sessions can't outlast absoluteTTL after the login, recorded in the max_session claim of their tokens,
+// and are then rejected with errSessionExpired. The idle TTL replaces the TTL requested on login.
+func WithSlidingSession(idleTTL, absoluteTTL time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.slidingIdleTTL = idleTTL
+		s.slidingAbsoluteTTL = absoluteTTL
+	}
+}
+
+// loginTTL returns the TTL of the token issued on login, the idle TTL for sliding sessions
+func (s *DefaultService) loginTTL(ttl time.Duration) time.Duration {
+	if s.slidingIdleTTL > 0 {
+		return s.slidingIdleTTL
+	}
+	return s.clampTokenTTL(ttl)
+}
+
+// startSlidingSession sets the absolute deadline of the session started by a login,
+// when sessions are sliding
+func (s *DefaultService) startSlidingSession(claims *jwtClaim) {
+	if s.slidingIdleTTL <= 0 {
+		return
+	}
+
+	claims.MaxSession = time.Unix(claims.IssuedAt, 0).Add(s.slidingAbsoluteTTL).Unix()
+	capToMaxSession(claims)
+}
+
+// capToMaxSession caps the expiration of a sliding session token to the session deadline
+func capToMaxSession(claims *jwtClaim) {
+	if claims.MaxSession != 0 && claims.ExpiresAt > claims.MaxSession {
+		claims.ExpiresAt = claims.MaxSession
+	}
+}
+
+// slideToken reissues a verified sliding session token with a fresh expiration, keeping the session deadline
+// and the token id, so revoking any token of the session revokes them all. It returns an empty token for other tokens.
+func (s *DefaultService) slideToken(ctx context.Context, claims *jwtClaim, resp *VerifyTokenResponse) (string, error) {
+	if s.slidingIdleTTL <= 0 || claims.MaxSession == 0 {
+		return "", nil
+	}
+
+	slid, err := s.newJWTClaims(resp.ID, role(resp.Role), s.slidingIdleTTL)
+	if err != nil {
+		return "", err
+	}
+
+	slid.Id = claims.Id
+	slid.Reactivation = resp.ReactivationRequired
+	slid.SessionID = claims.SessionID
+	slid.Audience = claims.Audience
+	slid.MaxSession = claims.MaxSession
+	slid.Extra = claims.Extra
+	capToMaxSession(slid)
+
+	// The server-side session, if any, must live as long as its token
+	if err := s.extendSession(ctx, slid.SessionID, time.Unix(slid.ExpiresAt, 0).Sub(s.now())); err != nil {
+		return "", err
+	}
+
+	token, err := s.signJWT(ctx, *slid)
+	if err != nil {
+		s.logger.Error("could not generate jwt", zap.String("operation", "slide_token"), userIDField(resp.ID), errorField(err))
+		return "", wrapErr(ctx, "could not generate jwt", err)
+	}
+	return token, nil
+}
+
+// expiredError returns the error of an expired token, errSessionExpired when the token
+// expired at the deadline of its sliding session, which can't be extended
+func (s *DefaultService) expiredError(claims *jwtClaim) error {
+	if claims.MaxSession != 0 && !s.now().Before(time.Unix(claims.MaxSession, 0)) {
+		return errSessionExpired
+	}
+	return errTokenExpired
+}
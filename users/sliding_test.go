@@ -0,0 +1,191 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestWithSlidingSession moves the clock of the jwt package along with the service clock,
+// so it doesn't run in parallel with the other tests
+func TestWithSlidingSession(t *testing.T) {
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Role:         string(RoleUser),
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+	}
+
+	repo := &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return givenUser, nil
+		},
+		selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+			return givenUser, nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return givenUser, nil
+		},
+	}
+
+	idleTTL, absoluteTTL := time.Hour, 3*time.Hour
+
+	login := time.Now().Truncate(time.Second)
+	now := login
+
+	jwt.TimeFunc = func() time.Time { return now }
+	defer func() { jwt.TimeFunc = time.Now }()
+
+	// newService returns a service logging in at login and whose clock is set by the returned function
+	newService := func() (*DefaultService, func(elapsed time.Duration)) {
+		svc := New(zap.NewNop(), "jwt-secret", repo, WithSlidingSession(idleTTL, absoluteTTL))
+		svc.clock = func() time.Time { return now }
+
+		now = login
+		return svc, func(elapsed time.Duration) { now = login.Add(elapsed) }
+	}
+
+	expiresAt := func(t *testing.T, token string) int64 {
+		t.Helper()
+
+		var claims jwtClaim
+		_, _, err := new(jwt.Parser).ParseUnverified(token, &claims)
+		require.NoError(t, err)
+		return claims.ExpiresAt
+	}
+
+	jwtID := func(t *testing.T, token string) string {
+		t.Helper()
+
+		var claims jwtClaim
+		_, _, err := new(jwt.Parser).ParseUnverified(token, &claims)
+		require.NoError(t, err)
+		return claims.Id
+	}
+
+	t.Run("activity extends the session", func(t *testing.T) {
+		svc, setElapsed := newService()
+
+		token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+		require.NoError(t, err)
+
+		setElapsed(50 * time.Minute)
+
+		resp, err := svc.VerifyToken(context.Background(), token)
+		require.NoError(t, err)
+		require.NotEmpty(t, resp.RefreshedToken)
+
+		assert.Equal(t, expiresAt(t, token)+int64((50*time.Minute).Seconds()), expiresAt(t, resp.RefreshedToken))
+
+		// the original token would have expired by now, the reissued one has not
+		setElapsed(100 * time.Minute)
+
+		_, err = svc.VerifyToken(context.Background(), token)
+		require.Equal(t, errTokenExpired, err)
+
+		_, err = svc.VerifyToken(context.Background(), resp.RefreshedToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("revoking a token revokes its reissued tokens", func(t *testing.T) {
+		svc, setElapsed := newService()
+
+		token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+		require.NoError(t, err)
+
+		setElapsed(10 * time.Minute)
+
+		resp, err := svc.VerifyToken(context.Background(), token)
+		require.NoError(t, err)
+		require.NotEmpty(t, resp.RefreshedToken)
+
+		revoked := make(map[string]time.Time)
+
+		svc.revocationStore = &tokenRevocationStoreMock{
+			revokeFunc: func(ctx context.Context, tokenID string, expiresAt time.Time) error {
+				revoked[tokenID] = expiresAt
+				return nil
+			},
+			isRevokedFunc: func(ctx context.Context, tokenID string) (bool, error) {
+				_, ok := revoked[tokenID]
+				return ok, nil
+			},
+		}
+
+		require.NoError(t, svc.RevokeToken(context.Background(), token))
+
+		_, err = svc.VerifyToken(context.Background(), resp.RefreshedToken)
+		require.Equal(t, errTokenRevoked, err)
+
+		// the revocation outlives the revoked token, until the session deadline
+		assert.Equal(t, map[string]time.Time{jwtID(t, token): login.Add(absoluteTTL)}, revoked)
+	})
+
+	t.Run("idle limit", func(t *testing.T) {
+		svc, setElapsed := newService()
+
+		token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+		require.NoError(t, err)
+
+		setElapsed(idleTTL + time.Second)
+
+		_, err = svc.VerifyToken(context.Background(), token)
+		require.Equal(t, errTokenExpired, err)
+	})
+
+	t.Run("absolute limit", func(t *testing.T) {
+		svc, setElapsed := newService()
+
+		token, err := svc.Authenticate(context.Background(), givenUser.Username, password)
+		require.NoError(t, err)
+
+		// active every 50 minutes, never idle
+		for elapsed := 50 * time.Minute; elapsed < absoluteTTL; elapsed += 50 * time.Minute {
+			setElapsed(elapsed)
+
+			resp, err := svc.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+			token = resp.RefreshedToken
+		}
+
+		// the last token is capped to the session deadline
+		assert.Equal(t, login.Add(absoluteTTL).Unix(), expiresAt(t, token))
+
+		setElapsed(absoluteTTL + time.Second)
+
+		_, err = svc.VerifyToken(context.Background(), token)
+		require.Equal(t, errSessionExpired, err)
+
+		// refreshing doesn't escape the deadline either
+		_, err = svc.RefreshToken(context.Background(), token)
+		require.Equal(t, errSessionExpired, err)
+	})
+
+	t.Run("tokens are not reissued without sliding sessions", func(t *testing.T) {
+		now = login
+
+		svc := New(zap.NewNop(), "jwt-secret", repo)
+		svc.clock = func() time.Time { return now }
+
+		token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+		require.NoError(t, err)
+
+		resp, err := svc.VerifyToken(context.Background(), token)
+		require.NoError(t, err)
+		assert.Empty(t, resp.RefreshedToken)
+	})
+}
@@ -0,0 +1,108 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"go.uber.org/zap"
+)
+
+// stepUpTokenTTL is the lifetime of step-up tokens, long enough for a single sensitive action
+const stepUpTokenTTL time.Duration = time.Minute * 5
+
+// StepUp re-authenticates the user with its password and returns a short-lived step-up token,
+// required by sensitive actions (e.g. deleting the account) through RequireStepUp.
+// Users with a TOTP second factor must give a current code as well, or fail with errMFARequired;
+// the code is ignored for other users. The password attempts are rate limited as logins.
+func (s *DefaultService) StepUp(ctx context.Context, userID, password, code string) (string, error) {
+	if err := s.validateID(userID); err != nil {
+		return "", fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if err := s.validateLoginPassword(password); err != nil {
+		return "", fmt.Errorf("could not validate password: %s", err)
+	}
+
+	storageUser, err := s.checkCredentials(ctx, "step-up:", password, func() (*repository.User, error) {
+		storageUser, err := s.repo.SelectByID(ctx, userID)
+		if err != nil {
+			return nil, wrapErr(ctx, "could not select user by id", err)
+		}
+		return storageUser, nil
+	})
+	if err != nil {
+		s.logger.Info("step-up failed", zap.String("operation", "step_up"), userIDField(userID), errorField(err))
+		return "", err
+	}
+
+	if err := s.checkStepUpCode(ctx, storageUser.ID, code); err != nil {
+		s.logger.Info("step-up failed", zap.String("operation", "step_up"), userIDField(userID), errorField(err))
+		return "", err
+	}
+
+	claims, err := s.newJWTClaims(storageUser.ID, role(storageUser.Role), stepUpTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	claims.StepUp = true
+
+	token, err := s.signJWT(ctx, *claims)
+	if err != nil {
+		s.logger.Error("could not generate jwt", zap.String("operation", "step_up"), userIDField(userID), errorField(err))
+		return "", wrapErr(ctx, "could not generate jwt", err)
+	}
+
+	s.rehashPassword(ctx, storageUser, password)
+
+	s.logger.Debug("step-up succeeded", zap.String("operation", "step_up"), userIDField(userID))
+	return token, nil
+}
+
+// checkStepUpCode checks the TOTP code of a user re-authenticating, if the user enabled the second factor
+func (s *DefaultService) checkStepUpCode(ctx context.Context, userID, code string) error {
+	if s.totp == nil {
+		return nil
+	}
+
+	storageTOTP, err := s.repo.SelectTOTP(ctx, userID)
+	if err != nil {
+		return wrapErr(ctx, "could not select totp", err)
+	}
+
+	if storageTOTP == nil || storageTOTP.ConfirmedAt == nil {
+		return nil
+	}
+
+	if code == "" {
+		return errMFARequired
+	}
+	return s.checkTOTPCode(ctx, storageTOTP, code)
+}
+
+// RequireStepUp verifies a step-up token issued by StepUp to the user about to perform a sensitive action.
+// It rejects expired tokens with errTokenExpired, and tokens lacking the step-up claim or issued to another user
+// with errStepUpRequired. As with VerifyToken, revoked tokens and tokens issued before the tokens of the user
+// were revoked are rejected.
+func (s *DefaultService) RequireStepUp(ctx context.Context, userID, token string) error {
+	claims, err := s.parseAndValidateClaims(token)
+	if err != nil {
+		return err
+	}
+
+	if !claims.StepUp {
+		return errStepUpRequired
+	}
+
+	resp, err := s.verifyClaims(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	if resp.ID != userID {
+		s.logger.Info("step-up token of another user", zap.String("operation", "require_step_up"), userIDField(userID))
+		return errStepUpRequired
+	}
+	return nil
+}
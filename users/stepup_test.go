@@ -0,0 +1,212 @@
+package users
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStepUp(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	mfaUser := *givenUser
+	mfaUser.ID = uuid.New().String()
+
+	secret := []byte("12345678901234567890")
+
+	var sealedSecret string
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			switch id {
+			case givenUser.ID:
+				return givenUser, nil
+			case mfaUser.ID:
+				return &mfaUser, nil
+			}
+			return nil, nil
+		},
+		selectTOTPFunc: func(ctx context.Context, userID string) (*repository.TOTP, error) {
+			if userID != mfaUser.ID {
+				return nil, nil
+			}
+
+			confirmedAt := time.Now()
+			return &repository.TOTP{UserID: userID, SecretCiphertext: sealedSecret, ConfirmedAt: &confirmedAt}, nil
+		},
+		useTOTPStepFunc: func(ctx context.Context, userID string, step int64) error {
+			return nil
+		},
+	}, WithTOTP("Acme", []byte("totp-key")))
+
+	sealedSecret, err = svc.totp.seal(mfaUser.ID, secret)
+	require.NoError(t, err)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	code := totpCode(secret, now.Unix()/30)
+
+	testCases := []struct {
+		name          string
+		givenUserID   string
+		givenPassword string
+		givenCode     string
+		expectedError error
+	}{
+		{
+			name:          "password match",
+			givenUserID:   givenUser.ID,
+			givenPassword: password,
+		},
+		{
+			name:          "password not match",
+			givenUserID:   givenUser.ID,
+			givenPassword: "wrong%&password123",
+			expectedError: errPasswordInvalid,
+		},
+		{
+			name:          "user not found",
+			givenUserID:   uuid.New().String(),
+			givenPassword: password,
+			expectedError: errNotFound,
+		},
+		{
+			name:          "password and code match",
+			givenUserID:   mfaUser.ID,
+			givenPassword: password,
+			givenCode:     code,
+		},
+		{
+			name:          "code missing",
+			givenUserID:   mfaUser.ID,
+			givenPassword: password,
+			expectedError: errMFARequired,
+		},
+		{
+			name:          "code not match",
+			givenUserID:   mfaUser.ID,
+			givenPassword: password,
+			givenCode:     "000000",
+			expectedError: errTOTPCodeInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := svc.StepUp(context.Background(), tc.givenUserID, tc.givenPassword, tc.givenCode)
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError != nil {
+				return
+			}
+
+			assert.NoError(t, svc.RequireStepUp(context.Background(), tc.givenUserID, token))
+
+			ttl, err := svc.TokenTimeToLive(token)
+			require.NoError(t, err)
+
+			assert.True(t, ttl <= stepUpTokenTTL)
+		})
+	}
+}
+
+func TestRequireStepUp(t *testing.T) {
+	t.Parallel()
+
+	userID := uuid.New().String()
+
+	tokensValidAfter := time.Now().Add(-30 * time.Minute)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser), TokensValidAfter: &tokensValidAfter}, nil
+		},
+	})
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	stepUpToken := func(issuedAt time.Time) string {
+		claims, err := svc.newJWTClaims(userID, RoleUser, stepUpTokenTTL)
+		require.NoError(t, err)
+
+		claims.StepUp = true
+		claims.IssuedAt = issuedAt.Unix()
+
+		token, err := svc.signJWT(context.Background(), *claims)
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("step-up token", func(t *testing.T) {
+		assert.NoError(t, svc.RequireStepUp(context.Background(), userID, stepUpToken(now)))
+	})
+
+	t.Run("regular token", func(t *testing.T) {
+		token, err := issueTestToken(context.Background(), svc, userID, time.Hour)
+		require.NoError(t, err)
+
+		assert.Equal(t, errStepUpRequired, svc.RequireStepUp(context.Background(), userID, token))
+	})
+
+	t.Run("step-up token of another user", func(t *testing.T) {
+		err := svc.RequireStepUp(context.Background(), uuid.New().String(), stepUpToken(now))
+		assert.Equal(t, errStepUpRequired, err)
+	})
+
+	t.Run("step-up token issued before the tokens were revoked", func(t *testing.T) {
+		err := svc.RequireStepUp(context.Background(), userID, stepUpToken(tokensValidAfter.Add(-time.Minute)))
+		assert.Equal(t, errTokenSuperseded, err)
+	})
+
+	t.Run("revoked step-up token", func(t *testing.T) {
+		token := stepUpToken(now)
+		require.NoError(t, svc.RevokeToken(context.Background(), token))
+
+		assert.Equal(t, errTokenRevoked, svc.RequireStepUp(context.Background(), userID, token))
+	})
+
+	t.Run("expired step-up token", func(t *testing.T) {
+		claims, err := svc.newJWTClaims(userID, RoleUser, stepUpTokenTTL)
+		require.NoError(t, err)
+
+		claims.StepUp = true
+		claims.IssuedAt = time.Now().Add(-time.Hour).Unix()
+		claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+		token, err := svc.signJWT(context.Background(), *claims)
+		require.NoError(t, err)
+
+		assert.Equal(t, errTokenExpired, svc.RequireStepUp(context.Background(), userID, token))
+	})
+
+	t.Run("empty token", func(t *testing.T) {
+		assert.Equal(t, errTokenEmpty, svc.RequireStepUp(context.Background(), userID, ""))
+	})
+}
@@ -0,0 +1,449 @@
+package users
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	// totpStep, totpDigits and totpSecretSize are the RFC 6238 defaults supported by authenticator apps
+	totpStep       = 30 * time.Second
+	totpDigits     = 6
+	totpSecretSize = 20
+
+	// mfaChallengeTTL is the lifetime of the challenge tokens, long enough to type a code
+	mfaChallengeTTL time.Duration = time.Minute * 5
+
+	// Enumerate the kinds of MFA challenges, telling VerifyTOTP what to issue once completed
+
+	mfaChallengeToken = "token"
+	mfaChallengePair  = "pair"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpConfig holds the issuer shown by authenticator apps and the cipher of the TOTP secrets at rest
+type totpConfig struct {
+	issuer string
+	aead   cipher.AEAD
+}
+
+// WithTOTP enables time-based one-time passwords (RFC 6238) as a second factor (see EnrollTOTP).
+// The issuer names the service in authenticator apps. The secrets are stored AES-GCM encrypted
+// with a key derived from key, which must be kept as safely as the database backups:
+// changing it makes the stored secrets unreadable and locks the enrolled users out.
+func WithTOTP(issuer string, key []byte) ServiceOption {
+	return func(s *DefaultService) {
+		s.totp = newTOTPConfig(issuer, key)
+	}
+}
+
+func newTOTPConfig(issuer string, key []byte) *totpConfig {
+	block, err := aes.NewCipher(deriveKey(key, "totp-secret"))
+	if err != nil {
+		// the derived key is always a valid AES-256 key
+		panic(fmt.Sprintf("could not create totp cipher: %s", err))
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Sprintf("could not create totp aead: %s", err))
+	}
+
+	return &totpConfig{
+		issuer: issuer,
+		aead:   aead,
+	}
+}
+
+// seal encrypts the secret binding the ciphertext to the user
+func (c *totpConfig) seal(userID string, secret []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %s", err)
+	}
+
+	ciphertext := c.aead.Seal(nonce, nonce, secret, []byte(userID))
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// open decrypts the secret sealed for the user
+func (c *totpConfig) open(userID, sealed string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode totp secret ciphertext: %s", err)
+	}
+
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.New("totp secret ciphertext is too short")
+	}
+
+	nonce, ciphertext := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+
+	secret, err := c.aead.Open(nil, nonce, ciphertext, []byte(userID))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt totp secret: %s", err)
+	}
+	return secret, nil
+}
+
+// EnrollTOTP generates a new TOTP secret for the user and returns it along with its provisioning URI,
+// to be shown once to the user. The second factor is only enabled once a code is confirmed
+// with ConfirmTOTP. Enrolling again replaces an unconfirmed secret.
+func (s *DefaultService) EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	if s.totp == nil {
+		return nil, errTOTPUnavailable
+	}
+
+	if err := s.validateID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageUser, err := s.selectUserByID(ctx, userID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return nil, errNotFound
+	}
+
+	storageTOTP, err := s.repo.SelectTOTP(ctx, userID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select totp", err)
+	}
+
+	if storageTOTP != nil && storageTOTP.ConfirmedAt != nil {
+		return nil, errTOTPAlreadyEnabled
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, fmt.Errorf("could not generate totp secret: %s", err)
+	}
+
+	ciphertext, err := s.totp.seal(userID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	in := repository.TOTP{
+		UserID:           userID,
+		SecretCiphertext: ciphertext,
+		CreatedAt:        s.now().UTC(),
+	}
+
+	if err := s.repo.UpsertTOTP(ctx, in); err != nil {
+		s.logger.Error("could not upsert totp", zap.String("operation", "enroll_totp"), userIDField(userID), errorField(err))
+		return nil, wrapErr(ctx, "could not upsert totp", err)
+	}
+
+	s.logger.Info("totp enrolled", zap.String("operation", "enroll_totp"), userIDField(userID))
+
+	encoded := totpEncoding.EncodeToString(secret)
+
+	return &TOTPEnrollment{
+		Secret: encoded,
+		URI:    s.totpURI(storageUser.Username, encoded),
+	}, nil
+}
+
+// ConfirmTOTP enables the TOTP second factor of the user once the code proves the secret returned
+// by EnrollTOTP was set up. From then on, logins require a code (see VerifyTOTP).
+func (s *DefaultService) ConfirmTOTP(ctx context.Context, userID, code string) error {
+	if s.totp == nil {
+		return errTOTPUnavailable
+	}
+
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageTOTP, err := s.repo.SelectTOTP(ctx, userID)
+	if err != nil {
+		return wrapErr(ctx, "could not select totp", err)
+	}
+
+	if storageTOTP == nil {
+		return errTOTPNotEnrolled
+	}
+
+	if storageTOTP.ConfirmedAt != nil {
+		return errTOTPAlreadyEnabled
+	}
+
+	if err := s.checkTOTPCode(ctx, storageTOTP, code); err != nil {
+		s.logger.Info("totp confirmation failed", zap.String("operation", "confirm_totp"), userIDField(userID), errorField(err))
+		return err
+	}
+
+	if err := s.repo.ConfirmTOTP(ctx, userID, s.now().UTC()); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errTOTPAlreadyEnabled
+		}
+		return wrapErr(ctx, "could not confirm totp", err)
+	}
+
+	s.logger.Info("totp enabled", zap.String("operation", "confirm_totp"), userIDField(userID))
+	return nil
+}
+
+// DisableTOTP disables the TOTP second factor of the user, deleting its secret.
+// Callers must re-authenticate the user first (see RequireStepUp), as it weakens the account security.
+func (s *DefaultService) DisableTOTP(ctx context.Context, userID string) error {
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if err := s.repo.DeleteTOTP(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errTOTPNotEnrolled
+		}
+		s.logger.Error("could not delete totp", zap.String("operation", "disable_totp"), userIDField(userID), errorField(err))
+		return wrapErr(ctx, "could not delete totp", err)
+	}
+
+	s.logger.Info("totp disabled", zap.String("operation", "disable_totp"), userIDField(userID))
+	return nil
+}
+
+// VerifyTOTP completes a login challenged for a second factor: it verifies the TOTP code of the user
+// of the challenge token returned in place of the JWT token by the login, and returns the JWT token,
+// valid for the token TTL. The refresh token is only set when the login was GenerateTokenPair.
+// Each challenge token and each code is only accepted once, and the code attempts are rate limited as logins.
+func (s *DefaultService) VerifyTOTP(ctx context.Context, challengeToken, code string) (*TokenPair, error) {
+	if s.totp == nil {
+		return nil, errTOTPUnavailable
+	}
+
+	claims, err := s.parseAndValidateClaims(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.MFA == "" {
+		return nil, errMFAChallengeInvalid
+	}
+
+	if err := s.checkTokenRevoked(ctx, claims); err != nil {
+		if errors.Is(err, errTokenRevoked) {
+			return nil, errMFAChallengeInvalid
+		}
+		return nil, err
+	}
+
+	storageUser, err := s.challengedUser(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	storageTOTP, err := s.repo.SelectTOTP(ctx, storageUser.ID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select totp", err)
+	}
+
+	// The second factor was disabled since the login
+	if storageTOTP == nil || storageTOTP.ConfirmedAt == nil {
+		return nil, errMFAChallengeInvalid
+	}
+
+	if err := s.checkTOTPCode(ctx, storageTOTP, code); err != nil {
+		s.logger.Info("login failed", zap.String("operation", "verify_totp"), userIDField(storageUser.ID), errorField(err))
+		return nil, err
+	}
+
+	if err := s.revocationStore.Revoke(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		s.logger.Error("could not revoke mfa challenge", zap.String("operation", "verify_totp"), userIDField(storageUser.ID), errorField(err))
+		return nil, wrapErr(ctx, "could not revoke mfa challenge", err)
+	}
+
+	issued, err := s.startLoginSession(ctx, storageUser, s.tokenTTLOrDefault(), claims.Audience, "verify_totp")
+	if err != nil {
+		return nil, err
+	}
+
+	pair := TokenPair{
+		AccessToken: issued.token,
+		ExpiresIn:   int64(issued.ttl / time.Second),
+	}
+
+	if claims.MFA == mfaChallengePair {
+		if pair.RefreshToken, err = s.issueRefreshToken(ctx, storageUser.ID, NewUUIDv4(), issued.sessionID); err != nil {
+			s.logger.Error("could not issue refresh token", zap.String("operation", "verify_totp"), userIDField(storageUser.ID), errorField(err))
+			return nil, err
+		}
+	}
+	return &pair, nil
+}
+
+// challengedUser returns the user of a challenge token, who must still be able to log in
+func (s *DefaultService) challengedUser(ctx context.Context, claims *jwtClaim) (*repository.User, error) {
+	selectUser := s.selectUserByID
+	if claims.Reactivation {
+		selectUser = s.repo.SelectByIDWithDeleted
+	}
+
+	storageUser, err := selectUser(ctx, claims.UserID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil || storageUser.DeletedAt != nil && !s.inDeletionGracePeriod(storageUser) {
+		return nil, errNotFound
+	}
+
+	if tokenSuperseded(claims.IssuedAt, storageUser.TokensValidAfter) {
+		return nil, errTokenSuperseded
+	}
+	return storageUser, nil
+}
+
+// issueMFAChallenge returns a challenge token of the given kind when the user enabled a second factor,
+// to be exchanged with a TOTP code for the JWT token by VerifyTOTP. It returns an empty token otherwise.
+func (s *DefaultService) issueMFAChallenge(ctx context.Context, storageUser *repository.User, audience, kind, operation string) (string, error) {
+	if s.totp == nil {
+		return "", nil
+	}
+
+	storageTOTP, err := s.repo.SelectTOTP(ctx, storageUser.ID)
+	if err != nil {
+		s.logger.Error("could not select totp", zap.String("operation", operation), userIDField(storageUser.ID), errorField(err))
+		return "", wrapErr(ctx, "could not select totp", err)
+	}
+
+	if storageTOTP == nil || storageTOTP.ConfirmedAt == nil {
+		return "", nil
+	}
+
+	claims, err := s.newJWTClaims(storageUser.ID, role(storageUser.Role), mfaChallengeTTL)
+	if err != nil {
+		return "", err
+	}
+
+	claims.MFA = kind
+	claims.Reactivation = storageUser.DeletedAt != nil
+	if audience != "" {
+		claims.Audience = audience
+	}
+
+	challenge, err := s.signJWT(ctx, *claims)
+	if err != nil {
+		s.logger.Error("could not generate jwt", zap.String("operation", operation), userIDField(storageUser.ID), errorField(err))
+		return "", wrapErr(ctx, "could not generate jwt", err)
+	}
+
+	s.logger.Debug("mfa challenge issued", zap.String("operation", operation), userIDField(storageUser.ID))
+	return challenge, nil
+}
+
+// checkTOTPCode checks the code against the secret of the user. The codes of the previous and next
+// time steps are accepted to tolerate clock drift, and the step of the accepted code is recorded
+// so the code can't be replayed.
+func (s *DefaultService) checkTOTPCode(ctx context.Context, storageTOTP *repository.TOTP, code string) error {
+	limitKey := "totp:" + storageTOTP.UserID
+
+	limit, err := s.allow(ctx, limitKey, s.loginRateLimit)
+	if err != nil {
+		return err
+	}
+
+	if limit != nil && !limit.Allowed {
+		return errRateLimited
+	}
+
+	secret, err := s.totp.open(storageTOTP.UserID, storageTOTP.SecretCiphertext)
+	if err != nil {
+		s.logger.Error("could not open totp secret", userIDField(storageTOTP.UserID), errorField(err))
+		return err
+	}
+
+	step, ok := matchTOTP(secret, code, s.now())
+	if !ok {
+		return errTOTPCodeInvalid
+	}
+
+	if err := s.repo.UseTOTPStep(ctx, storageTOTP.UserID, step); err != nil {
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return errTOTPCodeInvalid
+		}
+		return wrapErr(ctx, "could not use totp step", err)
+	}
+
+	if limit != nil {
+		if err := s.rateLimiter.Reset(ctx, limitKey); err != nil {
+			s.logger.Error("could not reset totp rate limit", userIDField(storageTOTP.UserID), errorField(err))
+		}
+	}
+	return nil
+}
+
+// totpURI returns the otpauth:// provisioning URI of the secret, as read from QR codes by authenticator apps
+func (s *DefaultService) totpURI(account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", s.totp.issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpStep/time.Second)))
+
+	label := account
+	if s.totp.issuer != "" {
+		label = s.totp.issuer + ":" + account
+	}
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + label,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// matchTOTP returns the time step of the code when it matches the code of the secret
+// at the previous, current or next time step
+func matchTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(totpStep/time.Second)
+
+	for step := current - 1; step <= current+1; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode returns the code of the secret at the time step (RFC 4226 HOTP with HMAC-SHA1)
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
@@ -0,0 +1,224 @@
+package users
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPCode(t *testing.T) {
+	t.Parallel()
+
+	// RFC 6238 test vectors, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+
+	testCases := []struct {
+		name     string
+		time     int64
+		expected string
+	}{
+		{name: "59", time: 59, expected: "287082"},
+		{name: "1111111109", time: 1111111109, expected: "081804"},
+		{name: "1234567890", time: 1234567890, expected: "005924"},
+		{name: "2000000000", time: 2000000000, expected: "279037"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, totpCode(secret, tc.time/30))
+		})
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	storedUser := repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	var storedTOTP *repository.TOTP
+	refreshTokens := make(map[string]repository.RefreshToken)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				user := storedUser
+				return &user, nil
+			},
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				user := storedUser
+				return &user, nil
+			},
+			insertRefreshTokenFunc: func(ctx context.Context, in repository.RefreshToken) error {
+				refreshTokens[in.TokenHash] = in
+				return nil
+			},
+			upsertTOTPFunc: func(ctx context.Context, in repository.TOTP) error {
+				storedTOTP = &in
+				return nil
+			},
+			selectTOTPFunc: func(ctx context.Context, userID string) (*repository.TOTP, error) {
+				if storedTOTP == nil {
+					return nil, nil
+				}
+				totp := *storedTOTP
+				return &totp, nil
+			},
+			confirmTOTPFunc: func(ctx context.Context, userID string, confirmedAt time.Time) error {
+				if storedTOTP == nil || storedTOTP.ConfirmedAt != nil {
+					return repository.ErrRecordNotFound
+				}
+				storedTOTP.ConfirmedAt = &confirmedAt
+				return nil
+			},
+			useTOTPStepFunc: func(ctx context.Context, userID string, step int64) error {
+				if storedTOTP == nil || storedTOTP.LastUsedStep >= step {
+					return repository.ErrRecordNotFound
+				}
+				storedTOTP.LastUsedStep = step
+				return nil
+			},
+			deleteTOTPFunc: func(ctx context.Context, userID string) error {
+				if storedTOTP == nil {
+					return repository.ErrRecordNotFound
+				}
+				storedTOTP = nil
+				return nil
+			},
+		},
+		WithTOTP("Acme", []byte("totp-key")),
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	step := now.Unix() / 30
+
+	assert.Equal(t, errTOTPNotEnrolled, svc.ConfirmTOTP(context.Background(), storedUser.ID, "123456"))
+
+	enrollment, err := svc.EnrollTOTP(context.Background(), storedUser.ID)
+	require.NoError(t, err)
+
+	secret, err := totpEncoding.DecodeString(enrollment.Secret)
+	require.NoError(t, err)
+
+	uri, err := url.Parse(enrollment.URI)
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Acme:jdoe", uri.Path)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	assert.Equal(t, "Acme", uri.Query().Get("issuer"))
+
+	// The secret is stored encrypted
+	assert.NotContains(t, storedTOTP.SecretCiphertext, enrollment.Secret)
+
+	// Logins are not challenged before the second factor is confirmed
+	token, err := svc.GenerateToken(context.Background(), storedUser.Email, password)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+
+	assert.Equal(t, errTOTPCodeInvalid, svc.ConfirmTOTP(context.Background(), storedUser.ID, "abcdef"))
+
+	require.NoError(t, svc.ConfirmTOTP(context.Background(), storedUser.ID, totpCode(secret, step-1)))
+
+	_, err = svc.EnrollTOTP(context.Background(), storedUser.ID)
+	assert.Equal(t, errTOTPAlreadyEnabled, err)
+
+	t.Run("login is challenged", func(t *testing.T) {
+		challenge, err := svc.GenerateToken(context.Background(), storedUser.Email, password)
+		require.NoError(t, err)
+
+		// Challenge tokens are not accepted as JWT tokens
+		_, err = svc.VerifyToken(context.Background(), challenge)
+		assert.Equal(t, errMFARequired, err)
+
+		_, err = svc.RefreshToken(context.Background(), challenge)
+		assert.Equal(t, errMFARequired, err)
+
+		// The code confirming the secret can't be replayed
+		_, err = svc.VerifyTOTP(context.Background(), challenge, totpCode(secret, step-1))
+		assert.Equal(t, errTOTPCodeInvalid, err)
+
+		pair, err := svc.VerifyTOTP(context.Background(), challenge, totpCode(secret, step))
+		require.NoError(t, err)
+
+		assert.Empty(t, pair.RefreshToken)
+
+		resp, err := svc.VerifyToken(context.Background(), pair.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, storedUser.ID, resp.ID)
+
+		// Each challenge is only completed once
+		_, err = svc.VerifyTOTP(context.Background(), challenge, totpCode(secret, step+1))
+		assert.Equal(t, errMFAChallengeInvalid, err)
+
+		// Only challenge tokens are exchanged
+		_, err = svc.VerifyTOTP(context.Background(), pair.AccessToken, totpCode(secret, step+1))
+		assert.Equal(t, errMFAChallengeInvalid, err)
+	})
+
+	t.Run("token pair login is challenged", func(t *testing.T) {
+		challenge, err := svc.GenerateTokenPair(context.Background(), storedUser.Email, password)
+		require.NoError(t, err)
+
+		assert.True(t, challenge.MFARequired)
+		assert.Empty(t, challenge.RefreshToken)
+
+		pair, err := svc.VerifyTOTP(context.Background(), challenge.AccessToken, totpCode(secret, step+1))
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, pair.RefreshToken)
+		assert.Contains(t, refreshTokens, tokenDigest(pair.RefreshToken))
+	})
+
+	t.Run("disabled totp", func(t *testing.T) {
+		require.NoError(t, svc.DisableTOTP(context.Background(), storedUser.ID))
+		assert.Equal(t, errTOTPNotEnrolled, svc.DisableTOTP(context.Background(), storedUser.ID))
+
+		token, err := svc.GenerateToken(context.Background(), storedUser.Email, password)
+		require.NoError(t, err)
+
+		_, err = svc.VerifyToken(context.Background(), token)
+		assert.NoError(t, err)
+	})
+}
+
+func TestTOTP_unavailable(t *testing.T) {
+	t.Parallel()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+	_, err := svc.EnrollTOTP(context.Background(), uuid.New().String())
+	assert.Equal(t, errTOTPUnavailable, err)
+
+	assert.Equal(t, errTOTPUnavailable, svc.ConfirmTOTP(context.Background(), uuid.New().String(), "123456"))
+
+	_, err = svc.VerifyTOTP(context.Background(), "challenge", "123456")
+	assert.Equal(t, errTOTPUnavailable, err)
+}
@@ -1,56 +1,314 @@
 package users
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
-	"path"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/alesr/stdservices/pkg/validate"
 	"github.com/alesr/stdservices/users/repository"
+	"github.com/alesr/stdservices/users/webauthn"
 	"go.uber.org/zap"
 
 	"github.com/golang-jwt/jwt"
-	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
+const defaultTokenTTL time.Duration = time.Hour * 24
+
 var (
 	_                Service                = (*DefaultService)(nil)
 	jwtSigningMethod *jwt.SigningMethodHMAC = jwt.SigningMethodHS512
 )
 
 type (
-	// Service defines the service interface
-	Service interface {
-		// Create creates a new user and returns the created user with its ID and "user" role
-		Create(ctx context.Context, in CreateUserInput) (*User, error)
+	// Authenticator defines the authentication subset of the service interface,
+	// for consumers that only need to issue and verify tokens (e.g. an API gateway)
+	Authenticator interface {
+		// GenerateToken generates a JWT token for the user
+		GenerateToken(ctx context.Context, email, password string) (string, error)
 
-		// Delete soft deletes a user by id
-		Delete(ctx context.Context, id string) error
+		// VerifyToken verifies a JWT token and returns the user username, id and role
+		VerifyToken(ctx context.Context, token string) (*VerifyTokenResponse, error)
+
+		// RefreshToken verifies a JWT token and returns a new token for the same user,
+		// valid for the same duration as the verified token
+		RefreshToken(ctx context.Context, token string) (string, error)
+	}
+
+	// UserManager defines the user management subset of the service interface,
+	// for consumers that only manage users (e.g. admin tooling)
+	UserManager interface {
+		// Create creates a new user and returns the created user with its ID and "user" role,
+		// reporting whether the verification email was sent
+		Create(ctx context.Context, in CreateUserInput) (*CreateUserResponse, error)
 
 		// FetchByID fetches a non-deleted user by id and returns the user
 		FetchByID(ctx context.Context, id string) (*User, error)
 
-		// GenerateToken generates a JWT token for the user
-		GenerateToken(ctx context.Context, email, password string) (string, error)
+		// FetchByEmail fetches a non-deleted user by email and returns the user
+		FetchByEmail(ctx context.Context, email string) (*User, error)
 
-		// VerifyToken verifies a JWT token and returns the user username, id and role
-		VerifyToken(ctx context.Context, token string) (*VerifyTokenResponse, error)
+		// FetchByUsername fetches a non-deleted user by username, case-insensitively, and returns the user
+		FetchByUsername(ctx context.Context, username string) (*User, error)
+
+		// Update partially updates the profile of a user, leaving the nil fields of the input unchanged
+		Update(ctx context.Context, id string, in UpdateUserInput) (*User, error)
+
+		// Delete soft deletes a user by id
+		Delete(ctx context.Context, id string) error
+	}
+
+	// Service defines the service interface, the union of the authentication and user management
+	// interfaces with the remaining features
+	Service interface {
+		Authenticator
+		UserManager
+
+		// DeleteWithReason soft deletes a user by id recording the reason and the actor found in the context
+		DeleteWithReason(ctx context.Context, id, reason string) error
+
+		// AnonymizeUser replaces the personal data of a user with placeholders, keeping its id
+		AnonymizeUser(ctx context.Context, id string) error
+
+		// FetchByIDWithDeleted fetches a user by id, including soft deleted users and their deletion details
+		FetchByIDWithDeleted(ctx context.Context, id string) (*User, error)
+
+		// GetRole returns the role of a non-deleted user without loading the whole user
+		GetRole(ctx context.Context, userID string) (role, error)
+
+		// ChangeEmail changes the email of the user after verifying the current password,
+		// once the verification sent to the new email is confirmed
+		ChangeEmail(ctx context.Context, userID, currentPassword, newEmail string) error
+
+		// ChangePassword changes the password of the user after verifying the current password
+		ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error
+
+		// RequestPasswordReset emails a single-use password reset code to the user with the given email
+		RequestPasswordReset(ctx context.Context, email string) error
+
+		// ResetPassword sets the password of the user the password reset code was sent to
+		ResetPassword(ctx context.Context, code, newPassword string) error
+
+		// SetPasswordAuthDisabled enables or disables password authentication for the user (e.g. SSO-only accounts)
+		SetPasswordAuthDisabled(ctx context.Context, userID string, disabled bool) error
+
+		// RevokeUserTokens invalidates all the tokens issued to the user so far
+		RevokeUserTokens(ctx context.Context, userID string) error
+
+		// RevokeToken revokes a single token before its expiration (e.g. on logout)
+		RevokeToken(ctx context.Context, token string) error
+
+		// Reactivate restores a soft deleted user within the deletion grace period
+		Reactivate(ctx context.Context, id string) error
+
+		// GenerateTokenWithTTL generates a JWT token for the user valid for the given ttl,
+		// capped to the maximum token TTL (e.g. for "remember me" logins)
+		GenerateTokenWithTTL(ctx context.Context, email, password string, ttl time.Duration) (string, error)
+
+		// GenerateTokenResponse generates a JWT token for the user wrapped in an OAuth-style token response
+		GenerateTokenResponse(ctx context.Context, email, password string) (*TokenResponse, error)
+
+		// GenerateTokenPair generates a JWT access token along with a refresh token for the user
+		GenerateTokenPair(ctx context.Context, email, password string) (*TokenPair, error)
+
+		// RefreshAccessToken exchanges a refresh token for a new JWT access token and a new refresh token
+		RefreshAccessToken(ctx context.Context, refreshToken string) (*TokenPair, error)
+
+		// RevokeRefreshToken revokes a refresh token, e.g. on logout
+		RevokeRefreshToken(ctx context.Context, refreshToken string) error
+
+		// GenerateTokenForAudience generates a JWT token for the user restricted to the given audience
+		GenerateTokenForAudience(ctx context.Context, email, password, audience string) (string, error)
+
+		// Authenticate generates a JWT token for the user identified by either its email or username
+		Authenticate(ctx context.Context, identifier, password string) (string, error)
+
+		// StepUp re-authenticates the user with its password, and its TOTP code if enabled,
+		// and returns a short-lived step-up token
+		StepUp(ctx context.Context, userID, password, code string) (string, error)
+
+		// RequireStepUp rejects tokens other than unexpired step-up tokens of the user, for sensitive actions
+		RequireStepUp(ctx context.Context, userID, token string) error
+
+		// EnrollTOTP generates a TOTP secret for the user, returned along with its provisioning URI
+		EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error)
+
+		// ConfirmTOTP enables the TOTP second factor of the user once a code of the enrolled secret is confirmed
+		ConfirmTOTP(ctx context.Context, userID, code string) error
+
+		// DisableTOTP disables the TOTP second factor of the user
+		DisableTOTP(ctx context.Context, userID string) error
+
+		// VerifyTOTP exchanges the challenge token returned by a login of a user with a second factor
+		// and a TOTP code for the JWT token
+		VerifyTOTP(ctx context.Context, challengeToken, code string) (*TokenPair, error)
+
+		// BeginPasskeyRegistration starts the registration of a passkey for the user
+		BeginPasskeyRegistration(ctx context.Context, userID string) (*PasskeyRegistration, error)
+
+		// FinishPasskeyRegistration verifies the registered credential and stores the passkey of the user
+		FinishPasskeyRegistration(ctx context.Context, state string, response []byte) error
+
+		// BeginPasskeyLogin starts a passkey login
+		BeginPasskeyLogin(ctx context.Context) (*PasskeyLogin, error)
+
+		// GenerateTokenWithPasskey generates a JWT token for the user of the asserted passkey
+		GenerateTokenWithPasskey(ctx context.Context, state string, response []byte) (string, error)
+
+		// AuthenticateWithOAuth exchanges the authorization code of the provider for the profile of the user,
+		// creating or linking the user of the provider identity, and generates a JWT token for the user
+		AuthenticateWithOAuth(ctx context.Context, provider, code string) (string, error)
+
+		// RequestLoginLink emails a single-use login link to the user with the given email
+		RequestLoginLink(ctx context.Context, email string) error
+
+		// ConsumeLoginLink generates a JWT token for the user the login link token was sent to
+		ConsumeLoginLink(ctx context.Context, token string) (string, error)
+
+		// VerifyAuthorizationHeader verifies the JWT token of a "Bearer <token>" authorization header
+		VerifyAuthorizationHeader(ctx context.Context, header string) (*VerifyTokenResponse, error)
+
+		// TokenTimeToLive returns the time left until a JWT token expires
+		TokenTimeToLive(token string) (time.Duration, error)
+
+		// UserInfo verifies a JWT token and returns the OIDC userinfo claims of its user
+		UserInfo(ctx context.Context, token string) (map[string]interface{}, error)
 
 		// SendEmailVerification sends an email verification to the user.
 		// The user must be created before calling this method.
 		SendEmailVerification(ctx context.Context, userID, username, to string) error
+
+		// SendEmailVerifications sends an email verification to each of the non-verified users
+		// with bounded concurrency, returning the number of emails sent and the per-user errors
+		SendEmailVerifications(ctx context.Context, userIDs []string, concurrency int) (sent int, errs []error)
+
+		// SendTestEmail sends a fixed test message with the configured emailer,
+		// so operators can check the email configuration before going live
+		SendTestEmail(ctx context.Context, to string) error
+
+		// ConfirmEmailVerification confirms the email of the user the verification code was sent to.
+		// Meant for link based flows, form based flows should use ConfirmEmailVerificationFor.
+		ConfirmEmailVerification(ctx context.Context, code string) error
+
+		// ConfirmEmailVerificationFor confirms the email of the given user with a verification code
+		// sent to that user, which shrinks the space of codes to guess to a single user
+		ConfirmEmailVerificationFor(ctx context.Context, userID, code string) error
+
+		// ListLoginHistory lists the newest successful logins of the user, up to limit, newest first
+		ListLoginHistory(ctx context.Context, userID string, limit int) ([]LoginEvent, error)
+
+		// LoginHistory lists a page of the recorded login attempts of the user, newest first
+		LoginHistory(ctx context.Context, userID string, page LoginHistoryInput) (*LoginHistoryPage, error)
+
+		// ListSessions lists the active sessions of the user along with their device, oldest first
+		ListSessions(ctx context.Context, userID string) ([]Session, error)
+
+		// RevokeSession revokes a session of the user, signing its device out
+		RevokeSession(ctx context.Context, userID, sessionID string) error
+
+		// ListEmailVerifications lists the email verifications sent to the user, newest first, with masked codes
+		ListEmailVerifications(ctx context.Context, userID string) ([]EmailVerification, error)
+
+		// GetUserStats returns aggregate statistics about non-deleted users
+		GetUserStats(ctx context.Context) (*UserStats, error)
+
+		// List lists a page of non-deleted users matching the input filters, for admin tooling
+		List(ctx context.Context, in ListUsersInput) (*UserPage, error)
+
+		// WhichEmailsExist reports, for each given email, whether it is held by a user
+		WhichEmailsExist(ctx context.Context, emails []string) (map[string]bool, error)
+
+		// ListUsersWithStalePasswords lists a page of the users whose password was last changed more than olderThan ago
+		ListUsersWithStalePasswords(ctx context.Context, olderThan time.Duration, page StalePasswordsInput) (*StalePasswordsPage, error)
+
+		// GenerateSignedActionURL returns a URL authenticating an action (e.g. unsubscribe) for the user
+		// until ttl elapses, to be embedded in emails
+		GenerateSignedActionURL(action, userID string, ttl time.Duration) (string, error)
+
+		// VerifySignedAction verifies a signed action URL and returns the action and user id it authenticates
+		VerifySignedAction(url string) (action, userID string, err error)
+
+		// Keys returns the public keys verifying the issued tokens as a typed JSON Web Key Set
+		Keys(ctx context.Context) (*JWKSet, error)
+
+		// JWKS returns the public keys verifying the issued tokens as a JSON Web Key Set
+		JWKS() ([]byte, error)
+
+		// JWKSHandler returns a handler serving the JSON Web Key Set at "/.well-known/jwks.json"
+		JWKSHandler() http.Handler
+
+		// RotateSigningKey signs the tokens issued from now on with the signer, still verifying
+		// the tokens signed with the replaced key during the key rotation grace period
+		RotateSigningKey(signer Signer) error
+
+		// ValidateSigningConfig signs and verifies a throwaway token to surface signing misconfigurations
+		ValidateSigningConfig() error
 	}
 
 	repo interface {
 		Insert(ctx context.Context, user *repository.User) (*repository.User, error)
 		SelectByID(ctx context.Context, id string) (*repository.User, error)
 		SelectByEmail(ctx context.Context, email string) (*repository.User, error)
-		DeleteByID(ctx context.Context, id string) error
+		SelectByUsername(ctx context.Context, username string) (*repository.User, error)
+		Update(ctx context.Context, user *repository.User) (*repository.User, error)
+		UpdatePasswordHash(ctx context.Context, id, currentHash, newHash string) error
+		SelectByIDWithDeleted(ctx context.Context, id string) (*repository.User, error)
+		SelectRoleByID(ctx context.Context, id string) (string, error)
+		SelectByEmailWithDeleted(ctx context.Context, email string) (*repository.User, error)
+		DeleteByID(ctx context.Context, id, reason, deletedBy string) error
+		RestoreByID(ctx context.Context, id string) error
+		AnonymizeByID(ctx context.Context, id, tombstone string, anonymizedAt time.Time) error
+		MarkPasswordResetRequested(ctx context.Context, id string, requestedAt, notBefore time.Time) (bool, error)
+		InsertPasswordReset(ctx context.Context, in repository.PasswordReset) error
+		SelectPasswordReset(ctx context.Context, codeHash string) (*repository.PasswordReset, error)
+		MarkPasswordResetUsed(ctx context.Context, codeHash string, usedAt time.Time) error
 		InsertEmailVerification(ctx context.Context, in repository.EmailVerification) error
+		SelectEmailVerification(ctx context.Context, code string) (*repository.EmailVerification, error)
+		SelectEmailVerificationForUser(ctx context.Context, userID, code string) (*repository.EmailVerification, error)
+		SelectEmailVerificationsByUserID(ctx context.Context, userID string) ([]repository.EmailVerification, error)
+		MarkEmailVerified(ctx context.Context, code string) error
+		SelectUserStats(ctx context.Context) (*repository.UserStats, error)
+		SelectUsers(ctx context.Context, filter repository.UserFilter) ([]repository.User, error)
+		SelectExistingEmails(ctx context.Context, emails []string) ([]string, error)
+		SelectByPasswordChangedBefore(ctx context.Context, before time.Time, after *repository.PasswordChangedCursor, limit int) ([]repository.User, error)
+		InsertSession(ctx context.Context, in repository.Session, maxActive int, evictOldest bool) error
+		SelectSession(ctx context.Context, id string) (*repository.Session, error)
+		SelectActiveSessionsByUserID(ctx context.Context, userID string, now time.Time) ([]repository.Session, error)
+		UpdateSessionExpiresAt(ctx context.Context, id string, expiresAt time.Time) error
+		DeleteSession(ctx context.Context, id string) error
+		InsertRefreshToken(ctx context.Context, in repository.RefreshToken) error
+		SelectRefreshToken(ctx context.Context, tokenHash string) (*repository.RefreshToken, error)
+		RevokeRefreshToken(ctx context.Context, tokenHash string, revokedAt time.Time) error
+		ConsumeRefreshToken(ctx context.Context, tokenHash string, consumedAt time.Time) error
+		RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+		RevokeRefreshTokensBySessionID(ctx context.Context, sessionID string, revokedAt time.Time) error
+		UpsertTOTP(ctx context.Context, in repository.TOTP) error
+		SelectTOTP(ctx context.Context, userID string) (*repository.TOTP, error)
+		ConfirmTOTP(ctx context.Context, userID string, confirmedAt time.Time) error
+		UseTOTPStep(ctx context.Context, userID string, step int64) error
+		DeleteTOTP(ctx context.Context, userID string) error
+		InsertPasskey(ctx context.Context, in repository.Passkey) error
+		SelectPasskey(ctx context.Context, id string) (*repository.Passkey, error)
+		SelectPasskeysByUserID(ctx context.Context, userID string) ([]repository.Passkey, error)
+		UpdatePasskeySignCount(ctx context.Context, id string, signCount int64, usedAt time.Time) error
+		InsertIdentity(ctx context.Context, in repository.Identity) error
+		SelectIdentity(ctx context.Context, provider, subject string) (*repository.Identity, error)
+		InsertLoginEvent(ctx context.Context, in repository.LoginEvent, keep int) error
+		SelectLoginEvents(ctx context.Context, userID string, before *repository.LoginEventCursor, limit int) ([]repository.LoginEvent, error)
+		SelectLoginEventsByOutcome(ctx context.Context, userID, outcome string, limit int) ([]repository.LoginEvent, error)
+		InsertPasswordHistory(ctx context.Context, userID, passwordHash string, createdAt time.Time, keep int) error
+		SelectPasswordHistory(ctx context.Context, userID string) ([]string, error)
 	}
 
 	emailer interface {
@@ -58,14 +316,202 @@ type (
 	}
 
 	jwtClaim struct {
-		id   string
-		role string
+		UserID       string `json:"user_id"`
+		Role         string `json:"role"`
+		Fingerprint  string `json:"fph,omitempty"`
+		Reactivation bool   `json:"rea,omitempty"`
+		SessionID    string `json:"sid,omitempty"`
+		StepUp       bool   `json:"step_up,omitempty"`
+		MaxSession   int64  `json:"max_session,omitempty"`
+		MFA          string `json:"mfa,omitempty"`
 		jwt.StandardClaims
+
+		// Extra holds the application-specific claims (see WithClaimsEnricher)
+		Extra map[string]interface{} `json:"-"`
 	}
 )
 
 type ServiceOption func(*DefaultService)
 
+// WithPasswordValidator sets a custom password validator that runs in addition to the
+// built-in password rules. An error returned by fn rejects the password with its message.
+func WithPasswordValidator(fn func(password string, user CreateUserInput) error) ServiceOption {
+	return func(s *DefaultService) {
+		s.passwordValidator = fn
+	}
+}
+
+// WithTokenBinding binds issued tokens to the client fingerprint found in the context
+// (see ContextWithClientInfo) and rejects tokens presented by a different client.
+//
+// Binding mitigates token theft, but the fingerprint is derived from the user-agent and IP,
+// so tokens are also rejected when a legitimate client changes network (e.g. mobile devices
+// switching between Wi-Fi and cellular). Only a hash of the fingerprint is stored in the token.
+func WithTokenBinding() ServiceOption {
+	return func(s *DefaultService) {
+		s.tokenBinding = true
+	}
+}
+
+// WithTokenTTL sets the TTL of the tokens issued without an explicit TTL, replacing the default of 24 hours
+func WithTokenTTL(ttl time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.tokenTTL = ttl
+	}
+}
+
+// WithMaxTokenTTL sets the maximum TTL callers can request for a token.
+// It defaults to the token TTL (see WithTokenTTL).
+func WithMaxTokenTTL(ttl time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.maxTokenTTL = ttl
+	}
+}
+
+// WithIssuer sets the "iss" claim of the issued tokens. Once set, VerifyToken rejects the tokens
+// of other issuers, including the tokens without issuer issued before, with errTokenIssuerUntrusted,
+// unless they are trusted with WithTrustedIssuers.
+func WithIssuer(issuer string) ServiceOption {
+	return func(s *DefaultService) {
+		s.issuer = issuer
+	}
+}
+
+// WithAudience sets the "aud" claim of the issued tokens not restricted to another audience with
+// GenerateTokenForAudience. Once set, VerifyToken rejects the tokens intended for another audience
+// with errTokenAudienceMismatch, unless the verification declares its audience with ContextWithAudience.
+func WithAudience(audience string) ServiceOption {
+	return func(s *DefaultService) {
+		s.audience = audience
+	}
+}
+
+// WithPreCheckUniqueness checks that the email and username are not taken before hashing
+// the password on create, so duplicate signups don't waste a bcrypt hash.
+// The unique constraints enforced on insert remain the authoritative guard against races.
+func WithPreCheckUniqueness() ServiceOption {
+	return func(s *DefaultService) {
+		s.preCheckUniqueness = true
+	}
+}
+
+// WithKeyID sets the id of the signing key in the "kid" header of the issued tokens,
+// so external verifiers (e.g. gateways fetching keys from a JWKS) can select the right key.
+// Tokens carrying a different key id are rejected on verification.
+func WithKeyID(kid string) ServiceOption {
+	return func(s *DefaultService) {
+		s.keyID = kid
+	}
+}
+
+// WithDeletionGracePeriod lets users who deleted their own account, deleted with DeletionReasonSelfService,
+// log in during the grace period after their deletion (e.g. "account recovery within 30 days").
+// Users deleted for any other reason (e.g. by an operator for spam) can't log in nor reactivate their
+// account. Tokens issued during the grace period carry a reactivation claim: VerifyToken accepts them
+// and reports ReactivationRequired, prompting the client to offer the account recovery, which is done
+// by calling Reactivate. Once the grace period is over, logins are rejected as for any other deleted user.
+func WithDeletionGracePeriod(d time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.deletionGracePeriod = d
+	}
+}
+
+// WithClaimsBuilder sets a function building additional claims (e.g. issuer, audience or custom claims)
+// for the issued tokens. The mandatory claims (user_id, role, exp, iat and the service claims) are
+// merged over the built claims, so the builder can't override or remove them.
+func WithClaimsBuilder(fn func(userID, role string) jwt.MapClaims) ServiceOption {
+	return func(s *DefaultService) {
+		s.claimsBuilder = fn
+	}
+}
+
+// WithTrustedIssuers makes token verification accept tokens minted by external issuers sharing the
+// signing key (e.g. a legacy auth service during a migration). Once set, only the tokens without issuer,
+// as issued by this service by default, and the tokens whose "iss" claim is one of the trusted issuers
+// are accepted; when the service sets its own issuer through WithClaimsBuilder rather than WithIssuer,
+// it must be trusted too.
+// Signature and expiry are enforced for all tokens.
+func WithTrustedIssuers(issuers ...string) ServiceOption {
+	return func(s *DefaultService) {
+		s.trustedIssuers = make(map[string]bool, len(issuers))
+		for _, issuer := range issuers {
+			s.trustedIssuers[issuer] = true
+		}
+	}
+}
+
+// WithRequireVerifiedEmail rejects the logins of users who haven't verified their email
+// (see WithVerificationGracePeriod to let new users log in before verifying it).
+func WithRequireVerifiedEmail() ServiceOption {
+	return func(s *DefaultService) {
+		s.requireVerifiedEmail = true
+	}
+}
+
+// WithVerificationGracePeriod lets users log in without a verified email during the given period
+// after their sign up, when a verified email is required (see WithRequireVerifiedEmail).
+// Once the period is over, unverified users are rejected until they verify their email.
+func WithVerificationGracePeriod(d time.Duration) ServiceOption {
+	return func(s *DefaultService) {
+		s.verificationGracePeriod = d
+	}
+}
+
+// WithHashedVerificationCodes stores the HMAC-SHA256 of the email verification codes, keyed by the HMAC key
+// (see WithHMACKey), instead of the codes, so reading the database doesn't expose valid codes: the short codes
+// can't be brute-forced from their digests without the key. The presented codes are hashed before the lookup.
+// Codes sent before enabling it, or stored as unkeyed SHA-256 digests by previous versions, can no longer
+// be confirmed, so users waiting for a verification have to request a new one.
+func WithHashedVerificationCodes() ServiceOption {
+	return func(s *DefaultService) {
+		s.hashedVerificationCodes = true
+	}
+}
+
+// WithSigningConfigValidation makes New validate the token signing configuration (see ValidateSigningConfig)
+// and panic when it is invalid, so misconfigured services fail at boot rather than on the first login.
+func WithSigningConfigValidation() ServiceOption {
+	return func(s *DefaultService) {
+		s.validateSigningConfig = true
+	}
+}
+
+// WithOptionalBirthdate lets users sign up without a birthdate, which is required by default.
+// Users created without a birthdate have an empty Birthdate, telling "not provided" apart from any real date.
+func WithOptionalBirthdate() ServiceOption {
+	return func(s *DefaultService) {
+		s.optionalBirthdate = true
+	}
+}
+
+// WithWelcomeEmail sends a welcome email to created users, in addition to the email verification.
+// The template is executed with a WelcomeEmailData to produce the message body.
+// Welcome emails are best-effort and require an emailer (see WithEmailVerification).
+func WithWelcomeEmail(tmpl *template.Template) ServiceOption {
+	return func(s *DefaultService) {
+		s.welcomeEmailTemplate = tmpl
+	}
+}
+
+// WithDeletionConfirmationEmail emails users once their account is deleted, to the address they had
+// before the deletion. The template is executed with a DeletionConfirmationEmailData to produce
+// the message body, which should tell what data was deleted and for how long it is retained.
+// Confirmations are best-effort and require an emailer (see WithEmailVerification).
+func WithDeletionConfirmationEmail(tmpl *template.Template) ServiceOption {
+	return func(s *DefaultService) {
+		s.deletionConfirmationTemplate = tmpl
+	}
+}
+
+// WithPasswordChangeNotifications emails users when their password is changed, including the time
+// and the client IP found in the context (see ContextWithClientInfo), so they notice unauthorized changes.
+// Notifications are best-effort and require an emailer (see WithEmailVerification).
+func WithPasswordChangeNotifications() ServiceOption {
+	return func(s *DefaultService) {
+		s.passwordChangeNotifications = true
+	}
+}
+
 func WithEmailVerification(fromName, fromAddr, endpoint string, emailer emailer) ServiceOption {
 	return func(s *DefaultService) {
 		s.emailer = emailer
@@ -76,13 +522,77 @@ func WithEmailVerification(fromName, fromAddr, endpoint string, emailer emailer)
 }
 
 type DefaultService struct {
-	logger                      *zap.Logger
-	jwtSigningKey               string
-	emailVerificationSenderName string
-	emailVerificationSenderAddr string
-	emailVerificationEndpoint   string
-	emailer                     emailer
-	repo                        repo
+	logger                       *zap.Logger
+	jwtSigningKey                string
+	hmacKey                      string
+	keyID                        string
+	signer                       Signer
+	keys                         *keyRing
+	keyRotationGracePeriod       time.Duration
+	publicKeys                   []publicKey
+	emailEncryption              *emailEncryption
+	totp                         *totpConfig
+	webauthn                     *webauthn.RelyingParty
+	oauthProviders               map[string]OAuthProvider
+	pagination                   pagination
+	idGenerator                  func() string
+	idValidator                  func(id string) error
+	clock                        func() time.Time
+	emailVerificationSenderName  string
+	emailVerificationSenderAddr  string
+	emailVerificationEndpoint    string
+	verificationEndpointResolver func(ctx context.Context) string
+	emailer                      emailer
+	hashedVerificationCodes      bool
+	passwordValidator            func(password string, user CreateUserInput) error
+	passwordPolicy               *validate.PasswordPolicy
+	inputValidator               Validator
+	tokenBinding                 bool
+	preCheckUniqueness           bool
+	passwordChangeNotifications  bool
+	welcomeEmailTemplate         *template.Template
+	deletionConfirmationTemplate *template.Template
+	loginAlertTemplate           *template.Template
+	optionalBirthdate            bool
+	requireVerifiedEmail         bool
+	verificationGracePeriod      time.Duration
+	confusableUsernameCheck      bool
+	claimsBuilder                func(userID, role string) jwt.MapClaims
+	claimsEnricher               func(ctx context.Context, user *User) (map[string]interface{}, error)
+	trustedIssuers               map[string]bool
+	validateSigningConfig        bool
+	mxValidator                  *mxValidator
+	maxActiveSessions            int
+	sessionTracking              bool
+	loginHistorySize             int
+	sessionLimitPolicy           SessionLimitPolicy
+	tokenTTL                     time.Duration
+	maxTokenTTL                  time.Duration
+	issuer                       string
+	audience                     string
+	refreshTokenTTL              time.Duration
+	slidingIdleTTL               time.Duration
+	slidingAbsoluteTTL           time.Duration
+	deletionGracePeriod          time.Duration
+	actionEndpoint               string
+	loginLinkEndpoint            string
+	userCache                    UserCache
+	userCacheTTL                 time.Duration
+	revocationStore              TokenRevocationStore
+	passwordHasher               Hasher
+	passwordHistorySize          int
+	breachChecker                BreachChecker
+	rateLimiter                  RateLimiter
+	loginRateLimit               rateLimit
+	tokenIssuanceRate            rateLimit
+	verificationRateLimit        rateLimit
+	passwordResetInterval        time.Duration
+	perDomainSendRate            rateLimit
+	repoRetry                    *retryPolicy
+	retryBackoffCeiling          time.Duration
+	retryJitter                  bool
+	background                   *sync.WaitGroup
+	repo                         repo
 }
 
 // New instantiates a new users service
@@ -90,232 +600,1716 @@ func New(logger *zap.Logger, jwtSigningKey string, repo repo, opts ...ServiceOpt
 	service := DefaultService{
 		logger:        logger,
 		jwtSigningKey: jwtSigningKey,
+		background:    &sync.WaitGroup{},
 		repo:          repo,
 	}
 
+	if service.logger == nil {
+		service.logger = zap.NewNop()
+	}
+
 	for _, opt := range opts {
 		opt(&service)
 	}
+
+	if service.rateLimiter == nil && (service.loginRateLimit.limit > 0 || service.tokenIssuanceRate.limit > 0 ||
+		service.verificationRateLimit.limit > 0 || service.perDomainSendRate.limit > 0) {
+		service.rateLimiter = newMemoryRateLimiter()
+	}
+
+	service.keys = &keyRing{}
+
+	if service.revocationStore == nil {
+		service.revocationStore = NewMemoryTokenRevocationStore()
+
+		// Single-use credentials consumed on one instance could be replayed on another, or after a restart
+		if service.loginLinkEndpoint != "" || service.webauthn != nil {
+			service.logger.Warn("login links and passkeys are single-use per service instance only, set a shared token revocation store")
+		}
+	}
+
+	if service.repoRetry != nil {
+		service.repoRetry.ceiling = service.retryBackoffCeiling
+		service.repoRetry.jitter = service.retryJitter
+		service.repo = &retryRepo{repo: service.repo, policy: service.repoRetry}
+	}
+
+	// Login links, passkeys and action URLs signed with an empty secret could be forged by anyone
+	if service.hmacRequired() && service.macKey() == "" {
+		panic(fmt.Sprintf("invalid signing config: %s", errHMACKeyEmpty))
+	}
+
+	if service.validateSigningConfig {
+		if err := service.ValidateSigningConfig(); err != nil {
+			panic(fmt.Sprintf("invalid signing config: %s", err))
+		}
+	}
 	return &service
 }
 
-// Create creates a new user and returns the created user
-func (s *DefaultService) Create(ctx context.Context, in CreateUserInput) (*User, error) {
-	if err := in.validate(); err != nil {
+// Create creates a new user and returns the created user along with whether the verification email was sent
+func (s *DefaultService) Create(ctx context.Context, in CreateUserInput) (*CreateUserResponse, error) {
+	// Usernames are stored in NFC, so differently encoded but identical usernames collide
+	in.Username = norm.NFC.String(in.Username)
+
+	if err := in.validate(s.newPasswordValidator(), s.optionalBirthdate); err != nil {
+		return nil, fmt.Errorf("could not validate create user input: %w", err)
+	}
+
+	if s.confusableUsernameCheck && usernameConfusable(in.Username) {
+		return nil, errUsernameConfusable
+	}
+
+	if err := s.validatePassword(in.Password, in); err != nil {
 		return nil, fmt.Errorf("could not validate create user input: %w", err)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(in.Password), bcrypt.DefaultCost)
+	if err := s.checkPasswordBreached(ctx, in.Password); err != nil {
+		return nil, err
+	}
+
+	if err := s.validateEmailDomain(ctx, in.Email); err != nil {
+		return nil, err
+	}
+
+	if s.preCheckUniqueness {
+		if err := s.checkUniqueness(ctx, in); err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := s.hashPassword(in.Password)
 	if err != nil {
-		return nil, fmt.Errorf("could not hash password: %s", err)
+		return nil, err
 	}
 
-	insertedUser, err := s.repo.Insert(ctx, &repository.User{
-		ID:            uuid.NewString(),
-		Fullname:      in.Fullname,
-		Username:      in.Username,
-		Birthdate:     in.Birthdate,
-		Email:         in.Email,
-		EmailVerified: false,
-		PasswordHash:  string(hash),
-		Role:          string(RoleUser),
-		CreatedAt:     time.Now(),
-		UpdatedAt:     time.Now(),
-	})
+	now := s.now()
+
+	newUser := repository.User{
+		ID:                 s.newID(),
+		Fullname:           in.Fullname,
+		Username:           in.Username,
+		UsernameNormalized: normalizeUsername(in.Username),
+		Birthdate:          in.Birthdate,
+		EmailVerified:      false,
+		PasswordHash:       hash,
+		Role:               string(RoleUser),
+		CreatedAt:          now,
+		UpdatedAt:          now,
+		PasswordChangedAt:  now,
+	}
+
+	if err := s.sealEmail(&newUser, in.Email); err != nil {
+		return nil, fmt.Errorf("could not encrypt email: %s", err)
+	}
+
+	insertedUser, err := s.repo.Insert(ctx, &newUser)
 	if err != nil {
-		if errors.Is(err, repository.ErrDuplicateRecord) {
+		switch {
+		case errors.Is(err, repository.ErrDuplicateEmail):
+			return nil, errEmailTaken
+		case errors.Is(err, repository.ErrDuplicateUsername):
+			return nil, errUsernameTaken
+		case errors.Is(err, repository.ErrDuplicateRecord):
 			return nil, errAlreadyExists
 		}
-		return nil, fmt.Errorf("could not insert user: %s", err)
+		s.logger.Error("could not insert user", zap.String("operation", "create"), errorField(err))
+		return nil, wrapErr(ctx, "could not insert user", err)
 	}
 
-	user, err := newUserFromRepository(insertedUser)
+	user, err := s.userFromRepository(insertedUser)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
 	}
 
+	resp := CreateUserResponse{User: user}
+
 	if s.emailer != nil {
 		if err := s.SendEmailVerification(ctx, user.ID, user.Username, user.Email); err != nil {
 			// It doesn't matter if the email verification fails.
 			// The next time an API call is made, a new verification will can be requested
-			s.logger.Error("could not send email verification", zap.String("user_id", user.ID), zap.Error(err))
+			s.logger.Error("could not send email verification", userIDField(user.ID), errorField(err))
+		} else {
+			resp.VerificationEmailSent = true
+			resp.VerificationEmailRecipient = MaskEmail(user.Email)
 		}
 	}
-	return user, nil
-}
 
-// FetchByID fetches a user by id and returns the user
-func (s *DefaultService) FetchByID(ctx context.Context, id string) (*User, error) {
-	if err := validate.ID(id); err != nil {
-		return nil, fmt.Errorf("could not validate id: %w", err)
-	}
+	s.sendWelcomeEmail(ctx, user)
 
-	storageUser, err := s.repo.SelectByID(ctx, id)
+	s.logger.Info("user created", zap.String("operation", "create"), userIDField(user.ID))
+	return &resp, nil
+}
+
+// checkUniqueness returns errEmailTaken or errUsernameTaken if the email or username is already taken
+func (s *DefaultService) checkUniqueness(ctx context.Context, in CreateUserInput) error {
+	storageUser, err := s.repo.SelectByEmail(ctx, s.emailLookup(in.Email))
 	if err != nil {
-		return nil, fmt.Errorf("could not select user by id: %s", err)
+		return wrapErr(ctx, "could not select user by email", err)
 	}
 
-	if storageUser == nil {
-		return nil, errNotFound
+	if storageUser != nil {
+		return errEmailTaken
 	}
 
-	user, err := newUserFromRepository(storageUser)
+	storageUser, err = s.repo.SelectByUsername(ctx, normalizeUsername(in.Username))
 	if err != nil {
-		return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+		return wrapErr(ctx, "could not select user by username", err)
 	}
-	return user, nil
+
+	if storageUser != nil {
+		return errUsernameTaken
+	}
+	return nil
 }
 
-func (s *DefaultService) Delete(ctx context.Context, id string) error {
-	if err := validate.ID(id); err != nil {
-		return fmt.Errorf("could not validate id: %w", err)
+// FetchByID fetches a user by id and returns the user
+func (s *DefaultService) FetchByID(ctx context.Context, id string) (*User, error) {
+	if err := s.validateID(id); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
 	}
 
-	if err := s.repo.DeleteByID(ctx, id); err != nil {
-		return fmt.Errorf("could not delete user by id: %s", err)
+	storageUser, err := s.selectUserByID(ctx, id)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by id", err)
 	}
-	return nil
+	return s.fetchedUser(storageUser)
 }
 
-// GenerateToken generates a JWT token for the user
-func (s *DefaultService) GenerateToken(ctx context.Context, email, password string) (string, error) {
-	if err := validate.Email(email); err != nil {
-		return "", fmt.Errorf("could not validate email: %s", err)
+// FetchByEmail fetches a non-deleted user by email and returns the user
+func (s *DefaultService) FetchByEmail(ctx context.Context, email string) (*User, error) {
+	if err := s.validator().ValidateEmail(email); err != nil {
+		return nil, fmt.Errorf("could not validate email: %w", err)
 	}
 
-	if err := validate.Password(password); err != nil {
-		return "", fmt.Errorf("could not validate password: %s", err)
+	storageUser, err := s.repo.SelectByEmail(ctx, s.emailLookup(email))
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by email", err)
 	}
+	return s.fetchedUser(storageUser)
+}
 
-	// Fetch user by username
-	storageUser, err := s.repo.SelectByEmail(ctx, email)
-	if err != nil {
-		return "", fmt.Errorf("could not select user by email: %s", err)
+// FetchByUsername fetches a non-deleted user by username and returns the user.
+// Usernames are compared in their canonical form, so the lookup is case-insensitive.
+func (s *DefaultService) FetchByUsername(ctx context.Context, username string) (*User, error) {
+	if err := validate.Username(username); err != nil {
+		return nil, fmt.Errorf("could not validate username: %w", newE(err.Error()))
 	}
 
-	// Check if user exists
-	if storageUser == nil {
-		return "", errNotFound
+	storageUser, err := s.repo.SelectByUsername(ctx, normalizeUsername(username))
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by username", err)
 	}
+	return s.fetchedUser(storageUser)
+}
 
-	// Check if password is correct
-	if err := bcrypt.CompareHashAndPassword([]byte(storageUser.PasswordHash), []byte(password)); err != nil {
-		return "", errPasswordInvalid
+// fetchedUser parses a fetched storage user to the domain model, returning errNotFound for missing users
+func (s *DefaultService) fetchedUser(storageUser *repository.User) (*User, error) {
+	if storageUser == nil {
+		return nil, errNotFound
 	}
 
-	// Generate JWT
-	token, err := s.generateJWT(storageUser.ID, role(storageUser.Role))
+	user, err := s.userFromRepository(storageUser)
 	if err != nil {
-		return "", fmt.Errorf("could not generate jwt: %s", err)
+		return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
 	}
-	return token, nil
+	return user, nil
 }
 
-// VerifyToken verifies a JWT token and returns the authentication data
-func (s *DefaultService) VerifyToken(ctx context.Context, token string) (*VerifyTokenResponse, error) {
-	if token == "" {
-		return nil, errTokenEmpty
+// GetRole returns the role of a non-deleted user, selecting only the role
+// (e.g. for authorization checks on hot paths)
+func (s *DefaultService) GetRole(ctx context.Context, userID string) (role, error) {
+	if err := s.validateID(userID); err != nil {
+		return "", fmt.Errorf("could not validate id: %w", err)
 	}
 
-	jwtToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		method, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		if method.Alg() != jwtSigningMethod.Alg() {
-			return nil, errors.New("invalid token signing method")
-		}
-		return []byte(s.jwtSigningKey), nil
-	})
+	storageRole, err := s.repo.SelectRoleByID(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse token: %s", err)
+		if errors.Is(err, repository.ErrRecordNotFound) {
+			return "", errNotFound
+		}
+		return "", wrapErr(ctx, "could not select role by id", err)
 	}
 
-	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok || !jwtToken.Valid {
-		return nil, errTokenInvalid
+	r, err := parseRole(storageRole)
+	if err != nil {
+		return "", fmt.Errorf("could not parse storage role: %s", err)
 	}
+	return r, nil
+}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return nil, fmt.Errorf("could not find user id in token")
+// Update partially updates the profile of a non-deleted user and returns the updated user.
+// Only the non-nil fields of the input are changed (see UpdateUserInput).
+func (s *DefaultService) Update(ctx context.Context, id string, in UpdateUserInput) (*User, error) {
+	if err := s.validateID(id); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
 	}
 
-	role, ok := claims["role"].(string)
-	if !ok {
-		return nil, fmt.Errorf("could not find role in token")
+	if in.Username != nil {
+		// As on create, usernames are stored in NFC
+		username := norm.NFC.String(*in.Username)
+		in.Username = &username
 	}
 
-	expiration, ok := claims["exp"].(float64)
-	if !ok {
-		return nil, fmt.Errorf("could not find expiration in token")
+	if err := in.validate(s.optionalBirthdate); err != nil {
+		return nil, fmt.Errorf("could not validate update user input: %w", err)
 	}
 
-	if time.Unix(int64(expiration), 0).Before(time.Now()) {
-		return nil, errTokenExpired
+	if in.Username != nil && s.confusableUsernameCheck && usernameConfusable(*in.Username) {
+		return nil, errUsernameConfusable
 	}
 
-	storageUser, err := s.repo.SelectByID(ctx, userID)
+	storageUser, err := s.repo.SelectByID(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("could not select user by id: %s", err)
+		return nil, wrapErr(ctx, "could not select user by id", err)
 	}
 
 	if storageUser == nil {
 		return nil, errNotFound
 	}
 
-	return &VerifyTokenResponse{
-		ID:       storageUser.ID,
-		Username: storageUser.Username,
-		Role:     role,
-	}, nil
-}
+	if in.Fullname != nil {
+		storageUser.Fullname = *in.Fullname
+	}
 
-func (s *DefaultService) SendEmailVerification(ctx context.Context, userID, username, to string) error {
-	code := randString(6)
+	if in.Username != nil {
+		storageUser.Username = *in.Username
+		storageUser.UsernameNormalized = normalizeUsername(*in.Username)
+	}
+
+	if in.Birthdate != nil {
+		storageUser.Birthdate = *in.Birthdate
+	}
+
+	storageUser.UpdatedAt = s.now()
+
+	updatedUser, err := s.repo.Update(ctx, storageUser)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicateUsername):
+			return nil, errUsernameTaken
+		case errors.Is(err, repository.ErrRecordNotFound):
+			return nil, errNotFound
+		case errors.Is(err, repository.ErrVersionConflict):
+			return nil, errConcurrentModification
+		}
+		s.logger.Error("could not update user", zap.String("operation", "update"), userIDField(id), errorField(err))
+		return nil, wrapErr(ctx, "could not update user", err)
+	}
+
+	s.invalidateCachedUser(ctx, id)
+
+	user, err := s.userFromRepository(updatedUser)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+	}
+
+	s.logger.Info("user updated", zap.String("operation", "update"), userIDField(id))
+	return user, nil
+}
+
+// FetchByIDWithDeleted fetches a user by id, including soft deleted users, and returns the user
+func (s *DefaultService) FetchByIDWithDeleted(ctx context.Context, id string) (*User, error) {
+	if err := s.validateID(id); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageUser, err := s.repo.SelectByIDWithDeleted(ctx, id)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by id with deleted", err)
+	}
+
+	if storageUser == nil {
+		return nil, errNotFound
+	}
+
+	user, err := s.userFromRepository(storageUser)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+	}
+	return user, nil
+}
+
+// ChangeEmail changes the email of the user after verifying the current password.
+// A verification email is sent to the new email, which replaces the current one
+// once the verification is confirmed (see ConfirmEmailVerification).
+func (s *DefaultService) ChangeEmail(ctx context.Context, userID, currentPassword, newEmail string) error {
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if err := s.validator().ValidateEmail(newEmail); err != nil {
+		return fmt.Errorf("could not validate email: %w", err)
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, userID)
+	if err != nil {
+		return wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return errNotFound
+	}
+
+	if !s.verifyPassword(storageUser.PasswordHash, currentPassword) {
+		return errPasswordInvalid
+	}
+
+	// Fast path, the unique constraint enforced when the email is swapped remains the authoritative guard
+	other, err := s.repo.SelectByEmail(ctx, s.emailLookup(newEmail))
+	if err != nil {
+		return wrapErr(ctx, "could not select user by email", err)
+	}
+
+	if other != nil {
+		return errEmailTaken
+	}
+
+	// The email is swapped once the verification is confirmed, the current email remains in use meanwhile
+	var pending repository.User
+	if err := s.sealEmail(&pending, newEmail); err != nil {
+		return fmt.Errorf("could not encrypt email: %s", err)
+	}
+
+	verification := repository.EmailVerification{
+		UserID:                 userID,
+		PendingEmail:           pending.Email,
+		PendingEmailCiphertext: pending.EmailCiphertext,
+	}
+
+	if err := s.sendEmailVerification(ctx, verification, newEmail); err != nil {
+		s.logger.Error("could not send email verification", zap.String("operation", "change_email"), userIDField(userID), errorField(err))
+		return err
+	}
+
+	s.logger.Info("user email change requested", zap.String("operation", "change_email"), userIDField(userID))
+	return nil
+}
+
+// ChangePassword changes the password of the user after verifying the current password.
+// The new password is subject to the same rules as on create.
+func (s *DefaultService) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error {
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if err := s.newPasswordValidator().ValidatePassword(newPassword); err != nil {
+		return fmt.Errorf("could not validate password: %w", newE(err.Error()))
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, userID)
+	if err != nil {
+		return wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return errNotFound
+	}
+
+	if !s.verifyPassword(storageUser.PasswordHash, currentPassword) {
+		return errPasswordInvalid
+	}
+
+	user, err := s.userFromRepository(storageUser)
+	if err != nil {
+		return fmt.Errorf("could not parse storage user to domain model: %s", err)
+	}
+
+	if err := s.checkNewPassword(ctx, user, newPassword); err != nil {
+		return err
+	}
+
+	if err := s.checkPasswordReuse(ctx, storageUser, newPassword); err != nil {
+		return err
+	}
+	return s.updatePassword(ctx, storageUser, user, newPassword, "change_password")
+}
+
+// checkNewPassword checks a new password of the user against the password policy
+func (s *DefaultService) checkNewPassword(ctx context.Context, user *User, newPassword string) error {
+	if passwordContainsIdentity(newPassword, user.Username, user.Email) {
+		return errPasswordContainsIdentity
+	}
+
+	if err := s.validatePassword(newPassword, CreateUserInput{
+		Fullname:        user.Fullname,
+		Username:        user.Username,
+		Birthdate:       user.Birthdate,
+		Email:           user.Email,
+		Password:        newPassword,
+		ConfirmPassword: newPassword,
+	}); err != nil {
+		return fmt.Errorf("could not validate password: %w", err)
+	}
+	return s.checkPasswordBreached(ctx, newPassword)
+}
+
+// updatePassword sets the password of the user and logs it out everywhere, notifying the change when configured
+func (s *DefaultService) updatePassword(ctx context.Context, storageUser *repository.User, user *User, newPassword, operation string) error {
+	hash, err := s.hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// Log out everywhere, tokens issued before the change are rejected on verification
+	tokensValidAfter := s.now()
+
+	replacedHash := storageUser.PasswordHash
+
+	storageUser.PasswordHash = hash
+	storageUser.TokensValidAfter = &tokensValidAfter
+	storageUser.PasswordChangedAt = tokensValidAfter
+	storageUser.UpdatedAt = tokensValidAfter
+
+	if _, err := s.repo.Update(ctx, storageUser); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			return errNotFound
+		case errors.Is(err, repository.ErrVersionConflict):
+			return errConcurrentModification
+		}
+		s.logger.Error("could not update user", zap.String("operation", operation), userIDField(storageUser.ID), errorField(err))
+		return wrapErr(ctx, "could not update user", err)
+	}
+
+	s.invalidateCachedUser(ctx, storageUser.ID)
+	s.recordPasswordHistory(ctx, storageUser.ID, replacedHash)
+
+	s.logger.Info("user password changed", zap.String("operation", operation), userIDField(storageUser.ID))
+
+	s.notifyPasswordChanged(ctx, user)
+	return nil
+}
+
+// RevokeUserTokens invalidates all the tokens issued to the user so far, e.g. after a compromise,
+// by moving its tokens validity start to now. Tokens issued within the current second remain valid.
+func (s *DefaultService) RevokeUserTokens(ctx context.Context, userID string) error {
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, userID)
+	if err != nil {
+		return wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return errNotFound
+	}
+
+	tokensValidAfter := s.now()
+
+	storageUser.TokensValidAfter = &tokensValidAfter
+	storageUser.UpdatedAt = tokensValidAfter
+
+	if _, err := s.repo.Update(ctx, storageUser); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			return errNotFound
+		case errors.Is(err, repository.ErrVersionConflict):
+			return errConcurrentModification
+		}
+		s.logger.Error("could not update user", zap.String("operation", "revoke_user_tokens"), userIDField(userID), errorField(err))
+		return wrapErr(ctx, "could not update user", err)
+	}
+
+	s.invalidateCachedUser(ctx, userID)
+
+	s.logger.Info("user tokens revoked", zap.String("operation", "revoke_user_tokens"), userIDField(userID))
+	return nil
+}
+
+// SetPasswordAuthDisabled enables or disables password authentication for the user,
+// e.g. for accounts which must sign in through SSO only.
+// The password hash is kept, so password authentication can be enabled again.
+func (s *DefaultService) SetPasswordAuthDisabled(ctx context.Context, userID string, disabled bool) error {
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, userID)
+	if err != nil {
+		return wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		return errNotFound
+	}
+
+	storageUser.PasswordAuthDisabled = disabled
+	storageUser.UpdatedAt = s.now()
+
+	if _, err := s.repo.Update(ctx, storageUser); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			return errNotFound
+		case errors.Is(err, repository.ErrVersionConflict):
+			return errConcurrentModification
+		}
+		s.logger.Error("could not update user", zap.String("operation", "set_password_auth_disabled"), userIDField(userID), errorField(err))
+		return wrapErr(ctx, "could not update user", err)
+	}
+
+	s.invalidateCachedUser(ctx, userID)
+
+	s.logger.Info("user password authentication toggled", zap.String("operation", "set_password_auth_disabled"),
+		userIDField(userID), zap.Bool("disabled", disabled))
+	return nil
+}
+
+// sendWelcomeEmail sends the welcome email to a created user, when configured.
+// Failures are logged only, as for the email verification.
+func (s *DefaultService) sendWelcomeEmail(ctx context.Context, user *User) {
+	if s.welcomeEmailTemplate == nil || s.emailer == nil {
+		return
+	}
+
+	var msg bytes.Buffer
+	if err := s.welcomeEmailTemplate.Execute(&msg, WelcomeEmailData{Fullname: user.Fullname, Username: user.Username}); err != nil {
+		s.logger.Error("could not execute welcome email template", userIDField(user.ID), errorField(err))
+		return
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Welcome to %s\r\n\r\n%s\r\n",
+		s.emailVerificationSenderAddr, user.Email, s.emailVerificationSenderName, msg.String())
+
+	if err := s.sendEmail(ctx, user.Email, []byte(body)); err != nil {
+		s.logger.Error("could not send welcome email", userIDField(user.ID), errorField(err))
+	}
+}
+
+// notifyPasswordChanged emails the user that the password was changed, when enabled.
+// Failures are logged only, the password change must not fail because of the notification.
+func (s *DefaultService) notifyPasswordChanged(ctx context.Context, user *User) {
+	if !s.passwordChangeNotifications || s.emailer == nil {
+		return
+	}
+
+	details := "Time: " + s.now().UTC().Format(time.RFC1123)
+	if info, ok := clientInfoFromContext(ctx); ok && info.IP != "" {
+		details += "\r\nIP address: " + info.IP
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s Password Changed\r\n\r\nYour password was changed. If you did not make this change, please reset your password and contact support.\r\n\r\n%s\r\n",
+		s.emailVerificationSenderAddr, user.Email, s.emailVerificationSenderName, details)
+
+	if err := s.sendEmail(ctx, user.Email, []byte(body)); err != nil {
+		s.logger.Error("could not send password change notification", userIDField(user.ID), errorField(err))
+	}
+}
+
+// DeletionReasonSelfService is the deletion reason of users deleting their own account (see DeleteWithReason),
+// the only deletions recoverable within the deletion grace period (see WithDeletionGracePeriod)
+const DeletionReasonSelfService = "self_service"
+
+// Delete soft deletes a user by id
+func (s *DefaultService) Delete(ctx context.Context, id string) error {
+	return s.DeleteWithReason(ctx, id, "")
+}
+
+// DeleteWithReason soft deletes a user by id recording the reason (e.g. user request, spam)
+// and the actor found in the context (see ContextWithActor).
+// The tokens issued to the user so far are revoked, so they stay invalid if the user is restored.
+func (s *DefaultService) DeleteWithReason(ctx context.Context, id, reason string) error {
+	if err := s.validateID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	deletedBy := actorFromContext(ctx)
+
+	// The recipient is read before the deletion, which may remove or anonymize the email
+	recipient := s.deletionConfirmationRecipient(ctx, id)
+
+	if err := s.repo.DeleteByID(ctx, id, reason, deletedBy); err != nil {
+		s.logger.Error("could not delete user", zap.String("operation", "delete"), userIDField(id), errorField(err))
+		return wrapErr(ctx, "could not delete user by id", err)
+	}
+
+	s.invalidateCachedUser(ctx, id)
+
+	s.logger.Info("user deleted",
+		zap.String("operation", "delete"),
+		userIDField(id),
+		zap.String("reason", reason),
+		zap.String("deleted_by", maskID(deletedBy)),
+	)
+
+	s.sendDeletionConfirmation(ctx, recipient, reason)
+	return nil
+}
+
+// deletionConfirmationRecipient returns the user to send the deletion confirmation to,
+// or nil when confirmations are disabled or the user can't be read
+func (s *DefaultService) deletionConfirmationRecipient(ctx context.Context, id string) *User {
+	if s.deletionConfirmationTemplate == nil || s.emailer == nil {
+		return nil
+	}
+
+	storageUser, err := s.repo.SelectByID(ctx, id)
+	if err != nil {
+		s.logger.Error("could not select deletion confirmation recipient", userIDField(id), errorField(err))
+		return nil
+	}
+
+	if storageUser == nil {
+		return nil
+	}
+
+	user, err := s.userFromRepository(storageUser)
+	if err != nil {
+		s.logger.Error("could not parse deletion confirmation recipient", userIDField(id), errorField(err))
+		return nil
+	}
+	return user
+}
+
+// sendDeletionConfirmation emails the deleted user a deletion confirmation, telling until when
+// self-service deletions can be recovered. Failures are logged only, the deletion must not fail because of the confirmation.
+func (s *DefaultService) sendDeletionConfirmation(ctx context.Context, user *User, reason string) {
+	if user == nil {
+		return
+	}
+
+	data := DeletionConfirmationEmailData{
+		Fullname:  user.Fullname,
+		Username:  user.Username,
+		DeletedAt: s.now().UTC(),
+	}
+
+	if s.deletionGracePeriod > 0 && reason == DeletionReasonSelfService {
+		data.RecoverableUntil = data.DeletedAt.Add(s.deletionGracePeriod)
+	}
+
+	var msg bytes.Buffer
+	if err := s.deletionConfirmationTemplate.Execute(&msg, data); err != nil {
+		s.logger.Error("could not execute deletion confirmation email template", userIDField(user.ID), errorField(err))
+		return
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Your %s account was deleted\r\n\r\n%s\r\n",
+		s.emailVerificationSenderAddr, user.Email, s.emailVerificationSenderName, msg.String())
+
+	if err := s.sendEmail(ctx, user.Email, []byte(body)); err != nil {
+		s.logger.Error("could not send deletion confirmation email", userIDField(user.ID), errorField(err))
+	}
+}
+
+// Reactivate restores a user who deleted its own account within the deletion grace period.
+// Reactivating a user that is not deleted is a no-op.
+func (s *DefaultService) Reactivate(ctx context.Context, id string) error {
+	if err := s.validateID(id); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageUser, err := s.repo.SelectByIDWithDeleted(ctx, id)
+	if err != nil {
+		return wrapErr(ctx, "could not select user by id with deleted", err)
+	}
+
+	if storageUser == nil || storageUser.DeletedAt != nil && !s.inDeletionGracePeriod(storageUser) {
+		return errNotFound
+	}
+
+	if storageUser.DeletedAt == nil {
+		return nil
+	}
+
+	if err := s.repo.RestoreByID(ctx, id); err != nil {
+		return wrapErr(ctx, "could not restore user by id", err)
+	}
+	return nil
+}
+
+// inDeletionGracePeriod reports whether a user who deleted its own account is still within the deletion grace period
+func (s *DefaultService) inDeletionGracePeriod(user *repository.User) bool {
+	return s.deletionGracePeriod > 0 && user.DeletedAt != nil && user.DeletionReason == DeletionReasonSelfService &&
+		s.now().Sub(*user.DeletedAt) <= s.deletionGracePeriod
+}
+
+// emailVerificationRequired reports whether the user must verify the email before logging in,
+// i.e. a verified email is required and the verification grace period after the sign up is over
+func (s *DefaultService) emailVerificationRequired(user *repository.User) bool {
+	return s.requireVerifiedEmail && !user.EmailVerified &&
+		!s.now().Before(user.CreatedAt.Add(s.verificationGracePeriod))
+}
+
+// GenerateToken generates a JWT token for the user
+func (s *DefaultService) GenerateToken(ctx context.Context, email, password string) (string, error) {
+	return s.GenerateTokenWithTTL(ctx, email, password, s.tokenTTLOrDefault())
+}
+
+// GenerateTokenWithTTL generates a JWT token for the user valid for the given ttl.
+// A non-positive ttl falls back to the default and ttl is capped to the maximum token TTL.
+func (s *DefaultService) GenerateTokenWithTTL(ctx context.Context, email, password string, ttl time.Duration) (string, error) {
+	return s.generateToken(ctx, email, password, ttl, "")
+}
+
+// GenerateTokenForAudience generates a JWT token for the user restricted to the given audience (aud claim),
+// e.g. a client such as "web" or "mobile". Verifications declaring another audience with ContextWithAudience
+// reject the token with errTokenAudienceMismatch.
+func (s *DefaultService) GenerateTokenForAudience(ctx context.Context, email, password, audience string) (string, error) {
+	if audience == "" {
+		return "", errTokenAudienceEmpty
+	}
+	return s.generateToken(ctx, email, password, s.tokenTTLOrDefault(), audience)
+}
+
+// generateToken generates a JWT token valid for ttl for the user authenticated by email,
+// restricted to audience when not empty
+func (s *DefaultService) generateToken(ctx context.Context, email, password string, ttl time.Duration, audience string) (string, error) {
+	issued, _, err := s.login(ctx, email, password, ttl, audience, mfaChallengeToken)
+	if err != nil {
+		return "", err
+	}
+	return issued.token, nil
+}
+
+// loginToken is a JWT token issued on login along with its session, empty when sessions are disabled,
+// and its actual lifetime. Challenged tokens are second factor challenges (see issueLoginToken),
+// which start no session.
+type loginToken struct {
+	token      string
+	sessionID  string
+	ttl        time.Duration
+	challenged bool
+}
+
+// login authenticates the user by email and returns a JWT token valid for ttl along with the user.
+// For users with a second factor, the token is a challenge of the given kind (see issueLoginToken).
+func (s *DefaultService) login(ctx context.Context, email, password string, ttl time.Duration, audience, mfaKind string) (*loginToken, *repository.User, error) {
+	storageUser, err := s.authenticate(ctx, email, password)
+	if err != nil {
+		s.logger.Info("login failed", zap.String("operation", "generate_token"), errorField(err))
+		return nil, nil, err
+	}
+
+	if err := s.allowTokenIssuance(ctx, storageUser.ID); err != nil {
+		s.logger.Info("login failed", zap.String("operation", "generate_token"), userIDField(storageUser.ID), errorField(err))
+		return nil, nil, err
+	}
+
+	issued, err := s.issueLoginToken(ctx, storageUser, ttl, audience, mfaKind, "generate_token")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.rehashPassword(ctx, storageUser, password)
+	return issued, storageUser, nil
+}
+
+// issueLoginToken starts a session for the authenticated user and returns a JWT token valid for ttl,
+// restricted to audience when not empty. For users with a second factor, it returns a challenge token
+// of the given kind instead, reporting the token as challenged (see VerifyTOTP).
+// The operation names the login in the logs.
+func (s *DefaultService) issueLoginToken(ctx context.Context, storageUser *repository.User, ttl time.Duration, audience, mfaKind, operation string) (*loginToken, error) {
+	challenge, err := s.issueMFAChallenge(ctx, storageUser, audience, mfaKind, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge != "" {
+		return &loginToken{token: challenge, ttl: mfaChallengeTTL, challenged: true}, nil
+	}
+	return s.startLoginSession(ctx, storageUser, ttl, audience, operation)
+}
+
+// startLoginSession starts a session for the authenticated user and returns a JWT token valid for ttl,
+// restricted to audience when not empty
+func (s *DefaultService) startLoginSession(ctx context.Context, storageUser *repository.User, ttl time.Duration, audience, operation string) (*loginToken, error) {
+	ttl = s.loginTTL(ttl)
+
+	sessionID, err := s.startSession(ctx, storageUser.ID, ttl)
+	if err != nil {
+		s.logger.Info("login failed", zap.String("operation", operation), userIDField(storageUser.ID), errorField(err))
+		return nil, err
+	}
+
+	claims, err := s.newJWTClaims(storageUser.ID, role(storageUser.Role), ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users deleted within the grace period get a token prompting their reactivation
+	claims.Reactivation = storageUser.DeletedAt != nil
+	claims.SessionID = sessionID
+	if audience != "" {
+		claims.Audience = audience
+	}
+	s.startSlidingSession(claims)
+
+	if err := s.enrichClaims(ctx, claims, storageUser); err != nil {
+		s.logger.Error("could not enrich claims", zap.String("operation", operation), userIDField(storageUser.ID), errorField(err))
+		return nil, err
+	}
+
+	token, err := s.signJWT(ctx, *claims)
+	if err != nil {
+		s.logger.Error("could not generate jwt", zap.String("operation", operation), userIDField(storageUser.ID), errorField(err))
+		return nil, wrapErr(ctx, "could not generate jwt", err)
+	}
+
+	s.alertNewLogin(ctx, storageUser)
+	s.recordLogin(ctx, storageUser.ID, LoginSucceeded)
+
+	s.logger.Debug("login succeeded", zap.String("operation", operation), userIDField(storageUser.ID))
+	// Sliding sessions may cap the expiration below ttl
+	expiresIn := time.Unix(claims.ExpiresAt, 0).Sub(time.Unix(claims.IssuedAt, 0))
+
+	return &loginToken{token: token, sessionID: sessionID, ttl: expiresIn}, nil
+}
+
+// Authenticate generates a JWT token for the user identified by either its email or username,
+// for login forms with a single field. Identifiers looking like an email are looked up by email.
+// Wrong identifiers and passwords fail alike with errInvalidCredentials.
+// Unlike email logins, username logins don't allow users deleted within the deletion grace period.
+func (s *DefaultService) Authenticate(ctx context.Context, identifier, password string) (string, error) {
+	authenticate := s.authenticateUsername
+	if strings.Contains(identifier, "@") && s.validator().ValidateEmail(identifier) == nil {
+		authenticate = s.authenticate
+	}
+
+	if s.validateLoginPassword(password) != nil {
+		s.logger.Info("login failed", zap.String("operation", "authenticate"), errorField(errInvalidCredentials))
+		return "", errInvalidCredentials
+	}
+
+	storageUser, err := authenticate(ctx, identifier, password)
+	if err != nil {
+		s.logger.Info("login failed", zap.String("operation", "authenticate"), errorField(err))
+		if errors.Is(err, errNotFound) || errors.Is(err, errPasswordInvalid) {
+			return "", errInvalidCredentials
+		}
+		return "", err
+	}
+
+	if err := s.allowTokenIssuance(ctx, storageUser.ID); err != nil {
+		s.logger.Info("login failed", zap.String("operation", "authenticate"), userIDField(storageUser.ID), errorField(err))
+		return "", err
+	}
+
+	issued, err := s.issueLoginToken(ctx, storageUser, s.tokenTTLOrDefault(), "", mfaChallengeToken, "authenticate")
+	if err != nil {
+		return "", err
+	}
+
+	s.rehashPassword(ctx, storageUser, password)
+	return issued.token, nil
+}
+
+// GenerateTokenResponse generates a JWT token for the user wrapped in an OAuth-style token response,
+// for clients expecting the access_token, token_type and expires_in fields.
+// For users with a second factor, the response holds no access token but a challenge token
+// in mfa_token, to be exchanged with a TOTP code by VerifyTOTP.
+func (s *DefaultService) GenerateTokenResponse(ctx context.Context, email, password string) (*TokenResponse, error) {
+	issued, _, err := s.login(ctx, email, password, s.tokenTTLOrDefault(), "", mfaChallengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if issued.challenged {
+		return &TokenResponse{
+			ExpiresIn:   int64(issued.ttl / time.Second),
+			MFARequired: true,
+			MFAToken:    issued.token,
+		}, nil
+	}
+
+	return &TokenResponse{
+		AccessToken: issued.token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(issued.ttl / time.Second),
+	}, nil
+}
+
+// authenticate checks the user credentials and returns the authenticated user
+func (s *DefaultService) authenticate(ctx context.Context, email, password string) (*repository.User, error) {
+	if err := s.validator().ValidateEmail(email); err != nil {
+		return nil, fmt.Errorf("could not validate email: %s", err)
+	}
+
+	if err := s.validateLoginPassword(password); err != nil {
+		return nil, fmt.Errorf("could not validate password: %s", err)
+	}
+
+	return s.checkCredentials(ctx, "login:", password, func() (*repository.User, error) {
+		storageUser, err := s.repo.SelectByEmail(ctx, s.emailLookup(email))
+		if err != nil {
+			return nil, wrapErr(ctx, "could not select user by email", err)
+		}
+
+		// Users who deleted their own account can still log in during the deletion grace period
+		if storageUser == nil && s.deletionGracePeriod > 0 {
+			storageUser, err = s.repo.SelectByEmailWithDeleted(ctx, s.emailLookup(email))
+			if err != nil {
+				return nil, wrapErr(ctx, "could not select user by email with deleted", err)
+			}
+
+			if storageUser != nil && !s.inDeletionGracePeriod(storageUser) {
+				storageUser = nil
+			}
+		}
+		return storageUser, nil
+	})
+}
+
+// authenticateUsername checks the user credentials by username and returns the authenticated user
+func (s *DefaultService) authenticateUsername(ctx context.Context, username, password string) (*repository.User, error) {
+	if err := s.validateLoginPassword(password); err != nil {
+		return nil, fmt.Errorf("could not validate password: %s", err)
+	}
+
+	username = normalizeUsername(username)
+
+	return s.checkCredentials(ctx, "login:", password, func() (*repository.User, error) {
+		storageUser, err := s.repo.SelectByUsername(ctx, username)
+		if err != nil {
+			return nil, wrapErr(ctx, "could not select user by username", err)
+		}
+		return storageUser, nil
+	})
+}
+
+// checkCredentials checks the password of the user returned by lookup, applying the login rate limit
+// to the key of the user id with keyPrefix. Keying the limit on the user id rather than on the identifier
+// makes logins by email, in any case, and by username share the same attempts.
+func (s *DefaultService) checkCredentials(ctx context.Context, keyPrefix, password string, lookup func() (*repository.User, error)) (*repository.User, error) {
+	storageUser, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if user exists. Soft deleted users only log in during the deletion grace period,
+	// whichever rows the repository lookup returns
+	if storageUser == nil || storageUser.DeletedAt != nil && !s.inDeletionGracePeriod(storageUser) {
+		return nil, errNotFound
+	}
+
+	loginKey := keyPrefix + storageUser.ID
+
+	limit, err := s.allow(ctx, loginKey, s.loginRateLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit != nil && !limit.Allowed {
+		return nil, errRateLimited
+	}
+
+	// Check if password is correct
+	if !s.verifyPassword(storageUser.PasswordHash, password) {
+		s.recordLogin(ctx, storageUser.ID, LoginFailedInvalidPassword)
+		if limit != nil {
+			return nil, newLoginAttemptsError(limit)
+		}
+		return nil, errPasswordInvalid
+	}
+
+	// Checked after the password, so the flag is not disclosed without valid credentials
+	if storageUser.PasswordAuthDisabled {
+		s.recordLogin(ctx, storageUser.ID, LoginFailedPasswordAuthDisabled)
+		return nil, errPasswordAuthDisabled
+	}
+
+	if s.emailVerificationRequired(storageUser) {
+		s.recordLogin(ctx, storageUser.ID, LoginFailedEmailNotVerified)
+		return nil, errEmailNotVerified
+	}
+
+	if limit != nil {
+		if err := s.rateLimiter.Reset(ctx, loginKey); err != nil {
+			s.logger.Error("could not reset login rate limit", userIDField(storageUser.ID), errorField(err))
+		}
+	}
+	return storageUser, nil
+}
+
+// clampTokenTTL returns the token TTL for non-positive values and caps ttl to the maximum token TTL
+func (s *DefaultService) clampTokenTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return s.tokenTTLOrDefault()
+	}
+
+	maxTTL := s.maxTokenTTL
+	if maxTTL <= 0 {
+		maxTTL = s.tokenTTLOrDefault()
+	}
+
+	if ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
+
+// tokenTTLOrDefault returns the TTL of the tokens issued without an explicit TTL
+func (s *DefaultService) tokenTTLOrDefault() time.Duration {
+	if s.tokenTTL <= 0 {
+		return defaultTokenTTL
+	}
+	return s.tokenTTL
+}
+
+// VerifyToken verifies a JWT token and returns the authentication data
+func (s *DefaultService) VerifyToken(ctx context.Context, token string) (*VerifyTokenResponse, error) {
+	claims, err := s.parseAndValidateClaims(token)
+	if err != nil {
+		s.logger.Debug("invalid token", zap.String("operation", "verify_token"), errorField(err))
+		return nil, err
+	}
+
+	resp, err := s.verifyClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.RefreshedToken, err = s.slideToken(ctx, claims, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// RefreshToken verifies a JWT token and returns a new token for the same user and role,
+// valid for the same duration as the verified token (capped to the maximum token TTL).
+// Tokens prompting a reactivation are refreshed as such.
+func (s *DefaultService) RefreshToken(ctx context.Context, token string) (string, error) {
+	claims, err := s.parseAndValidateClaims(token)
+	if err != nil {
+		s.logger.Debug("invalid token", zap.String("operation", "refresh_token"), errorField(err))
+		return "", err
+	}
+
+	resp, err := s.verifyClaims(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := s.clampTokenTTL(time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second)
+
+	// Refreshing keeps the session of the token alive instead of starting a new one
+	if err := s.extendSession(ctx, claims.SessionID, ttl); err != nil {
+		return "", err
+	}
+
+	refreshedClaims, err := s.newJWTClaims(resp.ID, role(resp.Role), ttl)
+	if err != nil {
+		return "", err
+	}
+
+	refreshedClaims.Reactivation = resp.ReactivationRequired
+	refreshedClaims.SessionID = claims.SessionID
+	refreshedClaims.Audience = claims.Audience
+	refreshedClaims.MaxSession = claims.MaxSession
+	refreshedClaims.Extra = claims.Extra
+	capToMaxSession(refreshedClaims)
+
+	refreshed, err := s.signJWT(ctx, *refreshedClaims)
+	if err != nil {
+		s.logger.Error("could not generate jwt", zap.String("operation", "refresh_token"), userIDField(resp.ID), errorField(err))
+		return "", wrapErr(ctx, "could not generate jwt", err)
+	}
+
+	s.logger.Debug("token refreshed", zap.String("operation", "refresh_token"), userIDField(resp.ID))
+	return refreshed, nil
+}
+
+// verifyClaims verifies the claims of a parsed token against the client and the stored user
+func (s *DefaultService) verifyClaims(ctx context.Context, claims *jwtClaim) (*VerifyTokenResponse, error) {
+	audience, ok := audienceFromContext(ctx)
+	if !ok && s.audience != "" {
+		audience, ok = s.audience, true
+	}
+
+	if ok && !claims.VerifyAudience(audience, true) {
+		s.logger.Debug("invalid token", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(errTokenAudienceMismatch))
+		return nil, errTokenAudienceMismatch
+	}
+
+	// Challenge tokens only prove the password, until VerifyTOTP exchanges them
+	if claims.MFA != "" {
+		s.logger.Debug("invalid token", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(errMFARequired))
+		return nil, errMFARequired
+	}
+
+	if err := s.checkSession(ctx, claims.SessionID); err != nil {
+		s.logger.Debug("invalid token", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(err))
+		return nil, err
+	}
+
+	if err := s.checkTokenRevoked(ctx, claims); err != nil {
+		s.logger.Debug("invalid token", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(err))
+		return nil, err
+	}
+
+	if s.tokenBinding {
+		info, _ := clientInfoFromContext(ctx)
+		if subtle.ConstantTimeCompare([]byte(claims.Fingerprint), []byte(info.fingerprint())) != 1 {
+			s.logger.Debug("invalid token", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(errTokenBindingMismatch))
+			return nil, errTokenBindingMismatch
+		}
+	}
+
+	if claims.Reactivation {
+		return s.verifyReactivationToken(ctx, claims)
+	}
+
+	storageUser, err := s.selectUserByID(ctx, claims.UserID)
+	if err != nil {
+		s.logger.Error("could not select user", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(err))
+		return nil, wrapErr(ctx, "could not select user by id", err)
+	}
+
+	if storageUser == nil {
+		s.logger.Debug("invalid token", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(errNotFound))
+		return nil, errNotFound
+	}
+
+	if tokenSuperseded(claims.IssuedAt, storageUser.TokensValidAfter) {
+		s.logger.Debug("invalid token", zap.String("operation", "verify_token"), userIDField(claims.UserID), errorField(errTokenSuperseded))
+		return nil, errTokenSuperseded
+	}
+
+	return &VerifyTokenResponse{
+		ID:       storageUser.ID,
+		Username: storageUser.Username,
+		Role:     claims.Role,
+		Claims:   claims.Extra,
+	}, nil
+}
+
+// verifyReactivationToken verifies a token issued to a user deleted within the deletion grace period.
+// Once the user has been reactivated the token is verified as any other token.
+func (s *DefaultService) verifyReactivationToken(ctx context.Context, claims *jwtClaim) (*VerifyTokenResponse, error) {
+	storageUser, err := s.repo.SelectByIDWithDeleted(ctx, claims.UserID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user by id with deleted", err)
+	}
+
+	if storageUser == nil || storageUser.DeletedAt != nil && !s.inDeletionGracePeriod(storageUser) {
+		return nil, errNotFound
+	}
+
+	if tokenSuperseded(claims.IssuedAt, storageUser.TokensValidAfter) {
+		return nil, errTokenSuperseded
+	}
+
+	return &VerifyTokenResponse{
+		ID:                   storageUser.ID,
+		Username:             storageUser.Username,
+		Role:                 claims.Role,
+		ReactivationRequired: storageUser.DeletedAt != nil,
+		Claims:               claims.Extra,
+	}, nil
+}
+
+// VerifyAuthorizationHeader verifies the JWT token of a "Bearer <token>" authorization header.
+// The scheme is case-insensitive and surrounding whitespace is ignored.
+func (s *DefaultService) VerifyAuthorizationHeader(ctx context.Context, header string) (*VerifyTokenResponse, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errMalformedAuthHeader
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, errMalformedAuthHeader
+	}
+	return s.VerifyToken(ctx, token)
+}
 
-	in := repository.EmailVerification{
-		Code:      code,
-		UserID:    userID,
-		CreatedAt: time.Now().UTC(),
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24),
+// parseAndValidateClaims verifies the token signature and returns its claims
+// once the required claims are present and the token is not expired
+func (s *DefaultService) parseAndValidateClaims(token string) (*jwtClaim, error) {
+	if token == "" {
+		return nil, errTokenEmpty
 	}
 
-	if err := s.repo.InsertEmailVerification(ctx, in); err != nil {
-		return fmt.Errorf("could not insert email verification: %s", err)
+	var claims jwtClaim
+	jwtToken, err := jwt.ParseWithClaims(token, &claims, s.jwtKeyFunc)
+	if err != nil {
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Errors == jwt.ValidationErrorExpired {
+			return nil, s.expiredError(&claims)
+		}
+		return nil, fmt.Errorf("could not parse token: %s", err)
+	}
+
+	if !jwtToken.Valid {
+		return nil, errTokenInvalid
+	}
+
+	if claims.UserID == "" {
+		return nil, fmt.Errorf("could not find user id in token")
+	}
+
+	if claims.Role == "" {
+		return nil, fmt.Errorf("could not find role in token")
+	}
+
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("could not find expiration in token")
+	}
+
+	if tokenExpired(claims.ExpiresAt, s.now()) {
+		return nil, s.expiredError(&claims)
+	}
+
+	if !s.issuerAccepted(claims.Issuer) {
+		return nil, errTokenIssuerUntrusted
+	}
+
+	if claims.Extra, err = extraClaims(token); err != nil {
+		return nil, fmt.Errorf("could not parse extra claims: %s", err)
+	}
+	return &claims, nil
+}
+
+// UserInfo verifies the token and returns the OIDC standard claims of its user
+// (sub, name, preferred_username, email and email_verified), as served by a userinfo endpoint.
+// Tokens do not carry scopes, so all claims are returned.
+func (s *DefaultService) UserInfo(ctx context.Context, token string) (map[string]interface{}, error) {
+	resp, err := s.VerifyToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.FetchByID(ctx, resp.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"sub":                user.ID,
+		"name":               user.Fullname,
+		"preferred_username": user.Username,
+		"email":              user.Email,
+		"email_verified":     user.EmailVerified,
+	}, nil
+}
+
+// TokenTimeToLive verifies the token signature and returns the time left until the token expires.
+// For an expired token it returns the (non-positive) elapsed duration along with errTokenExpired.
+func (s *DefaultService) TokenTimeToLive(token string) (time.Duration, error) {
+	if token == "" {
+		return 0, errTokenEmpty
+	}
+
+	var claims jwtClaim
+	if _, err := jwt.ParseWithClaims(token, &claims, s.jwtKeyFunc); err != nil {
+		// expiration is checked below, any other validation error is fatal
+		var vErr *jwt.ValidationError
+		if !errors.As(err, &vErr) || vErr.Errors != jwt.ValidationErrorExpired {
+			return 0, fmt.Errorf("could not parse token: %s", err)
+		}
+	}
+
+	if claims.ExpiresAt == 0 {
+		return 0, fmt.Errorf("could not find expiration in token")
+	}
+
+	now := s.now()
+
+	ttl := time.Unix(claims.ExpiresAt, 0).Sub(now)
+	if tokenExpired(claims.ExpiresAt, now) {
+		return ttl, errTokenExpired
+	}
+
+	// the token is still valid during the second it expires at
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// tokenExpired reports whether a token expiring at exp is expired at now.
+// Both sides are compared with the second precision of the exp claim, as the jwt library does,
+// so tokens don't expire up to a second early.
+func tokenExpired(exp int64, now time.Time) bool {
+	return now.Unix() > exp
+}
+
+// tokenSuperseded reports whether a token issued at iat predates validAfter (e.g. the last password change).
+// As for expiration, the comparison is done with the second precision of the iat claim.
+func tokenSuperseded(iat int64, validAfter *time.Time) bool {
+	return validAfter != nil && iat < validAfter.Unix()
+}
+
+// now returns the current time from the service clock
+func (s *DefaultService) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
+func (s *DefaultService) SendEmailVerification(ctx context.Context, userID, username, to string) error {
+	return s.sendEmailVerification(ctx, repository.EmailVerification{UserID: userID}, to)
+}
+
+// sendEmailVerification stores the email verification in, completed with its code and expiration,
+// and sends it to the given address. For email changes, in holds the pending email.
+func (s *DefaultService) sendEmailVerification(ctx context.Context, in repository.EmailVerification, to string) error {
+	if s.emailer == nil {
+		return errEmailerNotConfigured
+	}
+
+	limit, err := s.allow(ctx, "verification:"+in.UserID, s.verificationRateLimit)
+	if err != nil {
+		return err
+	}
+
+	if limit != nil && !limit.Allowed {
+		return errRateLimited
+	}
+
+	endpoint, err := s.verificationEndpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	code := randString(6)
+
+	link, err := verificationLink(endpoint, code)
+	if err != nil {
+		return err
+	}
+
+	if in.Code, err = s.storedVerificationCode(code); err != nil {
+		return err
 	}
 
+	in.CreatedAt = s.now().UTC()
+	in.ExpiresAt = in.CreatedAt.Add(time.Hour * 24)
+
 	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s Email Verification\r\n\r\nPlease click the following link to verify your email address: %s\r\n",
-		s.emailVerificationSenderAddr, to, s.emailVerificationSenderName, path.Join(s.emailVerificationEndpoint, code))
+		s.emailVerificationSenderAddr, to, s.emailVerificationSenderName, link)
+
+	// Awaited before storing the code, so delayed emails don't leave codes behind
+	if err := s.awaitDomainSendRate(ctx, to); err != nil {
+		return wrapErr(ctx, "could not send email verification", err)
+	}
+
+	if err := s.repo.InsertEmailVerification(ctx, in); err != nil {
+		return wrapErr(ctx, "could not insert email verification", err)
+	}
 
 	if err := s.emailer.Send(s.emailVerificationSenderName, to, []byte(body)); err != nil {
-		return fmt.Errorf("could not send email verification: %s", err)
+		return wrapErr(ctx, "could not send email verification", err)
 	}
 	return nil
 }
 
-func (s *DefaultService) generateJWT(userID string, role role) (string, error) {
-	if err := validate.ID(userID); err != nil {
-		return "", fmt.Errorf("could not validate id: %w", err)
+// storedVerificationCode returns the form a verification code is stored in,
+// its HMAC when the codes are hashed (see WithHashedVerificationCodes)
+func (s *DefaultService) storedVerificationCode(code string) (string, error) {
+	if !s.hashedVerificationCodes {
+		return code, nil
+	}
+	return s.signHMAC("email-verification", code)
+}
+
+// SendTestEmail sends a fixed test message to the given address with the configured emailer.
+// It returns the emailer error on failure, surfacing misconfigurations (e.g. SMTP credentials)
+// before the first user signs up.
+func (s *DefaultService) SendTestEmail(ctx context.Context, to string) error {
+	if s.emailer == nil {
+		return errEmailerNotConfigured
+	}
+
+	if err := s.validator().ValidateEmail(to); err != nil {
+		return fmt.Errorf("could not validate email: %w", err)
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s Test Email\r\n\r\nThis is a test email confirming the email configuration works.\r\n",
+		s.emailVerificationSenderAddr, to, s.emailVerificationSenderName)
+
+	if err := s.sendEmail(ctx, to, []byte(body)); err != nil {
+		return fmt.Errorf("could not send test email: %w", err)
+	}
+	return nil
+}
+
+// ConfirmEmailVerification confirms the email of the user the verification code was sent to.
+// The lookup is done by code only, so it is meant for links embedding a code too long to be guessed.
+// Prefer ConfirmEmailVerificationFor for codes typed by the user in a form.
+func (s *DefaultService) ConfirmEmailVerification(ctx context.Context, code string) error {
+	if code == "" {
+		return errVerificationNotFound
+	}
+
+	storedCode, err := s.storedVerificationCode(code)
+	if err != nil {
+		return err
+	}
+
+	verification, err := s.repo.SelectEmailVerification(ctx, storedCode)
+	if err != nil {
+		return wrapErr(ctx, "could not select email verification", err)
+	}
+	return s.confirmEmailVerification(ctx, verification)
+}
+
+// ConfirmEmailVerificationFor confirms the email of the given user with a verification code sent to that user.
+// A code sent to another user is rejected as not found.
+func (s *DefaultService) ConfirmEmailVerificationFor(ctx context.Context, userID, code string) error {
+	if err := s.validateID(userID); err != nil {
+		return fmt.Errorf("could not validate id: %w", err)
+	}
+
+	if code == "" {
+		return errVerificationNotFound
+	}
+
+	storedCode, err := s.storedVerificationCode(code)
+	if err != nil {
+		return err
+	}
+
+	verification, err := s.repo.SelectEmailVerificationForUser(ctx, userID, storedCode)
+	if err != nil {
+		return wrapErr(ctx, "could not select email verification", err)
+	}
+	return s.confirmEmailVerification(ctx, verification)
+}
+
+// ListEmailVerifications lists the email verifications sent to the user, newest first.
+// The codes are masked so the history can be shown to support staff without exposing valid codes.
+func (s *DefaultService) ListEmailVerifications(ctx context.Context, userID string) ([]EmailVerification, error) {
+	if err := s.validateID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
+	}
+
+	storageVerifications, err := s.repo.SelectEmailVerificationsByUserID(ctx, userID)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select email verifications", err)
+	}
+
+	verifications := make([]EmailVerification, 0, len(storageVerifications))
+	for _, v := range storageVerifications {
+		verifications = append(verifications, EmailVerification{
+			MaskedCode: maskCode(v.Code),
+			CreatedAt:  v.CreatedAt,
+			ExpiresAt:  v.ExpiresAt,
+			UsedAt:     v.UsedAt,
+		})
+	}
+	return verifications, nil
+}
+
+func (s *DefaultService) confirmEmailVerification(ctx context.Context, verification *repository.EmailVerification) error {
+	if verification == nil {
+		return errVerificationNotFound
+	}
+
+	if verification.UsedAt != nil {
+		return errVerificationUsed
+	}
+
+	if verification.ExpiresAt.Before(s.now()) {
+		return errVerificationExpired
+	}
+
+	if err := s.repo.MarkEmailVerified(ctx, verification.Code); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecordNotFound):
+			// the code was used concurrently
+			return errVerificationUsed
+		case errors.Is(err, repository.ErrDuplicateRecord):
+			// the pending email was taken since the email change was requested
+			return errEmailTaken
+		}
+		return wrapErr(ctx, "could not mark email as verified", err)
+	}
+
+	s.invalidateCachedUser(ctx, verification.UserID)
+	return nil
+}
+
+// sendEmail sends an email, delayed beyond the per recipient domain send rate when configured
+func (s *DefaultService) sendEmail(ctx context.Context, to string, body []byte) error {
+	if err := s.awaitDomainSendRate(ctx, to); err != nil {
+		return err
+	}
+	return s.emailer.Send(s.emailVerificationSenderName, to, body)
+}
+
+// validatePassword applies the custom password validator, if any, on top of the built-in rules
+func (s *DefaultService) validatePassword(password string, user CreateUserInput) error {
+	if s.passwordValidator == nil {
+		return nil
+	}
+
+	if err := s.passwordValidator(password, user); err != nil {
+		return newE(err.Error())
+	}
+	return nil
+}
+
+// stalePasswordsCursor is the position a stale passwords listing resumes from, encoded in the page cursors
+type stalePasswordsCursor struct {
+	PasswordChangedAt time.Time `json:"c"`
+	ID                string    `json:"i"`
+}
+
+// ListUsersWithStalePasswords lists a page of the non-deleted users whose password was last changed
+// more than olderThan ago, oldest password first (e.g. to prompt a password rotation).
+// Pages are limited as set with WithPagination and continue from the cursor of the previous page.
+// Users without password, such as the users created through OAuth, are not listed.
+func (s *DefaultService) ListUsersWithStalePasswords(ctx context.Context, olderThan time.Duration, page StalePasswordsInput) (*StalePasswordsPage, error) {
+	var after *repository.PasswordChangedCursor
+	if page.Cursor != "" {
+		var cursor stalePasswordsCursor
+		if err := decodeCursor(page.Cursor, &cursor); err != nil || cursor.ID == "" {
+			return nil, errCursorInvalid
+		}
+		after = &repository.PasswordChangedCursor{PasswordChangedAt: cursor.PasswordChangedAt, ID: cursor.ID}
+	}
+
+	limit := s.pageLimit(page.Limit)
+
+	// One more user tells whether there is a next page
+	storageUsers, err := s.repo.SelectByPasswordChangedBefore(ctx, s.now().Add(-olderThan), after, limit+1)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select users by password changed before", err)
+	}
+
+	var result StalePasswordsPage
+
+	if len(storageUsers) > limit {
+		storageUsers = storageUsers[:limit]
+
+		last := storageUsers[limit-1]
+		result.NextCursor = encodeCursor(stalePasswordsCursor{PasswordChangedAt: last.PasswordChangedAt, ID: last.ID})
+	}
+
+	result.Users = make([]User, 0, len(storageUsers))
+	for i := range storageUsers {
+		user, err := s.userFromRepository(&storageUsers[i])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse storage user to domain model: %s", err)
+		}
+		result.Users = append(result.Users, *user)
+	}
+	return &result, nil
+}
+
+// WhichEmailsExist reports, for each given email, whether it is held by a user, deleted users included,
+// as such emails cannot be registered again (e.g. for invite flows). Emails are compared case-insensitively
+// and the returned map is keyed by the given emails.
+func (s *DefaultService) WhichEmailsExist(ctx context.Context, emails []string) (map[string]bool, error) {
+	exist := make(map[string]bool, len(emails))
+	if len(emails) == 0 {
+		return exist, nil
+	}
+
+	// With email encryption the stored lookup hash depends on the case of the email, so the hashes
+	// of emails stored before emails were normalized are looked up as well
+	candidates := func(email string) []string {
+		if s.emailEncryption == nil {
+			return []string{s.emailLookup(email)}
+		}
+		return []string{s.emailLookup(email), s.emailEncryption.lookup(email)}
+	}
+
+	lookups := make([]string, 0, len(emails)*2)
+	for _, email := range emails {
+		lookups = append(lookups, candidates(email)...)
+	}
+
+	existing, err := s.repo.SelectExistingEmails(ctx, lookups)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select existing emails", err)
+	}
+
+	found := make(map[string]bool, len(existing))
+	for _, lookup := range existing {
+		found[lookup] = true
+	}
+
+	for _, email := range emails {
+		exist[email] = false
+		for _, lookup := range candidates(email) {
+			if found[lookup] {
+				exist[email] = true
+			}
+		}
+	}
+	return exist, nil
+}
+
+// GetUserStats returns aggregate statistics about non-deleted users
+func (s *DefaultService) GetUserStats(ctx context.Context) (*UserStats, error) {
+	storageStats, err := s.repo.SelectUserStats(ctx)
+	if err != nil {
+		return nil, wrapErr(ctx, "could not select user stats", err)
+	}
+
+	stats := UserStats{
+		Total:             storageStats.Total,
+		Verified:          storageStats.Verified,
+		Unverified:        storageStats.Total - storageStats.Verified,
+		CreatedLast7Days:  storageStats.CreatedLast7Days,
+		CreatedLast30Days: storageStats.CreatedLast30Days,
+		ByRole:            make(map[role]int, len(storageStats.ByRole)),
+	}
+
+	for r, count := range storageStats.ByRole {
+		stats.ByRole[role(r)] = count
+	}
+	return &stats, nil
+}
+
+// newJWTClaims returns the claims of a token issued now to the user, valid for ttl
+func (s *DefaultService) newJWTClaims(userID string, role role, ttl time.Duration) (*jwtClaim, error) {
+	if err := s.validateID(userID); err != nil {
+		return nil, fmt.Errorf("could not validate id: %w", err)
 	}
 
 	if err := role.validate(); err != nil {
-		return "", errRoleInvalid
+		return nil, errRoleInvalid
 	}
 
-	now := time.Now().UTC()
+	now := s.now().UTC()
 
-	token := jwt.NewWithClaims(jwtSigningMethod, jwtClaim{
-		userID,
-		string(role),
-		jwt.StandardClaims{
+	return &jwtClaim{
+		UserID: userID,
+		Role:   string(role),
+		StandardClaims: jwt.StandardClaims{
+			Id:        NewUUIDv4(),
+			Issuer:    s.issuer,
+			Audience:  s.audience,
 			IssuedAt:  now.Unix(),
-			ExpiresAt: now.Add(time.Hour * 24).Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
 		},
-	})
+	}, nil
+}
 
-	signedString, err := token.SignedString([]byte(s.jwtSigningKey))
+// signJWT binds the claims to the client when token binding is enabled and signs them
+func (s *DefaultService) signJWT(ctx context.Context, claims jwtClaim) (string, error) {
+	if s.tokenBinding {
+		info, ok := clientInfoFromContext(ctx)
+		if !ok {
+			return "", errors.New("could not bind token: client info not found in context")
+		}
+		claims.Fingerprint = info.fingerprint()
+	}
+
+	var tokenClaims jwt.Claims = claims
+	if s.claimsBuilder != nil || len(claims.Extra) > 0 {
+		mapClaims, err := s.buildClaims(claims)
+		if err != nil {
+			return "", fmt.Errorf("could not build claims: %s", err)
+		}
+		tokenClaims = mapClaims
+	}
+
+	signer := s.tokenSigner()
+
+	token := jwt.NewWithClaims(signer.Method(), tokenClaims)
+
+	if kid := signer.KeyID(); kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signedString, err := token.SignedString(signer.SigningKey())
 	if err != nil {
 		return "", fmt.Errorf("could not sign token: %s", err)
 	}
@@ -323,38 +2317,160 @@ func (s *DefaultService) generateJWT(userID string, role role) (string, error) {
 	return signedString, nil
 }
 
-func newUserFromRepository(user *repository.User) (*User, error) {
-	var role role
-	switch user.Role {
+// ValidateSigningConfig signs a throwaway token and verifies it as any presented token,
+// surfacing signing misconfigurations (e.g. an empty key or claims rejected on verification)
+// before the first token operation. No user is read or written.
+func (s *DefaultService) ValidateSigningConfig() error {
+	if s.jwtSigningKey == "" && s.signer == nil {
+		return errSigningKeyEmpty
+	}
+
+	if s.hmacRequired() && s.macKey() == "" {
+		return errHMACKeyEmpty
+	}
+
+	claims, err := s.newJWTClaims(s.newID(), RoleUser, time.Minute)
+	if err != nil {
+		return fmt.Errorf("could not create test claims: %s", err)
+	}
+
+	// token binding requires a client, any client does
+	ctx := ContextWithClientInfo(context.Background(), ClientInfo{})
+
+	token, err := s.signJWT(ctx, *claims)
+	if err != nil {
+		return fmt.Errorf("could not sign test token: %s", err)
+	}
+
+	verified, err := s.parseAndValidateClaims(token)
+	if err != nil {
+		return fmt.Errorf("could not verify test token: %s", err)
+	}
+
+	if verified.UserID != claims.UserID {
+		return errors.New("could not verify test token: user id mismatch")
+	}
+	return nil
+}
+
+// buildClaims merges the claims of the claims builder over the extra claims and the mandatory claims
+// over both, so the builder and the enricher can add claims but can't override or remove the mandatory ones
+func (s *DefaultService) buildClaims(claims jwtClaim) (jwt.MapClaims, error) {
+	mandatory, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	mapClaims := jwt.MapClaims{}
+	for k, v := range claims.Extra {
+		mapClaims[k] = v
+	}
+
+	if s.claimsBuilder != nil {
+		for k, v := range s.claimsBuilder(claims.UserID, claims.Role) {
+			mapClaims[k] = v
+		}
+	}
+
+	// Claims omitted when empty (e.g. fph) must not be set by the builder either
+	for _, k := range []string{"fph", "rea", "sid", "step_up", "max_session", "mfa"} {
+		delete(mapClaims, k)
+	}
+
+	if err := json.Unmarshal(mandatory, &mapClaims); err != nil {
+		return nil, err
+	}
+	return mapClaims, nil
+}
+
+// jwtKeyFunc returns the key used to verify tokens signed by the service
+func (s *DefaultService) jwtKeyFunc(token *jwt.Token) (interface{}, error) {
+	var signer Signer
+	if kid, ok := token.Header["kid"]; ok {
+		if kid, ok := kid.(string); ok {
+			signer = s.verificationSigner(kid)
+		}
+
+		if signer == nil {
+			return nil, fmt.Errorf("unknown key id: %v", kid)
+		}
+	} else {
+		// Tokens issued before the key id was configured have no kid header
+		if signer = s.verificationSigner(""); signer == nil {
+			signer = s.tokenSigner()
+		}
+	}
+
+	// Comparing the algorithms rejects tokens signed with another method, e.g. a HMAC keyed with a public key
+	if token.Method.Alg() != signer.Method().Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return signer.VerificationKey(), nil
+}
+
+// issuerAccepted reports whether the tokens of the issuer are accepted: the tokens of the service issuer
+// and of the trusted issuers, and the tokens without issuer unless the service issuer is set
+func (s *DefaultService) issuerAccepted(issuer string) bool {
+	switch {
+	case s.trustedIssuers[issuer]:
+		return true
+	case s.issuer != "":
+		return issuer == s.issuer
+	}
+	return s.trustedIssuers == nil || issuer == ""
+}
+
+// parseRole parses a role stored in the repository
+func parseRole(s string) (role, error) {
+	switch s {
 	case "user":
-		role = RoleUser
+		return RoleUser, nil
 	case "admin":
-		role = RoleAdmin
+		return RoleAdmin, nil
 	default:
-		return nil, fmt.Errorf("invalid role: %s", user.Role)
+		return "", fmt.Errorf("invalid role: %s", s)
+	}
+}
+
+func newUserFromRepository(user *repository.User) (*User, error) {
+	role, err := parseRole(user.Role)
+	if err != nil {
+		return nil, err
 	}
 
 	return &User{
-		ID:            user.ID,
-		Fullname:      user.Fullname,
-		Username:      user.Username,
-		Birthdate:     user.Birthdate,
-		Email:         user.Email,
-		EmailVerified: user.EmailVerified,
-		Role:          role,
-		CreatedAt:     user.CreatedAt,
-		UpdatedAt:     user.UpdatedAt,
+		ID:             user.ID,
+		Fullname:       user.Fullname,
+		Username:       user.Username,
+		Birthdate:      user.Birthdate,
+		Email:          user.Email,
+		EmailVerified:  user.EmailVerified,
+		Role:           role,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+		DeletedAt:      user.DeletedAt,
+		DeletionReason: user.DeletionReason,
+		DeletedBy:      user.DeletedBy,
+
+		PasswordAuthDisabled: user.PasswordAuthDisabled,
+		PasswordChangedAt:    user.PasswordChangedAt,
 	}, nil
 }
 
 const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
 
-var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
-
+// randString returns a random string of chars. It is safe for concurrent use.
 func randString(length int) string {
+	charCount := big.NewInt(int64(len(chars)))
+
 	b := make([]byte, length)
 	for i := range b {
-		b[i] = chars[seededRand.Intn(len(chars))]
+		n, err := rand.Int(rand.Reader, charCount)
+		if err != nil {
+			// the system random source doesn't fail on supported platforms
+			panic(fmt.Sprintf("could not read random source: %s", err))
+		}
+		b[i] = chars[n.Int64()]
 	}
 	return string(b)
 }
@@ -3,20 +3,81 @@ package users
 import (
 	"context"
 	"errors"
+	"net/http"
+	"time"
 )
 
 var _ Service = (*MockService)(nil)
 
 type MockService struct {
-	CreateFunc                func(ctx context.Context, in CreateUserInput) (*User, error)
-	DeleteFunc                func(ctx context.Context, id string) error
-	FetchByIDFunc             func(ctx context.Context, id string) (*User, error)
-	GenerateTokenFunc         func(ctx context.Context, email, password string) (string, error)
-	VerifyTokenFunc           func(ctx context.Context, token string) (*VerifyTokenResponse, error)
-	SendEmailVerificationFunc func(ctx context.Context, userID, username, to string) error
+	CreateFunc                      func(ctx context.Context, in CreateUserInput) (*CreateUserResponse, error)
+	DeleteFunc                      func(ctx context.Context, id string) error
+	DeleteWithReasonFunc            func(ctx context.Context, id, reason string) error
+	AnonymizeUserFunc               func(ctx context.Context, id string) error
+	FetchByIDFunc                   func(ctx context.Context, id string) (*User, error)
+	FetchByEmailFunc                func(ctx context.Context, email string) (*User, error)
+	FetchByUsernameFunc             func(ctx context.Context, username string) (*User, error)
+	UpdateFunc                      func(ctx context.Context, id string, in UpdateUserInput) (*User, error)
+	FetchByIDWithDeletedFunc        func(ctx context.Context, id string) (*User, error)
+	GetRoleFunc                     func(ctx context.Context, userID string) (role, error)
+	ChangeEmailFunc                 func(ctx context.Context, userID, currentPassword, newEmail string) error
+	ChangePasswordFunc              func(ctx context.Context, userID, currentPassword, newPassword string) error
+	RequestPasswordResetFunc        func(ctx context.Context, email string) error
+	ResetPasswordFunc               func(ctx context.Context, code, newPassword string) error
+	SetPasswordAuthDisabledFunc     func(ctx context.Context, userID string, disabled bool) error
+	RevokeUserTokensFunc            func(ctx context.Context, userID string) error
+	RevokeTokenFunc                 func(ctx context.Context, token string) error
+	ReactivateFunc                  func(ctx context.Context, id string) error
+	GenerateTokenFunc               func(ctx context.Context, email, password string) (string, error)
+	GenerateTokenWithTTLFunc        func(ctx context.Context, email, password string, ttl time.Duration) (string, error)
+	GenerateTokenForAudienceFunc    func(ctx context.Context, email, password, audience string) (string, error)
+	GenerateTokenResponseFunc       func(ctx context.Context, email, password string) (*TokenResponse, error)
+	GenerateTokenPairFunc           func(ctx context.Context, email, password string) (*TokenPair, error)
+	RefreshAccessTokenFunc          func(ctx context.Context, refreshToken string) (*TokenPair, error)
+	RevokeRefreshTokenFunc          func(ctx context.Context, refreshToken string) error
+	AuthenticateFunc                func(ctx context.Context, identifier, password string) (string, error)
+	VerifyTokenFunc                 func(ctx context.Context, token string) (*VerifyTokenResponse, error)
+	RefreshTokenFunc                func(ctx context.Context, token string) (string, error)
+	ConfirmEmailVerificationFunc    func(ctx context.Context, code string) error
+	ConfirmEmailVerificationForFunc func(ctx context.Context, userID, code string) error
+	VerifyAuthorizationHeaderFunc   func(ctx context.Context, header string) (*VerifyTokenResponse, error)
+	ListEmailVerificationsFunc      func(ctx context.Context, userID string) ([]EmailVerification, error)
+	ListLoginHistoryFunc            func(ctx context.Context, userID string, limit int) ([]LoginEvent, error)
+	LoginHistoryFunc                func(ctx context.Context, userID string, page LoginHistoryInput) (*LoginHistoryPage, error)
+	ListSessionsFunc                func(ctx context.Context, userID string) ([]Session, error)
+	RevokeSessionFunc               func(ctx context.Context, userID, sessionID string) error
+	TokenTimeToLiveFunc             func(token string) (time.Duration, error)
+	UserInfoFunc                    func(ctx context.Context, token string) (map[string]interface{}, error)
+	GenerateSignedActionURLFunc     func(action, userID string, ttl time.Duration) (string, error)
+	KeysFunc                        func(ctx context.Context) (*JWKSet, error)
+	JWKSFunc                        func() ([]byte, error)
+	JWKSHandlerFunc                 func() http.Handler
+	RotateSigningKeyFunc            func(signer Signer) error
+	ValidateSigningConfigFunc       func() error
+	VerifySignedActionFunc          func(url string) (action, userID string, err error)
+	SendEmailVerificationFunc       func(ctx context.Context, userID, username, to string) error
+	SendEmailVerificationsFunc      func(ctx context.Context, userIDs []string, concurrency int) (int, []error)
+	SendTestEmailFunc               func(ctx context.Context, to string) error
+	StepUpFunc                      func(ctx context.Context, userID, password, code string) (string, error)
+	RequireStepUpFunc               func(ctx context.Context, userID, token string) error
+	EnrollTOTPFunc                  func(ctx context.Context, userID string) (*TOTPEnrollment, error)
+	ConfirmTOTPFunc                 func(ctx context.Context, userID, code string) error
+	DisableTOTPFunc                 func(ctx context.Context, userID string) error
+	VerifyTOTPFunc                  func(ctx context.Context, challengeToken, code string) (*TokenPair, error)
+	BeginPasskeyRegistrationFunc    func(ctx context.Context, userID string) (*PasskeyRegistration, error)
+	FinishPasskeyRegistrationFunc   func(ctx context.Context, state string, response []byte) error
+	BeginPasskeyLoginFunc           func(ctx context.Context) (*PasskeyLogin, error)
+	GenerateTokenWithPasskeyFunc    func(ctx context.Context, state string, response []byte) (string, error)
+	AuthenticateWithOAuthFunc       func(ctx context.Context, provider, code string) (string, error)
+	RequestLoginLinkFunc            func(ctx context.Context, email string) error
+	ConsumeLoginLinkFunc            func(ctx context.Context, token string) (string, error)
+	GetUserStatsFunc                func(ctx context.Context) (*UserStats, error)
+	ListFunc                        func(ctx context.Context, in ListUsersInput) (*UserPage, error)
+	WhichEmailsExistFunc            func(ctx context.Context, emails []string) (map[string]bool, error)
+	ListUsersWithStalePasswordsFunc func(ctx context.Context, olderThan time.Duration, page StalePasswordsInput) (*StalePasswordsPage, error)
 }
 
-func (m *MockService) Create(ctx context.Context, in CreateUserInput) (*User, error) {
+func (m *MockService) Create(ctx context.Context, in CreateUserInput) (*CreateUserResponse, error) {
 	if m.CreateFunc == nil {
 		return nil, errors.New("MockService.CreateFunc is nil")
 	}
@@ -37,6 +98,111 @@ func (m *MockService) FetchByID(ctx context.Context, id string) (*User, error) {
 	return m.FetchByIDFunc(ctx, id)
 }
 
+func (m *MockService) FetchByEmail(ctx context.Context, email string) (*User, error) {
+	if m.FetchByEmailFunc == nil {
+		return nil, errors.New("MockService.FetchByEmailFunc is nil")
+	}
+	return m.FetchByEmailFunc(ctx, email)
+}
+
+func (m *MockService) FetchByUsername(ctx context.Context, username string) (*User, error) {
+	if m.FetchByUsernameFunc == nil {
+		return nil, errors.New("MockService.FetchByUsernameFunc is nil")
+	}
+	return m.FetchByUsernameFunc(ctx, username)
+}
+
+func (m *MockService) Update(ctx context.Context, id string, in UpdateUserInput) (*User, error) {
+	if m.UpdateFunc == nil {
+		return nil, errors.New("MockService.UpdateFunc is nil")
+	}
+	return m.UpdateFunc(ctx, id, in)
+}
+
+func (m *MockService) DeleteWithReason(ctx context.Context, id, reason string) error {
+	if m.DeleteWithReasonFunc == nil {
+		return errors.New("MockService.DeleteWithReasonFunc is nil")
+	}
+	return m.DeleteWithReasonFunc(ctx, id, reason)
+}
+
+func (m *MockService) AnonymizeUser(ctx context.Context, id string) error {
+	if m.AnonymizeUserFunc == nil {
+		return errors.New("MockService.AnonymizeUserFunc is nil")
+	}
+	return m.AnonymizeUserFunc(ctx, id)
+}
+
+func (m *MockService) FetchByIDWithDeleted(ctx context.Context, id string) (*User, error) {
+	if m.FetchByIDWithDeletedFunc == nil {
+		return nil, errors.New("MockService.FetchByIDWithDeletedFunc is nil")
+	}
+	return m.FetchByIDWithDeletedFunc(ctx, id)
+}
+
+func (m *MockService) GetRole(ctx context.Context, userID string) (role, error) {
+	if m.GetRoleFunc == nil {
+		return "", errors.New("MockService.GetRoleFunc is nil")
+	}
+	return m.GetRoleFunc(ctx, userID)
+}
+
+func (m *MockService) ChangeEmail(ctx context.Context, userID, currentPassword, newEmail string) error {
+	if m.ChangeEmailFunc == nil {
+		return errors.New("MockService.ChangeEmailFunc is nil")
+	}
+	return m.ChangeEmailFunc(ctx, userID, currentPassword, newEmail)
+}
+
+func (m *MockService) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string) error {
+	if m.ChangePasswordFunc == nil {
+		return errors.New("MockService.ChangePasswordFunc is nil")
+	}
+	return m.ChangePasswordFunc(ctx, userID, currentPassword, newPassword)
+}
+
+func (m *MockService) RequestPasswordReset(ctx context.Context, email string) error {
+	if m.RequestPasswordResetFunc == nil {
+		return errors.New("MockService.RequestPasswordResetFunc is nil")
+	}
+	return m.RequestPasswordResetFunc(ctx, email)
+}
+
+func (m *MockService) ResetPassword(ctx context.Context, code, newPassword string) error {
+	if m.ResetPasswordFunc == nil {
+		return errors.New("MockService.ResetPasswordFunc is nil")
+	}
+	return m.ResetPasswordFunc(ctx, code, newPassword)
+}
+
+func (m *MockService) SetPasswordAuthDisabled(ctx context.Context, userID string, disabled bool) error {
+	if m.SetPasswordAuthDisabledFunc == nil {
+		return errors.New("MockService.SetPasswordAuthDisabledFunc is nil")
+	}
+	return m.SetPasswordAuthDisabledFunc(ctx, userID, disabled)
+}
+
+func (m *MockService) RevokeUserTokens(ctx context.Context, userID string) error {
+	if m.RevokeUserTokensFunc == nil {
+		return errors.New("MockService.RevokeUserTokensFunc is nil")
+	}
+	return m.RevokeUserTokensFunc(ctx, userID)
+}
+
+func (m *MockService) RevokeToken(ctx context.Context, token string) error {
+	if m.RevokeTokenFunc == nil {
+		return errors.New("MockService.RevokeTokenFunc is nil")
+	}
+	return m.RevokeTokenFunc(ctx, token)
+}
+
+func (m *MockService) Reactivate(ctx context.Context, id string) error {
+	if m.ReactivateFunc == nil {
+		return errors.New("MockService.ReactivateFunc is nil")
+	}
+	return m.ReactivateFunc(ctx, id)
+}
+
 func (m *MockService) GenerateToken(ctx context.Context, email, password string) (string, error) {
 	if m.GenerateTokenFunc == nil {
 		return "", errors.New("MockService.GenerateTokenFunc is nil")
@@ -44,6 +210,146 @@ func (m *MockService) GenerateToken(ctx context.Context, email, password string)
 	return m.GenerateTokenFunc(ctx, email, password)
 }
 
+func (m *MockService) GenerateTokenWithTTL(ctx context.Context, email, password string, ttl time.Duration) (string, error) {
+	if m.GenerateTokenWithTTLFunc == nil {
+		return "", errors.New("MockService.GenerateTokenWithTTLFunc is nil")
+	}
+	return m.GenerateTokenWithTTLFunc(ctx, email, password, ttl)
+}
+
+func (m *MockService) GenerateTokenForAudience(ctx context.Context, email, password, audience string) (string, error) {
+	if m.GenerateTokenForAudienceFunc == nil {
+		return "", errors.New("MockService.GenerateTokenForAudienceFunc is nil")
+	}
+	return m.GenerateTokenForAudienceFunc(ctx, email, password, audience)
+}
+
+func (m *MockService) GenerateTokenPair(ctx context.Context, email, password string) (*TokenPair, error) {
+	if m.GenerateTokenPairFunc == nil {
+		return nil, errors.New("MockService.GenerateTokenPairFunc is nil")
+	}
+	return m.GenerateTokenPairFunc(ctx, email, password)
+}
+
+func (m *MockService) RefreshAccessToken(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if m.RefreshAccessTokenFunc == nil {
+		return nil, errors.New("MockService.RefreshAccessTokenFunc is nil")
+	}
+	return m.RefreshAccessTokenFunc(ctx, refreshToken)
+}
+
+func (m *MockService) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	if m.RevokeRefreshTokenFunc == nil {
+		return errors.New("MockService.RevokeRefreshTokenFunc is nil")
+	}
+	return m.RevokeRefreshTokenFunc(ctx, refreshToken)
+}
+
+func (m *MockService) GenerateTokenResponse(ctx context.Context, email, password string) (*TokenResponse, error) {
+	if m.GenerateTokenResponseFunc == nil {
+		return nil, errors.New("MockService.GenerateTokenResponseFunc is nil")
+	}
+	return m.GenerateTokenResponseFunc(ctx, email, password)
+}
+
+func (m *MockService) Authenticate(ctx context.Context, identifier, password string) (string, error) {
+	if m.AuthenticateFunc == nil {
+		return "", errors.New("MockService.AuthenticateFunc is nil")
+	}
+	return m.AuthenticateFunc(ctx, identifier, password)
+}
+
+func (m *MockService) StepUp(ctx context.Context, userID, password, code string) (string, error) {
+	if m.StepUpFunc == nil {
+		return "", errors.New("MockService.StepUpFunc is nil")
+	}
+	return m.StepUpFunc(ctx, userID, password, code)
+}
+
+func (m *MockService) RequireStepUp(ctx context.Context, userID, token string) error {
+	if m.RequireStepUpFunc == nil {
+		return errors.New("MockService.RequireStepUpFunc is nil")
+	}
+	return m.RequireStepUpFunc(ctx, userID, token)
+}
+
+func (m *MockService) EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	if m.EnrollTOTPFunc == nil {
+		return nil, errors.New("MockService.EnrollTOTPFunc is nil")
+	}
+	return m.EnrollTOTPFunc(ctx, userID)
+}
+
+func (m *MockService) ConfirmTOTP(ctx context.Context, userID, code string) error {
+	if m.ConfirmTOTPFunc == nil {
+		return errors.New("MockService.ConfirmTOTPFunc is nil")
+	}
+	return m.ConfirmTOTPFunc(ctx, userID, code)
+}
+
+func (m *MockService) DisableTOTP(ctx context.Context, userID string) error {
+	if m.DisableTOTPFunc == nil {
+		return errors.New("MockService.DisableTOTPFunc is nil")
+	}
+	return m.DisableTOTPFunc(ctx, userID)
+}
+
+func (m *MockService) VerifyTOTP(ctx context.Context, challengeToken, code string) (*TokenPair, error) {
+	if m.VerifyTOTPFunc == nil {
+		return nil, errors.New("MockService.VerifyTOTPFunc is nil")
+	}
+	return m.VerifyTOTPFunc(ctx, challengeToken, code)
+}
+
+func (m *MockService) BeginPasskeyRegistration(ctx context.Context, userID string) (*PasskeyRegistration, error) {
+	if m.BeginPasskeyRegistrationFunc == nil {
+		return nil, errors.New("MockService.BeginPasskeyRegistrationFunc is nil")
+	}
+	return m.BeginPasskeyRegistrationFunc(ctx, userID)
+}
+
+func (m *MockService) FinishPasskeyRegistration(ctx context.Context, state string, response []byte) error {
+	if m.FinishPasskeyRegistrationFunc == nil {
+		return errors.New("MockService.FinishPasskeyRegistrationFunc is nil")
+	}
+	return m.FinishPasskeyRegistrationFunc(ctx, state, response)
+}
+
+func (m *MockService) BeginPasskeyLogin(ctx context.Context) (*PasskeyLogin, error) {
+	if m.BeginPasskeyLoginFunc == nil {
+		return nil, errors.New("MockService.BeginPasskeyLoginFunc is nil")
+	}
+	return m.BeginPasskeyLoginFunc(ctx)
+}
+
+func (m *MockService) GenerateTokenWithPasskey(ctx context.Context, state string, response []byte) (string, error) {
+	if m.GenerateTokenWithPasskeyFunc == nil {
+		return "", errors.New("MockService.GenerateTokenWithPasskeyFunc is nil")
+	}
+	return m.GenerateTokenWithPasskeyFunc(ctx, state, response)
+}
+
+func (m *MockService) AuthenticateWithOAuth(ctx context.Context, provider, code string) (string, error) {
+	if m.AuthenticateWithOAuthFunc == nil {
+		return "", errors.New("MockService.AuthenticateWithOAuthFunc is nil")
+	}
+	return m.AuthenticateWithOAuthFunc(ctx, provider, code)
+}
+
+func (m *MockService) RequestLoginLink(ctx context.Context, email string) error {
+	if m.RequestLoginLinkFunc == nil {
+		return errors.New("MockService.RequestLoginLinkFunc is nil")
+	}
+	return m.RequestLoginLinkFunc(ctx, email)
+}
+
+func (m *MockService) ConsumeLoginLink(ctx context.Context, token string) (string, error) {
+	if m.ConsumeLoginLinkFunc == nil {
+		return "", errors.New("MockService.ConsumeLoginLinkFunc is nil")
+	}
+	return m.ConsumeLoginLinkFunc(ctx, token)
+}
+
 func (m *MockService) VerifyToken(ctx context.Context, token string) (*VerifyTokenResponse, error) {
 	if m.VerifyTokenFunc == nil {
 		return nil, errors.New("MockService.VerifyTokenFunc is nil")
@@ -51,9 +357,179 @@ func (m *MockService) VerifyToken(ctx context.Context, token string) (*VerifyTok
 	return m.VerifyTokenFunc(ctx, token)
 }
 
+func (m *MockService) RefreshToken(ctx context.Context, token string) (string, error) {
+	if m.RefreshTokenFunc == nil {
+		return "", errors.New("MockService.RefreshTokenFunc is nil")
+	}
+	return m.RefreshTokenFunc(ctx, token)
+}
+
+func (m *MockService) VerifyAuthorizationHeader(ctx context.Context, header string) (*VerifyTokenResponse, error) {
+	if m.VerifyAuthorizationHeaderFunc == nil {
+		return nil, errors.New("MockService.VerifyAuthorizationHeaderFunc is nil")
+	}
+	return m.VerifyAuthorizationHeaderFunc(ctx, header)
+}
+
 func (m *MockService) SendEmailVerification(ctx context.Context, userID, username, to string) error {
 	if m.SendEmailVerificationFunc == nil {
 		return errors.New("MockService.SendEmailVerificationFunc is nil")
 	}
 	return m.SendEmailVerificationFunc(ctx, userID, username, to)
 }
+
+func (m *MockService) SendEmailVerifications(ctx context.Context, userIDs []string, concurrency int) (int, []error) {
+	if m.SendEmailVerificationsFunc == nil {
+		return 0, []error{errors.New("MockService.SendEmailVerificationsFunc is nil")}
+	}
+	return m.SendEmailVerificationsFunc(ctx, userIDs, concurrency)
+}
+
+func (m *MockService) SendTestEmail(ctx context.Context, to string) error {
+	if m.SendTestEmailFunc == nil {
+		return errors.New("MockService.SendTestEmailFunc is nil")
+	}
+	return m.SendTestEmailFunc(ctx, to)
+}
+
+func (m *MockService) ConfirmEmailVerification(ctx context.Context, code string) error {
+	if m.ConfirmEmailVerificationFunc == nil {
+		return errors.New("MockService.ConfirmEmailVerificationFunc is nil")
+	}
+	return m.ConfirmEmailVerificationFunc(ctx, code)
+}
+
+func (m *MockService) ConfirmEmailVerificationFor(ctx context.Context, userID, code string) error {
+	if m.ConfirmEmailVerificationForFunc == nil {
+		return errors.New("MockService.ConfirmEmailVerificationForFunc is nil")
+	}
+	return m.ConfirmEmailVerificationForFunc(ctx, userID, code)
+}
+
+func (m *MockService) ListEmailVerifications(ctx context.Context, userID string) ([]EmailVerification, error) {
+	if m.ListEmailVerificationsFunc == nil {
+		return nil, errors.New("MockService.ListEmailVerificationsFunc is nil")
+	}
+	return m.ListEmailVerificationsFunc(ctx, userID)
+}
+
+func (m *MockService) List(ctx context.Context, in ListUsersInput) (*UserPage, error) {
+	if m.ListFunc == nil {
+		return nil, errors.New("MockService.ListFunc is nil")
+	}
+	return m.ListFunc(ctx, in)
+}
+
+func (m *MockService) GetUserStats(ctx context.Context) (*UserStats, error) {
+	if m.GetUserStatsFunc == nil {
+		return nil, errors.New("MockService.GetUserStatsFunc is nil")
+	}
+	return m.GetUserStatsFunc(ctx)
+}
+
+func (m *MockService) WhichEmailsExist(ctx context.Context, emails []string) (map[string]bool, error) {
+	if m.WhichEmailsExistFunc == nil {
+		return nil, errors.New("MockService.WhichEmailsExistFunc is nil")
+	}
+	return m.WhichEmailsExistFunc(ctx, emails)
+}
+
+func (m *MockService) ListUsersWithStalePasswords(ctx context.Context, olderThan time.Duration, page StalePasswordsInput) (*StalePasswordsPage, error) {
+	if m.ListUsersWithStalePasswordsFunc == nil {
+		return nil, errors.New("MockService.ListUsersWithStalePasswordsFunc is nil")
+	}
+	return m.ListUsersWithStalePasswordsFunc(ctx, olderThan, page)
+}
+
+func (m *MockService) ListLoginHistory(ctx context.Context, userID string, limit int) ([]LoginEvent, error) {
+	if m.ListLoginHistoryFunc == nil {
+		return nil, errors.New("MockService.ListLoginHistoryFunc is nil")
+	}
+	return m.ListLoginHistoryFunc(ctx, userID, limit)
+}
+
+func (m *MockService) LoginHistory(ctx context.Context, userID string, page LoginHistoryInput) (*LoginHistoryPage, error) {
+	if m.LoginHistoryFunc == nil {
+		return nil, errors.New("MockService.LoginHistoryFunc is nil")
+	}
+	return m.LoginHistoryFunc(ctx, userID, page)
+}
+
+func (m *MockService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	if m.ListSessionsFunc == nil {
+		return nil, errors.New("MockService.ListSessionsFunc is nil")
+	}
+	return m.ListSessionsFunc(ctx, userID)
+}
+
+func (m *MockService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	if m.RevokeSessionFunc == nil {
+		return errors.New("MockService.RevokeSessionFunc is nil")
+	}
+	return m.RevokeSessionFunc(ctx, userID, sessionID)
+}
+
+func (m *MockService) TokenTimeToLive(token string) (time.Duration, error) {
+	if m.TokenTimeToLiveFunc == nil {
+		return 0, errors.New("MockService.TokenTimeToLiveFunc is nil")
+	}
+	return m.TokenTimeToLiveFunc(token)
+}
+
+func (m *MockService) UserInfo(ctx context.Context, token string) (map[string]interface{}, error) {
+	if m.UserInfoFunc == nil {
+		return nil, errors.New("MockService.UserInfoFunc is nil")
+	}
+	return m.UserInfoFunc(ctx, token)
+}
+
+func (m *MockService) GenerateSignedActionURL(action, userID string, ttl time.Duration) (string, error) {
+	if m.GenerateSignedActionURLFunc == nil {
+		return "", errors.New("MockService.GenerateSignedActionURLFunc is nil")
+	}
+	return m.GenerateSignedActionURLFunc(action, userID, ttl)
+}
+
+func (m *MockService) VerifySignedAction(url string) (action, userID string, err error) {
+	if m.VerifySignedActionFunc == nil {
+		return "", "", errors.New("MockService.VerifySignedActionFunc is nil")
+	}
+	return m.VerifySignedActionFunc(url)
+}
+
+func (m *MockService) Keys(ctx context.Context) (*JWKSet, error) {
+	if m.KeysFunc == nil {
+		return nil, errors.New("MockService.KeysFunc is nil")
+	}
+	return m.KeysFunc(ctx)
+}
+
+func (m *MockService) JWKS() ([]byte, error) {
+	if m.JWKSFunc == nil {
+		return nil, errors.New("MockService.JWKSFunc is nil")
+	}
+	return m.JWKSFunc()
+}
+
+func (m *MockService) JWKSHandler() http.Handler {
+	if m.JWKSHandlerFunc == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "MockService.JWKSHandlerFunc is nil", http.StatusInternalServerError)
+		})
+	}
+	return m.JWKSHandlerFunc()
+}
+
+func (m *MockService) RotateSigningKey(signer Signer) error {
+	if m.RotateSigningKeyFunc == nil {
+		return errors.New("MockService.RotateSigningKeyFunc is nil")
+	}
+	return m.RotateSigningKeyFunc(signer)
+}
+
+func (m *MockService) ValidateSigningConfig() error {
+	if m.ValidateSigningConfigFunc == nil {
+		return errors.New("MockService.ValidateSigningConfigFunc is nil")
+	}
+	return m.ValidateSigningConfigFunc()
+}
@@ -2,12 +2,20 @@ package users
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/alesr/stdservices/users/repository"
+	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,6 +23,15 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// issueTestToken issues a token to the user through the login session path, as a successful login does
+func issueTestToken(ctx context.Context, svc *DefaultService, userID string, ttl time.Duration) (string, error) {
+	issued, err := svc.startLoginSession(ctx, &repository.User{ID: userID, Role: string(RoleUser)}, ttl, "", "test")
+	if err != nil {
+		return "", err
+	}
+	return issued.token, nil
+}
+
 func TestNew(t *testing.T) {
 	t.Parallel()
 
@@ -64,6 +81,53 @@ func TestCreate_validation(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCreate_passwordValidator(t *testing.T) {
+	t.Parallel()
+
+	givenUser := CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	t.Run("custom validator rejects password", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+			WithPasswordValidator(func(password string, user CreateUserInput) error {
+				assert.Equal(t, givenUser.Password, password)
+				assert.Equal(t, givenUser.Username, user.Username)
+				return errors.New("password must not contain the word password")
+			}),
+		)
+
+		_, err := svc.Create(context.Background(), givenUser)
+		require.Error(t, err)
+
+		var e E
+		require.True(t, errors.As(err, &e))
+		assert.Equal(t, "password must not contain the word password", e.Error())
+	})
+
+	t.Run("custom validator accepts password", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret",
+			&repositoryMock{
+				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					return user, nil
+				},
+			},
+			WithPasswordValidator(func(password string, user CreateUserInput) error {
+				return nil
+			}),
+		)
+
+		user, err := svc.Create(context.Background(), givenUser)
+		require.NoError(t, err)
+		assert.Equal(t, givenUser.Username, user.Username)
+	})
+}
+
 func TestCreate(t *testing.T) {
 	t.Parallel()
 
@@ -81,7 +145,7 @@ func TestCreate(t *testing.T) {
 		givenUser        CreateUserInput
 		givenEmailerMock *emailerMock
 		givenRepoMock    *repositoryMock
-		expectedUser     *User
+		expectedResponse *CreateUserResponse
 		expectedError    error
 	}{
 		{
@@ -92,8 +156,30 @@ func TestCreate(t *testing.T) {
 					return nil, repository.ErrDuplicateRecord
 				},
 			},
-			expectedUser:  nil,
-			expectedError: errAlreadyExists,
+			expectedResponse: nil,
+			expectedError:    errAlreadyExists,
+		},
+		{
+			name:      "email is taken",
+			givenUser: givenUser,
+			givenRepoMock: &repositoryMock{
+				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					return nil, repository.ErrDuplicateEmail
+				},
+			},
+			expectedResponse: nil,
+			expectedError:    errEmailTaken,
+		},
+		{
+			name:      "username is taken",
+			givenUser: givenUser,
+			givenRepoMock: &repositoryMock{
+				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					return nil, repository.ErrDuplicateUsername
+				},
+			},
+			expectedResponse: nil,
+			expectedError:    errUsernameTaken,
 		},
 		{
 			name:      "user is created",
@@ -130,16 +216,20 @@ func TestCreate(t *testing.T) {
 					}, nil
 				},
 			},
-			expectedUser: &User{
-				ID:            "123",
-				Fullname:      givenUser.Fullname,
-				Username:      givenUser.Username,
-				Birthdate:     givenUser.Birthdate,
-				Email:         givenUser.Email,
-				EmailVerified: false,
-				Role:          RoleUser,
-				CreatedAt:     time.Time{}.AddDate(2000, 1, 1),
-				UpdatedAt:     time.Time{}.AddDate(2000, 2, 2),
+			expectedResponse: &CreateUserResponse{
+				User: &User{
+					ID:            "123",
+					Fullname:      givenUser.Fullname,
+					Username:      givenUser.Username,
+					Birthdate:     givenUser.Birthdate,
+					Email:         givenUser.Email,
+					EmailVerified: false,
+					Role:          RoleUser,
+					CreatedAt:     time.Time{}.AddDate(2000, 1, 1),
+					UpdatedAt:     time.Time{}.AddDate(2000, 2, 2),
+				},
+				VerificationEmailSent:      true,
+				VerificationEmailRecipient: "j***@mail.com",
 			},
 			expectedError: nil,
 		},
@@ -151,8 +241,8 @@ func TestCreate(t *testing.T) {
 					return nil, errors.New("some error")
 				},
 			},
-			expectedUser:  nil,
-			expectedError: fmt.Errorf("could not insert user: some error"),
+			expectedResponse: nil,
+			expectedError:    fmt.Errorf("could not insert user: some error"),
 		},
 		{
 			name:      "send email verification error still creates an user",
@@ -189,16 +279,18 @@ func TestCreate(t *testing.T) {
 					}, nil
 				},
 			},
-			expectedUser: &User{
-				ID:            "123",
-				Fullname:      givenUser.Fullname,
-				Username:      givenUser.Username,
-				Birthdate:     givenUser.Birthdate,
-				Email:         givenUser.Email,
-				EmailVerified: false,
-				Role:          RoleUser,
-				CreatedAt:     time.Time{}.AddDate(2000, 1, 1),
-				UpdatedAt:     time.Time{}.AddDate(2000, 2, 2),
+			expectedResponse: &CreateUserResponse{
+				User: &User{
+					ID:            "123",
+					Fullname:      givenUser.Fullname,
+					Username:      givenUser.Username,
+					Birthdate:     givenUser.Birthdate,
+					Email:         givenUser.Email,
+					EmailVerified: false,
+					Role:          RoleUser,
+					CreatedAt:     time.Time{}.AddDate(2000, 1, 1),
+					UpdatedAt:     time.Time{}.AddDate(2000, 2, 2),
+				},
 			},
 			expectedError: nil,
 		},
@@ -212,9 +304,426 @@ func TestCreate(t *testing.T) {
 				repo:    tc.givenRepoMock,
 			}
 
-			user, err := svc.Create(context.Background(), tc.givenUser)
+			resp, err := svc.Create(context.Background(), tc.givenUser)
 			require.Equal(t, tc.expectedError, err)
-			require.Equal(t, tc.expectedUser, user)
+			require.Equal(t, tc.expectedResponse, resp)
+		})
+	}
+}
+
+func TestCreate_optionalBirthdate(t *testing.T) {
+	t.Parallel()
+
+	given := CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	repoMock := &repositoryMock{
+		insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+			assert.Empty(t, user.Birthdate)
+			return user, nil
+		},
+	}
+
+	t.Run("birthdate is required by default", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", repoMock)
+
+		_, err := svc.Create(context.Background(), given)
+		assert.Error(t, err)
+	})
+
+	t.Run("birthdate is omitted", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", repoMock, WithOptionalBirthdate())
+
+		resp, err := svc.Create(context.Background(), given)
+		require.NoError(t, err)
+
+		assert.Empty(t, resp.Birthdate)
+	})
+}
+
+func TestCreate_clock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var inserted repository.User
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+			inserted = *user
+			return user, nil
+		},
+	})
+	svc.clock = func() time.Time { return now }
+
+	_, err := svc.Create(context.Background(), CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, now, inserted.CreatedAt)
+	assert.Equal(t, now, inserted.UpdatedAt)
+	assert.Equal(t, now, inserted.PasswordChangedAt)
+}
+
+func TestCreate_welcomeEmail(t *testing.T) {
+	t.Parallel()
+
+	given := CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	givenTemplate := template.Must(template.New("welcome").Parse("Hello {{.Fullname}}, welcome aboard!"))
+
+	testCases := []struct {
+		name             string
+		givenTemplate    *template.Template
+		givenSendErr     error
+		expectedSubjects []string
+	}{
+		{
+			name:             "welcome email is sent",
+			givenTemplate:    givenTemplate,
+			expectedSubjects: []string{"Subject: test-app Email Verification", "Subject: Welcome to test-app"},
+		},
+		{
+			name:             "welcome email is not configured",
+			expectedSubjects: []string{"Subject: test-app Email Verification"},
+		},
+		{
+			name:             "send errors don't fail the creation",
+			givenTemplate:    givenTemplate,
+			givenSendErr:     errors.New("some error"),
+			expectedSubjects: []string{"Subject: test-app Email Verification", "Subject: Welcome to test-app"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var bodies []string
+
+			opts := []ServiceOption{
+				WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						bodies = append(bodies, string(body))
+						return tc.givenSendErr
+					},
+				}),
+			}
+
+			if tc.givenTemplate != nil {
+				opts = append(opts, WithWelcomeEmail(tc.givenTemplate))
+			}
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+						return user, nil
+					},
+					insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+						return nil
+					},
+				},
+				opts...,
+			)
+
+			_, err := svc.Create(context.Background(), given)
+			require.NoError(t, err)
+
+			require.Len(t, bodies, len(tc.expectedSubjects))
+			for i, subject := range tc.expectedSubjects {
+				assert.Contains(t, bodies[i], subject)
+			}
+
+			if tc.givenTemplate != nil {
+				assert.Contains(t, bodies[1], "Hello John Doe, welcome aboard!")
+			}
+		})
+	}
+}
+
+func TestCreate_caseInsensitiveUsername(t *testing.T) {
+	t.Parallel()
+
+	usernames := make(map[string]bool)
+
+	svc := DefaultService{
+		logger: zap.NewNop(),
+		repo: &repositoryMock{
+			insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				// Simulate the unique index on the normalized username
+				if usernames[user.UsernameNormalized] {
+					return nil, repository.ErrDuplicateUsername
+				}
+				usernames[user.UsernameNormalized] = true
+				return user, nil
+			},
+		},
+	}
+
+	given := CreateUserInput{
+		Fullname:        "Alice Doe",
+		Username:        "Alice",
+		Birthdate:       "2000-01-01",
+		Email:           "alice@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	user, err := svc.Create(context.Background(), given)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Alice", user.Username)
+
+	given.Username = "alice"
+	given.Email = "other-alice@mail.com"
+
+	_, err = svc.Create(context.Background(), given)
+	assert.Equal(t, errUsernameTaken, err)
+}
+
+func TestEmailNormalization(t *testing.T) {
+	t.Parallel()
+
+	givenKey := []byte("0123456789abcdef0123456789abcdef")
+
+	testCases := []struct {
+		name         string
+		givenOptions []ServiceOption
+	}{
+		{
+			name: "plaintext emails",
+		},
+		{
+			name:         "encrypted emails",
+			givenOptions: []ServiceOption{WithEmailEncryption(givenKey)},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stored *repository.User
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					stored = user
+					return user, nil
+				},
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					if stored == nil || email != stored.Email {
+						return nil, nil
+					}
+					return stored, nil
+				},
+			}, tc.givenOptions...)
+			svc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+			user, err := svc.Create(context.Background(), CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "jdoe",
+				Birthdate:       "2000-01-01",
+				Email:           "JoeDoe@Mail.com",
+				Password:        "password#123",
+				ConfirmPassword: "password#123",
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, "joedoe@mail.com", user.Email)
+			assert.Equal(t, svc.emailLookup("joedoe@mail.com"), stored.Email)
+
+			// Logins and lookups with another case find the same user
+			_, err = svc.GenerateToken(context.Background(), "JOEDOE@mail.com", "password#123")
+			assert.NoError(t, err)
+
+			actual, err := svc.FetchByEmail(context.Background(), "joeDoe@MAIL.com")
+			require.NoError(t, err)
+			assert.Equal(t, user.ID, actual.ID)
+		})
+	}
+}
+
+func TestCreate_usernameNormalization(t *testing.T) {
+	t.Parallel()
+
+	newService := func(opts ...ServiceOption) *DefaultService {
+		usernames := make(map[string]bool)
+
+		return New(zap.NewNop(), "jwt-secret", &repositoryMock{
+			insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				// Simulate the unique index on the normalized username
+				if usernames[user.UsernameNormalized] {
+					return nil, repository.ErrDuplicateUsername
+				}
+				usernames[user.UsernameNormalized] = true
+				return user, nil
+			},
+		}, opts...)
+	}
+
+	given := CreateUserInput{
+		Fullname:        "Jose Doe",
+		Birthdate:       "2000-01-01",
+		Email:           "jose@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	t.Run("usernames are stored in NFC", func(t *testing.T) {
+		svc := newService()
+
+		given := given
+		given.Username = "Jose\u0301"
+
+		resp, err := svc.Create(context.Background(), given)
+		require.NoError(t, err)
+
+		assert.Equal(t, "Jos\u00e9", resp.Username)
+
+		given.Username = "Jos\u00e9"
+		given.Email = "other-jose@mail.com"
+
+		_, err = svc.Create(context.Background(), given)
+		assert.Equal(t, errUsernameTaken, err)
+	})
+
+	t.Run("confusable usernames", func(t *testing.T) {
+		testCases := []struct {
+			name             string
+			givenUsername    string
+			givenOptions     []ServiceOption
+			expectedRejected bool
+		}{
+			{
+				name:          "mixed scripts allowed without the check",
+				givenUsername: "p\u0430ypal",
+			},
+			{
+				name:             "mixed scripts",
+				givenUsername:    "p\u0430ypal",
+				givenOptions:     []ServiceOption{WithConfusableUsernameCheck()},
+				expectedRejected: true,
+			},
+			{
+				name:             "Latin lookalikes only",
+				givenUsername:    "\u0440\u0430\u0443\u0440\u0430",
+				givenOptions:     []ServiceOption{WithConfusableUsernameCheck()},
+				expectedRejected: true,
+			},
+			{
+				name:          "single script",
+				givenUsername: "\u0411\u043e\u0440\u0438\u0441",
+				givenOptions:  []ServiceOption{WithConfusableUsernameCheck()},
+			},
+			{
+				name:          "latin",
+				givenUsername: "Jos\u00e9Doe",
+				givenOptions:  []ServiceOption{WithConfusableUsernameCheck()},
+			},
+		}
+
+		for _, tc := range testCases {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				given := given
+				given.Username = tc.givenUsername
+
+				_, err := newService(tc.givenOptions...).Create(context.Background(), given)
+				if tc.expectedRejected {
+					assert.Equal(t, errUsernameConfusable, err)
+					return
+				}
+				assert.NoError(t, err)
+			})
+		}
+	})
+}
+
+func TestCreate_preCheckUniqueness(t *testing.T) {
+	t.Parallel()
+
+	givenUser := CreateUserInput{
+		Fullname:        "Joe Doe",
+		Username:        "JDoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joedoe@mail.com",
+		Password:        "password#123",
+		ConfirmPassword: "password#123",
+	}
+
+	testCases := []struct {
+		name          string
+		givenTaken    string
+		expectedError error
+	}{
+		{
+			name:          "email is taken",
+			givenTaken:    "email",
+			expectedError: errEmailTaken,
+		},
+		{
+			name:          "username is taken",
+			givenTaken:    "username",
+			expectedError: errUsernameTaken,
+		},
+		{
+			name:          "email and username are available",
+			expectedError: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var inserted bool
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+						if tc.givenTaken == "email" && email == givenUser.Email {
+							return &repository.User{}, nil
+						}
+						return nil, nil
+					},
+					selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+						if tc.givenTaken == "username" && username == "jdoe" {
+							return &repository.User{}, nil
+						}
+						return nil, nil
+					},
+					insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+						inserted = true
+						return user, nil
+					},
+				},
+				WithPreCheckUniqueness(),
+			)
+
+			_, err := svc.Create(context.Background(), givenUser)
+			require.Equal(t, tc.expectedError, err)
+
+			assert.Equal(t, tc.expectedError == nil, inserted)
 		})
 	}
 }
@@ -298,203 +807,3045 @@ func TestFetchByID_validation(t *testing.T) {
 	}
 }
 
-func TestDelete_validation(t *testing.T) {
+func TestFetchByEmail(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
 		name          string
-		givenID       string
-		expectedError bool
+		givenEmail    string
+		givenRepoMock *repositoryMock
+		expectedUser  *User
+		expectedError error
 	}{
 		{
-			name:          "emty id",
-			givenID:       "",
-			expectedError: true,
+			name:       "user found",
+			givenEmail: "joedoe@mail.com",
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					require.Equal(t, "joedoe@mail.com", email)
+					return &repository.User{Username: "JDoe", Email: email, Role: string(RoleUser)}, nil
+				},
+			},
+			expectedUser: &User{Username: "JDoe", Email: "joedoe@mail.com", Role: RoleUser},
 		},
 		{
-			name:          "invalid id",
+			name:       "user not found",
+			givenEmail: "joedoe@mail.com",
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return nil, nil
+				},
+			},
+			expectedError: errNotFound,
+		},
+		{
+			name:       "select user error",
+			givenEmail: "joedoe@mail.com",
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return nil, errors.New("some error")
+				},
+			},
+			expectedError: fmt.Errorf("could not select user by email: some error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := DefaultService{
+				repo: tc.givenRepoMock,
+			}
+
+			user, err := svc.FetchByEmail(context.Background(), tc.givenEmail)
+			require.Equal(t, tc.expectedError, err)
+			require.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestFetchByEmail_validation(t *testing.T) {
+	t.Parallel()
+
+	svc := DefaultService{}
+
+	_, err := svc.FetchByEmail(context.Background(), "not-an-email")
+	require.Error(t, err)
+}
+
+func TestFetchByUsername(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenUsername string
+		givenRepoMock *repositoryMock
+		expectedUser  *User
+		expectedError error
+	}{
+		{
+			name:          "user found case-insensitively",
+			givenUsername: "JDOE",
+			givenRepoMock: &repositoryMock{
+				selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+					require.Equal(t, "jdoe", username)
+					return &repository.User{Username: "JDoe", UsernameNormalized: username, Role: string(RoleUser)}, nil
+				},
+			},
+			expectedUser: &User{Username: "JDoe", Role: RoleUser},
+		},
+		{
+			name:          "user not found",
+			givenUsername: "JDoe",
+			givenRepoMock: &repositoryMock{
+				selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+					return nil, nil
+				},
+			},
+			expectedError: errNotFound,
+		},
+		{
+			name:          "select user error",
+			givenUsername: "JDoe",
+			givenRepoMock: &repositoryMock{
+				selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+					return nil, errors.New("some error")
+				},
+			},
+			expectedError: fmt.Errorf("could not select user by username: some error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := DefaultService{
+				repo: tc.givenRepoMock,
+			}
+
+			user, err := svc.FetchByUsername(context.Background(), tc.givenUsername)
+			require.Equal(t, tc.expectedError, err)
+			require.Equal(t, tc.expectedUser, user)
+		})
+	}
+}
+
+func TestFetchByUsername_validation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenUsername string
+		expectedValid bool
+	}{
+		{
+			name:          "empty",
+			givenUsername: "",
+		},
+		{
+			name:          "valid fullname but invalid username",
+			givenUsername: "John Doe",
+		},
+		{
+			name:          "invalid fullname but valid username",
+			givenUsername: "jdoe_42",
+			expectedValid: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := DefaultService{
+				repo: &repositoryMock{
+					selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+						return nil, nil
+					},
+				},
+			}
+
+			_, err := svc.FetchByUsername(context.Background(), tc.givenUsername)
+			if tc.expectedValid {
+				assert.Equal(t, errNotFound, err)
+				return
+			}
+			assert.ErrorContains(t, err, "could not validate username")
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	strPtr := func(s string) *string { return &s }
+
+	givenID := uuid.New().String()
+
+	storageUser := func() *repository.User {
+		return &repository.User{
+			ID:                 givenID,
+			Fullname:           "Joe Doe",
+			Username:           "JDoe",
+			UsernameNormalized: "jdoe",
+			Birthdate:          "2000-01-01",
+			Email:              "joedoe@mail.com",
+			Role:               string(RoleUser),
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		givenInput    UpdateUserInput
+		givenOptions  []ServiceOption
+		givenRepoErr  error
+		expectedUser  *User
+		expectedError error
+	}{
+		{
+			name:       "nil fields are left unchanged",
+			givenInput: UpdateUserInput{Fullname: strPtr("Joe Smith")},
+			expectedUser: &User{
+				Fullname:  "Joe Smith",
+				Username:  "JDoe",
+				Birthdate: "2000-01-01",
+			},
+		},
+		{
+			name:       "username is updated",
+			givenInput: UpdateUserInput{Username: strPtr("Joey")},
+			expectedUser: &User{
+				Fullname:  "Joe Doe",
+				Username:  "Joey",
+				Birthdate: "2000-01-01",
+			},
+		},
+		{
+			name:         "empty birthdate with optional birthdate",
+			givenInput:   UpdateUserInput{Birthdate: strPtr("")},
+			givenOptions: []ServiceOption{WithOptionalBirthdate()},
+			expectedUser: &User{
+				Fullname: "Joe Doe",
+				Username: "JDoe",
+			},
+		},
+		{
+			name:          "empty birthdate",
+			givenInput:    UpdateUserInput{Birthdate: strPtr("")},
+			expectedError: fmt.Errorf("could not validate update user input: %w", newE("birthdate is required")),
+		},
+		{
+			name:          "empty fullname",
+			givenInput:    UpdateUserInput{Fullname: strPtr("")},
+			expectedError: fmt.Errorf("could not validate update user input: %w", newE("fullname is required")),
+		},
+		{
+			name:          "username taken",
+			givenInput:    UpdateUserInput{Username: strPtr("Other")},
+			givenRepoErr:  repository.ErrDuplicateUsername,
+			expectedError: errUsernameTaken,
+		},
+		{
+			name:          "concurrent modification",
+			givenInput:    UpdateUserInput{Fullname: strPtr("Joe Smith")},
+			givenRepoErr:  repository.ErrVersionConflict,
+			expectedError: errConcurrentModification,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var updated *repository.User
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return storageUser(), nil
+				},
+				updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					if tc.givenRepoErr != nil {
+						return nil, tc.givenRepoErr
+					}
+					updated = user
+					return user, nil
+				},
+			}, tc.givenOptions...)
+
+			user, err := svc.Update(context.Background(), givenID, tc.givenInput)
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError != nil {
+				return
+			}
+
+			assert.Equal(t, tc.expectedUser.Fullname, user.Fullname)
+			assert.Equal(t, tc.expectedUser.Username, user.Username)
+			assert.Equal(t, tc.expectedUser.Birthdate, user.Birthdate)
+
+			assert.Equal(t, normalizeUsername(tc.expectedUser.Username), updated.UsernameNormalized)
+			assert.Equal(t, "joedoe@mail.com", updated.Email)
+		})
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUserID := uuid.New().String()
+
+	testCases := []struct {
+		name            string
+		givenPassword   string
+		givenEmailTaken bool
+		givenMarkErr    error
+		expectedError   error
+		expectedConfirm error
+	}{
+		{
+			name:          "email is changed",
+			givenPassword: password,
+		},
+		{
+			name:          "invalid password",
+			givenPassword: "wrong%&password123",
+			expectedError: errPasswordInvalid,
+		},
+		{
+			name:            "email is taken",
+			givenPassword:   password,
+			givenEmailTaken: true,
+			expectedError:   errEmailTaken,
+		},
+		{
+			name:            "email is taken concurrently",
+			givenPassword:   password,
+			givenMarkErr:    repository.ErrDuplicateEmail,
+			expectedConfirm: errEmailTaken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				inserted []repository.EmailVerification
+				sentTo   []string
+			)
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+						return &repository.User{
+							ID:            id,
+							Username:      "jdoe",
+							Email:         "joedoe@mail.com",
+							EmailVerified: true,
+							PasswordHash:  string(givenHash),
+							Role:          string(RoleUser),
+						}, nil
+					},
+					selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+						if tc.givenEmailTaken {
+							return &repository.User{ID: uuid.New().String()}, nil
+						}
+						return nil, nil
+					},
+					insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+						inserted = append(inserted, in)
+						return nil
+					},
+					selectEmailVerificationFunc: func(ctx context.Context, code string) (*repository.EmailVerification, error) {
+						verification := inserted[0]
+						return &verification, nil
+					},
+					markEmailVerifiedFunc: func(ctx context.Context, code string) error {
+						return tc.givenMarkErr
+					},
+				},
+				WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						sentTo = append(sentTo, to)
+						return nil
+					},
+				}),
+			)
+
+			err := svc.ChangeEmail(context.Background(), givenUserID, tc.givenPassword, "newjoedoe@mail.com")
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError != nil {
+				assert.Empty(t, sentTo)
+				return
+			}
+
+			require.Len(t, inserted, 1)
+
+			assert.Equal(t, "newjoedoe@mail.com", inserted[0].PendingEmail)
+			assert.Equal(t, []string{"newjoedoe@mail.com"}, sentTo)
+
+			err = svc.ConfirmEmailVerification(context.Background(), "code")
+			assert.Equal(t, tc.expectedConfirm, err)
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUserID := uuid.New().String()
+
+	testCases := []struct {
+		name                  string
+		givenPassword         string
+		givenNewPassword      string
+		givenNotifications    bool
+		givenSendErr          error
+		expectedError         error
+		expectedNotifications int
+	}{
+		{
+			name:             "password is changed",
+			givenPassword:    password,
+			givenNewPassword: "new-password%&123",
+		},
+		{
+			name:                  "password is changed and the user is notified",
+			givenPassword:         password,
+			givenNewPassword:      "new-password%&123",
+			givenNotifications:    true,
+			expectedNotifications: 1,
+		},
+		{
+			name:                  "notification failure does not fail the change",
+			givenPassword:         password,
+			givenNewPassword:      "new-password%&123",
+			givenNotifications:    true,
+			givenSendErr:          errors.New("some error"),
+			expectedNotifications: 1,
+		},
+		{
+			name:             "invalid current password",
+			givenPassword:    "wrong%&password123",
+			givenNewPassword: "new-password%&123",
+			expectedError:    errPasswordInvalid,
+		},
+		{
+			name:             "new password contains the username",
+			givenPassword:    password,
+			givenNewPassword: "jdoe%&password123",
+			expectedError:    errPasswordContainsIdentity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				updated *repository.User
+				bodies  []string
+			)
+
+			opts := []ServiceOption{
+				WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						assert.Equal(t, "joedoe@mail.com", to)
+						bodies = append(bodies, string(body))
+						return tc.givenSendErr
+					},
+				}),
+			}
+
+			if tc.givenNotifications {
+				opts = append(opts, WithPasswordChangeNotifications())
+			}
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+						return &repository.User{
+							ID:           id,
+							Username:     "jdoe",
+							Email:        "joedoe@mail.com",
+							PasswordHash: string(givenHash),
+							Role:         string(RoleUser),
+						}, nil
+					},
+					updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+						updated = user
+						return user, nil
+					},
+				},
+				opts...,
+			)
+
+			now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			svc.clock = func() time.Time { return now }
+
+			ctx := ContextWithClientInfo(context.Background(), ClientInfo{IP: "10.0.0.1"})
+
+			err := svc.ChangePassword(ctx, givenUserID, tc.givenPassword, tc.givenNewPassword)
+			require.Equal(t, tc.expectedError, err)
+
+			require.Len(t, bodies, tc.expectedNotifications)
+
+			if tc.expectedError != nil {
+				assert.Nil(t, updated)
+				return
+			}
+
+			require.NotNil(t, updated)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(updated.PasswordHash), []byte(tc.givenNewPassword)))
+
+			// The change is dated with the service clock
+			assert.Equal(t, now, updated.PasswordChangedAt)
+			assert.Equal(t, now, updated.UpdatedAt)
+
+			for _, body := range bodies {
+				assert.Contains(t, body, "Password Changed")
+				assert.Contains(t, body, "IP address: 10.0.0.1")
+			}
+		})
+	}
+}
+
+func TestChangePassword_concurrentModification(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	var (
+		mu         sync.Mutex
+		storedUser = repository.User{
+			ID:           uuid.New().String(),
+			Username:     "jdoe",
+			Email:        "joedoe@mail.com",
+			PasswordHash: string(givenHash),
+			Role:         string(RoleUser),
+			Version:      1,
+		}
+		read sync.WaitGroup
+	)
+
+	// Both updates read the user before any of them writes it
+	read.Add(2)
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				mu.Lock()
+				user := storedUser
+				mu.Unlock()
+
+				read.Done()
+				read.Wait()
+				return &user, nil
+			},
+			updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				if user.Version != storedUser.Version {
+					return nil, repository.ErrVersionConflict
+				}
+
+				user.Version++
+				storedUser = *user
+				return user, nil
+			},
+		},
+	)
+
+	userID := storedUser.ID
+
+	errs := make(chan error, 2)
+	for _, newPassword := range []string{"first-password%&123", "second-password%&123"} {
+		go func(newPassword string) {
+			errs <- svc.ChangePassword(context.Background(), userID, password, newPassword)
+		}(newPassword)
+	}
+
+	var conflicts int
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err != nil {
+			require.Equal(t, errConcurrentModification, err)
+			conflicts++
+		}
+	}
+
+	assert.Equal(t, 1, conflicts)
+	assert.Equal(t, 2, storedUser.Version)
+}
+
+func TestDelete_validation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenID       string
+		expectedError bool
+	}{
+		{
+			name:          "emty id",
+			givenID:       "",
+			expectedError: true,
+		},
+		{
+			name:          "invalid id",
 			givenID:       "%invalid-id%",
 			expectedError: true,
 		},
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			svc := DefaultService{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{}
+
+			err := svc.Delete(context.Background(), tc.givenID)
+			require.Equal(t, tc.expectedError, err != nil)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenRepoMock *repositoryMock
+		expectError   bool
+	}{
+		{
+			name: "delete user error",
+			givenRepoMock: &repositoryMock{
+				deleteByIDFunc: func(ctx context.Context, id, reason, deletedBy string) error {
+					return errors.New("some error")
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "delete user success",
+			givenRepoMock: &repositoryMock{
+				deleteByIDFunc: func(ctx context.Context, id, reason, deletedBy string) error {
+					return nil
+				},
+			},
+			expectError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{
+				logger: zap.NewNop(),
+				repo:   tc.givenRepoMock,
+			}
+
+			err := svc.Delete(context.Background(), uuid.New().String())
+			assert.Equal(t, tc.expectError, err != nil)
+		})
+	}
+}
+
+func TestDeleteWithReason(t *testing.T) {
+	t.Parallel()
+
+	givenID := uuid.New().String()
+
+	var actualID, actualReason, actualDeletedBy string
+
+	svc := DefaultService{
+		logger: zap.NewNop(),
+		repo: &repositoryMock{
+			deleteByIDFunc: func(ctx context.Context, id, reason, deletedBy string) error {
+				actualID, actualReason, actualDeletedBy = id, reason, deletedBy
+				return nil
+			},
+		},
+	}
+
+	ctx := ContextWithActor(context.Background(), "admin-id")
+
+	err := svc.DeleteWithReason(ctx, givenID, "spam")
+	require.NoError(t, err)
+
+	assert.Equal(t, givenID, actualID)
+	assert.Equal(t, "spam", actualReason)
+	assert.Equal(t, "admin-id", actualDeletedBy)
+}
+
+func TestDelete_deletionConfirmationEmail(t *testing.T) {
+	t.Parallel()
+
+	givenTemplate := template.Must(template.New("deletion").Parse(
+		"Goodbye {{.Fullname}}, your data is kept until {{.RecoverableUntil.Format \"2006-01-02\"}}."))
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name            string
+		givenSendErr    error
+		givenDeleteErr  error
+		expectedError   bool
+		expectedBodies  int
+		expectedContent string
+	}{
+		{
+			name:            "confirmation is sent",
+			expectedBodies:  1,
+			expectedContent: "Goodbye John Doe, your data is kept until 2022-01-31.",
+		},
+		{
+			name:           "send failure does not fail the deletion",
+			givenSendErr:   errors.New("smtp down"),
+			expectedBodies: 1,
+		},
+		{
+			name:           "no confirmation when the deletion fails",
+			givenDeleteErr: errors.New("db down"),
+			expectedError:  true,
+			expectedBodies: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				recipients []string
+				bodies     []string
+			)
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return &repository.User{ID: id, Fullname: "John Doe", Email: "joedoe@mail.com", Role: string(RoleUser)}, nil
+				},
+				deleteByIDFunc: func(ctx context.Context, id, reason, deletedBy string) error {
+					return tc.givenDeleteErr
+				},
+			},
+				WithDeletionGracePeriod(30*24*time.Hour),
+				WithDeletionConfirmationEmail(givenTemplate),
+				WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						recipients = append(recipients, to)
+						bodies = append(bodies, string(body))
+						return tc.givenSendErr
+					},
+				}),
+			)
+			svc.clock = func() time.Time { return now }
+
+			err := svc.DeleteWithReason(context.Background(), uuid.New().String(), DeletionReasonSelfService)
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Len(t, bodies, tc.expectedBodies)
+			if tc.expectedBodies > 0 {
+				assert.Equal(t, []string{"joedoe@mail.com"}, recipients)
+				assert.Contains(t, bodies[0], tc.expectedContent)
+			}
+		})
+	}
+}
+
+func TestFetchByIDWithDeleted(t *testing.T) {
+	t.Parallel()
+
+	givenDeletedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	svc := DefaultService{
+		repo: &repositoryMock{
+			selectByIDWithDeletedFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{
+					ID:             id,
+					Role:           string(RoleUser),
+					DeletedAt:      &givenDeletedAt,
+					DeletionReason: "spam",
+					DeletedBy:      "admin-id",
+				}, nil
+			},
+		},
+	}
+
+	actual, err := svc.FetchByIDWithDeleted(context.Background(), uuid.New().String())
+	require.NoError(t, err)
+
+	require.NotNil(t, actual.DeletedAt)
+	assert.Equal(t, givenDeletedAt, *actual.DeletedAt)
+	assert.Equal(t, "spam", actual.DeletionReason)
+	assert.Equal(t, "admin-id", actual.DeletedBy)
+}
+
+func TestGetRole(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenUserID   string
+		givenRole     string
+		givenRepoErr  error
+		expectedRole  role
+		expectedError error
+	}{
+		{
+			name:         "user",
+			givenUserID:  uuid.New().String(),
+			givenRole:    "user",
+			expectedRole: RoleUser,
+		},
+		{
+			name:         "admin",
+			givenUserID:  uuid.New().String(),
+			givenRole:    "admin",
+			expectedRole: RoleAdmin,
+		},
+		{
+			name:          "missing or deleted user",
+			givenUserID:   uuid.New().String(),
+			givenRepoErr:  fmt.Errorf("could not select role by id: %w", repository.ErrRecordNotFound),
+			expectedError: errNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectRoleByIDFunc: func(ctx context.Context, id string) (string, error) {
+					assert.Equal(t, tc.givenUserID, id)
+					return tc.givenRole, tc.givenRepoErr
+				},
+			})
+
+			actual, err := svc.GetRole(context.Background(), tc.givenUserID)
+			require.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expectedRole, actual)
+		})
+	}
+
+	t.Run("invalid stored role", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+			selectRoleByIDFunc: func(ctx context.Context, id string) (string, error) {
+				return "root", nil
+			},
+		})
+
+		_, err := svc.GetRole(context.Background(), uuid.New().String())
+		require.Error(t, err)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := New(zap.NewNop(), "jwt-secret", &repositoryMock{}).GetRole(context.Background(), "invalid-id")
+		require.Error(t, err)
+	})
+}
+
+func TestDeletionGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name                         string
+		givenDeletedAgo              time.Duration
+		givenReason                  string
+		expectedError                error
+		expectedReactivationRequired bool
+	}{
+		{
+			name:                         "deleted within grace period",
+			givenDeletedAgo:              time.Hour,
+			givenReason:                  DeletionReasonSelfService,
+			expectedReactivationRequired: true,
+		},
+		{
+			name:            "deleted after grace period",
+			givenDeletedAgo: time.Hour * 24 * 31,
+			givenReason:     DeletionReasonSelfService,
+			expectedError:   errNotFound,
+		},
+		{
+			name:            "deleted by an operator within grace period",
+			givenDeletedAgo: time.Hour,
+			givenReason:     "spam",
+			expectedError:   errNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			givenDeletedAt := time.Now().Add(-tc.givenDeletedAgo)
+
+			givenUser := &repository.User{
+				ID:             uuid.New().String(),
+				Username:       "jdoe",
+				Role:           string(RoleUser),
+				Email:          "joedoe@mail.com",
+				PasswordHash:   string(givenHash),
+				DeletedAt:      &givenDeletedAt,
+				DeletionReason: tc.givenReason,
+			}
+
+			var restored bool
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+						return nil, nil
+					},
+					selectByEmailWithDeletedFunc: func(ctx context.Context, email string) (*repository.User, error) {
+						return givenUser, nil
+					},
+					selectByIDWithDeletedFunc: func(ctx context.Context, id string) (*repository.User, error) {
+						return givenUser, nil
+					},
+					restoreByIDFunc: func(ctx context.Context, id string) error {
+						restored = true
+						return nil
+					},
+				},
+				WithDeletionGracePeriod(time.Hour*24*30),
+			)
+
+			token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+			require.Equal(t, tc.expectedError, err)
+
+			err = svc.Reactivate(context.Background(), givenUser.ID)
+			require.Equal(t, tc.expectedError, err)
+
+			assert.Equal(t, tc.expectedError == nil, restored)
+
+			if tc.expectedError != nil {
+				return
+			}
+
+			actual, err := svc.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedReactivationRequired, actual.ReactivationRequired)
+
+			givenUser.DeletedAt = nil
+
+			actual, err = svc.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+
+			assert.False(t, actual.ReactivationRequired)
+		})
+	}
+
+	t.Run("grace period follows the service clock", func(t *testing.T) {
+		deletedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		user := &repository.User{DeletedAt: &deletedAt, DeletionReason: DeletionReasonSelfService}
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithDeletionGracePeriod(time.Hour*24*30))
+
+		svc.clock = func() time.Time { return deletedAt.Add(time.Hour * 24 * 30) }
+		assert.True(t, svc.inDeletionGracePeriod(user))
+
+		svc.clock = func() time.Time { return deletedAt.Add(time.Hour * 24 * 31) }
+		assert.False(t, svc.inDeletionGracePeriod(user))
+	})
+}
+
+func TestGenerateToken_softDeletedUser(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:                 uuid.New().String(),
+		Username:           "jdoe",
+		UsernameNormalized: "jdoe",
+		Role:               string(RoleUser),
+		Email:              "joedoe@mail.com",
+		PasswordHash:       string(givenHash),
+	}
+
+	// The lookups return the user even once deleted, the service must not rely on the repository to filter it out
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return givenUser, nil
+		},
+		selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+			return givenUser, nil
+		},
+		deleteByIDFunc: func(ctx context.Context, id, reason, deletedBy string) error {
+			deletedAt := time.Now()
+			givenUser.DeletedAt = &deletedAt
+			return nil
+		},
+	})
+
+	_, err = svc.GenerateToken(context.Background(), givenUser.Email, password)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.Delete(context.Background(), givenUser.ID))
+
+	_, err = svc.GenerateToken(context.Background(), givenUser.Email, password)
+	assert.Equal(t, errNotFound, err)
+
+	for _, identifier := range []string{givenUser.Email, givenUser.Username} {
+		_, err = svc.Authenticate(context.Background(), identifier, password)
+		assert.Equal(t, errInvalidCredentials, err)
+	}
+}
+
+func TestGenerateToken_validation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenEmail    string
+		givenPassword string
+		expectedError bool
+	}{
+		{
+			name:          "empty email",
+			givenEmail:    "",
+			givenPassword: "password%&123",
+			expectedError: true,
+		},
+		{
+			name:          "invalid email format",
+			givenEmail:    "invalid-email",
+			givenPassword: "password%&123",
+			expectedError: true,
+		},
+		{
+			name:          "empty password",
+			givenEmail:    "joedoe@mail.com",
+			givenPassword: "",
+			expectedError: true,
+		},
+		{
+			name:          "empty password format",
+			givenEmail:    "joedoe@mail.com",
+			givenPassword: "123",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{logger: zap.NewNop()}
+
+			_, err := svc.GenerateToken(context.Background(), tc.givenEmail, tc.givenPassword)
+			require.Equal(t, tc.expectedError, err != nil)
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		givenPassword string
+		givenRepoMock *repositoryMock
+		expectedToken bool
+		expectedError bool
+	}{
+		{
+			name:          "user not found",
+			givenPassword: password,
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return nil, nil
+				},
+			},
+			expectedToken: false,
+			expectedError: true,
+		},
+		{
+			name:          "select user error",
+			givenPassword: password,
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return nil, errors.New("some error")
+				},
+			},
+			expectedToken: false,
+			expectedError: true,
+		},
+		{
+			name:          "password match",
+			givenPassword: password,
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return &repository.User{
+						ID:           uuid.New().String(),
+						Role:         string(RoleUser),
+						Email:        email,
+						PasswordHash: string(givenHash),
+					}, nil
+				},
+			},
+			expectedToken: true,
+			expectedError: false,
+		},
+		{
+			name:          "password not match",
+			givenPassword: "somepassword&#%123",
+			givenRepoMock: &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return &repository.User{
+						ID:           uuid.New().String(),
+						Role:         string(RoleUser),
+						Email:        email,
+						PasswordHash: string(givenHash),
+					}, nil
+				},
+			},
+			expectedToken: false,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{
+				logger: zap.NewNop(),
+				repo:   tc.givenRepoMock,
+			}
+
+			token, err := svc.GenerateToken(context.Background(), "joedoe@mail.com", tc.givenPassword)
+			assert.Equal(t, tc.expectedError, err != nil)
+			assert.Equal(t, tc.expectedToken, token != "")
+		})
+	}
+}
+
+func TestGenerateToken_passwordAuthDisabled(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return &repository.User{
+				ID:                   uuid.New().String(),
+				Role:                 string(RoleUser),
+				Email:                email,
+				PasswordHash:         string(givenHash),
+				PasswordAuthDisabled: true,
+			}, nil
+		},
+	})
+
+	testCases := []struct {
+		name          string
+		givenPassword string
+		expectedError error
+	}{
+		{
+			name:          "password match",
+			givenPassword: password,
+			expectedError: errPasswordAuthDisabled,
+		},
+		{
+			name:          "password not match",
+			givenPassword: "somepassword&#%123",
+			expectedError: errPasswordInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := svc.GenerateToken(context.Background(), "joedoe@mail.com", tc.givenPassword)
+			require.Equal(t, tc.expectedError, err)
+			assert.Empty(t, token)
+		})
+	}
+}
+
+func TestGenerateToken_verificationGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	now := time.Now().Add(-time.Minute)
+	gracePeriod := 72 * time.Hour
+
+	testCases := []struct {
+		name           string
+		givenCreatedAt time.Time
+		givenVerified  bool
+		expectedError  error
+	}{
+		{
+			name:           "unverified before the deadline",
+			givenCreatedAt: now.Add(-gracePeriod + time.Second),
+			expectedError:  nil,
+		},
+		{
+			name:           "unverified at the deadline",
+			givenCreatedAt: now.Add(-gracePeriod),
+			expectedError:  errEmailNotVerified,
+		},
+		{
+			name:           "unverified after the deadline",
+			givenCreatedAt: now.Add(-gracePeriod - time.Hour),
+			expectedError:  errEmailNotVerified,
+		},
+		{
+			name:           "verified after the deadline",
+			givenCreatedAt: now.Add(-gracePeriod - time.Hour),
+			givenVerified:  true,
+			expectedError:  nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+					return &repository.User{
+						ID:            uuid.New().String(),
+						Role:          string(RoleUser),
+						Email:         email,
+						PasswordHash:  string(givenHash),
+						EmailVerified: tc.givenVerified,
+						CreatedAt:     tc.givenCreatedAt,
+					}, nil
+				},
+			}, WithRequireVerifiedEmail(), WithVerificationGracePeriod(gracePeriod))
+			svc.clock = func() time.Time { return now }
+
+			token, err := svc.GenerateToken(context.Background(), "joedoe@mail.com", password)
+			if tc.expectedError != nil {
+				require.Equal(t, tc.expectedError, err)
+				assert.Empty(t, token)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotEmpty(t, token)
+		})
+	}
+
+	t.Run("no grace period", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				return &repository.User{
+					ID:           uuid.New().String(),
+					Role:         string(RoleUser),
+					Email:        email,
+					PasswordHash: string(givenHash),
+					CreatedAt:    now,
+				}, nil
+			},
+		}, WithRequireVerifiedEmail())
+		svc.clock = func() time.Time { return now }
+
+		_, err := svc.GenerateToken(context.Background(), "joedoe@mail.com", password)
+		require.Equal(t, errEmailNotVerified, err)
+	})
+}
+
+func TestSetPasswordAuthDisabled(t *testing.T) {
+	t.Parallel()
+
+	givenID := uuid.New().String()
+
+	testCases := []struct {
+		name          string
+		givenID       string
+		givenRepoMock *repositoryMock
+		expectedError error
+	}{
+		{
+			name:    "disables password auth",
+			givenID: givenID,
+			givenRepoMock: &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return &repository.User{ID: id, Role: string(RoleUser), Version: 1}, nil
+				},
+				updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					if !user.PasswordAuthDisabled {
+						return nil, errors.New("password auth not disabled")
+					}
+					return user, nil
+				},
+			},
+		},
+		{
+			name:    "user not found",
+			givenID: givenID,
+			givenRepoMock: &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return nil, nil
+				},
+			},
+			expectedError: errNotFound,
+		},
+		{
+			name:    "concurrent modification",
+			givenID: givenID,
+			givenRepoMock: &repositoryMock{
+				selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+					return &repository.User{ID: id, Role: string(RoleUser), Version: 1}, nil
+				},
+				updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+					return nil, repository.ErrVersionConflict
+				},
+			},
+			expectedError: errConcurrentModification,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret", tc.givenRepoMock)
+
+			err := svc.SetPasswordAuthDisabled(context.Background(), tc.givenID, true)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+}
+
+func TestGenerateTokenWithTTL(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenRepoMock := &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return &repository.User{
+				ID:           uuid.New().String(),
+				Role:         string(RoleUser),
+				Email:        email,
+				PasswordHash: string(givenHash),
+			}, nil
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		givenMaxTTL time.Duration
+		givenTTL    time.Duration
+		expectedTTL time.Duration
+	}{
+		{
+			name:        "requested ttl within max",
+			givenMaxTTL: time.Hour * 24 * 30,
+			givenTTL:    time.Hour * 24 * 7,
+			expectedTTL: time.Hour * 24 * 7,
+		},
+		{
+			name:        "requested ttl is clamped to max",
+			givenMaxTTL: time.Hour * 24 * 30,
+			givenTTL:    time.Hour * 24 * 365,
+			expectedTTL: time.Hour * 24 * 30,
+		},
+		{
+			name:        "max defaults to the default ttl",
+			givenTTL:    time.Hour * 24 * 7,
+			expectedTTL: defaultTokenTTL,
+		},
+		{
+			name:        "non-positive ttl falls back to the default ttl",
+			givenMaxTTL: time.Hour * 24 * 30,
+			givenTTL:    0,
+			expectedTTL: defaultTokenTTL,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := New(zap.NewNop(), "jwt-secret", givenRepoMock, WithMaxTokenTTL(tc.givenMaxTTL))
+
+			token, err := svc.GenerateTokenWithTTL(context.Background(), "joedoe@mail.com", password, tc.givenTTL)
+			require.NoError(t, err)
+
+			var claims jwtClaim
+			_, _, err = new(jwt.Parser).ParseUnverified(token, &claims)
+			require.NoError(t, err)
+
+			assert.Equal(t, int64(tc.expectedTTL.Seconds()), claims.ExpiresAt-claims.IssuedAt)
+		})
+	}
+}
+
+func TestGenerateTokenForAudience(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:           uuid.New().String(),
+		Role:         string(RoleUser),
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return givenUser, nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return givenUser, nil
+		},
+	})
+
+	webToken, err := svc.GenerateTokenForAudience(context.Background(), givenUser.Email, password, "web")
+	require.NoError(t, err)
+
+	refreshedWebToken, err := svc.RefreshToken(context.Background(), webToken)
+	require.NoError(t, err)
+
+	token, err := svc.GenerateToken(context.Background(), givenUser.Email, password)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		givenToken    string
+		givenCtx      context.Context
+		expectedError error
+	}{
+		{
+			name:       "same audience",
+			givenToken: webToken,
+			givenCtx:   ContextWithAudience(context.Background(), "web"),
+		},
+		{
+			name:       "refreshed token keeps its audience",
+			givenToken: refreshedWebToken,
+			givenCtx:   ContextWithAudience(context.Background(), "web"),
+		},
+		{
+			name:          "other audience",
+			givenToken:    webToken,
+			givenCtx:      ContextWithAudience(context.Background(), "mobile"),
+			expectedError: errTokenAudienceMismatch,
+		},
+		{
+			name:       "no audience declared",
+			givenToken: webToken,
+			givenCtx:   context.Background(),
+		},
+		{
+			name:          "token without audience",
+			givenToken:    token,
+			givenCtx:      ContextWithAudience(context.Background(), "web"),
+			expectedError: errTokenAudienceMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := svc.VerifyToken(tc.givenCtx, tc.givenToken)
+			assert.Equal(t, tc.expectedError, err)
+		})
+	}
+
+	t.Run("empty audience", func(t *testing.T) {
+		_, err := svc.GenerateTokenForAudience(context.Background(), givenUser.Email, password, "")
+		assert.Equal(t, errTokenAudienceEmpty, err)
+	})
+}
+
+func TestGenerateTokenResponse(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return &repository.User{
+				ID:           uuid.New().String(),
+				Role:         string(RoleUser),
+				Email:        email,
+				PasswordHash: string(givenHash),
+			}, nil
+		},
+	})
+
+	resp, err := svc.GenerateTokenResponse(context.Background(), "joedoe@mail.com", password)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, int64(defaultTokenTTL.Seconds()), resp.ExpiresIn)
+
+	ttl, err := svc.TokenTimeToLive(resp.AccessToken)
+	require.NoError(t, err)
+
+	assert.InDelta(t, defaultTokenTTL.Seconds(), ttl.Seconds(), 2)
+
+	_, err = svc.GenerateTokenResponse(context.Background(), "joedoe@mail.com", "wrong%&password123")
+	assert.Equal(t, errPasswordInvalid, err)
+
+	t.Run("sliding session", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", svc.repo, WithSlidingSession(15*time.Minute, 24*time.Hour))
+
+		resp, err := svc.GenerateTokenResponse(context.Background(), "joedoe@mail.com", password)
+		require.NoError(t, err)
+
+		// The idle TTL replaces the token TTL
+		assert.Equal(t, int64((15 * time.Minute).Seconds()), resp.ExpiresIn)
+	})
+
+	t.Run("second factor enabled", func(t *testing.T) {
+		confirmedAt := time.Now()
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+			selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				return &repository.User{
+					ID:           uuid.New().String(),
+					Role:         string(RoleUser),
+					Email:        email,
+					PasswordHash: string(givenHash),
+				}, nil
+			},
+			selectTOTPFunc: func(ctx context.Context, userID string) (*repository.TOTP, error) {
+				return &repository.TOTP{UserID: userID, ConfirmedAt: &confirmedAt}, nil
+			},
+		}, WithTOTP("Acme", []byte("totp-key")))
+
+		resp, err := svc.GenerateTokenResponse(context.Background(), "joedoe@mail.com", password)
+		require.NoError(t, err)
+
+		assert.True(t, resp.MFARequired)
+		assert.Empty(t, resp.AccessToken)
+		assert.Empty(t, resp.TokenType)
+		assert.NotEmpty(t, resp.MFAToken)
+		assert.Equal(t, int64(mfaChallengeTTL.Seconds()), resp.ExpiresIn)
+	})
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	givenUser := &repository.User{
+		ID:                 uuid.New().String(),
+		Username:           "JDoe",
+		UsernameNormalized: "jdoe",
+		Email:              "joedoe@mail.com",
+		PasswordHash:       string(givenHash),
+		Role:               string(RoleUser),
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			if email == givenUser.Email {
+				return givenUser, nil
+			}
+			return nil, nil
+		},
+		selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+			if username == givenUser.UsernameNormalized {
+				return givenUser, nil
+			}
+			return nil, nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return givenUser, nil
+		},
+	})
+
+	testCases := []struct {
+		name            string
+		givenIdentifier string
+		givenPassword   string
+		expectedError   error
+	}{
+		{
+			name:            "email login",
+			givenIdentifier: "joedoe@mail.com",
+			givenPassword:   password,
+		},
+		{
+			name:            "username login",
+			givenIdentifier: "jdoe",
+			givenPassword:   password,
+		},
+		{
+			name:            "wrong password",
+			givenIdentifier: "joedoe@mail.com",
+			givenPassword:   "wrong%&password123",
+			expectedError:   errInvalidCredentials,
+		},
+		{
+			name:            "unknown email",
+			givenIdentifier: "other@mail.com",
+			givenPassword:   password,
+			expectedError:   errInvalidCredentials,
+		},
+		{
+			name:            "unknown username",
+			givenIdentifier: "other",
+			givenPassword:   password,
+			expectedError:   errInvalidCredentials,
+		},
+		{
+			name:            "invalid password",
+			givenIdentifier: "jdoe",
+			givenPassword:   "",
+			expectedError:   errInvalidCredentials,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := svc.Authenticate(context.Background(), tc.givenIdentifier, tc.givenPassword)
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError != nil {
+				return
+			}
+
+			resp, err := svc.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+
+			assert.Equal(t, givenUser.ID, resp.ID)
+		})
+	}
+}
+
+func TestTokenTimeToLive(t *testing.T) {
+	t.Parallel()
+
+	svc := DefaultService{jwtSigningKey: "jwt-secret"}
+
+	signToken := func(t *testing.T, key string, expiresAt time.Time) string {
+		token, err := jwt.NewWithClaims(jwtSigningMethod, jwtClaim{
+			UserID:         uuid.New().String(),
+			Role:           string(RoleUser),
+			StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix()},
+		}).SignedString([]byte(key))
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		ttl, err := svc.TokenTimeToLive(signToken(t, "jwt-secret", time.Now().Add(time.Hour)))
+		require.NoError(t, err)
+
+		assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 2)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		ttl, err := svc.TokenTimeToLive(signToken(t, "jwt-secret", time.Now().Add(-time.Hour)))
+		require.Equal(t, errTokenExpired, err)
+
+		assert.True(t, ttl <= 0)
+	})
+
+	t.Run("expired token with invalid signature", func(t *testing.T) {
+		_, err := svc.TokenTimeToLive(signToken(t, "other-secret", time.Now().Add(-time.Hour)))
+		require.Error(t, err)
+
+		assert.NotEqual(t, errTokenExpired, err)
+	})
+
+	t.Run("empty token", func(t *testing.T) {
+		_, err := svc.TokenTimeToLive("")
+		require.Equal(t, errTokenEmpty, err)
+	})
+}
+
+func TestUserInfo(t *testing.T) {
+	t.Parallel()
+
+	givenUser := repository.User{
+		ID:            uuid.New().String(),
+		Fullname:      "Joe Doe",
+		Username:      "jdoe",
+		Email:         "joedoe@mail.com",
+		EmailVerified: true,
+		Role:          string(RoleUser),
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			if id != givenUser.ID {
+				return nil, nil
+			}
+			user := givenUser
+			return &user, nil
+		},
+	})
+
+	// The jwt library rejects tokens issued in the future
+	svc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+	t.Run("returns the userinfo claims", func(t *testing.T) {
+		token, err := issueTestToken(context.Background(), svc, givenUser.ID, time.Hour)
+		require.NoError(t, err)
+
+		actual, err := svc.UserInfo(context.Background(), token)
+		require.NoError(t, err)
+
+		expected := map[string]interface{}{
+			"sub":                givenUser.ID,
+			"name":               "Joe Doe",
+			"preferred_username": "jdoe",
+			"email":              "joedoe@mail.com",
+			"email_verified":     true,
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("user not found", func(t *testing.T) {
+		token, err := issueTestToken(context.Background(), svc, uuid.New().String(), time.Hour)
+		require.NoError(t, err)
+
+		_, err = svc.UserInfo(context.Background(), token)
+		assert.Equal(t, errNotFound, err)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, err := svc.UserInfo(context.Background(), "invalid-token")
+		assert.Error(t, err)
+	})
+}
+
+func TestParseAndValidateClaims(t *testing.T) {
+	t.Parallel()
+
+	svc := DefaultService{jwtSigningKey: "jwt-secret"}
+
+	givenUserID := uuid.New().String()
+
+	signToken := func(t *testing.T, method jwt.SigningMethod, key string, claims jwtClaim) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString([]byte(key))
+		require.NoError(t, err)
+		return token
+	}
+
+	validClaims := jwtClaim{
+		UserID:         givenUserID,
+		Role:           string(RoleUser),
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}
+
+	testCases := []struct {
+		name           string
+		givenToken     string
+		expectedClaims *jwtClaim
+		expectedError  error
+		expectError    bool
+	}{
+		{
+			name:           "valid token",
+			givenToken:     signToken(t, jwtSigningMethod, "jwt-secret", validClaims),
+			expectedClaims: &validClaims,
+		},
+		{
+			name:          "empty token",
+			givenToken:    "",
+			expectedError: errTokenEmpty,
+			expectError:   true,
+		},
+		{
+			name:        "malformed token",
+			givenToken:  "not-a-token",
+			expectError: true,
+		},
+		{
+			name:        "invalid signature",
+			givenToken:  signToken(t, jwtSigningMethod, "other-secret", validClaims),
+			expectError: true,
+		},
+		{
+			name:        "unexpected signing method",
+			givenToken:  signToken(t, jwt.SigningMethodHS256, "jwt-secret", validClaims),
+			expectError: true,
+		},
+		{
+			name: "expired token",
+			givenToken: signToken(t, jwtSigningMethod, "jwt-secret", jwtClaim{
+				UserID:         givenUserID,
+				Role:           string(RoleUser),
+				StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Hour).Unix()},
+			}),
+			expectedError: errTokenExpired,
+			expectError:   true,
+		},
+		{
+			name: "missing user id",
+			givenToken: signToken(t, jwtSigningMethod, "jwt-secret", jwtClaim{
+				Role:           string(RoleUser),
+				StandardClaims: validClaims.StandardClaims,
+			}),
+			expectError: true,
+		},
+		{
+			name: "missing role",
+			givenToken: signToken(t, jwtSigningMethod, "jwt-secret", jwtClaim{
+				UserID:         givenUserID,
+				StandardClaims: validClaims.StandardClaims,
+			}),
+			expectError: true,
+		},
+		{
+			name: "missing expiration",
+			givenToken: signToken(t, jwtSigningMethod, "jwt-secret", jwtClaim{
+				UserID: givenUserID,
+				Role:   string(RoleUser),
+			}),
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := svc.parseAndValidateClaims(tc.givenToken)
+			require.Equal(t, tc.expectError, err != nil)
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+			}
+			assert.Equal(t, tc.expectedClaims, actual)
+		})
+	}
+}
+
+func TestGenerateJWT_keyID(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithKeyID("key-1"))
+
+	token, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwtClaim{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "key-1", parsed.Header["kid"])
+
+	_, err = svc.parseAndValidateClaims(token)
+	require.NoError(t, err)
+
+	t.Run("token without key id", func(t *testing.T) {
+		token, err := issueTestToken(context.Background(), New(zap.NewNop(), "jwt-secret", &repositoryMock{}), givenUserID, time.Hour)
+		require.NoError(t, err)
+
+		_, err = svc.parseAndValidateClaims(token)
+		require.NoError(t, err)
+	})
+
+	t.Run("token with another key id", func(t *testing.T) {
+		otherSvc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithKeyID("key-2"))
+
+		token, err := issueTestToken(context.Background(), otherSvc, givenUserID, time.Hour)
+		require.NoError(t, err)
+
+		_, err = svc.parseAndValidateClaims(token)
+		require.Error(t, err)
+	})
+}
+
+func TestGenerateJWT_claimsBuilder(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+		WithClaimsBuilder(func(userID, role string) jwt.MapClaims {
+			return jwt.MapClaims{
+				"iss":     "test-app",
+				"aud":     "test-api",
+				"tenant":  "acme",
+				"user_id": "someone-else",
+				"role":    string(RoleAdmin),
+				"exp":     nil,
+				"rea":     true,
+			}
+		}),
+	)
+
+	token, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	var claims jwt.MapClaims
+	_, _, err = new(jwt.Parser).ParseUnverified(token, &claims)
+	require.NoError(t, err)
+
+	assert.Equal(t, "test-app", claims["iss"])
+	assert.Equal(t, "test-api", claims["aud"])
+	assert.Equal(t, "acme", claims["tenant"])
+
+	// Mandatory claims can't be overridden or removed
+	assert.Equal(t, givenUserID, claims["user_id"])
+	assert.Equal(t, RoleUser.String(), claims["role"])
+	assert.NotNil(t, claims["exp"])
+	assert.NotNil(t, claims["iat"])
+	assert.NotContains(t, claims, "rea")
+
+	parsed, err := svc.parseAndValidateClaims(token)
+	require.NoError(t, err)
+
+	assert.Equal(t, givenUserID, parsed.UserID)
+	assert.False(t, parsed.Reactivation)
+}
+
+func TestVerifyToken_trustedIssuers(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	repo := &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+		},
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", repo, WithTrustedIssuers("legacy-auth"))
+
+	issue := func(t *testing.T, issuer string) string {
+		t.Helper()
+
+		issuerSvc := New(zap.NewNop(), "jwt-secret", repo, WithClaimsBuilder(func(userID, role string) jwt.MapClaims {
+			if issuer == "" {
+				return nil
+			}
+			return jwt.MapClaims{"iss": issuer}
+		}))
+		issuerSvc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+		token, err := issueTestToken(context.Background(), issuerSvc, givenUserID, time.Hour)
+		require.NoError(t, err)
+		return token
+	}
+
+	testCases := []struct {
+		name          string
+		givenIssuer   string
+		expectedError error
+	}{
+		{
+			name:          "own token without issuer",
+			givenIssuer:   "",
+			expectedError: nil,
+		},
+		{
+			name:          "trusted issuer",
+			givenIssuer:   "legacy-auth",
+			expectedError: nil,
+		},
+		{
+			name:          "untrusted issuer",
+			givenIssuer:   "someone-else",
+			expectedError: errTokenIssuerUntrusted,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := svc.VerifyToken(context.Background(), issue(t, tc.givenIssuer))
+			if tc.expectedError != nil {
+				require.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, givenUserID, resp.ID)
+		})
+	}
+
+	t.Run("trusted issuer with another signing key", func(t *testing.T) {
+		t.Parallel()
+
+		otherSvc := New(zap.NewNop(), "other-secret", repo, WithClaimsBuilder(func(userID, role string) jwt.MapClaims {
+			return jwt.MapClaims{"iss": "legacy-auth"}
+		}))
+		otherSvc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+		token, err := issueTestToken(context.Background(), otherSvc, givenUserID, time.Hour)
+		require.NoError(t, err)
+
+		_, err = svc.VerifyToken(context.Background(), token)
+		require.Error(t, err)
+	})
+
+	t.Run("any issuer is accepted without trusted issuers", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := New(zap.NewNop(), "jwt-secret", repo).VerifyToken(context.Background(), issue(t, "someone-else"))
+		require.NoError(t, err)
+	})
+}
+
+func TestVerifyToken_issuerAndAudience(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	repo := &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+		},
+	}
+
+	issue := func(t *testing.T, opts ...ServiceOption) string {
+		t.Helper()
+
+		issuerSvc := New(zap.NewNop(), "jwt-secret", repo, opts...)
+		issuerSvc.clock = func() time.Time { return time.Now().Add(-time.Minute) }
+
+		token, err := issueTestToken(context.Background(), issuerSvc, givenUserID, time.Hour)
+		require.NoError(t, err)
+		return token
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", repo, WithIssuer("auth"), WithAudience("api"), WithTrustedIssuers("legacy-auth"))
+
+	testCases := []struct {
+		name          string
+		givenToken    string
+		givenCtx      context.Context
+		expectedError error
+	}{
+		{
+			name:       "own token",
+			givenToken: issue(t, WithIssuer("auth"), WithAudience("api")),
+			givenCtx:   context.Background(),
+		},
+		{
+			name:       "trusted issuer",
+			givenToken: issue(t, WithIssuer("legacy-auth"), WithAudience("api")),
+			givenCtx:   context.Background(),
+		},
+		{
+			name:          "another issuer",
+			givenToken:    issue(t, WithIssuer("someone-else"), WithAudience("api")),
+			givenCtx:      context.Background(),
+			expectedError: errTokenIssuerUntrusted,
+		},
+		{
+			name:          "token without issuer",
+			givenToken:    issue(t, WithAudience("api")),
+			givenCtx:      context.Background(),
+			expectedError: errTokenIssuerUntrusted,
+		},
+		{
+			name:          "another audience",
+			givenToken:    issue(t, WithIssuer("auth"), WithAudience("admin")),
+			givenCtx:      context.Background(),
+			expectedError: errTokenAudienceMismatch,
+		},
+		{
+			name:       "audience declared in context",
+			givenToken: issue(t, WithIssuer("auth"), WithAudience("mobile")),
+			givenCtx:   ContextWithAudience(context.Background(), "mobile"),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := svc.VerifyToken(tc.givenCtx, tc.givenToken)
+			if tc.expectedError != nil {
+				require.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, givenUserID, resp.ID)
+		})
+	}
+}
+
+func TestWithTokenTTL(t *testing.T) {
+	t.Parallel()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithTokenTTL(time.Hour*48))
+
+	assert.Equal(t, time.Hour*48, svc.clampTokenTTL(0))
+
+	// The maximum token TTL defaults to the token TTL
+	assert.Equal(t, time.Hour*48, svc.clampTokenTTL(time.Hour*72))
+	assert.Equal(t, time.Hour, svc.clampTokenTTL(time.Hour))
+}
+
+func TestValidateSigningConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		givenKey      string
+		givenOpts     []ServiceOption
+		expectedError bool
+	}{
+		{
+			name:     "valid config",
+			givenKey: "jwt-secret",
+		},
+		{
+			name:      "valid config with token binding and key id",
+			givenKey:  "jwt-secret",
+			givenOpts: []ServiceOption{WithTokenBinding(), WithKeyID("key-1")},
+		},
+		{
+			name:          "empty key",
+			givenKey:      "",
+			expectedError: true,
+		},
+		{
+			name:     "issued tokens are rejected on verification",
+			givenKey: "jwt-secret",
+			givenOpts: []ServiceOption{
+				WithTrustedIssuers("legacy-auth"),
+				WithClaimsBuilder(func(userID, role string) jwt.MapClaims {
+					return jwt.MapClaims{"iss": "test-app"}
+				}),
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), tc.givenKey, &repositoryMock{}, tc.givenOpts...)
+
+			err := svc.ValidateSigningConfig()
+			if tc.expectedError {
+				require.Error(t, err)
+
+				assert.Panics(t, func() {
+					New(zap.NewNop(), tc.givenKey, &repositoryMock{}, append(tc.givenOpts, WithSigningConfigValidation())...)
+				})
+				return
+			}
+
+			require.NoError(t, err)
+
+			assert.NotPanics(t, func() {
+				New(zap.NewNop(), tc.givenKey, &repositoryMock{}, append(tc.givenOpts, WithSigningConfigValidation())...)
+			})
+		})
+	}
+}
+
+func TestVerifyAuthorizationHeader(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+		},
+	})
+
+	token, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		givenHeader   string
+		expectedError error
+		expectError   bool
+	}{
+		{
+			name:        "bearer token",
+			givenHeader: "Bearer " + token,
+		},
+		{
+			name:        "case-insensitive scheme and surrounding whitespace",
+			givenHeader: "  bEaReR   " + token + " ",
+		},
+		{
+			name:          "empty header",
+			givenHeader:   "",
+			expectedError: errMalformedAuthHeader,
+			expectError:   true,
+		},
+		{
+			name:          "missing scheme",
+			givenHeader:   token,
+			expectedError: errMalformedAuthHeader,
+			expectError:   true,
+		},
+		{
+			name:          "other scheme",
+			givenHeader:   "Basic " + token,
+			expectedError: errMalformedAuthHeader,
+			expectError:   true,
+		},
+		{
+			name:          "missing token",
+			givenHeader:   "Bearer ",
+			expectedError: errMalformedAuthHeader,
+			expectError:   true,
+		},
+		{
+			name:        "invalid token",
+			givenHeader: "Bearer invalid-token",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := svc.VerifyAuthorizationHeader(context.Background(), tc.givenHeader)
+			require.Equal(t, tc.expectError, err != nil)
+
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err)
+			}
+
+			if !tc.expectError {
+				assert.Equal(t, givenUserID, actual.ID)
+			}
+		})
+	}
+}
+
+func TestParseAndValidateClaims_expirationBoundary(t *testing.T) {
+	t.Parallel()
+
+	givenExpiresAt := time.Now().Add(time.Hour).Unix()
+
+	token, err := jwt.NewWithClaims(jwtSigningMethod, jwtClaim{
+		UserID:         uuid.New().String(),
+		Role:           string(RoleUser),
+		StandardClaims: jwt.StandardClaims{ExpiresAt: givenExpiresAt},
+	}).SignedString([]byte("jwt-secret"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		givenNow      time.Time
+		expectedError error
+	}{
+		{
+			name:     "second before expiration",
+			givenNow: time.Unix(givenExpiresAt-1, int64(time.Millisecond*999)),
+		},
+		{
+			name:     "fraction of the expiration second",
+			givenNow: time.Unix(givenExpiresAt, int64(time.Millisecond*500)),
+		},
+		{
+			name:          "second after expiration",
+			givenNow:      time.Unix(givenExpiresAt+1, 0),
+			expectedError: errTokenExpired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{
+				jwtSigningKey: "jwt-secret",
+				clock:         func() time.Time { return tc.givenNow },
+			}
+
+			_, err := svc.parseAndValidateClaims(token)
+			require.Equal(t, tc.expectedError, err)
+
+			ttl, err := svc.TokenTimeToLive(token)
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError == nil {
+				assert.True(t, ttl >= 0)
+			}
+		})
+	}
+}
+
+func TestVerifyToken_passwordChange(t *testing.T) {
+	t.Parallel()
+
+	password := "password%&123"
+
+	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	storedUser := repository.User{
+		ID:           uuid.New().String(),
+		Username:     "jdoe",
+		Email:        "joedoe@mail.com",
+		PasswordHash: string(givenHash),
+		Role:         string(RoleUser),
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				user := storedUser
+				return &user, nil
+			},
+			updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				storedUser = *user
+				return user, nil
+			},
+		},
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	oldToken, err := issueTestToken(context.Background(), svc, storedUser.ID, time.Hour)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), oldToken)
+	require.NoError(t, err)
+
+	now = now.Add(time.Second)
+
+	require.NoError(t, svc.ChangePassword(context.Background(), storedUser.ID, password, "new-password%&123"))
+
+	_, err = svc.VerifyToken(context.Background(), oldToken)
+	assert.Equal(t, errTokenSuperseded, err)
+
+	newToken, err := issueTestToken(context.Background(), svc, storedUser.ID, time.Hour)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), newToken)
+	assert.NoError(t, err)
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	t.Parallel()
+
+	storedUser := repository.User{
+		ID:       uuid.New().String(),
+		Username: "jdoe",
+		Email:    "joedoe@mail.com",
+		Role:     string(RoleUser),
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				if id != storedUser.ID {
+					return nil, nil
+				}
+				user := storedUser
+				return &user, nil
+			},
+			updateFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+				storedUser = *user
+				return user, nil
+			},
+		},
+	)
+
+	// The jwt library rejects tokens issued in the future
+	now := time.Now().Add(-time.Minute)
+	svc.clock = func() time.Time { return now }
+
+	token, err := issueTestToken(context.Background(), svc, storedUser.ID, time.Hour)
+	require.NoError(t, err)
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+
+	now = now.Add(time.Second)
+
+	require.NoError(t, svc.RevokeUserTokens(context.Background(), storedUser.ID))
+
+	_, err = svc.VerifyToken(context.Background(), token)
+	assert.Equal(t, errTokenSuperseded, err)
+
+	t.Run("user not found", func(t *testing.T) {
+		err := svc.RevokeUserTokens(context.Background(), uuid.New().String())
+		assert.Equal(t, errNotFound, err)
+	})
+}
+
+func TestRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				if id != givenUserID {
+					return nil, nil
+				}
+				return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+			},
+		},
+		WithMaxTokenTTL(time.Hour*24*30),
+	)
+
+	givenToken, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour*24*7)
+	require.NoError(t, err)
+
+	unknownUserToken, err := issueTestToken(context.Background(), svc, uuid.New().String(), time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		givenToken    string
+		expectedError error
+	}{
+		{
+			name:       "token is refreshed",
+			givenToken: givenToken,
+		},
+		{
+			name:          "empty token",
+			givenToken:    "",
+			expectedError: errTokenEmpty,
+		},
+		{
+			name:          "user not found",
+			givenToken:    unknownUserToken,
+			expectedError: errNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			token, err := svc.RefreshToken(context.Background(), tc.givenToken)
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError != nil {
+				return
+			}
+
+			resp, err := svc.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+
+			assert.Equal(t, givenUserID, resp.ID)
+			assert.Equal(t, RoleUser.String(), resp.Role)
+
+			var claims jwtClaim
+			_, _, err = new(jwt.Parser).ParseUnverified(token, &claims)
+			require.NoError(t, err)
+
+			assert.Equal(t, int64((time.Hour * 24 * 7).Seconds()), claims.ExpiresAt-claims.IssuedAt)
+		})
+	}
+}
+
+func TestVerifyToken_tokenBinding(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	givenClient := ClientInfo{IP: "10.0.0.1", UserAgent: "test-agent"}
+
+	svc := New(zap.NewNop(), "jwt-secret",
+		&repositoryMock{
+			selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+				return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+			},
+		},
+		WithTokenBinding(),
+	)
+
+	token, err := issueTestToken(ContextWithClientInfo(context.Background(), givenClient), svc, givenUserID, time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		givenCtx      context.Context
+		expectedError error
+	}{
+		{
+			name:          "same client",
+			givenCtx:      ContextWithClientInfo(context.Background(), givenClient),
+			expectedError: nil,
+		},
+		{
+			name:          "different user agent",
+			givenCtx:      ContextWithClientInfo(context.Background(), ClientInfo{IP: givenClient.IP, UserAgent: "other-agent"}),
+			expectedError: errTokenBindingMismatch,
+		},
+		{
+			name:          "different ip",
+			givenCtx:      ContextWithClientInfo(context.Background(), ClientInfo{IP: "10.0.0.2", UserAgent: givenClient.UserAgent}),
+			expectedError: errTokenBindingMismatch,
+		},
+		{
+			name:          "missing client info",
+			givenCtx:      context.Background(),
+			expectedError: errTokenBindingMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := svc.VerifyToken(tc.givenCtx, token)
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError == nil {
+				assert.Equal(t, givenUserID, resp.ID)
+				assert.Equal(t, RoleUser.String(), resp.Role)
+			}
+		})
+	}
+
+	t.Run("client info is required to issue a bound token", func(t *testing.T) {
+		_, err := issueTestToken(context.Background(), svc, givenUserID, time.Hour)
+		assert.Error(t, err)
+	})
+}
+
+func TestSendEmailVerification_emailerNotConfigured(t *testing.T) {
+	t.Parallel()
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
 
-			err := svc.Delete(context.Background(), tc.givenID)
-			require.Equal(t, tc.expectedError, err != nil)
-		})
-	}
+	err := svc.SendEmailVerification(context.Background(), uuid.New().String(), "jdoe", "joedoe@mail.com")
+	require.Equal(t, errEmailerNotConfigured, err)
 }
 
-func TestDelete(t *testing.T) {
+func TestSendTestEmail(t *testing.T) {
 	t.Parallel()
 
+	errSMTP := errors.New("535 authentication failed")
+
 	testCases := []struct {
 		name          string
-		givenRepoMock *repositoryMock
-		expectError   bool
+		givenTo       string
+		givenSendErr  error
+		expectedError error
 	}{
 		{
-			name: "delete user error",
-			givenRepoMock: &repositoryMock{
-				deleteByIDFunc: func(ctx context.Context, id string) error {
-					return errors.New("some error")
-				},
-			},
-			expectError: true,
+			name:    "test email is sent",
+			givenTo: "operator@mail.com",
 		},
 		{
-			name: "delete user success",
-			givenRepoMock: &repositoryMock{
-				deleteByIDFunc: func(ctx context.Context, id string) error {
-					return nil
-				},
-			},
-			expectError: false,
+			name:          "emailer error",
+			givenTo:       "operator@mail.com",
+			givenSendErr:  errSMTP,
+			expectedError: errSMTP,
 		},
 	}
 
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			svc := DefaultService{
-				repo: tc.givenRepoMock,
-			}
+			t.Parallel()
 
-			err := svc.Delete(context.Background(), uuid.New().String())
-			assert.Equal(t, tc.expectError, err != nil)
+			var sent int
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{},
+				WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+					sendFunc: func(from, to string, body []byte) error {
+						sent++
+						assert.Equal(t, tc.givenTo, to)
+						assert.Contains(t, string(body), "Test Email")
+						return tc.givenSendErr
+					},
+				}),
+			)
+
+			err := svc.SendTestEmail(context.Background(), tc.givenTo)
+			assert.ErrorIs(t, err, tc.expectedError)
+			assert.Equal(t, 1, sent)
 		})
 	}
+
+	t.Run("emailer is not configured", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+		err := svc.SendTestEmail(context.Background(), "operator@mail.com")
+		assert.Equal(t, errEmailerNotConfigured, err)
+	})
 }
 
-func TestGenerateToken_validation(t *testing.T) {
+func TestConfirmEmailVerification(t *testing.T) {
 	t.Parallel()
 
+	givenUserID := uuid.New().String()
+
+	// The expiration is checked against the service clock
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	givenUsedAt := now.Add(-time.Minute)
+
+	givenVerifications := map[string]*repository.EmailVerification{
+		"valid":   {Code: "valid", UserID: givenUserID, ExpiresAt: now.Add(time.Hour)},
+		"expired": {Code: "expired", UserID: givenUserID, ExpiresAt: now.Add(-time.Hour)},
+		"used":    {Code: "used", UserID: givenUserID, ExpiresAt: now.Add(time.Hour), UsedAt: &givenUsedAt},
+	}
+
+	newService := func(verified *[]string) *DefaultService {
+		return &DefaultService{
+			clock: func() time.Time { return now },
+			repo: &repositoryMock{
+				selectEmailVerificationFunc: func(ctx context.Context, code string) (*repository.EmailVerification, error) {
+					return givenVerifications[code], nil
+				},
+				selectEmailVerificationForUserFunc: func(ctx context.Context, userID, code string) (*repository.EmailVerification, error) {
+					v, ok := givenVerifications[code]
+					if !ok || v.UserID != userID {
+						return nil, nil
+					}
+					return v, nil
+				},
+				markEmailVerifiedFunc: func(ctx context.Context, code string) error {
+					*verified = append(*verified, code)
+					return nil
+				},
+			},
+		}
+	}
+
 	testCases := []struct {
 		name          string
-		givenEmail    string
-		givenPassword string
-		expectedError bool
+		givenUserID   string
+		givenCode     string
+		expectedError error
 	}{
 		{
-			name:          "empty email",
-			givenEmail:    "",
-			givenPassword: "password%&123",
-			expectedError: true,
+			name:        "valid code",
+			givenUserID: givenUserID,
+			givenCode:   "valid",
 		},
 		{
-			name:          "invalid email format",
-			givenEmail:    "invalid-email",
-			givenPassword: "password%&123",
-			expectedError: true,
+			name:          "code of another user",
+			givenUserID:   uuid.New().String(),
+			givenCode:     "valid",
+			expectedError: errVerificationNotFound,
 		},
 		{
-			name:          "empty password",
-			givenEmail:    "joedoe@mail.com",
-			givenPassword: "",
-			expectedError: true,
+			name:          "unknown code",
+			givenUserID:   givenUserID,
+			givenCode:     "unknown",
+			expectedError: errVerificationNotFound,
 		},
 		{
-			name:          "empty password format",
-			givenEmail:    "joedoe@mail.com",
-			givenPassword: "123",
-			expectedError: true,
+			name:          "expired code",
+			givenUserID:   givenUserID,
+			givenCode:     "expired",
+			expectedError: errVerificationExpired,
+		},
+		{
+			name:          "used code",
+			givenUserID:   givenUserID,
+			givenCode:     "used",
+			expectedError: errVerificationUsed,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			svc := DefaultService{}
+			var verified []string
 
-			_, err := svc.GenerateToken(context.Background(), tc.givenEmail, tc.givenPassword)
-			require.Equal(t, tc.expectedError, err != nil)
+			err := newService(&verified).ConfirmEmailVerificationFor(context.Background(), tc.givenUserID, tc.givenCode)
+			require.Equal(t, tc.expectedError, err)
+
+			if tc.expectedError == nil {
+				assert.Equal(t, []string{tc.givenCode}, verified)
+			} else {
+				assert.Empty(t, verified)
+			}
 		})
 	}
+
+	t.Run("code only lookup", func(t *testing.T) {
+		var verified []string
+
+		err := newService(&verified).ConfirmEmailVerification(context.Background(), "valid")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"valid"}, verified)
+	})
 }
 
-func TestGenerateToken(t *testing.T) {
+func TestConfirmEmailVerification_hashedCodes(t *testing.T) {
 	t.Parallel()
 
-	password := "password%&123"
+	givenUserID := uuid.New().String()
 
-	givenHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	var (
+		stored   = map[string]*repository.EmailVerification{}
+		body     string
+		verified []string
+	)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		insertEmailVerificationFunc: func(ctx context.Context, in repository.EmailVerification) error {
+			stored[in.Code] = &in
+			return nil
+		},
+		selectEmailVerificationFunc: func(ctx context.Context, code string) (*repository.EmailVerification, error) {
+			return stored[code], nil
+		},
+		selectEmailVerificationForUserFunc: func(ctx context.Context, userID, code string) (*repository.EmailVerification, error) {
+			v, ok := stored[code]
+			if !ok || v.UserID != userID {
+				return nil, nil
+			}
+			return v, nil
+		},
+		markEmailVerifiedFunc: func(ctx context.Context, code string) error {
+			verified = append(verified, code)
+			return nil
+		},
+	},
+		WithHashedVerificationCodes(),
+		WithEmailVerification("test-app", "test-app@foo.bar", "http://test-app:8080/verify-email", &emailerMock{
+			sendFunc: func(from, to string, b []byte) error {
+				body = string(b)
+				return nil
+			},
+		}),
+	)
+
+	err := svc.SendEmailVerification(context.Background(), givenUserID, "jdoe", "joedoe@mail.com")
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+
+	var storedCode string
+	for code := range stored {
+		storedCode = code
+	}
+
+	// the email carries the plaintext code, the storage its digest
+	sentCode := body[strings.LastIndex(body, "/")+1 : strings.LastIndex(body, "\r\n")]
+	require.Len(t, sentCode, 6)
+
+	// the digest is keyed, so it can't be computed from the code alone
+	sum := sha256.Sum256([]byte(sentCode))
+	assert.NotEqual(t, hex.EncodeToString(sum[:]), storedCode)
+	assert.NotContains(t, storedCode, sentCode)
+
+	mac := hmac.New(sha256.New, []byte("jwt-secret"))
+	mac.Write([]byte("email-verification\n" + sentCode))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), storedCode)
+
+	// the stored digest is not a valid code
+	require.Equal(t, errVerificationNotFound, svc.ConfirmEmailVerification(context.Background(), storedCode))
+
+	require.NoError(t, svc.ConfirmEmailVerificationFor(context.Background(), givenUserID, sentCode))
+	require.NoError(t, svc.ConfirmEmailVerification(context.Background(), sentCode))
+	assert.Equal(t, []string{storedCode, storedCode}, verified)
+}
+
+func TestListEmailVerifications(t *testing.T) {
+	t.Parallel()
+
+	givenUserID := uuid.New().String()
+
+	givenCreatedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	givenUsedAt := givenCreatedAt.Add(time.Minute)
+
+	svc := DefaultService{
+		repo: &repositoryMock{
+			selectEmailVerificationsByUserIDFunc: func(ctx context.Context, userID string) ([]repository.EmailVerification, error) {
+				return []repository.EmailVerification{
+					{Code: "ABC123", UserID: userID, CreatedAt: givenCreatedAt, ExpiresAt: givenCreatedAt.Add(time.Hour), UsedAt: &givenUsedAt},
+					{Code: "XYZ789", UserID: userID, CreatedAt: givenCreatedAt, ExpiresAt: givenCreatedAt.Add(time.Hour)},
+				}, nil
+			},
+		},
+	}
+
+	actual, err := svc.ListEmailVerifications(context.Background(), givenUserID)
 	require.NoError(t, err)
 
+	expected := []EmailVerification{
+		{MaskedCode: "****23", CreatedAt: givenCreatedAt, ExpiresAt: givenCreatedAt.Add(time.Hour), UsedAt: &givenUsedAt},
+		{MaskedCode: "****89", CreatedAt: givenCreatedAt, ExpiresAt: givenCreatedAt.Add(time.Hour)},
+	}
+	assert.Equal(t, expected, actual)
+
+	t.Run("invalid id", func(t *testing.T) {
+		_, err := svc.ListEmailVerifications(context.Background(), "%invalid-id%")
+		require.Error(t, err)
+	})
+}
+
+func TestGetUserStats(t *testing.T) {
+	t.Parallel()
+
 	testCases := []struct {
 		name          string
-		givenPassword string
 		givenRepoMock *repositoryMock
-		expectedToken bool
-		expectedError bool
+		expectedStats *UserStats
+		expectedError error
 	}{
 		{
-			name:          "user not found",
-			givenPassword: password,
+			name: "stats are aggregated",
 			givenRepoMock: &repositoryMock{
-				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
-					return nil, nil
+				selectUserStatsFunc: func(ctx context.Context) (*repository.UserStats, error) {
+					return &repository.UserStats{
+						Total:             10,
+						Verified:          7,
+						CreatedLast7Days:  2,
+						CreatedLast30Days: 5,
+						ByRole:            map[string]int{"user": 9, "admin": 1},
+					}, nil
 				},
 			},
-			expectedToken: false,
-			expectedError: true,
+			expectedStats: &UserStats{
+				Total:             10,
+				Verified:          7,
+				Unverified:        3,
+				CreatedLast7Days:  2,
+				CreatedLast30Days: 5,
+				ByRole:            map[role]int{RoleUser: 9, RoleAdmin: 1},
+			},
+			expectedError: nil,
 		},
 		{
-			name:          "select user error",
-			givenPassword: password,
+			name: "select user stats error",
 			givenRepoMock: &repositoryMock{
-				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+				selectUserStatsFunc: func(ctx context.Context) (*repository.UserStats, error) {
 					return nil, errors.New("some error")
 				},
 			},
-			expectedToken: false,
-			expectedError: true,
+			expectedStats: nil,
+			expectedError: fmt.Errorf("could not select user stats: some error"),
 		},
-		{
-			name:          "password match",
-			givenPassword: password,
-			givenRepoMock: &repositoryMock{
-				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
-					return &repository.User{
-						ID:           uuid.New().String(),
-						Role:         string(RoleUser),
-						Email:        email,
-						PasswordHash: string(givenHash),
-					}, nil
-				},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := DefaultService{
+				repo: tc.givenRepoMock,
+			}
+
+			stats, err := svc.GetUserStats(context.Background())
+			require.Equal(t, tc.expectedError, err)
+			require.Equal(t, tc.expectedStats, stats)
+		})
+	}
+}
+
+func TestListUsersWithStalePasswords(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	givenUser := repository.User{
+		ID:                uuid.New().String(),
+		Username:          "jdoe",
+		Email:             "joedoe@mail.com",
+		Role:              string(RoleUser),
+		PasswordChangedAt: now.AddDate(-1, 0, 0),
+	}
+
+	otherUser := givenUser
+	otherUser.ID = uuid.New().String()
+	otherUser.PasswordChangedAt = now.AddDate(0, -6, 0)
+
+	var (
+		actualBefore time.Time
+		actualAfter  []*repository.PasswordChangedCursor
+	)
+
+	svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+		selectByPasswordChangedBeforeFunc: func(ctx context.Context, before time.Time, after *repository.PasswordChangedCursor, limit int) ([]repository.User, error) {
+			assert.Equal(t, 2, limit)
+
+			actualBefore = before
+			actualAfter = append(actualAfter, after)
+
+			if after == nil {
+				return []repository.User{givenUser, otherUser}, nil
+			}
+			return []repository.User{otherUser}, nil
+		},
+	}, WithPagination(1, 10))
+	svc.clock = func() time.Time { return now }
+
+	page, err := svc.ListUsersWithStalePasswords(context.Background(), 90*24*time.Hour, StalePasswordsInput{})
+	require.NoError(t, err)
+
+	assert.Equal(t, now.Add(-90*24*time.Hour), actualBefore)
+
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, givenUser.ID, page.Users[0].ID)
+	assert.Equal(t, givenUser.PasswordChangedAt, page.Users[0].PasswordChangedAt)
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = svc.ListUsersWithStalePasswords(context.Background(), 90*24*time.Hour, StalePasswordsInput{Cursor: page.NextCursor})
+	require.NoError(t, err)
+
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, otherUser.ID, page.Users[0].ID)
+	assert.Empty(t, page.NextCursor)
+
+	// The second page resumes after the last user of the first one
+	assert.Equal(t, []*repository.PasswordChangedCursor{
+		nil, {PasswordChangedAt: givenUser.PasswordChangedAt, ID: givenUser.ID},
+	}, actualAfter)
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := svc.ListUsersWithStalePasswords(context.Background(), time.Hour, StalePasswordsInput{Cursor: "foo"})
+		assert.Equal(t, errCursorInvalid, err)
+	})
+
+	t.Run("select error", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+			selectByPasswordChangedBeforeFunc: func(ctx context.Context, before time.Time, after *repository.PasswordChangedCursor, limit int) ([]repository.User, error) {
+				return nil, errors.New("some error")
 			},
-			expectedToken: true,
-			expectedError: false,
+		})
+
+		_, err := svc.ListUsersWithStalePasswords(context.Background(), time.Hour, StalePasswordsInput{})
+		assert.Error(t, err)
+	})
+}
+
+func TestWhichEmailsExist(t *testing.T) {
+	t.Parallel()
+
+	givenKey := []byte("0123456789abcdef0123456789abcdef")
+
+	testCases := []struct {
+		name         string
+		givenOptions []ServiceOption
+	}{
+		{
+			name: "plaintext emails",
 		},
 		{
-			name:          "password not match",
-			givenPassword: "somepassword&#%123",
-			givenRepoMock: &repositoryMock{
-				selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
-					return &repository.User{
-						ID:           uuid.New().String(),
-						Role:         string(RoleUser),
-						Email:        email,
-						PasswordHash: string(givenHash),
-					}, nil
-				},
-			},
-			expectedToken: false,
-			expectedError: true,
+			name:         "encrypted emails",
+			givenOptions: []ServiceOption{WithEmailEncryption(givenKey)},
 		},
 	}
 
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			svc := DefaultService{
-				repo: tc.givenRepoMock,
+			t.Parallel()
+
+			var storedEmails []string
+
+			svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{
+				selectExistingEmailsFunc: func(ctx context.Context, emails []string) ([]string, error) {
+					var existing []string
+					for _, email := range emails {
+						for _, stored := range storedEmails {
+							if strings.EqualFold(email, stored) {
+								existing = append(existing, strings.ToLower(stored))
+							}
+						}
+					}
+					return existing, nil
+				},
+			}, tc.givenOptions...)
+
+			storedEmails = []string{svc.emailLookup("joedoe@mail.com"), svc.emailLookup("Alice@Mail.com")}
+
+			// Emails stored before emails were normalized keep their case
+			if svc.emailEncryption != nil {
+				storedEmails = append(storedEmails, svc.emailEncryption.lookup("Legacy@Mail.com"))
+			} else {
+				storedEmails = append(storedEmails, "Legacy@Mail.com")
 			}
 
-			token, err := svc.GenerateToken(context.Background(), "joedoe@mail.com", tc.givenPassword)
-			assert.Equal(t, tc.expectedError, err != nil)
-			assert.Equal(t, tc.expectedToken, token != "")
+			actual, err := svc.WhichEmailsExist(context.Background(), []string{
+				"joedoe@mail.com", "JoeDoe@Mail.com", "Alice@Mail.com", "Legacy@Mail.com", "other@mail.com",
+			})
+			require.NoError(t, err)
+
+			expected := map[string]bool{
+				"joedoe@mail.com": true,
+				"JoeDoe@Mail.com": true,
+				"Alice@Mail.com":  true,
+				"Legacy@Mail.com": true,
+				"other@mail.com":  false,
+			}
+			assert.Equal(t, expected, actual)
 		})
 	}
+
+	t.Run("no emails", func(t *testing.T) {
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{})
+
+		actual, err := svc.WhichEmailsExist(context.Background(), nil)
+		require.NoError(t, err)
+
+		assert.Empty(t, actual)
+	})
 }
 
 func TestNewUserFromRepository(t *testing.T) {
@@ -589,3 +3940,73 @@ func TestRandString(t *testing.T) {
 		})
 	}
 }
+
+func TestContextCancellation(t *testing.T) {
+	t.Parallel()
+
+	// the driver reports an opaque error once the context is done
+	errDriver := errors.New("driver: bad connection")
+
+	repo := &repositoryMock{
+		insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+			return nil, errDriver
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return nil, errDriver
+		},
+		selectByEmailFunc: func(ctx context.Context, email string) (*repository.User, error) {
+			return nil, errDriver
+		},
+		selectByUsernameFunc: func(ctx context.Context, username string) (*repository.User, error) {
+			return nil, errDriver
+		},
+	}
+
+	svc := New(zap.NewNop(), "jwt-secret", repo)
+
+	calls := map[string]func(ctx context.Context) error{
+		"create": func(ctx context.Context) error {
+			_, err := svc.Create(ctx, CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "jdoe",
+				Birthdate:       "2000-01-01",
+				Email:           "joedoe@mail.com",
+				Password:        "password#123",
+				ConfirmPassword: "password#123",
+			})
+			return err
+		},
+		"fetch by id": func(ctx context.Context) error {
+			_, err := svc.FetchByID(ctx, uuid.New().String())
+			return err
+		},
+		"generate token": func(ctx context.Context) error {
+			_, err := svc.GenerateToken(ctx, "joedoe@mail.com", "password#123")
+			return err
+		},
+		"authenticate": func(ctx context.Context) error {
+			_, err := svc.Authenticate(ctx, "jdoe", "password#123")
+			return err
+		},
+	}
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expiredCtx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	for name, call := range calls {
+		call := call
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			require.ErrorIs(t, call(canceledCtx), context.Canceled)
+			require.ErrorIs(t, call(expiredCtx), context.DeadlineExceeded)
+
+			err := call(context.Background())
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, context.Canceled)
+		})
+	}
+}
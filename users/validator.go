@@ -0,0 +1,83 @@
+package users
+
+import "github.com/alesr/stdservices/pkg/validate"
+
+var _ Validator = DefaultValidator{}
+
+// Validator validates the emails, passwords and ids received by the service.
+// Names and birthdates are always validated by the validate package.
+type Validator interface {
+	ValidateEmail(email string) error
+	ValidatePassword(password string) error
+	ValidateID(id string) error
+}
+
+// WithValidator replaces the default validation of emails, passwords and ids
+// (e.g. to accept internationalized email addresses). Custom validators can embed
+// DefaultValidator to only override some of the rules.
+// Ids are validated by the validator of WithIDGenerator, when given.
+func WithValidator(v Validator) ServiceOption {
+	return func(s *DefaultService) {
+		s.inputValidator = v
+	}
+}
+
+// DefaultValidator validates emails, passwords and ids with the validate package.
+// It is the default validator.
+type DefaultValidator struct{}
+
+func (DefaultValidator) ValidateEmail(email string) error {
+	return validate.Email(email)
+}
+
+func (DefaultValidator) ValidatePassword(password string) error {
+	return validate.Password(password)
+}
+
+func (DefaultValidator) ValidateID(id string) error {
+	return validate.ID(id)
+}
+
+// validator returns the validator of the service
+func (s *DefaultService) validator() Validator {
+	if s.inputValidator == nil {
+		return DefaultValidator{}
+	}
+	return s.inputValidator
+}
+
+// WithPasswordPolicy validates new passwords, on create, password change and reset, against the policy in place
+// of the password rules of the validator (see validate.DefaultPasswordPolicy). Violations are rejected with the
+// message of their rule. Logins only reject empty passwords, so passwords set under a previous policy keep working.
+func WithPasswordPolicy(policy validate.PasswordPolicy) ServiceOption {
+	return func(s *DefaultService) {
+		s.passwordPolicy = &policy
+	}
+}
+
+// policyValidator validates passwords against a password policy, and the rest with its embedded validator
+type policyValidator struct {
+	Validator
+	policy validate.PasswordPolicy
+}
+
+func (v policyValidator) ValidatePassword(password string) error {
+	return v.policy.Validate(password)
+}
+
+// newPasswordValidator returns the validator of new passwords, applying the password policy when set
+func (s *DefaultService) newPasswordValidator() Validator {
+	if s.passwordPolicy == nil {
+		return s.validator()
+	}
+	return policyValidator{Validator: s.validator(), policy: *s.passwordPolicy}
+}
+
+// validateLoginPassword validates a password given to authenticate. With a password policy,
+// the empty policy is applied, only rejecting empty passwords.
+func (s *DefaultService) validateLoginPassword(password string) error {
+	if s.passwordPolicy == nil {
+		return s.validator().ValidatePassword(password)
+	}
+	return validate.PasswordPolicy{}.Validate(password)
+}
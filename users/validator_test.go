@@ -0,0 +1,180 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alesr/stdservices/pkg/validate"
+	"github.com/alesr/stdservices/users/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// permissiveValidator accepts any email with an "@", any non-empty password and any non-empty id
+type permissiveValidator struct {
+	DefaultValidator
+}
+
+func (permissiveValidator) ValidateEmail(email string) error {
+	if !strings.Contains(email, "@") {
+		return errors.New("email must contain an @")
+	}
+	return nil
+}
+
+func (permissiveValidator) ValidatePassword(password string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+	return nil
+}
+
+func (permissiveValidator) ValidateID(id string) error {
+	if id == "" {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+func TestWithValidator(t *testing.T) {
+	t.Parallel()
+
+	given := CreateUserInput{
+		Fullname:        "John Doe",
+		Username:        "jdoe",
+		Birthdate:       "2000-01-01",
+		Email:           "joe..doe@mail.com",
+		Password:        "secret",
+		ConfirmPassword: "secret",
+	}
+
+	repo := &repositoryMock{
+		insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+			return user, nil
+		},
+		selectByIDFunc: func(ctx context.Context, id string) (*repository.User, error) {
+			return &repository.User{ID: id, Username: "jdoe", Role: string(RoleUser)}, nil
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		givenOpts     []ServiceOption
+		expectedValid bool
+	}{
+		{
+			name:          "default validator",
+			givenOpts:     nil,
+			expectedValid: false,
+		},
+		{
+			name:          "permissive validator",
+			givenOpts:     []ServiceOption{WithValidator(permissiveValidator{})},
+			expectedValid: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret", repo, tc.givenOpts...)
+
+			resp, err := svc.Create(context.Background(), given)
+			_, fetchErr := svc.FetchByID(context.Background(), "legacy-42")
+
+			if !tc.expectedValid {
+				require.Error(t, err)
+				require.Error(t, fetchErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, given.Email, resp.Email)
+			require.NoError(t, fetchErr)
+		})
+	}
+
+	t.Run("id generator validator takes precedence", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), "jwt-secret", repo,
+			WithValidator(permissiveValidator{}),
+			WithIDGenerator(NewUUIDv4, func(id string) error { return errors.New("invalid id") }),
+		)
+
+		_, err := svc.FetchByID(context.Background(), "legacy-42")
+		require.Error(t, err)
+	})
+}
+
+func TestWithPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := validate.PasswordPolicy{MinLength: 6, BannedWords: []string{"acme"}}
+
+	testCases := []struct {
+		name          string
+		givenPassword string
+		expectedErr   error
+	}{
+		{
+			name:          "password allowed by the policy only",
+			givenPassword: "secret",
+		},
+		{
+			name:          "password too short",
+			givenPassword: "short",
+			expectedErr:   newE("password must be at least 6 characters"),
+		},
+		{
+			name:          "password with a banned word",
+			givenPassword: "my-acme-secret",
+			expectedErr:   newE(`password must not contain "acme"`),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := New(zap.NewNop(), "jwt-secret",
+				&repositoryMock{
+					insertFunc: func(ctx context.Context, user *repository.User) (*repository.User, error) {
+						return user, nil
+					},
+				},
+				WithPasswordPolicy(policy),
+			)
+
+			_, err := svc.Create(context.Background(), CreateUserInput{
+				Fullname:        "John Doe",
+				Username:        "jdoe",
+				Birthdate:       "2000-01-01",
+				Email:           "joedoe@mail.com",
+				Password:        tc.givenPassword,
+				ConfirmPassword: tc.givenPassword,
+			})
+
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+
+	t.Run("logins only reject empty passwords", func(t *testing.T) {
+		t.Parallel()
+
+		svc := New(zap.NewNop(), "jwt-secret", &repositoryMock{}, WithPasswordPolicy(policy))
+
+		assert.NoError(t, svc.validateLoginPassword("a"))
+		assert.Error(t, svc.validateLoginPassword(""))
+	})
+}
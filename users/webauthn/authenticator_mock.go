@@ -0,0 +1,157 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MockAuthenticator is a software authenticator holding a single ES256 passkey,
+// answering the ceremonies as a browser would, for testing relying parties
+type MockAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	rpID         string
+	origin       string
+	flags        byte
+	signCount    uint32
+}
+
+// NewMockAuthenticator returns an authenticator for the relying party id answering from the origin
+func NewMockAuthenticator(rpID, origin string) (*MockAuthenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key: %s", err)
+	}
+
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, fmt.Errorf("could not generate credential id: %s", err)
+	}
+
+	return &MockAuthenticator{
+		key:          key,
+		credentialID: credentialID,
+		rpID:         rpID,
+		origin:       origin,
+		flags:        flagUserPresent | flagUserVerified,
+	}, nil
+}
+
+// CredentialID returns the id of the passkey
+func (m *MockAuthenticator) CredentialID() []byte {
+	return m.credentialID
+}
+
+// Register returns the JSON encoded response of a registration ceremony with the options
+func (m *MockAuthenticator) Register(opts *CreationOptions) ([]byte, error) {
+	userHandle, err := decode(opts.User.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode user handle: %s", err)
+	}
+	m.userHandle = userHandle
+
+	clientDataJSON, err := m.clientData(clientDataTypeCreate, opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := encodeCBOR(map[interface{}]interface{}{
+		coseKeyType:  coseKeyEC2,
+		coseKeyAlg:   AlgES256,
+		coseKeyCurve: coseCurveP256,
+		coseKeyX:     m.key.X.FillBytes(make([]byte, 32)),
+		coseKeyY:     m.key.Y.FillBytes(make([]byte, 32)),
+	})
+
+	// The attested credential data holds a zero aaguid, the length of the credential id,
+	// the credential id and the public key
+	attested := make([]byte, 18, 18+len(m.credentialID)+len(publicKey))
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(m.credentialID)))
+	attested = append(attested, m.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestationObject := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": append(m.authenticatorData(m.flags|flagAttestedCredData), attested...),
+	})
+
+	var resp registrationResponse
+	resp.RawID = encode(m.credentialID)
+	resp.Type = publicKeyCredentialType
+	resp.Response.ClientDataJSON = encode(clientDataJSON)
+	resp.Response.AttestationObject = encode(attestationObject)
+
+	return json.Marshal(map[string]interface{}{
+		"id":       resp.RawID,
+		"rawId":    resp.RawID,
+		"type":     resp.Type,
+		"response": resp.Response,
+	})
+}
+
+// Assert returns the JSON encoded response of an authentication ceremony with the options,
+// signed with the passkey, incrementing the sign count
+func (m *MockAuthenticator) Assert(opts *RequestOptions) ([]byte, error) {
+	if m.userHandle == nil {
+		return nil, errors.New("could not assert: no passkey registered")
+	}
+
+	clientDataJSON, err := m.clientData(clientDataTypeGet, opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	m.signCount++
+	authData := m.authenticatorData(m.flags)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, m.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("could not sign assertion: %s", err)
+	}
+
+	var resp assertionResponse
+	resp.RawID = encode(m.credentialID)
+	resp.Type = publicKeyCredentialType
+	resp.Response.ClientDataJSON = encode(clientDataJSON)
+	resp.Response.AuthenticatorData = encode(authData)
+	resp.Response.Signature = encode(signature)
+	resp.Response.UserHandle = encode(m.userHandle)
+
+	return json.Marshal(map[string]interface{}{
+		"id":       resp.RawID,
+		"rawId":    resp.RawID,
+		"type":     resp.Type,
+		"response": resp.Response,
+	})
+}
+
+// clientData returns the client data of a ceremony as collected by a browser
+func (m *MockAuthenticator) clientData(typ, challenge string) ([]byte, error) {
+	cd, err := json.Marshal(clientData{Type: typ, Challenge: challenge, Origin: m.origin})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal client data: %s", err)
+	}
+	return cd, nil
+}
+
+// authenticatorData returns the rp id hash, the flags and the sign count
+func (m *MockAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(m.rpID))
+
+	data := make([]byte, authDataMinLen)
+	copy(data, rpIDHash[:])
+	data[32] = flags
+	binary.BigEndian.PutUint32(data[33:], m.signCount)
+	return data
+}
@@ -0,0 +1,210 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// maxCBORDepth bounds the nesting of decoded items, as authenticator data never nests deeply
+const maxCBORDepth = 8
+
+// decodeCBOR decodes the first CBOR item of data (RFC 8949) and returns it along with the remaining bytes.
+// Only the subset used by WebAuthn is supported: integers, byte and text strings, arrays, maps,
+// booleans and null. Integers decode as int64, byte strings as []byte, text strings as string,
+// arrays as []interface{} and maps as map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errCBORMalformed
+	}
+
+	if len(data) == 0 {
+		return nil, nil, errCBORMalformed
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	// Simple values carry their value in the additional information
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22:
+			return nil, data, nil
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBORUnsupported, info)
+		}
+	}
+
+	arg, data, err := cborArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, errCBORUnsupported
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, errCBORUnsupported
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORMalformed
+		}
+
+		value := data[:arg]
+		if major == 3 {
+			return string(value), data[arg:], nil
+		}
+		return append([]byte(nil), value...), data[arg:], nil
+	case 4:
+		// Each item takes at least a byte, which bounds the allocation
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORMalformed
+		}
+
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORMalformed
+		}
+
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: map key of type %T", errCBORUnsupported, key)
+			}
+
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: major type %d", errCBORUnsupported, major)
+	}
+}
+
+// cborArgument decodes the argument of an item head from its additional information
+// and the following bytes. Indefinite lengths are not supported.
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errCBORMalformed
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errCBORMalformed
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errCBORMalformed
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errCBORMalformed
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, fmt.Errorf("%w: additional information %d", errCBORUnsupported, info)
+	}
+}
+
+// encodeCBOR encodes the subset of values decoded by decodeCBOR, as MockAuthenticator responses.
+// Map keys are sorted so the encoding is deterministic.
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case int64:
+		return encodeCBOR(int(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case []interface{}:
+		b := cborHead(4, uint64(len(v)))
+		for _, item := range v {
+			b = append(b, encodeCBOR(item)...)
+		}
+		return b
+	case map[interface{}]interface{}:
+		keys := make([][]byte, 0, len(v))
+		values := make(map[string][]byte, len(v))
+		for k, item := range v {
+			key := encodeCBOR(k)
+			keys = append(keys, key)
+			values[string(key)] = encodeCBOR(item)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+
+		b := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			b = append(b, key...)
+			b = append(b, values[string(key)]...)
+		}
+		return b
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	default:
+		panic(fmt.Sprintf("could not encode cbor value of type %T", v))
+	}
+}
+
+// cborHead encodes the head of an item of the major type with the argument
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		b := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(arg))
+		return b
+	default:
+		b := []byte{major<<5 | 27, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], arg)
+		return b
+	}
+}
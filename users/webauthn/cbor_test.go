@@ -0,0 +1,80 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	t.Parallel()
+
+	// RFC 8949 appendix A examples
+	testCases := []struct {
+		name     string
+		given    string
+		expected interface{}
+	}{
+		{name: "zero", given: "00", expected: int64(0)},
+		{name: "one byte uint", given: "1818", expected: int64(24)},
+		{name: "two bytes uint", given: "1903e8", expected: int64(1000)},
+		{name: "eight bytes uint", given: "1b000000e8d4a51000", expected: int64(1000000000000)},
+		{name: "negative int", given: "3863", expected: int64(-100)},
+		{name: "byte string", given: "4401020304", expected: []byte{1, 2, 3, 4}},
+		{name: "text string", given: "6449455446", expected: "IETF"},
+		{name: "array", given: "83010203", expected: []interface{}{int64(1), int64(2), int64(3)}},
+		{name: "map", given: "a201020304", expected: map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{name: "true", given: "f5", expected: true},
+		{name: "null", given: "f6", expected: nil},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := hex.DecodeString(tc.given)
+			require.NoError(t, err)
+
+			actual, rest, err := decodeCBOR(data)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expected, actual)
+			assert.Empty(t, rest)
+		})
+	}
+}
+
+func TestDecodeCBOR_errors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		given    string
+		expected error
+	}{
+		{name: "empty", given: "", expected: errCBORMalformed},
+		{name: "truncated argument", given: "19", expected: errCBORMalformed},
+		{name: "truncated byte string", given: "4401", expected: errCBORMalformed},
+		{name: "truncated map", given: "a201", expected: errCBORMalformed},
+		{name: "indefinite length", given: "5f", expected: errCBORUnsupported},
+		{name: "float", given: "f93c00", expected: errCBORUnsupported},
+		{name: "tag", given: "c074", expected: errCBORUnsupported},
+		{name: "array map key", given: "a18001", expected: errCBORUnsupported},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := hex.DecodeString(tc.given)
+			require.NoError(t, err)
+
+			_, _, err = decodeCBOR(data)
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
+}
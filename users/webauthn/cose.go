@@ -0,0 +1,125 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+const (
+	// Enumerate the supported COSE algorithms (RFC 9053)
+
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+
+	// COSE key parameters and values (RFC 9052)
+
+	coseKeyType   int64 = 1
+	coseKeyAlg    int64 = 3
+	coseKeyCurve  int64 = -1
+	coseKeyX      int64 = -2
+	coseKeyY      int64 = -3
+	coseKeyRSAN   int64 = -1
+	coseKeyRSAE   int64 = -2
+	coseKeyOKP    int64 = 1
+	coseKeyEC2    int64 = 2
+	coseKeyRSA    int64 = 3
+	coseCurveP256 int64 = 1
+	coseCurveEd   int64 = 6
+)
+
+// supportedAlgorithms are the algorithms offered to authenticators, by order of preference
+var supportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// coseKey is a credential public key decoded from its COSE_Key encoding
+type coseKey struct {
+	alg       int64
+	publicKey crypto.PublicKey
+}
+
+// parseCOSEKey decodes a COSE_Key holding an ES256, EdDSA or RS256 public key
+func parseCOSEKey(data []byte) (*coseKey, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	params, ok := item.(map[interface{}]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errPublicKeyMalformed
+	}
+
+	kty, _ := params[coseKeyType].(int64)
+	alg, _ := params[coseKeyAlg].(int64)
+
+	switch {
+	case kty == coseKeyEC2 && alg == AlgES256:
+		crv, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		y, _ := params[coseKeyY].([]byte)
+
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errPublicKeyMalformed
+		}
+
+		key := ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errPublicKeyMalformed
+		}
+		return &coseKey{alg: alg, publicKey: &key}, nil
+	case kty == coseKeyOKP && alg == AlgEdDSA:
+		crv, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+
+		if crv != coseCurveEd || len(x) != ed25519.PublicKeySize {
+			return nil, errPublicKeyMalformed
+		}
+		return &coseKey{alg: alg, publicKey: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyRSA && alg == AlgRS256:
+		n, _ := params[coseKeyRSAN].([]byte)
+		e, _ := params[coseKeyRSAE].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errPublicKeyMalformed
+		}
+
+		key := rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return &coseKey{alg: alg, publicKey: &key}, nil
+	default:
+		return nil, errAlgorithmUnsupported
+	}
+}
+
+// verify verifies the signature of the message with the key
+func (k *coseKey) verify(message, signature []byte) error {
+	var valid bool
+
+	switch key := k.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, message, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(message)
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+
+	if !valid {
+		return errSignatureInvalid
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package webauthn
+
+import "errors"
+
+var (
+	// List error messages
+
+	errAlgorithmUnsupported = errors.New("public key algorithm is not supported")
+	errAttestationMalformed = errors.New("attestation object is malformed")
+	errAuthDataMalformed    = errors.New("authenticator data is malformed")
+	errCBORMalformed        = errors.New("cbor data is malformed")
+	errCBORUnsupported      = errors.New("cbor data is not supported")
+	errChallengeMismatch    = errors.New("challenge does not match")
+	errClientDataMalformed  = errors.New("client data is malformed")
+	errClientDataType       = errors.New("client data type is invalid")
+	errCredentialMissing    = errors.New("attested credential is missing")
+	errCredentialType       = errors.New("credential type is invalid")
+	errOriginNotAllowed     = errors.New("origin is not allowed")
+	errPublicKeyMalformed   = errors.New("public key is malformed")
+	errRPIDMismatch         = errors.New("relying party id does not match")
+	errResponseMalformed    = errors.New("credential response is malformed")
+	errSignatureInvalid     = errors.New("signature is invalid")
+	errSignCountInvalid     = errors.New("sign count did not increase, the authenticator may be cloned")
+	errUserNotPresent       = errors.New("user was not present")
+	errUserNotVerified      = errors.New("user was not verified")
+)
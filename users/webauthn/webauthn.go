@@ -0,0 +1,458 @@
+// Package webauthn implements the relying party side of the WebAuthn registration and authentication
+// ceremonies (https://www.w3.org/TR/webauthn-2/), as used by passkeys. It issues the options passed to
+// navigator.credentials.create and navigator.credentials.get and verifies the credentials they return,
+// encoded as by PublicKeyCredential.toJSON. Storing the challenges and credentials is left to the caller.
+//
+// Credentials are registered with the "none" attestation conveyance: attestation statements are not
+// verified, so the make of the authenticators is not trusted. ES256, EdDSA and RS256 keys are supported.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// CeremonyTimeout is the time left to the user to complete a ceremony, advertised in the options
+const CeremonyTimeout time.Duration = time.Minute * 5
+
+const (
+	publicKeyCredentialType = "public-key"
+
+	// Enumerate the client data types of the ceremonies
+
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+
+	// Enumerate the authenticator data flags
+
+	flagUserPresent      byte = 0x01
+	flagUserVerified     byte = 0x04
+	flagAttestedCredData byte = 0x40
+
+	// authDataMinLen is the length of the rp id hash, flags and sign count of the authenticator data
+	authDataMinLen = 37
+)
+
+type (
+	// RelyingParty is the service the credentials are scoped to, identified by its domain
+	RelyingParty struct {
+		// ID is the domain of the relying party (e.g. "example.com")
+		ID string
+		// Name is the name of the relying party shown by authenticators
+		Name string
+		// Origins are the origins the ceremonies are allowed from (e.g. "https://login.example.com")
+		Origins []string
+	}
+
+	// User is the account a credential is registered for. ID is the user handle returned
+	// on authentication, which must not hold personal information.
+	User struct {
+		ID          []byte
+		Name        string
+		DisplayName string
+	}
+
+	// Credential is a public key credential registered by an authenticator
+	Credential struct {
+		// ID is the id of the credential, chosen by the authenticator
+		ID []byte
+		// PublicKey is the COSE_Key encoded public key of the credential
+		PublicKey []byte
+		// SignCount is the signature counter of the authenticator, or 0 when it doesn't count
+		SignCount uint32
+	}
+
+	// Assertion is an authentication response parsed by ParseAssertion, to be verified with VerifyAssertion
+	// against the credential identified by CredentialID
+	Assertion struct {
+		CredentialID []byte
+		// UserHandle is the user id the credential was registered for, returned by discoverable credentials
+		UserHandle []byte
+
+		clientDataJSON    []byte
+		authenticatorData []byte
+		signature         []byte
+	}
+
+	// CreationOptions are the options of a registration ceremony, passed to navigator.credentials.create
+	CreationOptions struct {
+		RP                     rpEntity               `json:"rp"`
+		User                   userEntity             `json:"user"`
+		Challenge              string                 `json:"challenge"`
+		PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+		Timeout                int64                  `json:"timeout"`
+		ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials,omitempty"`
+		AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+		Attestation            string                 `json:"attestation"`
+	}
+
+	// RequestOptions are the options of an authentication ceremony, passed to navigator.credentials.get.
+	// No credentials are allowed explicitly, so the authenticator offers the discoverable credentials (passkeys).
+	RequestOptions struct {
+		Challenge        string `json:"challenge"`
+		Timeout          int64  `json:"timeout"`
+		RPID             string `json:"rpId"`
+		UserVerification string `json:"userVerification"`
+	}
+
+	rpEntity struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	userEntity struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	}
+
+	credentialParameter struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	}
+
+	credentialDescriptor struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	}
+
+	authenticatorSelection struct {
+		ResidentKey        string `json:"residentKey"`
+		RequireResidentKey bool   `json:"requireResidentKey"`
+		UserVerification   string `json:"userVerification"`
+	}
+
+	registrationResponse struct {
+		RawID    string `json:"rawId"`
+		Type     string `json:"type"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AttestationObject string `json:"attestationObject"`
+		} `json:"response"`
+	}
+
+	assertionResponse struct {
+		RawID    string `json:"rawId"`
+		Type     string `json:"type"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AuthenticatorData string `json:"authenticatorData"`
+			Signature         string `json:"signature"`
+			UserHandle        string `json:"userHandle"`
+		} `json:"response"`
+	}
+
+	clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+
+	authenticatorData struct {
+		rpIDHash     []byte
+		flags        byte
+		signCount    uint32
+		credentialID []byte
+		publicKey    []byte
+	}
+)
+
+// NewRelyingParty returns the relying party of the domain id, allowing the ceremonies from the origins.
+// When no origins are given, only "https://" + id is allowed.
+func NewRelyingParty(id, name string, origins ...string) *RelyingParty {
+	if len(origins) == 0 {
+		origins = []string{"https://" + id}
+	}
+
+	return &RelyingParty{
+		ID:      id,
+		Name:    name,
+		Origins: origins,
+	}
+}
+
+// CreationOptions returns the options registering a discoverable credential (passkey) of the user,
+// requiring user verification. The exclude credential ids prevent registering an authenticator twice.
+func (rp *RelyingParty) CreationOptions(challenge []byte, user User, exclude [][]byte) *CreationOptions {
+	opts := CreationOptions{
+		RP: rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID:          encode(user.ID),
+			Name:        user.Name,
+			DisplayName: user.DisplayName,
+		},
+		Challenge: encode(challenge),
+		Timeout:   int64(CeremonyTimeout / time.Millisecond),
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+
+	for _, alg := range supportedAlgorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, credentialParameter{Type: publicKeyCredentialType, Alg: alg})
+	}
+
+	for _, id := range exclude {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, credentialDescriptor{Type: publicKeyCredentialType, ID: encode(id)})
+	}
+	return &opts
+}
+
+// RequestOptions returns the options authenticating with a discoverable credential, requiring user verification
+func (rp *RelyingParty) RequestOptions(challenge []byte) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        encode(challenge),
+		Timeout:          int64(CeremonyTimeout / time.Millisecond),
+		RPID:             rp.ID,
+		UserVerification: "required",
+	}
+}
+
+// VerifyRegistration verifies the JSON encoded response of a registration ceremony started with the challenge
+// and returns the registered credential
+func (rp *RelyingParty) VerifyRegistration(challenge, response []byte) (*Credential, error) {
+	var resp registrationResponse
+	if err := json.Unmarshal(response, &resp); err != nil {
+		return nil, errResponseMalformed
+	}
+
+	if resp.Type != publicKeyCredentialType {
+		return nil, errCredentialType
+	}
+
+	clientDataJSON, err := decode(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, errResponseMalformed
+	}
+
+	if err := rp.verifyClientData(clientDataJSON, clientDataTypeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	attestationObject, err := decode(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, errResponseMalformed
+	}
+
+	item, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errAttestationMalformed
+	}
+
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errAttestationMalformed
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+
+	if authData.flags&flagAttestedCredData == 0 {
+		return nil, errCredentialMissing
+	}
+
+	// The credential is only stored once its key is known to be usable
+	if _, err := parseCOSEKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// ParseAssertion parses the JSON encoded response of an authentication ceremony
+func ParseAssertion(response []byte) (*Assertion, error) {
+	var resp assertionResponse
+	if err := json.Unmarshal(response, &resp); err != nil {
+		return nil, errResponseMalformed
+	}
+
+	if resp.Type != publicKeyCredentialType {
+		return nil, errCredentialType
+	}
+
+	var (
+		a   Assertion
+		err error
+	)
+
+	for _, field := range []struct {
+		dst *[]byte
+		src string
+	}{
+		{dst: &a.CredentialID, src: resp.RawID},
+		{dst: &a.UserHandle, src: resp.Response.UserHandle},
+		{dst: &a.clientDataJSON, src: resp.Response.ClientDataJSON},
+		{dst: &a.authenticatorData, src: resp.Response.AuthenticatorData},
+		{dst: &a.signature, src: resp.Response.Signature},
+	} {
+		if *field.dst, err = decode(field.src); err != nil {
+			return nil, errResponseMalformed
+		}
+	}
+
+	if len(a.CredentialID) == 0 {
+		return nil, errResponseMalformed
+	}
+	return &a, nil
+}
+
+// VerifyAssertion verifies an assertion of an authentication ceremony started with the challenge,
+// signed by the credential, and returns the new sign count of the credential to be stored.
+// It fails when the sign count did not increase, which hints at a cloned authenticator.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, assertion *Assertion, credential Credential) (uint32, error) {
+	if !bytes.Equal(assertion.CredentialID, credential.ID) {
+		return 0, errResponseMalformed
+	}
+
+	if err := rp.verifyClientData(assertion.clientDataJSON, clientDataTypeGet, challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(assertion.authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := rp.verifyAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(assertion.clientDataJSON)
+
+	signed := make([]byte, 0, len(assertion.authenticatorData)+len(clientDataHash))
+	signed = append(signed, assertion.authenticatorData...)
+	signed = append(signed, clientDataHash[:]...)
+
+	if err := key.verify(signed, assertion.signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators not counting signatures always report 0
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, errSignCountInvalid
+	}
+	return authData.signCount, nil
+}
+
+// verifyClientData verifies the ceremony type, challenge and origin of the client data
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return errClientDataMalformed
+	}
+
+	if cd.Type != typ {
+		return errClientDataType
+	}
+
+	given, err := decode(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(given, challenge) != 1 {
+		return errChallengeMismatch
+	}
+
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return errOriginNotAllowed
+}
+
+// verifyAuthenticatorData verifies the authenticator data is scoped to the relying party
+// and that the user was present and verified
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(authData.rpIDHash, rpIDHash[:]) != 1 {
+		return errRPIDMismatch
+	}
+
+	if authData.flags&flagUserPresent == 0 {
+		return errUserNotPresent
+	}
+
+	if authData.flags&flagUserVerified == 0 {
+		return errUserNotVerified
+	}
+	return nil
+}
+
+// parseAuthenticatorData parses the authenticator data along with the attested credential data, if any
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authDataMinLen {
+		return nil, errAuthDataMalformed
+	}
+
+	authData := authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if authData.flags&flagAttestedCredData == 0 {
+		return &authData, nil
+	}
+
+	// The aaguid of the authenticator model is followed by the length of the credential id
+	rest := data[authDataMinLen:]
+	if len(rest) < 18 {
+		return nil, errAuthDataMalformed
+	}
+
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if idLen == 0 || len(rest) < idLen {
+		return nil, errAuthDataMalformed
+	}
+
+	authData.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	// The public key is followed by the extensions, if any
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, errAuthDataMalformed
+	}
+
+	authData.publicKey = rest[:len(rest)-len(extensions)]
+	return &authData, nil
+}
+
+// encode encodes binary values as base64url without padding, as the JSON encoding of WebAuthn
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decode decodes base64url values, with or without padding
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,259 @@
+package webauthn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelyingParty_VerifyRegistration(t *testing.T) {
+	t.Parallel()
+
+	rp := NewRelyingParty("example.com", "Example")
+
+	challenge := []byte("registration-challenge")
+	opts := rp.CreationOptions(challenge, User{ID: []byte("user-1"), Name: "jdoe", DisplayName: "John Doe"}, nil)
+
+	testCases := []struct {
+		name     string
+		given    func(a *MockAuthenticator)
+		expected error
+	}{
+		{
+			name:     "registration succeeds",
+			given:    func(a *MockAuthenticator) {},
+			expected: nil,
+		},
+		{
+			name:     "origin not allowed",
+			given:    func(a *MockAuthenticator) { a.origin = "https://evil.com" },
+			expected: errOriginNotAllowed,
+		},
+		{
+			name:     "rp id mismatch",
+			given:    func(a *MockAuthenticator) { a.rpID = "evil.com" },
+			expected: errRPIDMismatch,
+		},
+		{
+			name:     "user not verified",
+			given:    func(a *MockAuthenticator) { a.flags = flagUserPresent },
+			expected: errUserNotVerified,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := NewMockAuthenticator("example.com", "https://example.com")
+			require.NoError(t, err)
+
+			tc.given(a)
+
+			resp, err := a.Register(opts)
+			require.NoError(t, err)
+
+			credential, err := rp.VerifyRegistration(challenge, resp)
+			assert.Equal(t, tc.expected, err)
+
+			if tc.expected == nil {
+				assert.Equal(t, a.CredentialID(), credential.ID)
+				assert.Equal(t, uint32(0), credential.SignCount)
+
+				key, err := parseCOSEKey(credential.PublicKey)
+				require.NoError(t, err)
+				assert.Equal(t, AlgES256, key.alg)
+			}
+		})
+	}
+
+	t.Run("challenge mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		a, err := NewMockAuthenticator("example.com", "https://example.com")
+		require.NoError(t, err)
+
+		resp, err := a.Register(opts)
+		require.NoError(t, err)
+
+		_, err = rp.VerifyRegistration([]byte("other-challenge"), resp)
+		assert.Equal(t, errChallengeMismatch, err)
+	})
+
+	t.Run("malformed response", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := rp.VerifyRegistration(challenge, []byte("{"))
+		assert.Equal(t, errResponseMalformed, err)
+	})
+}
+
+func TestRelyingParty_VerifyAssertion(t *testing.T) {
+	t.Parallel()
+
+	rp := NewRelyingParty("example.com", "Example", "https://example.com", "https://login.example.com")
+
+	newCredential := func(t *testing.T, a *MockAuthenticator) *Credential {
+		challenge := []byte("registration-challenge")
+
+		resp, err := a.Register(rp.CreationOptions(challenge, User{ID: []byte("user-1"), Name: "jdoe"}, nil))
+		require.NoError(t, err)
+
+		credential, err := rp.VerifyRegistration(challenge, resp)
+		require.NoError(t, err)
+		return credential
+	}
+
+	t.Run("assertion succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		a, err := NewMockAuthenticator("example.com", "https://login.example.com")
+		require.NoError(t, err)
+
+		credential := newCredential(t, a)
+
+		challenge := []byte("login-challenge")
+
+		resp, err := a.Assert(rp.RequestOptions(challenge))
+		require.NoError(t, err)
+
+		assertion, err := ParseAssertion(resp)
+		require.NoError(t, err)
+
+		assert.Equal(t, credential.ID, assertion.CredentialID)
+		assert.Equal(t, []byte("user-1"), assertion.UserHandle)
+
+		signCount, err := rp.VerifyAssertion(challenge, assertion, *credential)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), signCount)
+
+		// A replayed assertion doesn't increase the sign count
+		credential.SignCount = signCount
+
+		_, err = rp.VerifyAssertion(challenge, assertion, *credential)
+		assert.Equal(t, errSignCountInvalid, err)
+	})
+
+	t.Run("challenge mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		a, err := NewMockAuthenticator("example.com", "https://example.com")
+		require.NoError(t, err)
+
+		credential := newCredential(t, a)
+
+		resp, err := a.Assert(rp.RequestOptions([]byte("login-challenge")))
+		require.NoError(t, err)
+
+		assertion, err := ParseAssertion(resp)
+		require.NoError(t, err)
+
+		_, err = rp.VerifyAssertion([]byte("other-challenge"), assertion, *credential)
+		assert.Equal(t, errChallengeMismatch, err)
+	})
+
+	t.Run("signed by another key", func(t *testing.T) {
+		t.Parallel()
+
+		a, err := NewMockAuthenticator("example.com", "https://example.com")
+		require.NoError(t, err)
+
+		other, err := NewMockAuthenticator("example.com", "https://example.com")
+		require.NoError(t, err)
+
+		credential := newCredential(t, a)
+		credential.PublicKey = newCredential(t, other).PublicKey
+
+		challenge := []byte("login-challenge")
+
+		resp, err := a.Assert(rp.RequestOptions(challenge))
+		require.NoError(t, err)
+
+		assertion, err := ParseAssertion(resp)
+		require.NoError(t, err)
+
+		_, err = rp.VerifyAssertion(challenge, assertion, *credential)
+		assert.Equal(t, errSignatureInvalid, err)
+	})
+
+	t.Run("registration response", func(t *testing.T) {
+		t.Parallel()
+
+		a, err := NewMockAuthenticator("example.com", "https://example.com")
+		require.NoError(t, err)
+
+		credential := newCredential(t, a)
+
+		resp, err := a.Assert(rp.RequestOptions([]byte("login-challenge")))
+		require.NoError(t, err)
+
+		assertion, err := ParseAssertion(resp)
+		require.NoError(t, err)
+
+		// The client data of a registration is not accepted for an authentication
+		registration, err := a.clientData(clientDataTypeCreate, encode([]byte("login-challenge")))
+		require.NoError(t, err)
+		assertion.clientDataJSON = registration
+
+		_, err = rp.VerifyAssertion([]byte("login-challenge"), assertion, *credential)
+		assert.Equal(t, errClientDataType, err)
+	})
+}
+
+func TestParseCOSEKey_ed25519(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := parseCOSEKey(encodeCBOR(map[interface{}]interface{}{
+		coseKeyType:  coseKeyOKP,
+		coseKeyAlg:   AlgEdDSA,
+		coseKeyCurve: coseCurveEd,
+		coseKeyX:     []byte(publicKey),
+	}))
+	require.NoError(t, err)
+
+	message := []byte("message")
+
+	assert.NoError(t, key.verify(message, ed25519.Sign(privateKey, message)))
+	assert.Equal(t, errSignatureInvalid, key.verify([]byte("other message"), ed25519.Sign(privateKey, message)))
+
+	_, err = parseCOSEKey(encodeCBOR(map[interface{}]interface{}{
+		coseKeyType: coseKeyOKP,
+		coseKeyAlg:  AlgES256,
+	}))
+	assert.Equal(t, errAlgorithmUnsupported, err)
+}
+
+func TestCreationOptions(t *testing.T) {
+	t.Parallel()
+
+	rp := NewRelyingParty("example.com", "Example")
+
+	opts := rp.CreationOptions([]byte{0xff, 0xfe}, User{ID: []byte("user-1"), Name: "jdoe", DisplayName: "John Doe"}, [][]byte{{0x01}})
+
+	b, err := json.Marshal(opts)
+	require.NoError(t, err)
+
+	expected := `{
+		"rp": {"id": "example.com", "name": "Example"},
+		"user": {"id": "dXNlci0x", "name": "jdoe", "displayName": "John Doe"},
+		"challenge": "__4",
+		"pubKeyCredParams": [
+			{"type": "public-key", "alg": -7},
+			{"type": "public-key", "alg": -8},
+			{"type": "public-key", "alg": -257}
+		],
+		"timeout": 300000,
+		"excludeCredentials": [{"type": "public-key", "id": "AQ"}],
+		"authenticatorSelection": {"residentKey": "required", "requireResidentKey": true, "userVerification": "required"},
+		"attestation": "none"
+	}`
+	assert.JSONEq(t, expected, string(b))
+}